@@ -0,0 +1,441 @@
+package diff
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+const (
+	DefaultContext = 3
+
+	// git takes files with NUL byte in this prefix for binary
+	binaryCheckSize = 8000
+	// git never shows longer function names in hunk headers
+	maxFuncNameLength = 80
+	// common tail of texts is trimmed in blocks of this size for diffs without
+	// context
+	trimBlockSize   = 1024
+	noNewlineMarker = "\n\\ No newline at end of file\n"
+)
+
+type PatchOptions struct {
+	Algorithm Algorithm
+	// lines of context around changes, DefaultContext if zero, none if negative
+	Context int
+	// hunks are merged, if there are up to this many unchanged lines between
+	// their contexts
+	InterHunkContext int
+	// length of abbreviated ids in index lines, rawgit.DefaultAbbrevLength if zero.
+	// Ids are made longer, if they are ambiguous
+	Abbrev int
+	// write full ids in index lines
+	FullIndex bool
+}
+
+func (opts *PatchOptions) context() int {
+	switch {
+	case opts.Context == 0:
+		return DefaultContext
+	case opts.Context < 0:
+		return 0
+	}
+	return opts.Context
+}
+
+// WritePatch writes changes as 'git diff' does, so that patch can be applied by
+// 'git apply'. Changes of file kind are written as deletion and creation
+func WritePatch(w io.Writer, repo rawgit.Repository, changes []*Change, opts *PatchOptions) error {
+	if opts == nil {
+		opts = &PatchOptions{}
+	}
+
+	out := bufio.NewWriter(w)
+	writer := &patchWriter{repo: repo, out: out, opts: opts}
+	for _, change := range changes {
+		var err error
+		if change.Old.Exists() && change.New.Exists() && change.Old.Mode&0170000 != change.New.Mode&0170000 {
+			deleted := Change{Type: Deleted, Old: change.Old, New: Entry{Path: change.Old.Path}}
+			added := Change{Type: Added, Old: Entry{Path: change.New.Path}, New: change.New}
+			err = writer.writeFile(&deleted)
+			if err == nil {
+				err = writer.writeFile(&added)
+			}
+		} else {
+			err = writer.writeFile(change)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return out.Flush()
+}
+
+// WriteUnified writes hunks of unified diff between texts, without file headers
+func WriteUnified(w io.Writer, oldText, newText []byte, opts *PatchOptions) error {
+	if opts == nil {
+		opts = &PatchOptions{}
+	}
+
+	out := bufio.NewWriter(w)
+	writeHunks(out, oldText, newText, opts, nil)
+	return out.Flush()
+}
+
+type patchWriter struct {
+	repo rawgit.Repository
+	out  *bufio.Writer
+	opts *PatchOptions
+}
+
+func (p *patchWriter) writeFile(change *Change) error {
+	oldPath, newPath := change.Old.Path, change.New.Path
+	if !change.Old.Exists() {
+		oldPath = newPath
+	}
+	if !change.New.Exists() {
+		newPath = oldPath
+	}
+
+	oldLabel, newLabel := quotePaths("a/", oldPath), quotePaths("b/", newPath)
+
+	var header bytes.Buffer
+	fmt.Fprintf(&header, "diff --git %s %s\n", oldLabel, newLabel)
+
+	mustShowHeader := false
+	switch {
+	case !change.Old.Exists():
+		fmt.Fprintf(&header, "new file mode %06o\n", change.New.Mode)
+		oldLabel = "/dev/null"
+		mustShowHeader = true
+	case !change.New.Exists():
+		fmt.Fprintf(&header, "deleted file mode %06o\n", change.Old.Mode)
+		newLabel = "/dev/null"
+		mustShowHeader = true
+	case change.Old.Mode != change.New.Mode:
+		fmt.Fprintf(&header, "old mode %06o\nnew mode %06o\n", change.Old.Mode, change.New.Mode)
+		mustShowHeader = true
+	}
+
+	switch change.Type {
+	case Renamed, Copied:
+		action := "rename"
+		if change.Type == Copied {
+			action = "copy"
+		}
+		fmt.Fprintf(&header, "similarity index %d%%\n", change.Score)
+		fmt.Fprintf(&header, "%s from %s\n", action, quotePath(oldPath))
+		fmt.Fprintf(&header, "%s to %s\n", action, quotePath(newPath))
+		mustShowHeader = true
+	}
+
+	if change.Old.OID != change.New.OID {
+		oldID, err := p.abbreviate(&change.Old.OID)
+		if err != nil {
+			return err
+		}
+		newID, err := p.abbreviate(&change.New.OID)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&header, "index %s..%s", oldID, newID)
+		if change.Old.Mode == change.New.Mode {
+			fmt.Fprintf(&header, " %06o", change.Old.Mode)
+		}
+		header.WriteString("\n")
+	}
+
+	oldText, err := ReadContent(p.repo, &change.Old)
+	if err != nil {
+		return err
+	}
+	newText, err := ReadContent(p.repo, &change.New)
+	if err != nil {
+		return err
+	}
+
+	if IsBinary(oldText) || IsBinary(newText) {
+		if bytes.Equal(oldText, newText) {
+			if mustShowHeader {
+				p.out.Write(header.Bytes())
+			}
+			return nil
+		}
+
+		p.out.Write(header.Bytes())
+		fmt.Fprintf(p.out, "Binary files %s and %s differ\n", oldLabel, newLabel)
+		return nil
+	}
+
+	if mustShowHeader {
+		p.out.Write(header.Bytes())
+		header.Reset()
+	}
+
+	// header and file names are written only if there are hunks
+	fmt.Fprintf(&header, "--- %s%s\n", oldLabel, labelTab(oldLabel))
+	fmt.Fprintf(&header, "+++ %s%s\n", newLabel, labelTab(newLabel))
+	writeHunks(p.out, oldText, newText, p.opts, header.Bytes())
+	return nil
+}
+
+func (p *patchWriter) abbreviate(oid *rawgit.OID) (string, error) {
+	if p.opts.FullIndex {
+		return oid.String(), nil
+	}
+
+	length := p.opts.Abbrev
+	if length == 0 {
+		length = rawgit.DefaultAbbrevLength
+	}
+	return rawgit.AbbreviateOID(p.repo, oid, length)
+}
+
+// ReadContent returns text of entry, which is empty for missing entries.
+// Submodules are shown by commit id
+func ReadContent(repo rawgit.Storage, entry *Entry) ([]byte, error) {
+	switch {
+	case !entry.Exists():
+		return nil, nil
+	case entry.Mode == rawgit.TreeCommitMode:
+		return []byte("Subproject commit " + entry.OID.String() + "\n"), nil
+	}
+	return readBlob(repo, &entry.OID)
+}
+
+func readBlob(repo rawgit.Storage, oid *rawgit.OID) ([]byte, error) {
+	_, body, err := repo.OpenObject(oid)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
+
+// writeHunks writes unified diff of texts. Header is written before the first
+// hunk, if there are any
+func writeHunks(out *bufio.Writer, oldText, newText []byte, opts *PatchOptions, header []byte) {
+	context := opts.context()
+	if context == 0 {
+		oldText, newText = trimCommonTail(oldText, newText)
+	}
+
+	oldLines, newLines := SplitLines(oldText), SplitLines(newText)
+	edits := diffRecords(oldLines, newLines, opts.Algorithm, true)
+	if len(edits) > 0 && header != nil {
+		out.Write(header)
+	}
+
+	maxCommon := 2*context + opts.InterHunkContext
+	var funcName []byte
+	funcLinePrev := -1
+	for len(edits) > 0 {
+		// take edits, which are close enough to share context
+		last := 0
+		for last+1 < len(edits) && edits[last+1].OldStart-(edits[last].OldStart+edits[last].OldLines) <= maxCommon {
+			last++
+		}
+		first, end := edits[0], edits[last]
+		hunk := edits[:last+1]
+		edits = edits[last+1:]
+
+		s1 := maxInt(first.OldStart-context, 0)
+		s2 := maxInt(first.NewStart-context, 0)
+
+		postContext := context
+		postContext = minInt(postContext, len(oldLines)-(end.OldStart+end.OldLines))
+		postContext = minInt(postContext, len(newLines)-(end.NewStart+end.NewLines))
+		e1 := end.OldStart + end.OldLines + postContext
+		e2 := end.NewStart + end.NewLines + postContext
+
+		if name, ok := findFuncName(oldLines, s1-1, funcLinePrev); ok {
+			funcName = name
+		}
+		funcLinePrev = s1 - 1
+
+		out.WriteString("@@ -")
+		writeRange(out, s1+1, e1-s1)
+		out.WriteString(" +")
+		writeRange(out, s2+1, e2-s2)
+		out.WriteString(" @@")
+		if len(funcName) > 0 {
+			out.WriteString(" ")
+			out.Write(funcName)
+		}
+		out.WriteString("\n")
+
+		for ; s2 < first.NewStart; s2++ {
+			writeLine(out, ' ', newLines[s2])
+		}
+
+		s1, s2 = first.OldStart, first.NewStart
+		for _, edit := range hunk {
+			for ; s1 < edit.OldStart && s2 < edit.NewStart; s1, s2 = s1+1, s2+1 {
+				writeLine(out, ' ', newLines[s2])
+			}
+			for s1 = edit.OldStart; s1 < edit.OldStart+edit.OldLines; s1++ {
+				writeLine(out, '-', oldLines[s1])
+			}
+			for s2 = edit.NewStart; s2 < edit.NewStart+edit.NewLines; s2++ {
+				writeLine(out, '+', newLines[s2])
+			}
+			s1, s2 = edit.OldStart+edit.OldLines, edit.NewStart+edit.NewLines
+		}
+
+		for s2 = end.NewStart + end.NewLines; s2 < e2; s2++ {
+			writeLine(out, ' ', newLines[s2])
+		}
+	}
+}
+
+func writeRange(out *bufio.Writer, start, count int) {
+	if count == 0 {
+		start--
+	}
+	out.WriteString(strconv.Itoa(start))
+	if count != 1 {
+		out.WriteString("," + strconv.Itoa(count))
+	}
+}
+
+func writeLine(out *bufio.Writer, prefix byte, line []byte) {
+	out.WriteByte(prefix)
+	out.Write(line)
+	if len(line) > 0 && line[len(line)-1] != '\n' {
+		out.WriteString(noNewlineMarker)
+	}
+}
+
+// findFuncName looks for function line before hunk, going back from start to
+// limit, exclusive. Like git without diff drivers, it takes any line starting
+// with letter, '_' or '$'
+func findFuncName(lines [][]byte, start, limit int) ([]byte, bool) {
+	step := 1
+	if start > limit {
+		step = -1
+	}
+
+	for idx := start; idx != limit && idx >= 0 && idx < len(lines); idx += step {
+		line := lines[idx]
+		if len(line) == 0 || !isFuncNameStart(line[0]) {
+			continue
+		}
+
+		if len(line) > maxFuncNameLength {
+			line = line[:maxFuncNameLength]
+		}
+		return bytes.TrimRight(line, " \t\r\n"), true
+	}
+	return nil, false
+}
+
+func isFuncNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$'
+}
+
+// trimCommonTail drops common tail of texts in large blocks, keeping the first
+// line of it, as git does for diffs without context
+func trimCommonTail(oldText, newText []byte) ([]byte, []byte) {
+	smaller := minInt(len(oldText), len(newText))
+	trimmed := 0
+	for trimBlockSize+trimmed <= smaller &&
+		bytes.Equal(oldText[len(oldText)-trimmed-trimBlockSize:len(oldText)-trimmed],
+			newText[len(newText)-trimmed-trimBlockSize:len(newText)-trimmed]) {
+		trimmed += trimBlockSize
+	}
+
+	recovered := 0
+	tail := oldText[len(oldText)-trimmed:]
+	for recovered < trimmed {
+		recovered++
+		if tail[recovered-1] == '\n' {
+			break
+		}
+	}
+
+	cut := trimmed - recovered
+	return oldText[:len(oldText)-cut], newText[:len(newText)-cut]
+}
+
+// IsBinary reports whether git takes data for binary
+func IsBinary(data []byte) bool {
+	return bytes.IndexByte(data[:minInt(len(data), binaryCheckSize)], 0) != -1
+}
+
+// labelTab returns tab to be written after file name with spaces, so that it
+// is not confused with timestamps
+func labelTab(label string) string {
+	if strings.IndexByte(label, ' ') != -1 {
+		return "\t"
+	}
+	return ""
+}
+
+// quotePath quotes path, as git does with core.quotePath enabled, if it has
+// special characters
+func quotePath(path string) string {
+	quoted, ok := quoteC(path)
+	if !ok {
+		return path
+	}
+	return `"` + quoted + `"`
+}
+
+// quotePaths joins prefix and path, quoting them together if needed
+func quotePaths(prefix, path string) string {
+	quotedPrefix, prefixOk := quoteC(prefix)
+	quotedPath, pathOk := quoteC(path)
+	if !prefixOk && !pathOk {
+		return prefix + path
+	}
+	return `"` + quotedPrefix + quotedPath + `"`
+}
+
+// quoteC escapes value in c style, without surrounding quotes. It reports, if
+// quoting is needed
+func quoteC(value string) (string, bool) {
+	var buf strings.Builder
+	needed := false
+	for idx := 0; idx < len(value); idx++ {
+		c := value[idx]
+		switch {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c >= 0x07 && c <= 0x0d:
+			buf.WriteByte('\\')
+			buf.WriteByte("abtnvfr"[c-0x07])
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&buf, "\\%03o", c)
+		default:
+			buf.WriteByte(c)
+			continue
+		}
+		needed = true
+	}
+	return buf.String(), needed
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,500 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mechmind/git-go/rawgit"
+	"github.com/mechmind/git-go/storage/fsstor"
+)
+
+type testFile struct {
+	mode uint32
+	data string
+}
+
+func blob(data string) testFile {
+	return testFile{rawgit.TreeBlobMode, data}
+}
+
+// numbered makes text of numbered lines, replacing some of them
+func numbered(count int, replaced map[int]string) string {
+	var sb strings.Builder
+	for n := 1; n <= count; n++ {
+		if line, ok := replaced[n]; ok {
+			sb.WriteString(line)
+		} else {
+			fmt.Fprintf(&sb, "line %d\n", n)
+		}
+	}
+	return sb.String()
+}
+
+// patchTests are compared with output of 'git diff' for the same trees, with
+// -M for DetectRenames and -U0 for negative context
+var patchTests = []struct {
+	name     string
+	old, new map[string]testFile
+	opts     PatchOptions
+	diffOpts Options
+	want     string
+}{
+	{
+		name: "modified file",
+		old:  map[string]testFile{"file.c": blob(numbered(30, map[int]string{10: "int main(void)\n"}))},
+		new: map[string]testFile{"file.c": blob(numbered(30, map[int]string{
+			10: "int main(void)\n", 13: "changed 13\n", 14: "", 27: "changed 27\nadded\n",
+		}))},
+		want: `diff --git a/file.c b/file.c
+index db1a350..2a57cc5 100644
+--- a/file.c
++++ b/file.c
+@@ -10,8 +10,7 @@ line 9
+ int main(void)
+ line 11
+ line 12
+-line 13
+-line 14
++changed 13
+ line 15
+ line 16
+ line 17
+@@ -24,7 +23,8 @@ line 23
+ line 24
+ line 25
+ line 26
+-line 27
++changed 27
++added
+ line 28
+ line 29
+ line 30
+`,
+	},
+	{
+		name: "added and deleted files",
+		old:  map[string]testFile{"deleted": blob("old\ncontents\n"), "kept": blob("kept\n")},
+		new: map[string]testFile{
+			"added":     blob("new\ncontents\n"),
+			"dir/empty": blob(""),
+			"kept":      blob("kept\n"),
+		},
+		want: `diff --git a/added b/added
+new file mode 100644
+index 0000000..926dfbd
+--- /dev/null
++++ b/added
+@@ -0,0 +1,2 @@
++new
++contents
+diff --git a/deleted b/deleted
+deleted file mode 100644
+index b582625..0000000
+--- a/deleted
++++ /dev/null
+@@ -1,2 +0,0 @@
+-old
+-contents
+diff --git a/dir/empty b/dir/empty
+new file mode 100644
+index 0000000..e69de29
+`,
+	},
+	{
+		name: "mode changes",
+		old: map[string]testFile{
+			"script": blob("#!/bin/sh\n"),
+			"tool":   blob("#!/bin/sh\necho\n"),
+			"link":   blob("target\n"),
+		},
+		new: map[string]testFile{
+			"script": {rawgit.TreeExecutableBlobMode, "#!/bin/sh\n"},
+			"tool":   {rawgit.TreeExecutableBlobMode, "#!/bin/sh\necho changed\n"},
+			"link":   {rawgit.TreeSymlinkMode, "target"},
+		},
+		want: `diff --git a/link b/link
+deleted file mode 100644
+index eb5a316..0000000
+--- a/link
++++ /dev/null
+@@ -1 +0,0 @@
+-target
+diff --git a/link b/link
+new file mode 120000
+index 0000000..1de5659
+--- /dev/null
++++ b/link
+@@ -0,0 +1 @@
++target
+\ No newline at end of file
+diff --git a/script b/script
+old mode 100644
+new mode 100755
+diff --git a/tool b/tool
+old mode 100644
+new mode 100755
+index 82a76d3..5608007
+--- a/tool
++++ b/tool
+@@ -1,2 +1,2 @@
+ #!/bin/sh
+-echo
++echo changed
+`,
+	},
+	{
+		name: "renames",
+		old: map[string]testFile{
+			"old/name":   blob(numbered(20, nil)),
+			"moved":      blob(numbered(10, nil)),
+			"unrelated1": blob("one\n"),
+		},
+		new: map[string]testFile{
+			"new/name":   blob(numbered(20, map[int]string{5: "changed\n"})),
+			"moved-here": blob(numbered(10, nil)),
+			"unrelated2": blob("two\n"),
+		},
+		diffOpts: Options{DetectRenames: true},
+		want: `diff --git a/moved b/moved-here
+similarity index 100%
+rename from moved
+rename to moved-here
+diff --git a/old/name b/new/name
+similarity index 94%
+rename from old/name
+rename to new/name
+index c4352f8..d9e5015 100644
+--- a/old/name
++++ b/new/name
+@@ -2,7 +2,7 @@ line 1
+ line 2
+ line 3
+ line 4
+-line 5
++changed
+ line 6
+ line 7
+ line 8
+diff --git a/unrelated1 b/unrelated1
+deleted file mode 100644
+index 5626abf..0000000
+--- a/unrelated1
++++ /dev/null
+@@ -1 +0,0 @@
+-one
+diff --git a/unrelated2 b/unrelated2
+new file mode 100644
+index 0000000..f719efd
+--- /dev/null
++++ b/unrelated2
+@@ -0,0 +1 @@
++two
+`,
+	},
+	{
+		name: "quoted paths",
+		old: map[string]testFile{
+			"tab\tname":   blob("a\n"),
+			"quote\"d":    blob("a\n"),
+			"back\\slash": blob("a\n"),
+			"utf-ü":       blob("a\n"),
+			"with space":  blob("a\n"),
+			"renamed é":   blob(numbered(10, nil)),
+		},
+		new: map[string]testFile{
+			"tab\tname":   blob("b\n"),
+			"quote\"d":    blob("b\n"),
+			"back\\slash": blob("b\n"),
+			"utf-ü":       blob("b\n"),
+			"with space":  blob("b\n"),
+			"renamed è":   blob(numbered(10, nil)),
+		},
+		diffOpts: Options{DetectRenames: true},
+		// file labels of paths with spaces end with tab
+		want: `diff --git "a/back\\slash" "b/back\\slash"
+index 7898192..6178079 100644
+--- "a/back\\slash"
++++ "b/back\\slash"
+@@ -1 +1 @@
+-a
++b
+diff --git "a/quote\"d" "b/quote\"d"
+index 7898192..6178079 100644
+--- "a/quote\"d"
++++ "b/quote\"d"
+@@ -1 +1 @@
+-a
++b
+diff --git "a/renamed \303\251" "b/renamed \303\250"
+similarity index 100%
+rename from "renamed \303\251"
+rename to "renamed \303\250"
+diff --git "a/tab\tname" "b/tab\tname"
+index 7898192..6178079 100644
+--- "a/tab\tname"
++++ "b/tab\tname"
+@@ -1 +1 @@
+-a
++b
+diff --git "a/utf-\303\274" "b/utf-\303\274"
+index 7898192..6178079 100644
+--- "a/utf-\303\274"
++++ "b/utf-\303\274"
+@@ -1 +1 @@
+-a
++b
+diff --git a/with space b/with space
+index 7898192..6178079 100644
+--- a/with space	
++++ b/with space	
+@@ -1 +1 @@
+-a
++b
+`,
+	},
+	{
+		name: "no newline at end of file",
+		old: map[string]testFile{
+			"add-newline":    blob("one\ntwo"),
+			"remove-newline": blob("one\ntwo\n"),
+			"both":           blob("one\ntwo"),
+			"context":        blob("one\ntwo\nthree"),
+		},
+		new: map[string]testFile{
+			"add-newline":    blob("one\ntwo\n"),
+			"remove-newline": blob("one\ntwo"),
+			"both":           blob("one\nthree"),
+			"context":        blob("changed\ntwo\nthree"),
+		},
+		want: `diff --git a/add-newline b/add-newline
+index 9ed40b4..814f4a4 100644
+--- a/add-newline
++++ b/add-newline
+@@ -1,2 +1,2 @@
+ one
+-two
+\ No newline at end of file
++two
+diff --git a/both b/both
+index 9ed40b4..7279b45 100644
+--- a/both
++++ b/both
+@@ -1,2 +1,2 @@
+ one
+-two
+\ No newline at end of file
++three
+\ No newline at end of file
+diff --git a/context b/context
+index 54d55bf..553fbd8 100644
+--- a/context
++++ b/context
+@@ -1,3 +1,3 @@
+-one
++changed
+ two
+ three
+\ No newline at end of file
+diff --git a/remove-newline b/remove-newline
+index 814f4a4..9ed40b4 100644
+--- a/remove-newline
++++ b/remove-newline
+@@ -1,2 +1,2 @@
+ one
+-two
++two
+\ No newline at end of file
+`,
+	},
+	{
+		name: "binary files",
+		old: map[string]testFile{
+			"changed":   blob("binary\x00data"),
+			"to-text":   blob("binary\x00data"),
+			"deleted":   blob("\x00"),
+			"mode-only": blob("same\x00data"),
+		},
+		new: map[string]testFile{
+			"changed":   blob("binary\x00data changed"),
+			"to-text":   blob("text\n"),
+			"added":     blob("new\x00binary"),
+			"mode-only": {rawgit.TreeExecutableBlobMode, "same\x00data"},
+		},
+		want: `diff --git a/added b/added
+new file mode 100644
+index 0000000..d2b944c
+Binary files /dev/null and b/added differ
+diff --git a/changed b/changed
+index 731e575..59fb894 100644
+Binary files a/changed and b/changed differ
+diff --git a/deleted b/deleted
+deleted file mode 100644
+index f76dd23..0000000
+Binary files a/deleted and /dev/null differ
+diff --git a/mode-only b/mode-only
+old mode 100644
+new mode 100755
+diff --git a/to-text b/to-text
+index 731e575..8e27be7 100644
+Binary files a/to-text and b/to-text differ
+`,
+	},
+	{
+		name: "zero context",
+		old:  map[string]testFile{"file": blob(numbered(20, nil)), "tail": blob("a\nb")},
+		new: map[string]testFile{
+			"file": blob(numbered(20, map[int]string{1: "first\n", 3: "", 10: "ten\nextra\n", 20: "last"})),
+			"tail": blob("a\nc"),
+		},
+		opts: PatchOptions{Context: -1},
+		want: `diff --git a/file b/file
+index c4352f8..6f0d32a 100644
+--- a/file
++++ b/file
+@@ -1 +1 @@
+-line 1
++first
+@@ -3 +2,0 @@ line 2
+-line 3
+@@ -10 +9,2 @@ line 9
+-line 10
++ten
++extra
+@@ -20 +20 @@ line 19
+-line 20
++last
+\ No newline at end of file
+diff --git a/tail b/tail
+index 0a207c0..817f660 100644
+--- a/tail
++++ b/tail
+@@ -2 +2 @@ a
+-b
+\ No newline at end of file
++c
+\ No newline at end of file
+`,
+	},
+}
+
+// writeTestTree writes files into repository and returns id of the root tree
+func writeTestTree(t *testing.T, repo rawgit.Repository, files map[string]testFile) *rawgit.OID {
+	dirs := make(map[string]map[string]testFile)
+	tree := &rawgit.Tree{}
+	for path, file := range files {
+		if slash := strings.IndexByte(path, '/'); slash != -1 {
+			dir := path[:slash]
+			if dirs[dir] == nil {
+				dirs[dir] = make(map[string]testFile)
+			}
+			dirs[dir][path[slash+1:]] = file
+			continue
+		}
+
+		oid, err := repo.WriteBlob([]byte(file.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree.Items = append(tree.Items, rawgit.TreeItem{Name: path, Mode: file.mode, OID: *oid})
+	}
+
+	for dir, files := range dirs {
+		oid := writeTestTree(t, repo, files)
+		tree.Items = append(tree.Items, rawgit.TreeItem{Name: dir, Mode: rawgit.TreeDirectoryMode, OID: *oid})
+	}
+
+	tree.Sort()
+	oid, err := repo.WriteTree(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return oid
+}
+
+func newTestRepository(t *testing.T) rawgit.Repository {
+	stor, err := fsstor.InitFSStorage(fsstor.NewOSFS(t.TempDir()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rawgit.NewRepository(stor, stor)
+}
+
+func TestWritePatch(t *testing.T) {
+	for _, test := range patchTests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := newTestRepository(t)
+			oldTree := writeTestTree(t, repo, test.old)
+			newTree := writeTestTree(t, repo, test.new)
+
+			changes, err := DiffTrees(repo, oldTree, newTree, &test.diffOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			err = WritePatch(&buf, repo, changes, &test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if buf.String() != test.want {
+				t.Errorf("patch differs from git:\n%s\nwant:\n%s", buf.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestWriteUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		opts     PatchOptions
+		want     string
+	}{
+		{
+			name: "equal texts",
+			old:  "same\n",
+			new:  "same\n",
+		},
+		{
+			name: "from empty",
+			new:  "one\ntwo",
+			want: "@@ -0,0 +1,2 @@\n+one\n+two\n\\ No newline at end of file\n",
+		},
+		{
+			name: "to empty",
+			old:  "one\n",
+			want: "@@ -1 +0,0 @@\n-one\n",
+		},
+		{
+			name: "merged hunks",
+			old:  numbered(12, nil),
+			new:  numbered(12, map[int]string{2: "two\n", 9: "nine\n"}),
+			want: "@@ -1,12 +1,12 @@\n line 1\n-line 2\n+two\n line 3\n line 4\n" +
+				" line 5\n line 6\n line 7\n line 8\n-line 9\n+nine\n line 10\n line 11\n line 12\n",
+		},
+		{
+			name: "split hunks",
+			old:  numbered(12, nil),
+			new:  numbered(12, map[int]string{2: "two\n", 9: "nine\n"}),
+			opts: PatchOptions{Context: 1},
+			want: "@@ -1,3 +1,3 @@\n line 1\n-line 2\n+two\n line 3\n" +
+				"@@ -8,3 +8,3 @@ line 7\n line 8\n-line 9\n+nine\n line 10\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := WriteUnified(&buf, []byte(test.old), []byte(test.new), &test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != test.want {
+				t.Errorf("got:\n%s\nwant:\n%s", buf.String(), test.want)
+			}
+		})
+	}
+}
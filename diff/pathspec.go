@@ -0,0 +1,85 @@
+package diff
+
+import (
+	"path"
+	"strings"
+)
+
+const wildcards = "*?["
+
+// MatchPathspec reports whether path matches git pathspec. Pathspec matches path
+// itself and everything under it, if it is a directory. Pathspecs with wildcards
+// are matched against the whole path, '*' matches slashes too
+func MatchPathspec(pathspec, filePath string) bool {
+	pathspec = strings.TrimSuffix(pathspec, "/")
+	if pathspec == "" || pathspec == "." {
+		return true
+	}
+
+	if filePath == pathspec || strings.HasPrefix(filePath, pathspec+"/") {
+		return true
+	}
+
+	if !strings.ContainsAny(pathspec, wildcards) {
+		return false
+	}
+	return matchWildcard(pathspec, filePath)
+}
+
+// mayMatchUnder reports whether pathspec may match some path inside directory
+func mayMatchUnder(pathspec, dir string) bool {
+	prefix := pathspec
+	if idx := strings.IndexAny(pathspec, wildcards); idx != -1 {
+		prefix = pathspec[:idx]
+		if strings.HasPrefix(dir+"/", prefix) {
+			return true
+		}
+	}
+
+	return strings.HasPrefix(prefix, dir+"/")
+}
+
+// matchWildcard matches like fnmatch without FNM_PATHNAME
+func matchWildcard(pattern, value string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for idx := 0; idx <= len(value); idx++ {
+				if matchWildcard(pattern[1:], value[idx:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if value == "" {
+				return false
+			}
+		case '[':
+			end := strings.IndexByte(pattern[1:], ']')
+			if end == -1 || value == "" {
+				return false
+			}
+
+			class := pattern[:end+2]
+			if strings.HasPrefix(class, "[!") {
+				class = "[^" + class[2:]
+			}
+			if matched, err := path.Match(class, value[:1]); err != nil || !matched {
+				return false
+			}
+			pattern, value = pattern[end+2:], value[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if value == "" || value[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, value = pattern[1:], value[1:]
+	}
+	return value == ""
+}
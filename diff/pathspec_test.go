@@ -0,0 +1,106 @@
+package diff
+
+import "testing"
+
+func TestMatchPathspec(t *testing.T) {
+	tests := []struct {
+		pathspec string
+		path     string
+		want     bool
+	}{
+		{pathspec: "", path: "file", want: true},
+		{pathspec: ".", path: "dir/file", want: true},
+		{pathspec: "dir", path: "dir", want: true},
+		{pathspec: "dir", path: "dir/sub/file", want: true},
+		{pathspec: "dir/", path: "dir/file", want: true},
+		{pathspec: "dir", path: "dirt", want: false},
+		{pathspec: "dir", path: "other/dir", want: false},
+		{pathspec: "dir/file", path: "dir", want: false},
+		{pathspec: "*.c", path: "main.c", want: true},
+		{pathspec: "*.c", path: "dir/sub/main.c", want: true},
+		{pathspec: "*.c", path: "main.h", want: false},
+		{pathspec: "dir/*.c", path: "dir/sub/main.c", want: true},
+		{pathspec: "dir/*.c", path: "other/main.c", want: false},
+		{pathspec: "d*", path: "dir/file", want: true},
+		{pathspec: "dir/?.c", path: "dir/a.c", want: true},
+		{pathspec: "dir/?.c", path: "dir/ab.c", want: false},
+		{pathspec: "[ab].c", path: "b.c", want: true},
+		{pathspec: "[!ab].c", path: "b.c", want: false},
+		{pathspec: "[!ab].c", path: "c.c", want: true},
+		{pathspec: `\*.c`, path: "*.c", want: true},
+		{pathspec: `\*.c`, path: "main.c", want: false},
+		// invalid patterns still match literally
+		{pathspec: "[a", path: "[a", want: true},
+		{pathspec: "[a", path: "a", want: false},
+	}
+
+	for _, test := range tests {
+		if got := MatchPathspec(test.pathspec, test.path); got != test.want {
+			t.Errorf("MatchPathspec(%q, %q) = %v, want %v", test.pathspec, test.path, got, test.want)
+		}
+	}
+}
+
+func TestMayMatchUnder(t *testing.T) {
+	tests := []struct {
+		pathspec string
+		dir      string
+		want     bool
+	}{
+		{pathspec: "dir/sub/file", dir: "dir", want: true},
+		{pathspec: "dir/sub/file", dir: "dir/sub", want: true},
+		{pathspec: "dir/sub/file", dir: "di", want: false},
+		{pathspec: "dir/sub/file", dir: "dir/sub/file", want: false},
+		{pathspec: "dir/sub/file", dir: "other", want: false},
+		{pathspec: "dir/*.c", dir: "dir", want: true},
+		{pathspec: "dir/*.c", dir: "dir/sub/deeper", want: true},
+		{pathspec: "dir/*.c", dir: "other", want: false},
+		{pathspec: "dir/*.c", dir: "dirt", want: false},
+		{pathspec: "*.c", dir: "any/dir", want: true},
+		{pathspec: "d*", dir: "dir", want: true},
+		{pathspec: "d*", dir: "other", want: false},
+		{pathspec: "di?/file", dir: "dir", want: true},
+		{pathspec: "dir/[ab]/file", dir: "dir", want: true},
+		{pathspec: "dir/[ab]/file", dir: "dir/a", want: true},
+		{pathspec: "dir/[ab]/file", dir: "other", want: false},
+	}
+
+	for _, test := range tests {
+		if got := mayMatchUnder(test.pathspec, test.dir); got != test.want {
+			t.Errorf("mayMatchUnder(%q, %q) = %v, want %v", test.pathspec, test.dir, got, test.want)
+		}
+	}
+}
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{pattern: "", value: "", want: true},
+		{pattern: "", value: "a", want: false},
+		{pattern: "*", value: "", want: true},
+		{pattern: "*", value: "a/b", want: true},
+		{pattern: "a*b", value: "ab", want: true},
+		{pattern: "a*b", value: "axx/yb", want: true},
+		{pattern: "a*b", value: "axxc", want: false},
+		{pattern: "*a*", value: "bab", want: true},
+		{pattern: "a?", value: "a", want: false},
+		{pattern: "a?", value: "a/", want: true},
+		{pattern: "[a-c]x", value: "bx", want: true},
+		{pattern: "[a-c]x", value: "dx", want: false},
+		{pattern: "[!a-c]x", value: "dx", want: true},
+		{pattern: "[a-c]", value: "", want: false},
+		{pattern: "[abc", value: "a", want: false},
+		{pattern: `\?`, value: "?", want: true},
+		{pattern: `\?`, value: "a", want: false},
+		{pattern: `a\`, value: `a\`, want: true},
+	}
+
+	for _, test := range tests {
+		if got := matchWildcard(test.pattern, test.value); got != test.want {
+			t.Errorf("matchWildcard(%q, %q) = %v, want %v", test.pattern, test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package diff
+
+// patience diff matches lines, which are unique on both sides, and recurses into
+// ranges between them, as git's xpatience does. Ranges without unique common
+// lines are compared with myers diff
+
+type patience struct {
+	f1, f2 *xdfile
+}
+
+// patienceEntry is a line of the first range. Lines are counted from one
+type patienceEntry struct {
+	line1, line2 int
+	// line is not unique on one of sides
+	nonUnique      bool
+	next, previous *patienceEntry
+}
+
+// diff marks changes in ranges of lines, counted from one
+func (p *patience) diff(line1, count1, line2, count2 int) {
+	switch {
+	case count1 == 0:
+		for ; count2 > 0; count2-- {
+			p.f2.setChanged(line2-1, true)
+			line2++
+		}
+		return
+	case count2 == 0:
+		for ; count1 > 0; count1-- {
+			p.f1.setChanged(line1-1, true)
+			line1++
+		}
+		return
+	}
+
+	first, hasMatches := p.uniqueLines(line1, count1, line2, count2)
+	if !hasMatches {
+		for idx := 0; idx < count1; idx++ {
+			p.f1.setChanged(line1-1+idx, true)
+		}
+		for idx := 0; idx < count2; idx++ {
+			p.f2.setChanged(line2-1+idx, true)
+		}
+		return
+	}
+
+	sequence := longestCommonSequence(first)
+	if sequence != nil {
+		p.walkCommonSequence(sequence, line1, count1, line2, count2)
+	} else {
+		p.fallBack(line1, count1, line2, count2)
+	}
+}
+
+// uniqueLines returns lines of the first range in order, with matches in the
+// second range, and whether any line matched
+func (p *patience) uniqueLines(line1, count1, line2, count2 int) (*patienceEntry, bool) {
+	entries := make(map[int]*patienceEntry)
+	var first, last *patienceEntry
+	for line := line1; line < line1+count1; line++ {
+		class := p.f1.class[line-1]
+		if entry, ok := entries[class]; ok {
+			entry.nonUnique = true
+			continue
+		}
+
+		entry := &patienceEntry{line1: line}
+		entries[class] = entry
+		if first == nil {
+			first = entry
+		}
+		if last != nil {
+			last.next = entry
+			entry.previous = last
+		}
+		last = entry
+	}
+
+	hasMatches := false
+	for line := line2; line < line2+count2; line++ {
+		entry, ok := entries[p.f2.class[line-1]]
+		if !ok {
+			continue
+		}
+
+		hasMatches = true
+		if entry.line2 != 0 {
+			entry.nonUnique = true
+		} else {
+			entry.line2 = line
+		}
+	}
+
+	return first, hasMatches
+}
+
+// longestCommonSequence finds the longest sequence of unique common lines, which
+// are in order on both sides, and links it with next pointers
+func longestCommonSequence(first *patienceEntry) *patienceEntry {
+	// the best sequence of each length ends with the smallest line2
+	var sequence []*patienceEntry
+	for entry := first; entry != nil; entry = entry.next {
+		if entry.line2 == 0 || entry.nonUnique {
+			continue
+		}
+
+		// find the longest sequence with smaller last element
+		left, right := -1, len(sequence)
+		for left+1 < right {
+			middle := left + (right-left)/2
+			if sequence[middle].line2 > entry.line2 {
+				right = middle
+			} else {
+				left = middle
+			}
+		}
+
+		entry.previous = nil
+		if left >= 0 {
+			entry.previous = sequence[left]
+		}
+		if left+1 == len(sequence) {
+			sequence = append(sequence, entry)
+		} else {
+			sequence[left+1] = entry
+		}
+	}
+
+	if len(sequence) == 0 {
+		return nil
+	}
+
+	entry := sequence[len(sequence)-1]
+	entry.next = nil
+	for entry.previous != nil {
+		entry.previous.next = entry
+		entry = entry.previous
+	}
+	return entry
+}
+
+func (p *patience) match(line1, line2 int) bool {
+	return p.f1.class[line1-1] == p.f2.class[line2-1]
+}
+
+// walkCommonSequence grows ranges of common lines around the sequence and
+// recurses into ranges between them
+func (p *patience) walkCommonSequence(first *patienceEntry, line1, count1, line2, count2 int) {
+	end1, end2 := line1+count1, line2+count2
+	for {
+		var next1, next2 int
+		if first != nil {
+			next1, next2 = first.line1, first.line2
+			for next1 > line1 && next2 > line2 && p.match(next1-1, next2-1) {
+				next1--
+				next2--
+			}
+		} else {
+			next1, next2 = end1, end2
+		}
+		for line1 < next1 && line2 < next2 && p.match(line1, line2) {
+			line1++
+			line2++
+		}
+
+		if next1 > line1 || next2 > line2 {
+			p.diff(line1, next1-line1, line2, next2-line2)
+		}
+
+		if first == nil {
+			return
+		}
+
+		for first.next != nil && first.next.line1 == first.line1+1 && first.next.line2 == first.line2+1 {
+			first = first.next
+		}
+
+		line1, line2 = first.line1+1, first.line2+1
+		first = first.next
+	}
+}
+
+// fallBack compares ranges with myers diff, as separate texts
+func (p *patience) fallBack(line1, count1, line2, count2 int) {
+	f1, f2 := doDiff(p.f1.recs[line1-1:line1-1+count1], p.f2.recs[line2-1:line2-1+count2], Myers)
+	copy(p.f1.rchg[line1-1:], f1.rchg)
+	copy(p.f2.rchg[line2-1:], f2.rchg)
+}
@@ -0,0 +1,506 @@
+package diff
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+const (
+	DefaultRenameThreshold = 50
+	DefaultRenameLimit     = 1000
+
+	// similarity scores are scaled to maxScore, as in git
+	maxScore = 60000
+	// number of best sources remembered for each destination
+	numCandidates = 4
+)
+
+// renameSource is a file, which may be renamed or copied
+type renameSource struct {
+	change *Change
+	// number of destinations taken from source. Sources, which are not deleted,
+	// start from 1, so they are reported as copies
+	used int
+}
+
+type renameCandidate struct {
+	dst, src  int
+	score     int
+	nameScore int
+}
+
+// renameDetector pairs added files with their sources, like diffcore-rename
+type renameDetector struct {
+	repo     rawgit.Storage
+	copies   bool
+	minScore int
+	limit    int
+	harder   bool
+
+	sources []*renameSource
+	dsts    []*Change
+	// source of destination, indexed as dsts
+	matches []*renameSource
+	scores  []int
+	blobs   map[rawgit.OID]*blobData
+}
+
+// blobData is blob loaded for similarity estimation
+type blobData struct {
+	size  uint64
+	spans map[uint32]int
+}
+
+// detectRenames replaces added files and their sources with renamed and copied
+// pairs. Unmodified files are dropped from result
+func detectRenames(repo rawgit.Storage, changes []*Change, opts *Options) ([]*Change, error) {
+	d := &renameDetector{
+		repo:     repo,
+		copies:   opts.DetectCopies || opts.FindCopiesHarder,
+		harder:   opts.FindCopiesHarder,
+		minScore: DefaultRenameThreshold * maxScore / 100,
+		limit:    DefaultRenameLimit,
+		blobs:    make(map[rawgit.OID]*blobData),
+	}
+	if opts.RenameThreshold > 0 {
+		d.minScore = opts.RenameThreshold * maxScore / 100
+		if d.minScore > maxScore {
+			d.minScore = maxScore
+		}
+	}
+	if opts.RenameLimit != 0 {
+		d.limit = opts.RenameLimit
+	}
+
+	for _, change := range changes {
+		switch change.Type {
+		case Added:
+			d.dsts = append(d.dsts, change)
+		case Deleted:
+			d.sources = append(d.sources, &renameSource{change: change})
+		case Modified, TypeChanged, unmodified:
+			if d.copies {
+				d.sources = append(d.sources, &renameSource{change: change, used: 1})
+			}
+		}
+	}
+	d.matches = make([]*renameSource, len(d.dsts))
+	d.scores = make([]int, len(d.dsts))
+
+	if len(d.dsts) > 0 && len(d.sources) > 0 {
+		d.findExact()
+		if !d.copies {
+			d.dropUsedSources()
+			if err := d.findByBasename(); err != nil {
+				return nil, err
+			}
+			d.dropUsedSources()
+		}
+		if err := d.findSimilar(); err != nil {
+			return nil, err
+		}
+	}
+
+	return d.result(changes), nil
+}
+
+// findExact pairs destinations with sources of the same content, preferring
+// unused sources with the same name
+func (d *renameDetector) findExact() {
+	for idx, dst := range d.dsts {
+		var best *renameSource
+		bestScore := -1
+		for _, src := range d.sources {
+			old := &src.change.Old
+			if old.OID != dst.New.OID {
+				continue
+			}
+			if (!isRegular(old.Mode) || !isRegular(dst.New.Mode)) && old.Mode != dst.New.Mode {
+				continue
+			}
+			if src.used > 0 && !d.copies {
+				continue
+			}
+
+			score := 0
+			if src.used == 0 {
+				score++
+			}
+			if sameBasename(old.Path, dst.New.Path) {
+				score++
+			}
+			if score > bestScore {
+				best, bestScore = src, score
+			}
+			if score == 2 {
+				break
+			}
+		}
+
+		if best != nil {
+			d.match(idx, best, maxScore)
+		}
+	}
+}
+
+// findByBasename pairs files, whose names are unique among remaining sources and
+// destinations, if they are similar enough
+func (d *renameDetector) findByBasename() error {
+	minScore := d.minScore + (maxScore-d.minScore)/2
+
+	srcNames := make(map[string]int)
+	for idx, src := range d.sources {
+		name := basename(src.change.Old.Path)
+		if _, ok := srcNames[name]; ok {
+			srcNames[name] = -1
+		} else {
+			srcNames[name] = idx
+		}
+	}
+
+	dstNames := make(map[string]int)
+	for idx, dst := range d.dsts {
+		if d.matches[idx] != nil {
+			continue
+		}
+		name := basename(dst.New.Path)
+		if _, ok := dstNames[name]; ok {
+			dstNames[name] = -1
+		} else {
+			dstNames[name] = idx
+		}
+	}
+
+	for idx, dst := range d.dsts {
+		name := basename(dst.New.Path)
+		if d.matches[idx] != nil || dstNames[name] != idx {
+			continue
+		}
+		srcIdx, ok := srcNames[name]
+		if !ok || srcIdx == -1 {
+			continue
+		}
+
+		src := d.sources[srcIdx]
+		if src.used > 0 {
+			continue
+		}
+
+		score, err := d.similarity(&src.change.Old, &dst.New, minScore)
+		if err != nil {
+			return err
+		}
+		if score >= minScore {
+			d.match(idx, src, score)
+		}
+	}
+	return nil
+}
+
+// findSimilar estimates similarity of every remaining pair and takes best pairs
+// first, renames before copies
+func (d *renameDetector) findSimilar() error {
+	var dsts []int
+	for idx := range d.dsts {
+		if d.matches[idx] == nil {
+			dsts = append(dsts, idx)
+		}
+	}
+	if len(dsts) == 0 || len(d.sources) == 0 {
+		return nil
+	}
+
+	sources := d.sources
+	if d.tooManyCandidates(len(dsts), len(sources)) {
+		if !d.harder {
+			return nil
+		}
+
+		// try without unmodified files, like git does
+		sources = nil
+		for _, src := range d.sources {
+			if src.change.Type != unmodified {
+				sources = append(sources, src)
+			}
+		}
+		if d.tooManyCandidates(len(dsts), len(sources)) {
+			return nil
+		}
+	}
+
+	var candidates []renameCandidate
+	for _, dstIdx := range dsts {
+		dst := d.dsts[dstIdx]
+		var best []renameCandidate
+		for srcIdx, src := range sources {
+			score, err := d.similarity(&src.change.Old, &dst.New, d.minScore)
+			if err != nil {
+				return err
+			}
+
+			candidate := renameCandidate{dst: dstIdx, src: srcIdx, score: score}
+			if sameBasename(src.change.Old.Path, dst.New.Path) {
+				candidate.nameScore = 1
+			}
+			best = keepBest(best, candidate)
+		}
+		candidates = append(candidates, best...)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return betterCandidate(&candidates[i], &candidates[j])
+	})
+
+	d.takeCandidates(candidates, sources, false)
+	if d.copies {
+		d.takeCandidates(candidates, sources, true)
+	}
+	return nil
+}
+
+func (d *renameDetector) tooManyCandidates(dsts, sources int) bool {
+	if d.limit < 0 {
+		return false
+	}
+	return uint64(dsts)*uint64(sources) > uint64(d.limit)*uint64(d.limit)
+}
+
+// takeCandidates matches destinations in order of candidates. Used sources are
+// taken only for copies
+func (d *renameDetector) takeCandidates(candidates []renameCandidate, sources []*renameSource, copies bool) {
+	for _, candidate := range candidates {
+		if candidate.score < d.minScore {
+			break
+		}
+		if d.matches[candidate.dst] != nil {
+			continue
+		}
+
+		src := sources[candidate.src]
+		if !copies && src.used > 0 {
+			continue
+		}
+		d.match(candidate.dst, src, candidate.score)
+	}
+}
+
+func (d *renameDetector) match(dst int, src *renameSource, score int) {
+	d.matches[dst] = src
+	d.scores[dst] = score
+	src.used++
+}
+
+// dropUsedSources removes sources, which are already renamed
+func (d *renameDetector) dropUsedSources() {
+	sources := d.sources[:0]
+	for _, src := range d.sources {
+		if src.used == 0 {
+			sources = append(sources, src)
+		}
+	}
+	d.sources = sources
+}
+
+// result builds change list in original order. Destination pairs take place of
+// added files, deleted files, which were renamed, are dropped. Source taken by
+// several destinations is renamed by the last one and copied by the others
+func (d *renameDetector) result(changes []*Change) []*Change {
+	dstIndex := make(map[*Change]int, len(d.dsts))
+	for idx, dst := range d.dsts {
+		dstIndex[dst] = idx
+	}
+
+	renamed := make(map[*Change]bool)
+	for _, src := range d.matches {
+		if src != nil {
+			renamed[src.change] = true
+		}
+	}
+
+	var result []*Change
+	for _, change := range changes {
+		switch {
+		case change.Type == unmodified:
+			continue
+		case change.Type == Deleted && renamed[change]:
+			continue
+		case change.Type != Added:
+			result = append(result, change)
+			continue
+		}
+
+		idx := dstIndex[change]
+		src := d.matches[idx]
+		if src == nil {
+			result = append(result, change)
+			continue
+		}
+
+		pair := &Change{
+			Type:  Renamed,
+			Old:   src.change.Old,
+			New:   change.New,
+			Score: d.scores[idx] * 100 / maxScore,
+		}
+		src.used--
+		if src.used > 0 {
+			pair.Type = Copied
+		}
+		result = append(result, pair)
+	}
+
+	return result
+}
+
+// similarity estimates how much of src content is kept in dst, scaled to
+// maxScore. Pairs which obviously score less than minScore get zero
+func (d *renameDetector) similarity(src, dst *Entry, minScore int) (int, error) {
+	if !isRegular(src.Mode) || !isRegular(dst.Mode) {
+		return 0, nil
+	}
+
+	srcSize, err := d.size(&src.OID)
+	if err != nil {
+		return 0, err
+	}
+	dstSize, err := d.size(&dst.OID)
+	if err != nil {
+		return 0, err
+	}
+
+	maxSize, minSize := srcSize, dstSize
+	if maxSize < minSize {
+		maxSize, minSize = minSize, maxSize
+	}
+	delta := maxSize - minSize
+	// too much of content was added or removed
+	if maxSize*uint64(maxScore-minScore) < delta*maxScore {
+		return 0, nil
+	}
+	if dstSize == 0 {
+		return 0, nil
+	}
+
+	srcBlob, err := d.load(&src.OID)
+	if err != nil {
+		return 0, err
+	}
+	dstBlob, err := d.load(&dst.OID)
+	if err != nil {
+		return 0, err
+	}
+
+	var copied uint64
+	for hash, count := range srcBlob.spans {
+		dstCount := dstBlob.spans[hash]
+		if dstCount < count {
+			count = dstCount
+		}
+		copied += uint64(count)
+	}
+
+	return int(copied * maxScore / maxSize), nil
+}
+
+func (d *renameDetector) size(oid *rawgit.OID) (uint64, error) {
+	if blob, ok := d.blobs[*oid]; ok {
+		return blob.size, nil
+	}
+
+	info, _, err := d.repo.StatObject(oid)
+	if err != nil {
+		return 0, err
+	}
+
+	d.blobs[*oid] = &blobData{size: info.Size}
+	return info.Size, nil
+}
+
+func (d *renameDetector) load(oid *rawgit.OID) (*blobData, error) {
+	blob, ok := d.blobs[*oid]
+	if ok && blob.spans != nil {
+		return blob, nil
+	}
+
+	data, err := readBlob(d.repo, oid)
+	if err != nil {
+		return nil, err
+	}
+
+	blob = &blobData{size: uint64(len(data)), spans: hashSpans(data)}
+	d.blobs[*oid] = blob
+	return blob, nil
+}
+
+// hashSpans counts bytes in content chunks by chunk hash, as in git's
+// diffcore-delta. Chunks end at newline or after 64 bytes. CR before LF is
+// ignored in text files. Like git, incomplete chunk at the end is not counted
+func hashSpans(data []byte) map[uint32]int {
+	const hashBase = 107927
+
+	isText := !IsBinary(data)
+	spans := make(map[uint32]int)
+
+	var accum1, accum2 uint32
+	n := 0
+	for idx := 0; idx < len(data); idx++ {
+		c := data[idx]
+		if isText && c == '\r' && idx+1 < len(data) && data[idx+1] == '\n' {
+			continue
+		}
+
+		old1 := accum1
+		accum1 = accum1<<7 ^ accum2>>25
+		accum2 = accum2<<7 ^ old1>>25
+		accum1 += uint32(c)
+		n++
+		if n < 64 && c != '\n' {
+			continue
+		}
+
+		spans[(accum1+accum2*0x61)%hashBase] += n
+		accum1, accum2, n = 0, 0, 0
+	}
+
+	return spans
+}
+
+// keepBest replaces the worst of best candidates, if candidate is better. Slots
+// keep their places, so candidates of equal score stay in order of sources
+func keepBest(best []renameCandidate, candidate renameCandidate) []renameCandidate {
+	if len(best) < numCandidates {
+		return append(best, candidate)
+	}
+
+	worst := 0
+	for idx := 1; idx < len(best); idx++ {
+		if betterCandidate(&best[worst], &best[idx]) {
+			worst = idx
+		}
+	}
+	if betterCandidate(&candidate, &best[worst]) {
+		best[worst] = candidate
+	}
+	return best
+}
+
+func betterCandidate(a, b *renameCandidate) bool {
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	return a.nameScore > b.nameScore
+}
+
+func isRegular(mode uint32) bool {
+	return mode&0170000 == rawgit.TreeBlobMode&0170000
+}
+
+func basename(path string) string {
+	return path[strings.LastIndexByte(path, '/')+1:]
+}
+
+func sameBasename(a, b string) bool {
+	return basename(a) == basename(b)
+}
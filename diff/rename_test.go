@@ -0,0 +1,180 @@
+package diff
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// formatNameStatus formats changes like 'git diff --name-status'
+func formatNameStatus(changes []*Change) []string {
+	var result []string
+	for _, change := range changes {
+		switch change.Type {
+		case Renamed, Copied:
+			result = append(result, fmt.Sprintf("%s%03d\t%s\t%s", change.Type, change.Score, change.Old.Path, change.New.Path))
+		default:
+			result = append(result, fmt.Sprintf("%s\t%s", change.Type, change.Path()))
+		}
+	}
+	return result
+}
+
+// renameTests are compared with output of 'git diff --name-status' with -M, -C,
+// --find-copies-harder and -l for the same trees
+var renameTests = []struct {
+	name     string
+	old, new map[string]testFile
+	opts     Options
+	want     []string
+}{
+	{
+		name: "exact rename",
+		old:  map[string]testFile{"old/name.txt": blob(numbered(10, nil))},
+		new:  map[string]testFile{"new/name.txt": blob(numbered(10, nil))},
+		opts: Options{DetectRenames: true},
+		want: []string{"R100\told/name.txt\tnew/name.txt"},
+	},
+	{
+		name: "exact rename prefers source of the same name",
+		old: map[string]testFile{
+			"a/other": blob(numbered(10, nil)),
+			"b/file":  blob(numbered(10, nil)),
+		},
+		new:  map[string]testFile{"c/file": blob(numbered(10, nil))},
+		opts: Options{DetectRenames: true},
+		want: []string{"D\ta/other", "R100\tb/file\tc/file"},
+	},
+	{
+		name: "similar rename",
+		old:  map[string]testFile{"file.c": blob(numbered(20, nil))},
+		new:  map[string]testFile{"moved.c": blob(numbered(20, map[int]string{5: "changed\n"}))},
+		opts: Options{DetectRenames: true},
+		want: []string{"R094\tfile.c\tmoved.c"},
+	},
+	{
+		name: "similarity below threshold",
+		old:  map[string]testFile{"file.c": blob(numbered(20, nil))},
+		new:  map[string]testFile{"moved.c": blob(numbered(20, map[int]string{5: "changed\n"}))},
+		opts: Options{DetectRenames: true, RenameThreshold: 99},
+		want: []string{"D\tfile.c", "A\tmoved.c"},
+	},
+	{
+		name: "dissimilar files",
+		old:  map[string]testFile{"file.c": blob(numbered(10, nil))},
+		new: map[string]testFile{"moved.c": blob(numbered(10, map[int]string{
+			1: "one\n", 2: "two\n", 3: "three\n", 4: "four\n", 5: "five\n", 6: "six\n",
+		}))},
+		opts: Options{DetectRenames: true},
+		want: []string{"D\tfile.c", "A\tmoved.c"},
+	},
+	{
+		name: "rename limit",
+		old: map[string]testFile{
+			"a": blob(numbered(10, nil)),
+			"b": blob(numbered(12, nil)),
+			"c": blob(numbered(14, nil)),
+		},
+		new: map[string]testFile{
+			"d": blob(numbered(10, nil)),
+			"e": blob(numbered(12, map[int]string{1: "changed\n"})),
+			"f": blob(numbered(14, map[int]string{1: "changed\n"})),
+		},
+		opts: Options{DetectRenames: true, RenameLimit: 1},
+		want: []string{"D\tb", "D\tc", "R100\ta\td", "A\te", "A\tf"},
+	},
+	{
+		name: "rename limit allows remaining pairs",
+		old: map[string]testFile{
+			"a": blob(numbered(10, nil)),
+			"b": blob(numbered(12, nil)),
+		},
+		new: map[string]testFile{
+			"d": blob(numbered(10, nil)),
+			"e": blob(numbered(12, map[int]string{1: "changed\n"})),
+		},
+		opts: Options{DetectRenames: true, RenameLimit: 1},
+		want: []string{"R100\ta\td", "R090\tb\te"},
+	},
+	{
+		name: "basename match is taken before better source",
+		old: map[string]testFile{
+			"a/file.c":  blob(numbered(20, map[int]string{1: "a1\n", 2: "a2\n"})),
+			"b/other.c": blob(numbered(20, nil)),
+		},
+		new:  map[string]testFile{"c/file.c": blob(numbered(20, map[int]string{20: "changed\n"}))},
+		opts: Options{DetectRenames: true},
+		want: []string{"D\tb/other.c", "R085\ta/file.c\tc/file.c"},
+	},
+	{
+		name: "equal scores prefer source of the same name",
+		old: map[string]testFile{
+			"a/other": blob(numbered(10, map[int]string{6: "a6\n", 7: "a7\n", 8: "a8\n", 9: "a9\n", 10: "a10\n"})),
+			"b/file":  blob(numbered(10, map[int]string{6: "b6\n", 7: "b7\n", 8: "b8\n", 9: "b9\n", 10: "b10\n"})),
+		},
+		new: map[string]testFile{
+			"c/file": blob(numbered(10, map[int]string{6: "c6\n", 7: "c7\n", 8: "c8\n", 9: "c9\n", 10: "c10\n"})),
+		},
+		opts: Options{DetectRenames: true},
+		want: []string{"D\ta/other", "R068\tb/file\tc/file"},
+	},
+	{
+		name: "copy of modified file",
+		old:  map[string]testFile{"orig": blob(numbered(10, nil))},
+		new: map[string]testFile{
+			"copy": blob(numbered(10, map[int]string{10: "changed\n"})),
+			"orig": blob(numbered(10, map[int]string{1: "changed\n"})),
+		},
+		opts: Options{DetectCopies: true},
+		want: []string{"C088\torig\tcopy", "M\torig"},
+	},
+	{
+		name: "copy of unmodified file",
+		old:  map[string]testFile{"kept": blob(numbered(10, nil))},
+		new: map[string]testFile{
+			"copy": blob(numbered(10, nil)),
+			"kept": blob(numbered(10, nil)),
+		},
+		opts: Options{DetectCopies: true},
+		want: []string{"A\tcopy"},
+	},
+	{
+		name: "copy of unmodified file with harder search",
+		old:  map[string]testFile{"kept": blob(numbered(10, nil))},
+		new: map[string]testFile{
+			"copy": blob(numbered(10, nil)),
+			"kept": blob(numbered(10, nil)),
+		},
+		opts: Options{FindCopiesHarder: true},
+		want: []string{"C100\tkept\tcopy"},
+	},
+	{
+		name: "rename and copies of deleted file",
+		old:  map[string]testFile{"src": blob(numbered(10, nil))},
+		new: map[string]testFile{
+			"a": blob(numbered(10, nil)),
+			"b": blob(numbered(10, map[int]string{1: "changed\n"})),
+			"c": blob(numbered(10, nil)),
+		},
+		opts: Options{DetectCopies: true},
+		want: []string{"C100\tsrc\ta", "C088\tsrc\tb", "R100\tsrc\tc"},
+	},
+}
+
+func TestDetectRenames(t *testing.T) {
+	for _, test := range renameTests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := newTestRepository(t)
+			oldTree := writeTestTree(t, repo, test.old)
+			newTree := writeTestTree(t, repo, test.new)
+
+			changes, err := DiffTrees(repo, oldTree, newTree, &test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := formatNameStatus(changes); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got changes %q, want %q", got, test.want)
+			}
+		})
+	}
+}
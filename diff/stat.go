@@ -0,0 +1,498 @@
+package diff
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+const (
+	DefaultStatWidth = 80
+	// directories with smaller share of changes are not shown by dirstat
+	DefaultDirStatPermille = 30
+
+	// binary files are counted in lines of this size by DirStatLines
+	binaryLineSize = 64
+)
+
+// FileStat is count of changed lines of file, as in 'git diff --numstat'
+type FileStat struct {
+	*Change
+	Added   int
+	Deleted int
+	// binary files are not split into lines, Added and Deleted are sizes of new
+	// and old contents then. Both are zero, if contents are equal
+	Binary bool
+}
+
+// Name returns path of file, as 'git diff --stat' shows it. Renamed and copied
+// files are shown with both paths, like 'dir/{old => new}'
+func (s *FileStat) Name() string {
+	if s.Type != Renamed && s.Type != Copied {
+		return quotePath(s.Path())
+	}
+	return renameName(s.Old.Path, s.New.Path)
+}
+
+// DiffStat counts changed lines of files. Texts are compared only if ids
+// differ, so pure renames and mode changes are cheap
+func DiffStat(repo rawgit.Repository, changes []*Change, algorithm Algorithm) ([]*FileStat, error) {
+	stats := make([]*FileStat, 0, len(changes))
+	for _, change := range changes {
+		oldText, err := ReadContent(repo, &change.Old)
+		if err != nil {
+			return nil, err
+		}
+
+		var newText = oldText
+		sameContents := change.Old.Exists() && change.New.Exists() && change.Old.OID == change.New.OID
+		if !sameContents {
+			newText, err = ReadContent(repo, &change.New)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		stat := &FileStat{Change: change}
+		switch {
+		case IsBinary(oldText) || IsBinary(newText):
+			stat.Binary = true
+			if !sameContents {
+				stat.Added, stat.Deleted = len(newText), len(oldText)
+			}
+		case !sameContents:
+			for _, edit := range DiffLines(oldText, newText, algorithm) {
+				stat.Added += edit.NewLines
+				stat.Deleted += edit.OldLines
+			}
+		}
+		stats = append(stats, stat)
+	}
+
+	return stats, nil
+}
+
+// CommitStat counts changed lines of commit against its first parent, as 'git
+// show --numstat' does. Root commits are compared with empty tree
+func CommitStat(repo rawgit.Repository, commit *rawgit.Commit, opts *Options, algorithm Algorithm) ([]*FileStat, error) {
+	changes, err := DiffCommit(repo, commit, opts)
+	if err != nil {
+		return nil, err
+	}
+	return DiffStat(repo, changes, algorithm)
+}
+
+// StatSummary is totals of diff, as in 'git diff --shortstat'. Binary files
+// are not counted in insertions and deletions
+type StatSummary struct {
+	Files      int
+	Insertions int
+	Deletions  int
+}
+
+func Summarize(stats []*FileStat) StatSummary {
+	summary := StatSummary{Files: len(stats)}
+	for _, stat := range stats {
+		if !stat.Binary {
+			summary.Insertions += stat.Added
+			summary.Deletions += stat.Deleted
+		}
+	}
+	return summary
+}
+
+// String formats summary as git does, like ' 1 file changed, 2 insertions(+)'
+func (s StatSummary) String() string {
+	if s.Files == 0 {
+		return " 0 files changed"
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, " %d %s changed", s.Files, plural(s.Files, "file", "files"))
+	if s.Insertions != 0 || s.Deletions == 0 {
+		fmt.Fprintf(&buf, ", %d %s(+)", s.Insertions, plural(s.Insertions, "insertion", "insertions"))
+	}
+	if s.Deletions != 0 || s.Insertions == 0 {
+		fmt.Fprintf(&buf, ", %d %s(-)", s.Deletions, plural(s.Deletions, "deletion", "deletions"))
+	}
+	return buf.String()
+}
+
+// WriteNumStat writes stats as 'git diff --numstat' does
+func WriteNumStat(w io.Writer, stats []*FileStat) error {
+	out := bufio.NewWriter(w)
+	for _, stat := range stats {
+		if stat.Binary {
+			fmt.Fprintf(out, "-\t-\t%s\n", stat.Name())
+		} else {
+			fmt.Fprintf(out, "%d\t%d\t%s\n", stat.Added, stat.Deleted, stat.Name())
+		}
+	}
+	return out.Flush()
+}
+
+// WriteStat writes stats with graph of changes and summary, as 'git diff
+// --stat=width' does. Width is DefaultStatWidth if zero
+func WriteStat(w io.Writer, stats []*FileStat, width int) error {
+	if len(stats) == 0 {
+		return nil
+	}
+	if width == 0 {
+		width = DefaultStatWidth
+	}
+
+	maxChange, maxNameLen, numberWidth, binWidth := 0, 0, 0, 0
+	names := make([]string, len(stats))
+	for idx, stat := range stats {
+		names[idx] = stat.Name()
+		maxNameLen = maxInt(maxNameLen, len(names[idx]))
+		if stat.Binary {
+			// "Bin XXX -> YYY bytes", counts are aligned with "Bin"
+			binWidth = maxInt(binWidth, 14+decimalWidth(stat.Added)+decimalWidth(stat.Deleted))
+			numberWidth = 3
+			continue
+		}
+		maxChange = maxInt(maxChange, stat.Added+stat.Deleted)
+	}
+
+	numberWidth = maxInt(numberWidth, decimalWidth(maxChange))
+	// leave at least 10 columns for name and 6 for graph
+	width = maxInt(width, 16+6+numberWidth)
+
+	graphWidth := maxChange
+	if maxChange+4 <= binWidth {
+		graphWidth = binWidth - 4
+	}
+	nameWidth := maxNameLen
+
+	// name takes at least 5/8 of width, if everything does not fit
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = maxInt(width*3/8-numberWidth-6, 6)
+		}
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	out := bufio.NewWriter(w)
+	for idx, stat := range stats {
+		// long names are cut at directory boundary
+		name, prefix := names[idx], ""
+		length := nameWidth
+		if len(name) > nameWidth {
+			prefix = "..."
+			length = maxInt(length-3, 0)
+			name = name[len(name)-length:]
+			if slash := strings.IndexByte(name, '/'); slash != -1 {
+				name = name[slash:]
+			}
+		}
+		padding := strings.Repeat(" ", maxInt(length-len(name), 0))
+
+		if stat.Binary {
+			fmt.Fprintf(out, " %s%s%s | %*s", prefix, name, padding, numberWidth, "Bin")
+			if stat.Added != 0 || stat.Deleted != 0 {
+				fmt.Fprintf(out, " %d -> %d bytes", stat.Deleted, stat.Added)
+			}
+			out.WriteString("\n")
+			continue
+		}
+
+		added, deleted := stat.Added, stat.Deleted
+		if graphWidth <= maxChange {
+			total := scaleLinear(added+deleted, graphWidth, maxChange)
+			if total < 2 && added != 0 && deleted != 0 {
+				total = 2
+			}
+			if added < deleted {
+				added = scaleLinear(added, graphWidth, maxChange)
+				deleted = total - added
+			} else {
+				deleted = scaleLinear(deleted, graphWidth, maxChange)
+				added = total - deleted
+			}
+		}
+
+		fmt.Fprintf(out, " %s%s%s | %*d", prefix, name, padding, numberWidth, stat.Added+stat.Deleted)
+		if stat.Added+stat.Deleted != 0 {
+			out.WriteString(" ")
+		}
+		out.WriteString(strings.Repeat("+", added))
+		out.WriteString(strings.Repeat("-", deleted))
+		out.WriteString("\n")
+	}
+
+	fmt.Fprintln(out, Summarize(stats))
+	return out.Flush()
+}
+
+type DirStatMode int
+
+const (
+	// count changed bytes, as git does by default
+	DirStatChanges DirStatMode = iota
+	// count changed lines, binary files are counted in 64 byte lines
+	DirStatLines
+	// count changed files
+	DirStatFiles
+)
+
+type DirStatOptions struct {
+	Mode DirStatMode
+	// minimum share of changes of shown directories, in permille.
+	// DefaultDirStatPermille if zero, all directories with changes if negative
+	MinPermille int
+	// count changes in subdirectories towards parent directory even if
+	// subdirectories are shown
+	Cumulative bool
+}
+
+// DirShare is share of changes made in directory, in permille
+type DirShare struct {
+	// directory path with trailing slash
+	Dir      string
+	Permille int
+}
+
+// DirStat distributes changes over directories, as 'git diff --dirstat' does.
+// Directories are listed in path order, subdirectories before their parents.
+// Directories are not shown, if all of their changes are in one subdirectory
+func DirStat(repo rawgit.Repository, stats []*FileStat, opts *DirStatOptions) ([]DirShare, error) {
+	if opts == nil {
+		opts = &DirStatOptions{}
+	}
+
+	gatherer := &dirStatGatherer{opts: opts, minPermille: opts.MinPermille}
+	switch {
+	case opts.MinPermille == 0:
+		gatherer.minPermille = DefaultDirStatPermille
+	case opts.MinPermille < 0:
+		gatherer.minPermille = 0
+	}
+
+	for _, stat := range stats {
+		damage, err := fileDamage(repo, stat, opts.Mode)
+		if err != nil {
+			return nil, err
+		}
+
+		gatherer.files = append(gatherer.files, dirStatFile{path: stat.Path(), damage: damage})
+		gatherer.total += damage
+	}
+
+	// can happen with pure renames
+	if gatherer.total == 0 {
+		return nil, nil
+	}
+
+	sort.SliceStable(gatherer.files, func(i, j int) bool {
+		return gatherer.files[i].path < gatherer.files[j].path
+	})
+	gatherer.gather("")
+	return gatherer.shares, nil
+}
+
+// WriteDirStat writes shares as 'git diff --dirstat' does
+func WriteDirStat(w io.Writer, shares []DirShare) error {
+	out := bufio.NewWriter(w)
+	for _, share := range shares {
+		fmt.Fprintf(out, "%4d.%01d%% %s\n", share.Permille/10, share.Permille%10, share.Dir)
+	}
+	return out.Flush()
+}
+
+type dirStatFile struct {
+	path   string
+	damage int
+}
+
+type dirStatGatherer struct {
+	opts        *DirStatOptions
+	minPermille int
+	files       []dirStatFile
+	total       int
+	shares      []DirShare
+}
+
+// gather consumes files under base directory and returns their damage, which is
+// not yet reported
+func (g *dirStatGatherer) gather(base string) int {
+	damage, sources := 0, 0
+	for len(g.files) > 0 && strings.HasPrefix(g.files[0].path, base) {
+		file := g.files[0]
+		if slash := strings.IndexByte(file.path[len(base):], '/'); slash != -1 {
+			damage += g.gather(file.path[:len(base)+slash+1])
+			sources++
+		} else {
+			damage += file.damage
+			g.files = g.files[1:]
+			sources += 2
+		}
+	}
+
+	// top level and directories with changes from a single subdirectory are not
+	// shown
+	if base == "" || sources == 1 || damage == 0 {
+		return damage
+	}
+
+	permille := damage * 1000 / g.total
+	if permille < g.minPermille {
+		return damage
+	}
+
+	g.shares = append(g.shares, DirShare{Dir: base, Permille: permille})
+	if g.opts.Cumulative {
+		return damage
+	}
+	return 0
+}
+
+// fileDamage returns amount of changes made to file
+func fileDamage(repo rawgit.Repository, stat *FileStat, mode DirStatMode) (int, error) {
+	if mode == DirStatLines {
+		damage := stat.Added + stat.Deleted
+		if stat.Binary {
+			damage = (damage + binaryLineSize - 1) / binaryLineSize
+		}
+		return damage, nil
+	}
+
+	if stat.Old.Exists() && stat.New.Exists() && stat.Old.OID == stat.New.OID {
+		return 0, nil
+	}
+	if mode == DirStatFiles {
+		return 1, nil
+	}
+
+	if !stat.Old.Exists() || !stat.New.Exists() {
+		var damage int
+		var err error
+		if stat.Old.Exists() {
+			damage, err = contentSize(repo, &stat.Old)
+		} else {
+			damage, err = contentSize(repo, &stat.New)
+		}
+		return maxInt(damage, 1), err
+	}
+
+	// removed material of old content and added material of new one
+	oldText, err := ReadContent(repo, &stat.Old)
+	if err != nil {
+		return 0, err
+	}
+	newText, err := ReadContent(repo, &stat.New)
+	if err != nil {
+		return 0, err
+	}
+
+	copied, added := countChanges(hashSpans(oldText), hashSpans(newText))
+
+	// ids differ, so there is some change
+	return maxInt(len(oldText)-copied+added, 1), nil
+}
+
+func contentSize(repo rawgit.Repository, entry *Entry) (int, error) {
+	if entry.Mode == rawgit.TreeCommitMode {
+		content, err := ReadContent(repo, entry)
+		return len(content), err
+	}
+
+	info, _, err := repo.StatObject(&entry.OID)
+	if err != nil {
+		return 0, err
+	}
+	return int(info.Size), nil
+}
+
+// countChanges returns amount of source material kept in destination and
+// amount of new material in destination
+func countChanges(src, dst map[uint32]int) (int, int) {
+	copied, added := 0, 0
+	for hash, dstCount := range dst {
+		srcCount := src[hash]
+		if srcCount < dstCount {
+			copied += srcCount
+			added += dstCount - srcCount
+		} else {
+			copied += dstCount
+		}
+	}
+	return copied, added
+}
+
+// renameName shows both paths, with common directories of their beginnings and
+// ends written once, like 'dir/{old => new}/file'
+func renameName(oldPath, newPath string) string {
+	_, oldQuoted := quoteC(oldPath)
+	_, newQuoted := quoteC(newPath)
+	if oldQuoted || newQuoted {
+		return quotePath(oldPath) + " => " + quotePath(newPath)
+	}
+
+	// common prefix ends with slash
+	prefixLen := 0
+	for idx := 0; idx < len(oldPath) && idx < len(newPath) && oldPath[idx] == newPath[idx]; idx++ {
+		if oldPath[idx] == '/' {
+			prefixLen = idx + 1
+		}
+	}
+
+	// common suffix starts with slash, which may be the last one of prefix.
+	// Walk starts at the end of paths, where both have terminator
+	suffixLen := 0
+	start := prefixLen
+	if prefixLen > 0 {
+		start--
+	}
+	oldIdx, newIdx := len(oldPath), len(newPath)
+	for oldIdx >= start && newIdx >= start && byteAt(oldPath, oldIdx) == byteAt(newPath, newIdx) {
+		if byteAt(oldPath, oldIdx) == '/' {
+			suffixLen = len(oldPath) - oldIdx
+		}
+		oldIdx--
+		newIdx--
+	}
+
+	oldMiddle := oldPath[prefixLen:maxInt(len(oldPath)-suffixLen, prefixLen)]
+	newMiddle := newPath[prefixLen:maxInt(len(newPath)-suffixLen, prefixLen)]
+	if prefixLen+suffixLen == 0 {
+		return oldMiddle + " => " + newMiddle
+	}
+	return oldPath[:prefixLen] + "{" + oldMiddle + " => " + newMiddle + "}" + oldPath[len(oldPath)-suffixLen:]
+}
+
+// byteAt returns byte of string, or zero at its end
+func byteAt(value string, idx int) byte {
+	if idx == len(value) {
+		return 0
+	}
+	return value[idx]
+}
+
+// scaleLinear scales count to width, so that any change takes at least one
+// column
+func scaleLinear(count, width, maxChange int) int {
+	if count == 0 {
+		return 0
+	}
+	return 1 + count*(width-1)/maxChange
+}
+
+func decimalWidth(n int) int {
+	return len(fmt.Sprint(n))
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
@@ -0,0 +1,281 @@
+package diff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// statTests are compared with output of 'git diff --numstat', 'git diff
+// --stat=width' and 'git diff --dirstat' for the same trees
+var statTests = []struct {
+	name     string
+	old, new map[string]testFile
+	diffOpts Options
+	width    int
+	dirOpts  DirStatOptions
+
+	numStat string
+	stat    string
+	dirStat string
+}{
+	{
+		name: "no changes",
+		old:  map[string]testFile{"file": blob("same\n")},
+		new:  map[string]testFile{"file": blob("same\n")},
+	},
+	{
+		name: "modified, added and deleted files",
+		old: map[string]testFile{
+			"README":        blob("hello\n"),
+			"doc/guide.txt": blob(numbered(5, nil)),
+			"image.png":     blob("\x89PNG\x00\x01\x02"),
+			"src/main.c":    blob(numbered(20, nil)),
+			"src/util.c":    blob(numbered(10, nil)),
+		},
+		new: map[string]testFile{
+			"NEWS":          blob(""),
+			"doc/guide.txt": blob(numbered(5, map[int]string{5: "five\n"})),
+			"image.png":     blob("\x89PNG\x00\x01\x02\x03\x04"),
+			"src/lib/new.c": blob(numbered(8, nil)),
+			"src/main.c":    blob(numbered(20, map[int]string{3: "three\n", 4: "", 15: "fifteen\nextra\n"})),
+			"src/util.c":    blob(numbered(10, nil)),
+		},
+		numStat: `0	0	NEWS
+0	1	README
+1	1	doc/guide.txt
+-	-	image.png
+8	0	src/lib/new.c
+3	3	src/main.c
+`,
+		stat: ` NEWS          |   0
+ README        |   1 -
+ doc/guide.txt |   2 +-
+ image.png     | Bin 7 -> 9 bytes
+ src/lib/new.c |   8 ++++++++
+ src/main.c    |   6 +++---
+ 6 files changed, 12 insertions(+), 5 deletions(-)
+`,
+		dirStat: `   9.6% doc/
+  45.1% src/lib/
+  33.8% src/
+`,
+	},
+	{
+		name: "renames and mode changes",
+		old: map[string]testFile{
+			"a.txt":           blob(numbered(30, nil)),
+			"bin/tool":        blob("#!/bin/sh\n"),
+			"lib/old/file.go": blob(numbered(10, nil)),
+		},
+		new: map[string]testFile{
+			"b.txt":           blob(numbered(30, nil)),
+			"bin/tool":        {rawgit.TreeExecutableBlobMode, "#!/bin/sh\n"},
+			"lib/new/file.go": blob(numbered(10, map[int]string{10: "changed\n"})),
+		},
+		diffOpts: Options{DetectRenames: true},
+		numStat: `0	0	a.txt => b.txt
+0	0	bin/tool
+1	1	lib/{old => new}/file.go
+`,
+		stat: ` a.txt => b.txt           | 0
+ bin/tool                 | 0
+ lib/{old => new}/file.go | 2 +-
+ 3 files changed, 1 insertion(+), 1 deletion(-)
+`,
+		dirStat: ` 100.0% lib/new/
+`,
+	},
+	{
+		name: "scaled graph and long names",
+		old: map[string]testFile{
+			"short":                             blob("one\n"),
+			"very/long/directory/name/file.txt": blob(numbered(100, nil)),
+			"bin":                               blob(strings.Repeat("\x00", 100)),
+		},
+		new: map[string]testFile{
+			"short":                             blob("two\n"),
+			"very/long/directory/name/file.txt": blob(numbered(40, nil)),
+			"bin":                               blob(strings.Repeat("\x00", 2000)),
+		},
+		width: 40,
+		numStat: `-	-	bin
+1	1	short
+0	60	very/long/directory/name/file.txt
+`,
+		stat: ` bin                       | Bin 100 -> 2000 bytes
+ short                     |   2 +-
+ .../name/file.txt         |  60 ------
+ 3 files changed, 1 insertion(+), 61 deletions(-)
+`,
+		dirStat: `  19.6% very/long/directory/name/
+`,
+	},
+	{
+		name: "cumulative dirstat of lines",
+		old: map[string]testFile{
+			"a/b/c/file": blob(numbered(10, nil)),
+			"a/b/file":   blob(numbered(10, nil)),
+			"a/x/file":   blob(numbered(4, nil)),
+			"top":        blob("top\n"),
+			"z/bin":      blob("\x00"),
+		},
+		new: map[string]testFile{
+			"a/b/c/file": blob(numbered(10, map[int]string{1: "", 2: "", 3: ""})),
+			"a/b/file":   blob(numbered(10, map[int]string{10: "ten\n"})),
+			"a/x/file":   blob(numbered(4, map[int]string{4: "four\n"})),
+			"top":        blob("changed\n"),
+			"z/bin":      blob(strings.Repeat("\x00", 200)),
+		},
+		dirOpts: DirStatOptions{Mode: DirStatLines, Cumulative: true, MinPermille: -1},
+		numStat: `0	3	a/b/c/file
+1	1	a/b/file
+1	1	a/x/file
+1	1	top
+-	-	z/bin
+`,
+		stat: ` a/b/c/file |   3 ---
+ a/b/file   |   2 +-
+ a/x/file   |   2 +-
+ top        |   2 +-
+ z/bin      | Bin 1 -> 200 bytes
+ 5 files changed, 3 insertions(+), 6 deletions(-)
+`,
+		dirStat: `  23.0% a/b/c/
+  38.4% a/b/
+  15.3% a/x/
+  53.8% a/
+  30.7% z/
+`,
+	},
+	{
+		name: "dirstat of files",
+		old: map[string]testFile{
+			"a/one":   blob("1\n"),
+			"a/two":   blob("2\n"),
+			"b/c/one": blob("1\n"),
+			"b/d/one": blob("1\n"),
+			"e/one":   blob("1\n"),
+		},
+		new: map[string]testFile{
+			"a/one":   blob("one\n"),
+			"a/two":   blob("two\n"),
+			"b/c/one": blob("one\n"),
+			"b/d/one": blob("one\n"),
+			"e/one":   blob("1\n"),
+			"e/two":   blob("2\n"),
+		},
+		dirOpts: DirStatOptions{Mode: DirStatFiles, MinPermille: 200},
+		numStat: `1	1	a/one
+1	1	a/two
+1	1	b/c/one
+1	1	b/d/one
+1	0	e/two
+`,
+		stat: ` a/one   | 2 +-
+ a/two   | 2 +-
+ b/c/one | 2 +-
+ b/d/one | 2 +-
+ e/two   | 1 +
+ 5 files changed, 5 insertions(+), 4 deletions(-)
+`,
+		dirStat: `  40.0% a/
+  20.0% b/c/
+  20.0% b/d/
+  20.0% e/
+`,
+	},
+}
+
+func TestDiffStat(t *testing.T) {
+	for _, test := range statTests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := newTestRepository(t)
+			oldTree := writeTestTree(t, repo, test.old)
+			newTree := writeTestTree(t, repo, test.new)
+
+			changes, err := DiffTrees(repo, oldTree, newTree, &test.diffOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stats, err := DiffStat(repo, changes, Myers)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := WriteNumStat(&buf, stats); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != test.numStat {
+				t.Errorf("numstat differs from git:\n%s\nwant:\n%s", buf.String(), test.numStat)
+			}
+
+			buf.Reset()
+			if err := WriteStat(&buf, stats, test.width); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != test.stat {
+				t.Errorf("stat differs from git:\n%s\nwant:\n%s", buf.String(), test.stat)
+			}
+
+			shares, err := DirStat(repo, stats, &test.dirOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf.Reset()
+			if err := WriteDirStat(&buf, shares); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != test.dirStat {
+				t.Errorf("dirstat differs from git:\n%s\nwant:\n%s", buf.String(), test.dirStat)
+			}
+		})
+	}
+}
+
+func TestCommitStat(t *testing.T) {
+	repo := newTestRepository(t)
+	ident := rawgit.UserTime{Name: "A U Thor", Email: "author@example.com"}
+	root := &rawgit.Commit{
+		TreeOID:   writeTestTree(t, repo, map[string]testFile{"file": blob("one\ntwo\n")}),
+		Author:    ident,
+		Committer: ident,
+		Message:   "root\n",
+	}
+	rootOID, err := repo.WriteCommit(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := &rawgit.Commit{
+		TreeOID:    writeTestTree(t, repo, map[string]testFile{"file": blob("one\n2\n3\n"), "new": blob("new\n")}),
+		ParentOIDs: []*rawgit.OID{rootOID},
+		Author:     ident,
+		Committer:  ident,
+		Message:    "second\n",
+	}
+
+	tests := []struct {
+		commit *rawgit.Commit
+		want   string
+	}{
+		{commit: root, want: "2\t0\tfile\n"},
+		{commit: commit, want: "2\t1\tfile\n1\t0\tnew\n"},
+	}
+
+	for _, test := range tests {
+		stats, err := CommitStat(repo, test.commit, nil, Myers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := WriteNumStat(&buf, stats); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("%q: got numstat %q, want %q", test.commit.Message, buf.String(), test.want)
+		}
+	}
+}
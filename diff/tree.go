@@ -0,0 +1,270 @@
+package diff
+
+import (
+	"strings"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+type ChangeType int
+
+const (
+	Added ChangeType = iota
+	Deleted
+	Modified
+	// kind of entry changed, like regular file replaced by symlink
+	TypeChanged
+	Renamed
+	Copied
+
+	// unmodified files are collected only as copy sources
+	unmodified ChangeType = -1
+)
+
+// String returns status letter, as in 'git diff --name-status'
+func (t ChangeType) String() string {
+	switch t {
+	case Added:
+		return "A"
+	case Deleted:
+		return "D"
+	case Modified:
+		return "M"
+	case TypeChanged:
+		return "T"
+	case Renamed:
+		return "R"
+	case Copied:
+		return "C"
+	}
+	return "?"
+}
+
+// Entry is one side of change. Entry of missing side has zero mode and id
+type Entry struct {
+	Path string
+	Mode uint32
+	OID  rawgit.OID
+}
+
+// Exists reports whether entry is present on its side of diff
+func (e *Entry) Exists() bool {
+	return e.Mode != 0
+}
+
+type Change struct {
+	Type ChangeType
+	Old  Entry
+	New  Entry
+	// similarity of renamed or copied file to its source, in percent
+	Score int
+}
+
+// Path returns path of new entry, or of old one for deletions
+func (c *Change) Path() string {
+	if c.Type == Deleted {
+		return c.Old.Path
+	}
+	return c.New.Path
+}
+
+type Options struct {
+	// pathspecs limiting diff, see MatchPathspec. Empty list matches all paths
+	Paths []string
+
+	// detect renamed files, like 'git diff -M'
+	DetectRenames bool
+	// detect files copied from modified files too, like 'git diff -C'. Implies
+	// DetectRenames
+	DetectCopies bool
+	// look for copy sources among unmodified files too, like
+	// '--find-copies-harder'. Implies DetectCopies
+	FindCopiesHarder bool
+	// minimum similarity of renamed files in percent, DefaultRenameThreshold if zero
+	RenameThreshold int
+	// similarity is not estimated when number of sources times number of
+	// destinations exceeds square of limit, like '-l'. DefaultRenameLimit if zero,
+	// unlimited if negative
+	RenameLimit int
+}
+
+// DiffTrees compares trees recursively and returns changed files in git order.
+// Nil id means empty tree. Subtrees with equal ids are skipped
+func DiffTrees(repo rawgit.Repository, oldTree, newTree *rawgit.OID, opts *Options) ([]*Change, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	differ := &treeDiffer{repo: repo, paths: opts.Paths, unmodified: opts.FindCopiesHarder}
+	err := differ.diff("", oldTree, newTree)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.DetectRenames || opts.DetectCopies || opts.FindCopiesHarder {
+		return detectRenames(repo, differ.changes, opts)
+	}
+	return differ.changes, nil
+}
+
+// DiffCommit compares commit with its first parent. Root commits are compared
+// with empty tree
+func DiffCommit(repo rawgit.Repository, commit *rawgit.Commit, opts *Options) ([]*Change, error) {
+	var parentTree *rawgit.OID
+	if len(commit.ParentOIDs) > 0 {
+		parent, err := repo.OpenCommit(commit.ParentOIDs[0])
+		if err != nil {
+			return nil, err
+		}
+		parentTree = parent.TreeOID
+	}
+
+	return DiffTrees(repo, parentTree, commit.TreeOID, opts)
+}
+
+type treeDiffer struct {
+	repo  rawgit.Repository
+	paths []string
+	// report unmodified files too
+	unmodified bool
+	changes    []*Change
+}
+
+func (d *treeDiffer) diff(base string, oldTree, newTree *rawgit.OID) error {
+	if oldTree != nil && newTree != nil && oldTree.Equal(newTree) && !d.unmodified {
+		return nil
+	}
+
+	oldItems, err := d.readTree(oldTree)
+	if err != nil {
+		return err
+	}
+
+	newItems, err := d.readTree(newTree)
+	if err != nil {
+		return err
+	}
+
+	// both lists are in git order, walk them together
+	for len(oldItems) > 0 || len(newItems) > 0 {
+		var cmp int
+		switch {
+		case len(oldItems) == 0:
+			cmp = 1
+		case len(newItems) == 0:
+			cmp = -1
+		default:
+			cmp = strings.Compare(sortName(&oldItems[0]), sortName(&newItems[0]))
+		}
+
+		switch {
+		case cmp < 0:
+			err = d.report(base, &oldItems[0], nil)
+			oldItems = oldItems[1:]
+		case cmp > 0:
+			err = d.report(base, nil, &newItems[0])
+			newItems = newItems[1:]
+		default:
+			err = d.report(base, &oldItems[0], &newItems[0])
+			oldItems, newItems = oldItems[1:], newItems[1:]
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// report records change of item, which is missing on one side if nil. Items of
+// the same name are both directories or both not
+func (d *treeDiffer) report(base string, oldItem, newItem *rawgit.TreeItem) error {
+	var item = oldItem
+	if item == nil {
+		item = newItem
+	}
+
+	path := base + item.Name
+	if !d.matchPath(path, item.IsDir()) {
+		return nil
+	}
+
+	if item.IsDir() {
+		var oldTree, newTree *rawgit.OID
+		if oldItem != nil {
+			oldTree = &oldItem.OID
+		}
+		if newItem != nil {
+			newTree = &newItem.OID
+		}
+		return d.diff(path+"/", oldTree, newTree)
+	}
+
+	change := &Change{}
+	switch {
+	case oldItem == nil:
+		change.Type = Added
+	case newItem == nil:
+		change.Type = Deleted
+	case oldItem.Mode == newItem.Mode && oldItem.OID == newItem.OID:
+		if !d.unmodified {
+			return nil
+		}
+		change.Type = unmodified
+	case oldItem.GetOType() != newItem.GetOType() || isSymlink(oldItem) != isSymlink(newItem):
+		change.Type = TypeChanged
+	default:
+		change.Type = Modified
+	}
+
+	if oldItem != nil {
+		change.Old = Entry{Path: path, Mode: oldItem.Mode, OID: oldItem.OID}
+	}
+	if newItem != nil {
+		change.New = Entry{Path: path, Mode: newItem.Mode, OID: newItem.OID}
+	}
+
+	d.changes = append(d.changes, change)
+	return nil
+}
+
+func (d *treeDiffer) matchPath(path string, isDir bool) bool {
+	if len(d.paths) == 0 {
+		return true
+	}
+
+	for _, pathspec := range d.paths {
+		if MatchPathspec(pathspec, path) || isDir && mayMatchUnder(pathspec, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *treeDiffer) readTree(oid *rawgit.OID) ([]rawgit.TreeItem, error) {
+	if oid == nil {
+		return nil, nil
+	}
+
+	tree, err := d.repo.OpenTree(oid)
+	if err != nil {
+		return nil, err
+	}
+
+	tree.Sort()
+	return tree.Items, nil
+}
+
+// sortName returns name, which sorts in git order: directories as if they had
+// trailing slash
+func sortName(item *rawgit.TreeItem) string {
+	if item.IsDir() {
+		return item.Name + "/"
+	}
+	return item.Name
+}
+
+func isSymlink(item *rawgit.TreeItem) bool {
+	return item.Mode&0170000 == rawgit.TreeSymlinkMode
+}
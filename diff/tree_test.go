@@ -0,0 +1,253 @@
+package diff
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// treeCountingRepository records trees read through it
+type treeCountingRepository struct {
+	rawgit.Repository
+	opened map[rawgit.OID]bool
+}
+
+func (repo *treeCountingRepository) OpenTree(oid *rawgit.OID) (*rawgit.Tree, error) {
+	repo.opened[*oid] = true
+	return repo.Repository.OpenTree(oid)
+}
+
+// formatChanges formats changes like 'git diff --raw' without ids
+func formatChanges(changes []*Change) []string {
+	var result []string
+	for _, change := range changes {
+		result = append(result, fmt.Sprintf("%o %o %s %s", change.Old.Mode, change.New.Mode, change.Type, change.Path()))
+	}
+	return result
+}
+
+func TestDiffTrees(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new map[string]testFile
+		paths    []string
+		want     []string
+	}{
+		{
+			name: "equal trees",
+			old:  map[string]testFile{"dir/file": blob("same\n")},
+			new:  map[string]testFile{"dir/file": blob("same\n")},
+		},
+		{
+			name: "nested additions",
+			old:  map[string]testFile{"top": blob("top\n")},
+			new: map[string]testFile{
+				"a/b/c/file": blob("file\n"),
+				"a/b/other":  blob("other\n"),
+				"a/d":        blob("d\n"),
+				"top":        blob("top\n"),
+			},
+			want: []string{
+				"0 100644 A a/b/c/file",
+				"0 100644 A a/b/other",
+				"0 100644 A a/d",
+			},
+		},
+		{
+			name: "nested deletions",
+			old: map[string]testFile{
+				"a/b/c/file": blob("file\n"),
+				"a/b/other":  blob("other\n"),
+				"z":          blob("z\n"),
+			},
+			new: map[string]testFile{"z": blob("z\n")},
+			want: []string{
+				"100644 0 D a/b/c/file",
+				"100644 0 D a/b/other",
+			},
+		},
+		{
+			name: "modifications in unchanged directories",
+			old: map[string]testFile{
+				"dir/kept/file":    blob("kept\n"),
+				"dir/changed/file": blob("old\n"),
+				"dir.txt":          blob("dir.txt\n"),
+			},
+			new: map[string]testFile{
+				"dir/kept/file":    blob("kept\n"),
+				"dir/changed/file": blob("new\n"),
+				"dir.txt":          blob("changed\n"),
+			},
+			want: []string{
+				"100644 100644 M dir.txt",
+				"100644 100644 M dir/changed/file",
+			},
+		},
+		{
+			name: "type changes",
+			old: map[string]testFile{
+				"exec":   blob("#!/bin/sh\n"),
+				"link":   blob("target"),
+				"module": blob("module\n"),
+				"back":   {rawgit.TreeSymlinkMode, "target"},
+			},
+			new: map[string]testFile{
+				"exec":   {rawgit.TreeExecutableBlobMode, "#!/bin/sh\n"},
+				"link":   {rawgit.TreeSymlinkMode, "target"},
+				"module": {rawgit.TreeCommitMode, "module\n"},
+				"back":   {rawgit.TreeExecutableBlobMode, "target"},
+			},
+			want: []string{
+				"120000 100755 T back",
+				"100644 100755 M exec",
+				"100644 120000 T link",
+				"100644 160000 T module",
+			},
+		},
+		{
+			name: "file replaced by directory",
+			old: map[string]testFile{
+				"a":       blob("file\n"),
+				"a.txt":   blob("kept\n"),
+				"b/file":  blob("file\n"),
+				"b0":      blob("kept\n"),
+				"c/d/e/f": blob("deep\n"),
+			},
+			new: map[string]testFile{
+				"a/file": blob("file\n"),
+				"a.txt":  blob("kept\n"),
+				"b":      blob("file\n"),
+				"b0":     blob("kept\n"),
+				"c/d":    blob("deep\n"),
+			},
+			want: []string{
+				"100644 0 D a",
+				"0 100644 A a/file",
+				"0 100644 A b",
+				"100644 0 D b/file",
+				"0 100644 A c/d",
+				"100644 0 D c/d/e/f",
+			},
+		},
+		{
+			name: "pathspecs",
+			old: map[string]testFile{
+				"dir/a.c":     blob("a\n"),
+				"dir/sub/b.c": blob("b\n"),
+				"dir/sub/b.h": blob("b\n"),
+				"other/c.c":   blob("c\n"),
+				"top.c":       blob("top\n"),
+			},
+			new: map[string]testFile{
+				"dir/a.c":     blob("a2\n"),
+				"dir/sub/b.c": blob("b2\n"),
+				"dir/sub/b.h": blob("b2\n"),
+				"other/c.c":   blob("c2\n"),
+				"top.c":       blob("top2\n"),
+			},
+			paths: []string{"dir/sub/*.c", "top.c"},
+			want: []string{
+				"100644 100644 M dir/sub/b.c",
+				"100644 100644 M top.c",
+			},
+		},
+		{
+			name:  "directory pathspec",
+			old:   map[string]testFile{"dir/sub/file": blob("old\n"), "dir/subway": blob("old\n")},
+			new:   map[string]testFile{"dir/sub/file": blob("new\n"), "dir/subway": blob("new\n")},
+			paths: []string{"dir/sub/"},
+			want:  []string{"100644 100644 M dir/sub/file"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := newTestRepository(t)
+			oldTree := writeTestTree(t, repo, test.old)
+			newTree := writeTestTree(t, repo, test.new)
+
+			changes, err := DiffTrees(repo, oldTree, newTree, &Options{Paths: test.paths})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := formatChanges(changes); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got changes %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDiffTreesEmpty(t *testing.T) {
+	repo := newTestRepository(t)
+	tree := writeTestTree(t, repo, map[string]testFile{"dir/file": blob("file\n"), "top": blob("top\n")})
+
+	changes, err := DiffTrees(repo, nil, tree, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := formatChanges(changes), []string{"0 100644 A dir/file", "0 100644 A top"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got changes %q, want %q", got, want)
+	}
+
+	changes, err = DiffTrees(repo, tree, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := formatChanges(changes), []string{"100644 0 D dir/file", "100644 0 D top"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got changes %q, want %q", got, want)
+	}
+}
+
+func TestDiffTreesPruning(t *testing.T) {
+	old := map[string]testFile{
+		"changed/sub/file": blob("old\n"),
+		"kept/sub/file":    blob("kept\n"),
+		"other/sub/file":   blob("old other\n"),
+	}
+	new := map[string]testFile{
+		"changed/sub/file": blob("new\n"),
+		"kept/sub/file":    blob("kept\n"),
+		"other/sub/file":   blob("new other\n"),
+	}
+
+	tests := []struct {
+		paths []string
+		// directories, which are read on both sides
+		want []string
+	}{
+		{want: []string{"changed", "changed/sub", "other", "other/sub"}},
+		{paths: []string{"changed/sub/file"}, want: []string{"changed", "changed/sub"}},
+		{paths: []string{"changed/*"}, want: []string{"changed", "changed/sub"}},
+		{paths: []string{"*/file"}, want: []string{"changed", "changed/sub", "other", "other/sub"}},
+		{paths: []string{"missing"}},
+	}
+
+	for _, test := range tests {
+		repo := &treeCountingRepository{Repository: newTestRepository(t), opened: make(map[rawgit.OID]bool)}
+		oldTree := writeTestTree(t, repo, old)
+		newTree := writeTestTree(t, repo, new)
+		if _, err := DiffTrees(repo, oldTree, newTree, &Options{Paths: test.paths}); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, dir := range []string{"changed", "changed/sub", "kept", "kept/sub", "other", "other/sub"} {
+			_, oldDir, err := repo.FindInTree(oldTree, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, newDir, err := repo.FindInTree(newTree, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if repo.opened[*oldDir] && repo.opened[*newDir] {
+				got = append(got, dir)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got read directories %q, want %q", test.paths, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,828 @@
+package diff
+
+// This is a port of myers diff from git's xdiff. Besides the algorithm itself,
+// heuristics of xdiff are kept as is, so that hunks are the same as git makes
+
+const (
+	// minimum edit cost, after which search for optimal path is cut short
+	xdMaxCostMin = 256
+	// edit cost, after which good snakes are taken as split points
+	xdHeurMinCost = 256
+	// length of snake, which is considered good
+	xdSnakeCnt = 20
+	xdKHeur    = 4
+	// lines with more matches are discarded, if they are surrounded by changes
+	xdMaxEqLimit    = 1024
+	xdSimscanWindow = 100
+	xdKpdisRun      = 4
+	xdLineMax       = int(^uint(0) >> 1)
+)
+
+// Algorithm selects algorithm of line diff
+type Algorithm int
+
+const (
+	// myers diff with git's heuristics, default in git
+	Myers Algorithm = iota
+	// myers diff, which always finds the smallest diff, like '--minimal'
+	Minimal
+	// patience diff, like '--patience'
+	Patience
+)
+
+// Edit is a range of lines of old text, replaced with range of lines of new
+// text. Lines are counted from zero, one of ranges may be empty
+type Edit struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+}
+
+// xdfile is one side of line diff
+type xdfile struct {
+	recs [][]byte
+	// equal records have the same class
+	class []int
+	// change marks of records
+	rchg []bool
+	// records, which take part in diff, and their classes
+	rindex []int
+	rclass []int
+	// range of records left after trimming common head and tail
+	dstart, dend int
+}
+
+// changed reports whether record is changed. Records beyond both ends are not
+func (f *xdfile) changed(idx int) bool {
+	return idx >= 0 && idx < len(f.rchg) && f.rchg[idx]
+}
+
+func (f *xdfile) setChanged(idx int, changed bool) {
+	f.rchg[idx] = changed
+}
+
+// DiffLines compares texts line by line and returns changed ranges in order
+func DiffLines(oldText, newText []byte, algorithm Algorithm) []Edit {
+	return diffRecords(SplitLines(oldText), SplitLines(newText), algorithm, true)
+}
+
+// DiffLinesNoHeuristic compares texts as DiffLines does, but without indent
+// heuristic: changes, which can be shifted, are placed as low as possible. Git
+// diffs texts this way for merges
+func DiffLinesNoHeuristic(oldText, newText []byte, algorithm Algorithm) []Edit {
+	return diffRecords(SplitLines(oldText), SplitLines(newText), algorithm, false)
+}
+
+func diffRecords(recs1, recs2 [][]byte, algorithm Algorithm, indentHeuristic bool) []Edit {
+	f1, f2 := doDiff(recs1, recs2, algorithm)
+	changeCompact(f1, f2, indentHeuristic)
+	changeCompact(f2, f1, indentHeuristic)
+	return buildScript(f1, f2)
+}
+
+// splitLines splits text after newlines. Last line may have no newline
+func SplitLines(text []byte) [][]byte {
+	var lines [][]byte
+	for len(text) > 0 {
+		end := len(text)
+		for idx, c := range text {
+			if c == '\n' {
+				end = idx + 1
+				break
+			}
+		}
+		lines = append(lines, text[:end])
+		text = text[end:]
+	}
+	return lines
+}
+
+// prepare classifies records. Myers diff skips common head and tail and
+// discards records, which can't match or likely won't
+func prepare(recs1, recs2 [][]byte, algorithm Algorithm) (*xdfile, *xdfile) {
+	classes := make(map[string]int)
+	var count1, count2 []int
+	classify := func(recs [][]byte, counts *[]int) *xdfile {
+		f := &xdfile{recs: recs, class: make([]int, len(recs)), rchg: make([]bool, len(recs))}
+		for idx, rec := range recs {
+			class, ok := classes[string(rec)]
+			if !ok {
+				class = len(classes)
+				classes[string(rec)] = class
+				count1 = append(count1, 0)
+				count2 = append(count2, 0)
+			}
+			f.class[idx] = class
+			(*counts)[class]++
+		}
+		return f
+	}
+
+	f1 := classify(recs1, &count1)
+	f2 := classify(recs2, &count2)
+
+	if algorithm == Myers || algorithm == Minimal {
+		trimEnds(f1, f2)
+		cleanupRecords(f1, f2, count1, count2)
+	}
+	return f1, f2
+}
+
+func trimEnds(f1, f2 *xdfile) {
+	lim := len(f1.recs)
+	if len(f2.recs) < lim {
+		lim = len(f2.recs)
+	}
+
+	head := 0
+	for head < lim && f1.class[head] == f2.class[head] {
+		head++
+	}
+
+	tail := 0
+	for tail < lim-head && f1.class[len(f1.recs)-1-tail] == f2.class[len(f2.recs)-1-tail] {
+		tail++
+	}
+
+	f1.dstart, f2.dstart = head, head
+	f1.dend, f2.dend = len(f1.recs)-tail-1, len(f2.recs)-tail-1
+}
+
+// cleanupRecords takes records into diff, except those without matches on the
+// other side and those with too many matches amid unmatched records. Both are
+// marked as changed right away. Git does this for minimal diffs too
+func cleanupRecords(f1, f2 *xdfile, count1, count2 []int) {
+	discards := func(f *xdfile, otherCounts []int) []int {
+		limit := bogosqrt(len(f.recs))
+		if limit > xdMaxEqLimit {
+			limit = xdMaxEqLimit
+		}
+
+		dis := make([]int, len(f.recs)+1)
+		for idx := f.dstart; idx <= f.dend; idx++ {
+			matches := otherCounts[f.class[idx]]
+			switch {
+			case matches == 0:
+				dis[idx] = 0
+			case matches >= limit:
+				dis[idx] = 2
+			default:
+				dis[idx] = 1
+			}
+		}
+		return dis
+	}
+
+	dis1 := discards(f1, count2)
+	dis2 := discards(f2, count1)
+
+	for _, side := range []struct {
+		f   *xdfile
+		dis []int
+	}{{f1, dis1}, {f2, dis2}} {
+		f, dis := side.f, side.dis
+		for idx := f.dstart; idx <= f.dend; idx++ {
+			if dis[idx] == 1 || dis[idx] == 2 && !cleanMatch(dis, idx, f.dstart, f.dend) {
+				f.rindex = append(f.rindex, idx)
+				f.rclass = append(f.rclass, f.class[idx])
+			} else {
+				f.setChanged(idx, true)
+			}
+		}
+	}
+}
+
+// cleanMatch reports whether record with many matches is amid records without
+// matches and should be discarded
+func cleanMatch(dis []int, idx, start, end int) bool {
+	if idx-start > xdSimscanWindow {
+		start = idx - xdSimscanWindow
+	}
+	if end-idx > xdSimscanWindow {
+		end = idx + xdSimscanWindow
+	}
+
+	var unmatched, multi = 0, 1
+	for r := 1; idx-r >= start; r++ {
+		if dis[idx-r] == 0 {
+			unmatched++
+		} else if dis[idx-r] == 2 {
+			multi++
+		} else {
+			break
+		}
+	}
+	if unmatched == 0 {
+		return false
+	}
+
+	var unmatchedAfter, multiAfter = 0, 1
+	for r := 1; idx+r <= end; r++ {
+		if dis[idx+r] == 0 {
+			unmatchedAfter++
+		} else if dis[idx+r] == 2 {
+			multiAfter++
+		} else {
+			break
+		}
+	}
+	if unmatchedAfter == 0 {
+		return false
+	}
+
+	unmatched += unmatchedAfter
+	multi += multiAfter
+	return multi*xdKpdisRun < multi+unmatched
+}
+
+// bogosqrt approximates square root with shifts
+func bogosqrt(n int) int {
+	res := 1
+	for ; n > 0; n >>= 2 {
+		res <<= 1
+	}
+	return res
+}
+
+func doDiff(recs1, recs2 [][]byte, algorithm Algorithm) (*xdfile, *xdfile) {
+	f1, f2 := prepare(recs1, recs2, algorithm)
+	if algorithm == Patience {
+		p := &patience{f1: f1, f2: f2}
+		p.diff(1, len(recs1), 1, len(recs2))
+		return f1, f2
+	}
+
+	m := newMyers(f1, f2)
+	m.compare(0, len(f1.rindex), 0, len(f2.rindex), algorithm == Minimal)
+	return f1, f2
+}
+
+type myers struct {
+	f1, f2     *xdfile
+	ha1, ha2   []int
+	kvdf, kvdb []int
+	// kv vectors are indexed by diagonals, which may be negative
+	base   int
+	mxcost int
+}
+
+func newMyers(f1, f2 *xdfile) *myers {
+	ndiags := len(f1.rindex) + len(f2.rindex) + 3
+	m := &myers{
+		f1:     f1,
+		f2:     f2,
+		ha1:    f1.rclass,
+		ha2:    f2.rclass,
+		kvdf:   make([]int, ndiags),
+		kvdb:   make([]int, ndiags),
+		base:   len(f2.rindex) + 1,
+		mxcost: bogosqrt(ndiags),
+	}
+	if m.mxcost < xdMaxCostMin {
+		m.mxcost = xdMaxCostMin
+	}
+	return m
+}
+
+// compare marks changed records in the box, dividing it at middle snake
+func (m *myers) compare(off1, lim1, off2, lim2 int, needMin bool) {
+	// shrink the box by walking through diagonal snakes
+	for off1 < lim1 && off2 < lim2 && m.ha1[off1] == m.ha2[off2] {
+		off1++
+		off2++
+	}
+	for off1 < lim1 && off2 < lim2 && m.ha1[lim1-1] == m.ha2[lim2-1] {
+		lim1--
+		lim2--
+	}
+
+	switch {
+	case off1 == lim1:
+		for ; off2 < lim2; off2++ {
+			m.f2.setChanged(m.f2.rindex[off2], true)
+		}
+	case off2 == lim2:
+		for ; off1 < lim1; off1++ {
+			m.f1.setChanged(m.f1.rindex[off1], true)
+		}
+	default:
+		i1, i2, minLo, minHi := m.split(off1, lim1, off2, lim2, needMin)
+		m.compare(off1, i1, off2, i2, minLo)
+		m.compare(i1, lim1, i2, lim2, minHi)
+	}
+}
+
+// split finds point, where the box is divided, and whether halves need minimal
+// diff
+func (m *myers) split(off1, lim1, off2, lim2 int, needMin bool) (int, int, bool, bool) {
+	ha1, ha2 := m.ha1, m.ha2
+	// diagonals may be negative, shift them to kv vectors
+	kf := func(d int) *int { return &m.kvdf[m.base+d] }
+	kb := func(d int) *int { return &m.kvdb[m.base+d] }
+
+	dmin, dmax := off1-lim2, lim1-off2
+	fmid, bmid := off1-off2, lim1-lim2
+	odd := (fmid-bmid)&1 != 0
+	fmin, fmax := fmid, fmid
+	bmin, bmax := bmid, bmid
+
+	*kf(fmid) = off1
+	*kb(bmid) = lim1
+
+	for ec := 1; ; ec++ {
+		gotSnake := false
+
+		// extend diagonal domain by one, or shrink it at box boundaries
+		if fmin > dmin {
+			fmin--
+			*kf(fmin - 1) = -1
+		} else {
+			fmin++
+		}
+		if fmax < dmax {
+			fmax++
+			*kf(fmax + 1) = -1
+		} else {
+			fmax--
+		}
+
+		for d := fmax; d >= fmin; d -= 2 {
+			var i1 int
+			if *kf(d - 1) >= *kf(d + 1) {
+				i1 = *kf(d - 1) + 1
+			} else {
+				i1 = *kf(d + 1)
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 < lim1 && i2 < lim2 && ha1[i1] == ha2[i2] {
+				i1++
+				i2++
+			}
+			if i1-prev1 > xdSnakeCnt {
+				gotSnake = true
+			}
+			*kf(d) = i1
+			if odd && bmin <= d && d <= bmax && *kb(d) <= i1 {
+				return i1, i2, true, true
+			}
+		}
+
+		if bmin > dmin {
+			bmin--
+			*kb(bmin - 1) = xdLineMax
+		} else {
+			bmin++
+		}
+		if bmax < dmax {
+			bmax++
+			*kb(bmax + 1) = xdLineMax
+		} else {
+			bmax--
+		}
+
+		for d := bmax; d >= bmin; d -= 2 {
+			var i1 int
+			if *kb(d - 1) < *kb(d + 1) {
+				i1 = *kb(d - 1)
+			} else {
+				i1 = *kb(d + 1) - 1
+			}
+			prev1 := i1
+			i2 := i1 - d
+			for i1 > off1 && i2 > off2 && ha1[i1-1] == ha2[i2-1] {
+				i1--
+				i2--
+			}
+			if prev1-i1 > xdSnakeCnt {
+				gotSnake = true
+			}
+			*kb(d) = i1
+			if !odd && fmin <= d && d <= fmax && i1 <= *kf(d) {
+				return i1, i2, true, true
+			}
+		}
+
+		if needMin {
+			continue
+		}
+
+		// when edit cost is high, take a good snake far enough from the
+		// corner and close enough to the mid diagonal
+		if gotSnake && ec > xdHeurMinCost {
+			best, split1, split2 := 0, 0, 0
+			for d := fmax; d >= fmin; d -= 2 {
+				dd := d - fmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *kf(d)
+				i2 := i1 - d
+				v := (i1 - off1) + (i2 - off2) - dd
+
+				if v > xdKHeur*ec && v > best &&
+					off1+xdSnakeCnt <= i1 && i1 < lim1 &&
+					off2+xdSnakeCnt <= i2 && i2 < lim2 {
+					for k := 1; ha1[i1-k] == ha2[i2-k]; k++ {
+						if k == xdSnakeCnt {
+							best, split1, split2 = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return split1, split2, true, false
+			}
+
+			for d := bmax; d >= bmin; d -= 2 {
+				dd := d - bmid
+				if dd < 0 {
+					dd = -dd
+				}
+				i1 := *kb(d)
+				i2 := i1 - d
+				v := (lim1 - i1) + (lim2 - i2) - dd
+
+				if v > xdKHeur*ec && v > best &&
+					off1 < i1 && i1 <= lim1-xdSnakeCnt &&
+					off2 < i2 && i2 <= lim2-xdSnakeCnt {
+					for k := 0; ha1[i1+k] == ha2[i2+k]; k++ {
+						if k == xdSnakeCnt-1 {
+							best, split1, split2 = v, i1, i2
+							break
+						}
+					}
+				}
+			}
+			if best > 0 {
+				return split1, split2, false, true
+			}
+		}
+
+		// enough is enough, take the furthest reaching path
+		if ec >= m.mxcost {
+			fbest, fbest1 := -1, -1
+			for d := fmax; d >= fmin; d -= 2 {
+				i1 := *kf(d)
+				if i1 > lim1 {
+					i1 = lim1
+				}
+				i2 := i1 - d
+				if lim2 < i2 {
+					i1, i2 = lim2+d, lim2
+				}
+				if fbest < i1+i2 {
+					fbest, fbest1 = i1+i2, i1
+				}
+			}
+
+			bbest, bbest1 := xdLineMax, xdLineMax
+			for d := bmax; d >= bmin; d -= 2 {
+				i1 := *kb(d)
+				if i1 < off1 {
+					i1 = off1
+				}
+				i2 := i1 - d
+				if i2 < off2 {
+					i1, i2 = off2+d, off2
+				}
+				if i1+i2 < bbest {
+					bbest, bbest1 = i1+i2, i1
+				}
+			}
+
+			if (lim1+lim2)-bbest < fbest-(off1+off2) {
+				return fbest1, fbest - fbest1, true, false
+			}
+			return bbest1, bbest - bbest1, false, true
+		}
+	}
+}
+
+// group is a range of changed records, possibly empty
+type group struct {
+	start, end int
+}
+
+func (f *xdfile) groupInit() group {
+	g := group{}
+	for f.changed(g.end) {
+		g.end++
+	}
+	return g
+}
+
+func (f *xdfile) groupNext(g *group) bool {
+	if g.end == len(f.recs) {
+		return false
+	}
+	g.start = g.end + 1
+	for g.end = g.start; f.changed(g.end); g.end++ {
+	}
+	return true
+}
+
+func (f *xdfile) groupPrevious(g *group) bool {
+	if g.start == 0 {
+		return false
+	}
+	g.end = g.start - 1
+	for g.start = g.end; f.changed(g.start - 1); g.start-- {
+	}
+	return true
+}
+
+func (f *xdfile) groupSlideDown(g *group) bool {
+	if g.end < len(f.recs) && f.class[g.start] == f.class[g.end] {
+		f.setChanged(g.start, false)
+		f.setChanged(g.end, true)
+		g.start++
+		g.end++
+		for f.changed(g.end) {
+			g.end++
+		}
+		return true
+	}
+	return false
+}
+
+func (f *xdfile) groupSlideUp(g *group) bool {
+	if g.start > 0 && f.class[g.start-1] == f.class[g.end-1] {
+		g.start--
+		g.end--
+		f.setChanged(g.start, true)
+		f.setChanged(g.end, false)
+		for f.changed(g.start - 1) {
+			g.start--
+		}
+		return true
+	}
+	return false
+}
+
+// changeCompact slides groups of changes, which can be shifted, merging them with
+// adjacent groups, aligning them with changes of the other file or placing them
+// where indentation suggests
+func changeCompact(f, other *xdfile, indentHeuristic bool) {
+	g := f.groupInit()
+	og := other.groupInit()
+
+	for {
+		if g.end != g.start {
+			var size, earliestEnd int
+			endMatchingOther := -1
+			for {
+				size = g.end - g.start
+				endMatchingOther = -1
+
+				for f.groupSlideUp(&g) {
+					other.groupPrevious(&og)
+				}
+				earliestEnd = g.end
+				if og.end > og.start {
+					endMatchingOther = g.end
+				}
+
+				for f.groupSlideDown(&g) {
+					other.groupNext(&og)
+					if og.end > og.start {
+						endMatchingOther = g.end
+					}
+				}
+
+				if size == g.end-g.start {
+					break
+				}
+			}
+
+			switch {
+			case g.end == earliestEnd:
+				// no shifting was possible
+			case endMatchingOther != -1:
+				for og.end == og.start {
+					f.groupSlideUp(&g)
+					other.groupPrevious(&og)
+				}
+			case indentHeuristic:
+				bestShift := f.indentShift(g, size, earliestEnd)
+				for g.end > bestShift {
+					f.groupSlideUp(&g)
+					other.groupPrevious(&og)
+				}
+			}
+		}
+
+		if !f.groupNext(&g) {
+			break
+		}
+		other.groupNext(&og)
+	}
+}
+
+// buildScript collects groups of changed records
+func buildScript(f1, f2 *xdfile) []Edit {
+	var edits []Edit
+	for i1, i2 := len(f1.recs), len(f2.recs); i1 >= 0 || i2 >= 0; i1, i2 = i1-1, i2-1 {
+		if !f1.changed(i1-1) && !f2.changed(i2-1) {
+			continue
+		}
+
+		l1, l2 := i1, i2
+		for f1.changed(i1 - 1) {
+			i1--
+		}
+		for f2.changed(i2 - 1) {
+			i2--
+		}
+		edits = append(edits, Edit{OldStart: i1, OldLines: l1 - i1, NewStart: i2, NewLines: l2 - i2})
+	}
+
+	// edits were collected from the end
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// indent heuristic of xdiff: each position of group splits text twice, before and
+// after the group. Splits are scored by blank lines and indentation around them
+const (
+	maxIndent = 200
+	maxBlanks = 20
+
+	startOfFilePenalty              = 1
+	endOfFilePenalty                = 21
+	totalBlankWeight                = -30
+	postBlankWeight                 = 6
+	relativeIndentPenalty           = -4
+	relativeIndentWithBlankPenalty  = 10
+	relativeOutdentPenalty          = 24
+	relativeOutdentWithBlankPenalty = 17
+	relativeDedentPenalty           = 23
+	relativeDedentWithBlankPenalty  = 17
+
+	indentWeight              = 60
+	indentHeuristicMaxSliding = 100
+)
+
+type splitMeasurement struct {
+	endOfFile  bool
+	indent     int
+	preBlank   int
+	preIndent  int
+	postBlank  int
+	postIndent int
+}
+
+type splitScore struct {
+	effectiveIndent int
+	penalty         int
+}
+
+// indentShift returns the best end of group, which is shifted as far down as
+// possible
+func (f *xdfile) indentShift(g group, size, earliestEnd int) int {
+	shift := earliestEnd
+	if g.end-size-1 > shift {
+		shift = g.end - size - 1
+	}
+	if g.end-indentHeuristicMaxSliding > shift {
+		shift = g.end - indentHeuristicMaxSliding
+	}
+
+	bestShift := -1
+	var bestScore splitScore
+	for ; shift <= g.end; shift++ {
+		var score splitScore
+		score.add(f.measureSplit(shift))
+		score.add(f.measureSplit(shift - size))
+		if bestShift == -1 || score.cmp(&bestScore) <= 0 {
+			bestScore = score
+			bestShift = shift
+		}
+	}
+	return bestShift
+}
+
+func (f *xdfile) measureSplit(split int) *splitMeasurement {
+	m := &splitMeasurement{}
+	if split >= len(f.recs) {
+		m.endOfFile = true
+		m.indent = -1
+	} else {
+		m.indent = getIndent(f.recs[split])
+	}
+
+	m.preIndent = -1
+	for idx := split - 1; idx >= 0; idx-- {
+		m.preIndent = getIndent(f.recs[idx])
+		if m.preIndent != -1 {
+			break
+		}
+		m.preBlank++
+		if m.preBlank == maxBlanks {
+			m.preIndent = 0
+			break
+		}
+	}
+
+	m.postIndent = -1
+	for idx := split + 1; idx < len(f.recs); idx++ {
+		m.postIndent = getIndent(f.recs[idx])
+		if m.postIndent != -1 {
+			break
+		}
+		m.postBlank++
+		if m.postBlank == maxBlanks {
+			m.postIndent = 0
+			break
+		}
+	}
+
+	return m
+}
+
+// getIndent returns width of leading whitespace with tabs of 8, or -1 for blank
+// lines
+func getIndent(rec []byte) int {
+	indent := 0
+	for _, c := range rec {
+		switch c {
+		case ' ':
+			indent++
+		case '\t':
+			indent += 8 - indent%8
+		case '\n', '\r':
+		default:
+			return indent
+		}
+
+		if indent >= maxIndent {
+			return maxIndent
+		}
+	}
+	return -1
+}
+
+func (s *splitScore) add(m *splitMeasurement) {
+	if m.preIndent == -1 && m.preBlank == 0 {
+		s.penalty += startOfFilePenalty
+	}
+	if m.endOfFile {
+		s.penalty += endOfFilePenalty
+	}
+
+	// blank lines after split, including the line right after it
+	postBlank := 0
+	if m.indent == -1 {
+		postBlank = 1 + m.postBlank
+	}
+	totalBlank := m.preBlank + postBlank
+
+	s.penalty += totalBlankWeight * totalBlank
+	s.penalty += postBlankWeight * postBlank
+
+	indent := m.indent
+	if indent == -1 {
+		indent = m.postIndent
+	}
+	anyBlanks := totalBlank != 0
+
+	// effective indent is -1 at the end of file
+	s.effectiveIndent += indent
+
+	switch {
+	case indent == -1, m.preIndent == -1:
+	case indent > m.preIndent:
+		if anyBlanks {
+			s.penalty += relativeIndentWithBlankPenalty
+		} else {
+			s.penalty += relativeIndentPenalty
+		}
+	case indent == m.preIndent:
+	case m.postIndent != -1 && m.postIndent > indent:
+		// line is likely a start of block
+		if anyBlanks {
+			s.penalty += relativeOutdentWithBlankPenalty
+		} else {
+			s.penalty += relativeOutdentPenalty
+		}
+	default:
+		// line is likely an end of block
+		if anyBlanks {
+			s.penalty += relativeDedentWithBlankPenalty
+		} else {
+			s.penalty += relativeDedentPenalty
+		}
+	}
+}
+
+func (s *splitScore) cmp(other *splitScore) int {
+	cmpIndents := 0
+	if s.effectiveIndent > other.effectiveIndent {
+		cmpIndents = 1
+	} else if s.effectiveIndent < other.effectiveIndent {
+		cmpIndents = -1
+	}
+	return indentWeight*cmpIndents + s.penalty - other.penalty
+}
@@ -0,0 +1,154 @@
+package git
+
+import (
+	"strings"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// CommitBuilder makes commits without working directory: it takes tree of the
+// parent commit, applies staged changes to it and writes only modified trees
+type CommitBuilder struct {
+	repo   *Repository
+	parent *rawgit.OID
+	editor *rawgit.TreeEditor
+}
+
+// NewCommitBuilder starts commit on top of parent. Nil parent starts root commit
+// with empty tree
+func (repo *Repository) NewCommitBuilder(parent *rawgit.OID) (*CommitBuilder, error) {
+	var tree *rawgit.OID
+	if parent != nil {
+		commit, err := repo.OpenCommit(parent)
+		if err != nil {
+			return nil, err
+		}
+		tree = commit.TreeOID
+	}
+
+	editor, err := rawgit.NewTreeEditor(repo, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CommitBuilder{repo: repo, parent: parent, editor: editor}, nil
+}
+
+// AddFile stores data as blob and puts it to path, replacing existing file.
+// Missing directories are created
+func (b *CommitBuilder) AddFile(path string, data []byte, mode uint32) error {
+	if !rawgit.IsFileMode(mode) || mode == rawgit.TreeCommitMode {
+		return ErrInvalidMode
+	}
+
+	oid, err := b.repo.WriteBlob(data)
+	if err != nil {
+		return err
+	}
+
+	return b.Add(path, oid, mode)
+}
+
+// Add puts existing object to path, replacing existing file. Mode must be one of
+// blob, executable, symlink or submodule modes
+func (b *CommitBuilder) Add(path string, oid *rawgit.OID, mode uint32) error {
+	if !rawgit.IsFileMode(mode) {
+		return ErrInvalidMode
+	}
+
+	return b.editor.Set(path, rawgit.TreeItem{Mode: mode, OID: *oid})
+}
+
+// Remove removes file or directory with all its contents. Directories, which
+// become empty, are removed too
+func (b *CommitBuilder) Remove(path string) error {
+	_, err := b.editor.Remove(path)
+	return err
+}
+
+// Rename moves file or directory to new path, which must not exist
+func (b *CommitBuilder) Rename(from, to string) error {
+	return b.editor.Rename(from, to)
+}
+
+// Chmod changes mode of file. Mode must be one of blob, executable or symlink modes
+func (b *CommitBuilder) Chmod(path string, mode uint32) error {
+	if !rawgit.IsFileMode(mode) || mode == rawgit.TreeCommitMode {
+		return ErrInvalidMode
+	}
+
+	item, err := b.editor.Find(path)
+	if err != nil {
+		return err
+	}
+
+	if item.GetOType() != rawgit.OTypeBlob {
+		return ErrIsADirectory
+	}
+
+	item.Mode = mode
+	return b.editor.Set(path, *item)
+}
+
+// WriteTree writes modified trees and returns id of the root tree
+func (b *CommitBuilder) WriteTree() (*rawgit.OID, error) {
+	return b.editor.Write()
+}
+
+// Commit writes trees and commit object. Refs are not updated
+func (b *CommitBuilder) Commit(author, committer rawgit.UserTime, message string) (*rawgit.Commit, error) {
+	tree, err := b.WriteTree()
+	if err != nil {
+		return nil, err
+	}
+
+	commit := &rawgit.Commit{
+		TreeOID:   tree,
+		Author:    author,
+		Committer: committer,
+		Message:   message,
+	}
+	if b.parent != nil {
+		parent := *b.parent
+		commit.ParentOIDs = []*rawgit.OID{&parent}
+	}
+
+	_, err = b.repo.WriteCommit(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	return commit, nil
+}
+
+// CommitToBranch commits and moves branch to the new commit. Branch must still
+// point to the parent commit or, for root commits, must not exist. Otherwise
+// *rawgit.RefConflictError is returned and the commit is left unreferenced
+func (b *CommitBuilder) CommitToBranch(branch string, author, committer rawgit.UserTime, message string) (*rawgit.Commit, error) {
+	if err := rawgit.CheckBranchName(branch); err != nil {
+		return nil, err
+	}
+
+	commit, err := b.Commit(author, committer, message)
+	if err != nil {
+		return nil, err
+	}
+
+	oldOID := &rawgit.OID{}
+	reflogMessage := "commit (initial): "
+	if b.parent != nil {
+		oldOID = b.parent
+		reflogMessage = "commit: "
+	}
+	subject := strings.SplitN(strings.TrimSpace(message), "\n", 2)[0]
+
+	tx := rawgit.NewRefTransaction(b.repo)
+	tx.Update(rawgit.RefBranchNS+branch, oldOID, &commit.OID)
+	tx.SetReflog(&commit.Committer, reflogMessage+subject)
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return commit, nil
+}
@@ -0,0 +1,391 @@
+package git
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/mechmind/git-go/rawgit"
+	"github.com/mechmind/git-go/storage/fsstor"
+)
+
+var (
+	testAuthor = rawgit.UserTime{Name: "A U Thor", Email: "author@example.com"}
+	testFiles  = map[string]string{
+		"README":         "readme\n",
+		"bin/tool":       "tool\n",
+		"src/main.go":    "main\n",
+		"src/lib/lib.go": "lib\n",
+	}
+)
+
+func newTestRepository(t *testing.T) *Repository {
+	repo, err := InitRepositoryFS(fsstor.NewOSFS(t.TempDir()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// commitTestFiles makes commit with regular files
+func commitTestFiles(t *testing.T, repo *Repository, files map[string]string) *rawgit.Commit {
+	b, err := repo.NewCommitBuilder(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, data := range files {
+		if err := b.AddFile(path, []byte(data), rawgit.TreeBlobMode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	commit, err := b.Commit(testAuthor, testAuthor, "files\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commit
+}
+
+// readTestTree returns files of tree as "<mode> <contents>"
+func readTestTree(t *testing.T, repo *Repository, oid *rawgit.OID, prefix string, files map[string]string) map[string]string {
+	if files == nil {
+		files = make(map[string]string)
+	}
+
+	tree, err := repo.OpenTree(oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range tree.Items {
+		if item.IsDir() {
+			readTestTree(t, repo, &item.OID, prefix+item.Name+"/", files)
+			continue
+		}
+
+		_, body, err := repo.OpenObject(&item.OID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[prefix+item.Name] = strconv.FormatUint(uint64(item.Mode), 8) + " " + string(data)
+	}
+	return files
+}
+
+func TestCommitBuilder(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(b *CommitBuilder) error
+		// changed files, empty value means removed file
+		want map[string]string
+		err  error
+	}{
+		{
+			name: "add file",
+			edit: func(b *CommitBuilder) error {
+				return b.AddFile("new/dir/file", []byte("new\n"), rawgit.TreeBlobMode)
+			},
+			want: map[string]string{"new/dir/file": "100644 new\n"},
+		},
+		{
+			name: "replace file",
+			edit: func(b *CommitBuilder) error {
+				return b.AddFile("src/main.go", []byte("changed\n"), rawgit.TreeExecutableBlobMode)
+			},
+			want: map[string]string{"src/main.go": "100755 changed\n"},
+		},
+		{
+			name: "add file in place of directory",
+			edit: func(b *CommitBuilder) error {
+				return b.AddFile("src/lib", []byte("file\n"), rawgit.TreeBlobMode)
+			},
+			err: ErrIsADirectory,
+		},
+		{
+			name: "add file under file",
+			edit: func(b *CommitBuilder) error {
+				return b.AddFile("README/new/file", []byte("file\n"), rawgit.TreeBlobMode)
+			},
+			err: ErrNotADirectory,
+		},
+		{
+			name: "add file with invalid mode",
+			edit: func(b *CommitBuilder) error {
+				return b.AddFile("file", []byte("file\n"), rawgit.TreeDirectoryMode)
+			},
+			err: ErrInvalidMode,
+		},
+		{
+			name: "add file with invalid path",
+			edit: func(b *CommitBuilder) error {
+				return b.AddFile("src/../file", []byte("file\n"), rawgit.TreeBlobMode)
+			},
+			err: ErrInvalidPath,
+		},
+		{
+			name: "add .git",
+			edit: func(b *CommitBuilder) error {
+				return b.AddFile("src/.Git", []byte("file\n"), rawgit.TreeBlobMode)
+			},
+			err: ErrInvalidPath,
+		},
+		{
+			name: "remove file",
+			edit: func(b *CommitBuilder) error {
+				return b.Remove("bin/tool")
+			},
+			want: map[string]string{"bin/tool": ""},
+		},
+		{
+			name: "remove directory",
+			edit: func(b *CommitBuilder) error {
+				return b.Remove("src")
+			},
+			want: map[string]string{"src/main.go": "", "src/lib/lib.go": ""},
+		},
+		{
+			name: "remove missing file",
+			edit: func(b *CommitBuilder) error {
+				return b.Remove("src/missing")
+			},
+			err: ErrNotFound,
+		},
+		{
+			name: "rename file",
+			edit: func(b *CommitBuilder) error {
+				return b.Rename("src/lib/lib.go", "lib/lib.go")
+			},
+			want: map[string]string{"src/lib/lib.go": "", "lib/lib.go": "100644 lib\n"},
+		},
+		{
+			name: "rename changed directory",
+			edit: func(b *CommitBuilder) error {
+				if err := b.AddFile("src/lib/new.go", []byte("new\n"), rawgit.TreeBlobMode); err != nil {
+					return err
+				}
+				return b.Rename("src", "pkg/src")
+			},
+			want: map[string]string{
+				"src/main.go": "", "src/lib/lib.go": "",
+				"pkg/src/main.go": "100644 main\n", "pkg/src/lib/lib.go": "100644 lib\n", "pkg/src/lib/new.go": "100644 new\n",
+			},
+		},
+		{
+			name: "rename to existing path",
+			edit: func(b *CommitBuilder) error {
+				return b.Rename("README", "src/main.go")
+			},
+			err: ErrPathExists,
+		},
+		{
+			name: "rename directory into itself",
+			edit: func(b *CommitBuilder) error {
+				return b.Rename("src", "src/lib/src")
+			},
+			err: ErrInvalidPath,
+		},
+		{
+			name: "rename missing file",
+			edit: func(b *CommitBuilder) error {
+				return b.Rename("missing", "other")
+			},
+			err: ErrNotFound,
+		},
+		{
+			name: "rename under file",
+			edit: func(b *CommitBuilder) error {
+				return b.Rename("bin/tool", "README/tool")
+			},
+			err: ErrNotADirectory,
+		},
+		{
+			name: "chmod",
+			edit: func(b *CommitBuilder) error {
+				return b.Chmod("bin/tool", rawgit.TreeExecutableBlobMode)
+			},
+			want: map[string]string{"bin/tool": "100755 tool\n"},
+		},
+		{
+			name: "chmod directory",
+			edit: func(b *CommitBuilder) error {
+				return b.Chmod("src", rawgit.TreeExecutableBlobMode)
+			},
+			err: ErrIsADirectory,
+		},
+		{
+			name: "chmod to submodule",
+			edit: func(b *CommitBuilder) error {
+				return b.Chmod("bin/tool", rawgit.TreeCommitMode)
+			},
+			err: ErrInvalidMode,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := newTestRepository(t)
+			parent := commitTestFiles(t, repo, testFiles)
+
+			b, err := repo.NewCommitBuilder(&parent.OID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = test.edit(b)
+			if err != test.err {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+
+			tree, err := b.WriteTree()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.err != nil {
+				// failed changes leave no trace, like created directories
+				if *tree != *parent.TreeOID {
+					t.Errorf("failed change modified tree: %v", readTestTree(t, repo, tree, "", nil))
+				}
+				return
+			}
+
+			want := make(map[string]string)
+			for path, data := range testFiles {
+				want[path] = "100644 " + data
+			}
+			for path, data := range test.want {
+				if data == "" {
+					delete(want, path)
+				} else {
+					want[path] = data
+				}
+			}
+			if got := readTestTree(t, repo, tree, "", nil); !reflect.DeepEqual(got, want) {
+				t.Errorf("got files %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCommitBuilderKeepsUnchangedTrees(t *testing.T) {
+	repo := newTestRepository(t)
+	parent := commitTestFiles(t, repo, testFiles)
+
+	b, err := repo.NewCommitBuilder(&parent.OID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// loaded, but not changed in the end
+	if err := b.AddFile("src/lib/lib.go", []byte("lib\n"), rawgit.TreeBlobMode); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("README", []byte("changed\n"), rawgit.TreeBlobMode); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := b.WriteTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldTree, err := repo.OpenTree(parent.TreeOID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTree, err := repo.OpenTree(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"bin", "src"} {
+		if *oldTree.Find(name) != *newTree.Find(name) {
+			t.Errorf("%s changed from %v to %v", name, oldTree.Find(name), newTree.Find(name))
+		}
+	}
+}
+
+func TestCommitToBranch(t *testing.T) {
+	repo := newTestRepository(t)
+	committer := rawgit.UserTime{Name: "C O Mitter", Email: "committer@example.com"}
+
+	b, err := repo.NewCommitBuilder(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("file", []byte("first\n"), rawgit.TreeBlobMode); err != nil {
+		t.Fatal(err)
+	}
+	first, err := b.CommitToBranch("master", testAuthor, committer, "first\n\nbody\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.ParentOIDs) != 0 {
+		t.Errorf("root commit has parents %v", first.ParentOIDs)
+	}
+
+	b, err = repo.NewCommitBuilder(&first.OID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddFile("file", []byte("second\n"), rawgit.TreeBlobMode); err != nil {
+		t.Fatal(err)
+	}
+	second, err := b.CommitToBranch("master", testAuthor, committer, "second\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second.ParentOIDs) != 1 || *second.ParentOIDs[0] != first.OID {
+		t.Errorf("got parents %v, want %s", second.ParentOIDs, first.OID)
+	}
+
+	oid, err := repo.ResolveRef("refs/heads/master")
+	if err != nil || *oid != second.OID {
+		t.Fatalf("branch points to %v, %v, want %s", oid, err, second.OID)
+	}
+
+	entries, err := repo.ReadReflog("refs/heads/master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, entry := range entries {
+		messages = append(messages, entry.Message)
+		if entry.Committer.Name != committer.Name {
+			t.Errorf("logged by %q", entry.Committer.Name)
+		}
+	}
+	if want := []string{"commit (initial): first", "commit: second"}; !reflect.DeepEqual(messages, want) {
+		t.Errorf("got reflog %q, want %q", messages, want)
+	}
+
+	// branch has moved since builder was started
+	stale, err := repo.NewCommitBuilder(&first.OID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stale.CommitToBranch("master", testAuthor, committer, "stale\n")
+	if conflict, ok := err.(*rawgit.RefConflictError); !ok || *conflict.Actual != second.OID {
+		t.Errorf("got error %v", err)
+	}
+
+	// root commit to existing branch
+	root, err := repo.NewCommitBuilder(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = root.CommitToBranch("master", testAuthor, committer, "root\n")
+	if _, ok := err.(*rawgit.RefConflictError); !ok {
+		t.Errorf("got error %v", err)
+	}
+
+	if oid, err := repo.ResolveRef("refs/heads/master"); err != nil || *oid != second.OID {
+		t.Errorf("branch moved to %v, %v", oid, err)
+	}
+
+	_, err = root.CommitToBranch("bad..name", testAuthor, committer, "root\n")
+	if err != rawgit.ErrInvalidRefName {
+		t.Errorf("got error %v for invalid branch name", err)
+	}
+}
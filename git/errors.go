@@ -0,0 +1,20 @@
+package git
+
+import (
+	"errors"
+
+	"github.com/mechmind/git-go/rawgit"
+	"github.com/mechmind/git-go/storage/fsstor"
+)
+
+var (
+	ErrInvalidPath   = rawgit.ErrInvalidPath
+	ErrNotFound      = rawgit.ErrNotFound
+	ErrNotADirectory = rawgit.ErrNotADirectory
+	ErrIsADirectory  = rawgit.ErrIsADirectory
+	ErrPathExists    = rawgit.ErrPathExists
+)
+
+var ErrRepositoryExists = fsstor.ErrRepositoryExists
+
+var ErrInvalidMode = errors.New("invalid file mode")
@@ -1,6 +1,8 @@
 package git
 
 import (
+	"path/filepath"
+
 	"github.com/mechmind/git-go/rawgit"
 	"github.com/mechmind/git-go/storage/fsstor"
 )
@@ -13,3 +15,25 @@ func OpenRepository(path string) (*Repository, error) {
 
 	return NewRepository(rawgit.NewRepository(storage, storage)), nil
 }
+
+// InitRepository creates repository at path. ErrRepositoryExists is returned, if
+// there is repository already. Git directory of non-bare repository is '.git'
+// subdirectory of path
+func InitRepository(path string, bare bool) (*Repository, error) {
+	gitdir := path
+	if !bare {
+		gitdir = filepath.Join(path, ".git")
+	}
+
+	return InitRepositoryFS(fsstor.NewOSFS(gitdir), bare)
+}
+
+// InitRepositoryFS creates repository in git directory, represented by fs
+func InitRepositoryFS(fs fsstor.FS, bare bool) (*Repository, error) {
+	storage, err := fsstor.InitFSStorage(fs, bare)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRepository(rawgit.NewRepository(storage, storage)), nil
+}
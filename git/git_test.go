@@ -0,0 +1,55 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestInitRepository(t *testing.T) {
+	tests := []struct {
+		bare   bool
+		gitdir string
+	}{
+		{bare: true, gitdir: "."},
+		{bare: false, gitdir: ".git"},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "repo")
+		repo, err := InitRepository(path, test.bare)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gitdir := filepath.Join(path, test.gitdir)
+		for _, name := range []string{"HEAD", "config", "description", "objects/pack", "refs/heads", "refs/tags"} {
+			if _, err := os.Stat(filepath.Join(gitdir, name)); err != nil {
+				t.Errorf("bare %v: %v", test.bare, err)
+			}
+		}
+		if !test.bare {
+			if entries, err := os.ReadDir(path); err != nil || len(entries) != 1 {
+				t.Errorf("working directory has %v, %v", entries, err)
+			}
+		}
+
+		config, err := repo.Config()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bare, _ := config.Get("core", "", "bare"); bare != strconv.FormatBool(test.bare) {
+			t.Errorf("bare %v: got core.bare %q", test.bare, bare)
+		}
+
+		// repository is opened from git directory
+		if _, err := OpenRepository(gitdir); err != nil {
+			t.Errorf("bare %v: %v", test.bare, err)
+		}
+
+		if _, err := InitRepository(path, test.bare); err != ErrRepositoryExists {
+			t.Errorf("bare %v: reinit returned %v", test.bare, err)
+		}
+	}
+}
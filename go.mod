@@ -0,0 +1,14 @@
+module github.com/mechmind/git-go
+
+go 1.21
+
+require (
+	github.com/ProtonMail/go-crypto v1.1.6
+	golang.org/x/crypto v0.31.0
+	golang.org/x/text v0.21.0
+)
+
+require (
+	github.com/cloudflare/circl v1.3.7 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+)
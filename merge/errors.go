@@ -0,0 +1,7 @@
+package merge
+
+import (
+	"errors"
+)
+
+var ErrNoMergeBase = errors.New("no merge base")
@@ -0,0 +1,474 @@
+package merge
+
+import (
+	"strings"
+
+	"github.com/mechmind/git-go/diff"
+	"github.com/mechmind/git-go/history"
+	"github.com/mechmind/git-go/rawgit"
+)
+
+type ConflictType int
+
+const (
+	// both sides changed file in different ways
+	ContentConflict ConflictType = iota
+	// both sides added different files at the same path
+	AddAddConflict
+	// one side modified file, which other side deleted
+	ModifyDeleteConflict
+	// sides renamed file to different paths, or renamed different files to the
+	// same path
+	RenameRenameConflict
+	// one side renamed file, which other side deleted
+	RenameDeleteConflict
+	// file is put where other side has directory, or inside of other side's file
+	FileDirectoryConflict
+)
+
+// String returns kind of conflict, as git reports it
+func (t ConflictType) String() string {
+	switch t {
+	case ContentConflict:
+		return "content"
+	case AddAddConflict:
+		return "add/add"
+	case ModifyDeleteConflict:
+		return "modify/delete"
+	case RenameRenameConflict:
+		return "rename/rename"
+	case RenameDeleteConflict:
+		return "rename/delete"
+	case FileDirectoryConflict:
+		return "file/directory"
+	}
+	return "unknown"
+}
+
+// Conflict is a path, which could not be merged. Entries of sides keep their own
+// paths, which differ for renamed files. Entries, which are missing on their
+// side, have zero mode
+type Conflict struct {
+	Type   ConflictType
+	Path   string
+	Base   diff.Entry
+	Ours   diff.Entry
+	Theirs diff.Entry
+}
+
+type Options struct {
+	// detect renames on both sides, as git does by default
+	DetectRenames bool
+	// see diff.Options
+	RenameThreshold int
+	RenameLimit     int
+	// algorithm of line diffs for content merges
+	Algorithm diff.Algorithm
+}
+
+// MergeTrees merges changes made to base tree in ours and theirs trees and
+// writes merged tree. If changes conflict, nothing is written and conflicts are
+// returned instead. Nil id means empty tree
+func MergeTrees(repo rawgit.Repository, base, ours, theirs *rawgit.OID, opts *Options) (*rawgit.OID, []*Conflict, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	// trees, which were changed on one side only, are taken as is
+	var result *rawgit.OID
+	switch {
+	case sameTree(ours, theirs) || sameTree(base, theirs):
+		result = ours
+	case sameTree(base, ours):
+		result = theirs
+	default:
+		merger := &treeMerger{repo: repo, opts: opts, touched: make(map[string]bool)}
+		return merger.merge(base, ours, theirs)
+	}
+
+	if result == nil {
+		oid, err := repo.WriteTree(new(rawgit.Tree))
+		return oid, nil, err
+	}
+	return result, nil, nil
+}
+
+// MergeCommits merges trees of commits with tree of their merge base
+func MergeCommits(repo rawgit.Repository, ours, theirs *rawgit.Commit, opts *Options) (*rawgit.OID, []*Conflict, error) {
+	base, err := history.New(repo).Find3WayMergeBase(ours, theirs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if base == nil {
+		return nil, nil, ErrNoMergeBase
+	}
+
+	return MergeTrees(repo, base.TreeOID, ours.TreeOID, theirs.TreeOID, opts)
+}
+
+// treeMerger applies changes of theirs side to ours tree. Changes, which ours
+// side made to the same files, are merged with them
+type treeMerger struct {
+	repo rawgit.Repository
+	opts *Options
+	// items of theirs tree, which replace untouched directories
+	theirsTree *rawgit.TreeEditor
+
+	ours, theirs *sideChanges
+	// paths, changed by ours side or by merge, and their directories
+	touched map[string]bool
+
+	removals []string
+	updates  []*update
+	// directories, which were taken from theirs tree
+	replaced  map[string]bool
+	conflicts []*Conflict
+}
+
+type sideChanges struct {
+	// changes by path in base tree and by path in side's tree
+	bySource map[string]*diff.Change
+	byTarget map[string]*diff.Change
+}
+
+// update puts entry to merged tree. Merged contents are written only if
+// there are no conflicts
+type update struct {
+	entry diff.Entry
+	data  []byte
+	// update is made by theirs side only
+	theirsOnly bool
+}
+
+func (m *treeMerger) merge(base, ours, theirs *rawgit.OID) (*rawgit.OID, []*Conflict, error) {
+	oursChanges, err := m.diff(base, ours)
+	if err != nil {
+		return nil, nil, err
+	}
+	theirsChanges, err := m.diff(base, theirs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m.ours, m.theirs = indexChanges(oursChanges), indexChanges(theirsChanges)
+	for _, change := range oursChanges {
+		m.touch(change.Old.Path)
+		m.touch(change.New.Path)
+	}
+
+	for _, change := range theirsChanges {
+		err = m.mergeChange(change)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	editor, err := rawgit.NewTreeEditor(m.repo, ours)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.theirsTree, err = rawgit.NewTreeEditor(m.repo, theirs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = m.apply(editor)
+	if err != nil || len(m.conflicts) > 0 {
+		return nil, m.conflicts, err
+	}
+
+	oid, err := editor.Write()
+	return oid, nil, err
+}
+
+func (m *treeMerger) diff(base, side *rawgit.OID) ([]*diff.Change, error) {
+	return diff.DiffTrees(m.repo, base, side, &diff.Options{
+		DetectRenames:   m.opts.DetectRenames,
+		RenameThreshold: m.opts.RenameThreshold,
+		RenameLimit:     m.opts.RenameLimit,
+	})
+}
+
+func indexChanges(changes []*diff.Change) *sideChanges {
+	side := &sideChanges{bySource: make(map[string]*diff.Change), byTarget: make(map[string]*diff.Change)}
+	for _, change := range changes {
+		if change.Old.Exists() {
+			side.bySource[change.Old.Path] = change
+		}
+		if change.New.Exists() {
+			side.byTarget[change.New.Path] = change
+		}
+	}
+	return side
+}
+
+// mergeChange decides, how change of theirs side goes to ours tree
+func (m *treeMerger) mergeChange(theirs *diff.Change) error {
+	switch theirs.Type {
+	case diff.Added:
+		return m.mergeAddition(theirs)
+	case diff.Deleted:
+		m.mergeDeletion(theirs)
+		return nil
+	}
+
+	// modified or renamed file
+	ours := m.ours.bySource[theirs.Old.Path]
+	switch {
+	case ours == nil:
+		if theirs.Type == diff.Renamed {
+			m.removals = append(m.removals, theirs.Old.Path)
+			return m.mergeAddition(theirs)
+		}
+		m.updates = append(m.updates, &update{entry: theirs.New, theirsOnly: true})
+	case ours.Type == diff.Deleted && theirs.Type == diff.Renamed:
+		m.conflict(RenameDeleteConflict, theirs.New.Path, theirs.Old, diff.Entry{}, theirs.New)
+	case ours.Type == diff.Deleted:
+		m.conflict(ModifyDeleteConflict, theirs.Old.Path, theirs.Old, diff.Entry{}, theirs.New)
+	case ours.Type == diff.Renamed && theirs.Type == diff.Renamed && ours.New.Path != theirs.New.Path:
+		m.conflict(RenameRenameConflict, theirs.Old.Path, theirs.Old, ours.New, theirs.New)
+	case theirs.Type == diff.Renamed && ours.Type != diff.Renamed:
+		// modified file goes to path, where theirs side renamed it
+		if collision := m.ours.byTarget[theirs.New.Path]; collision != nil {
+			m.conflict(AddAddConflict, theirs.New.Path, theirs.Old, collision.New, theirs.New)
+			return nil
+		}
+		m.removals = append(m.removals, theirs.Old.Path)
+		return m.mergeEntries(ContentConflict, &theirs.Old, &ours.New, &theirs.New, theirs.New.Path)
+	default:
+		// ours side put file to the right path already
+		return m.mergeEntries(ContentConflict, &theirs.Old, &ours.New, &theirs.New, ours.New.Path)
+	}
+	return nil
+}
+
+// mergeAddition puts file, which theirs side added or renamed, unless ours side
+// put something else at the same path
+func (m *treeMerger) mergeAddition(theirs *diff.Change) error {
+	ours := m.ours.byTarget[theirs.New.Path]
+	if ours == nil {
+		m.updates = append(m.updates, &update{entry: theirs.New, theirsOnly: true})
+		return nil
+	}
+	return m.mergeCollision(ours, theirs)
+}
+
+// mergeCollision merges files, which both sides put at the same path
+func (m *treeMerger) mergeCollision(ours, theirs *diff.Change) error {
+	switch {
+	case ours.New == theirs.New:
+		return nil
+	case ours.Type == diff.Renamed && theirs.Type == diff.Renamed:
+		m.conflict(RenameRenameConflict, theirs.New.Path, diff.Entry{}, ours.New, theirs.New)
+		return nil
+	}
+
+	// files have no common base
+	return m.mergeEntries(AddAddConflict, &diff.Entry{}, &ours.New, &theirs.New, theirs.New.Path)
+}
+
+func (m *treeMerger) mergeDeletion(theirs *diff.Change) {
+	ours := m.ours.bySource[theirs.Old.Path]
+	switch {
+	case ours == nil:
+		m.removals = append(m.removals, theirs.Old.Path)
+	case ours.Type == diff.Renamed:
+		m.conflict(RenameDeleteConflict, ours.New.Path, theirs.Old, ours.New, diff.Entry{})
+	case ours.Type != diff.Deleted:
+		m.conflict(ModifyDeleteConflict, theirs.Old.Path, theirs.Old, ours.New, diff.Entry{})
+	}
+}
+
+// mergeEntries merges modes and contents of changed files and puts result to
+// path. Entry of missing base has zero mode
+func (m *treeMerger) mergeEntries(conflictType ConflictType, base, ours, theirs *diff.Entry, path string) error {
+	m.touch(path)
+	merged := update{entry: diff.Entry{Path: path, Mode: ours.Mode, OID: ours.OID}}
+	switch {
+	case ours.Mode == theirs.Mode || theirs.Mode == base.Mode:
+	case ours.Mode == base.Mode:
+		merged.entry.Mode = theirs.Mode
+	default:
+		m.conflict(conflictType, path, *base, *ours, *theirs)
+		return nil
+	}
+
+	switch {
+	case ours.OID == theirs.OID || theirs.OID == base.OID && base.Exists():
+	case ours.OID == base.OID && base.Exists():
+		merged.entry.OID = theirs.OID
+	case !rawgit.IsRegularMode(ours.Mode) || !rawgit.IsRegularMode(theirs.Mode) ||
+		base.Exists() && !rawgit.IsRegularMode(base.Mode):
+		m.conflict(conflictType, path, *base, *ours, *theirs)
+		return nil
+	default:
+		data, ok, err := m.mergeContents(base, ours, theirs)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			m.conflict(conflictType, path, *base, *ours, *theirs)
+			return nil
+		}
+		merged.data = data
+	}
+
+	if merged.entry.Mode != ours.Mode || merged.entry.OID != ours.OID || ours.Path != path || merged.data != nil {
+		m.updates = append(m.updates, &merged)
+	}
+	return nil
+}
+
+func (m *treeMerger) mergeContents(base, ours, theirs *diff.Entry) ([]byte, bool, error) {
+	baseText, err := diff.ReadContent(m.repo, base)
+	if err != nil {
+		return nil, false, err
+	}
+	oursText, err := diff.ReadContent(m.repo, ours)
+	if err != nil {
+		return nil, false, err
+	}
+	theirsText, err := diff.ReadContent(m.repo, theirs)
+	if err != nil {
+		return nil, false, err
+	}
+
+	merged, ok := mergeText(baseText, oursText, theirsText, m.opts.Algorithm)
+	return merged, ok, nil
+}
+
+func (m *treeMerger) conflict(conflictType ConflictType, path string, base, ours, theirs diff.Entry) {
+	m.touch(path)
+	m.conflicts = append(m.conflicts, &Conflict{Type: conflictType, Path: path, Base: base, Ours: ours, Theirs: theirs})
+}
+
+// touch marks path and its directories as changed by ours side or by merge
+func (m *treeMerger) touch(path string) {
+	for path != "" && !m.touched[path] {
+		m.touched[path] = true
+		slash := strings.LastIndexByte(path, '/')
+		if slash == -1 {
+			break
+		}
+		path = path[:slash]
+	}
+}
+
+// apply makes decided changes in ours tree. Removals go first, so that files
+// may replace removed directories and vice versa
+func (m *treeMerger) apply(editor *rawgit.TreeEditor) error {
+	m.replaced = make(map[string]bool)
+	for _, path := range m.removals {
+		replaced, err := m.replaceUntouched(editor, path)
+		switch {
+		case isFileDirectory(err):
+			m.conflicts = append(m.conflicts, &Conflict{Type: FileDirectoryConflict, Path: path})
+			continue
+		case err != nil:
+			return err
+		case replaced:
+			continue
+		}
+
+		_, err = editor.Remove(path)
+		if err != nil && !isMissing(err) {
+			return err
+		}
+	}
+
+	for _, change := range m.updates {
+		if change.theirsOnly {
+			replaced, err := m.replaceUntouched(editor, change.entry.Path)
+			switch {
+			case isFileDirectory(err):
+				m.conflicts = append(m.conflicts, &Conflict{Type: FileDirectoryConflict, Path: change.entry.Path, Theirs: change.entry})
+				continue
+			case err != nil:
+				return err
+			case replaced:
+				continue
+			}
+		}
+
+		item := rawgit.TreeItem{Mode: change.entry.Mode, OID: change.entry.OID}
+		if change.data != nil && len(m.conflicts) == 0 {
+			oid, err := m.repo.WriteBlob(change.data)
+			if err != nil {
+				return err
+			}
+			item.OID = *oid
+		}
+
+		err := editor.Set(change.entry.Path, item)
+		switch {
+		case isFileDirectory(err):
+			m.conflicts = append(m.conflicts, &Conflict{Type: FileDirectoryConflict, Path: change.entry.Path, Theirs: change.entry})
+		case err != nil:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replaceUntouched replaces the topmost directory above path, which ours side
+// did not change, with its version from theirs tree. It reports false, if there
+// is no such directory
+func (m *treeMerger) replaceUntouched(editor *rawgit.TreeEditor, path string) (bool, error) {
+	dir := ""
+	for idx := strings.IndexByte(path, '/'); idx != -1; {
+		if !m.touched[path[:idx]] {
+			dir = path[:idx]
+			break
+		}
+
+		next := strings.IndexByte(path[idx+1:], '/')
+		if next == -1 {
+			break
+		}
+		idx += next + 1
+	}
+	if dir == "" {
+		return false, nil
+	}
+	if m.replaced[dir] {
+		return true, nil
+	}
+
+	item, err := m.theirsTree.Find(dir)
+	if err != nil && !isMissing(err) {
+		return false, err
+	}
+	if item == nil || !item.IsDir() {
+		_, err = editor.Remove(dir)
+		if isMissing(err) {
+			err = nil
+		}
+	} else {
+		err = editor.Set(dir, *item)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	m.replaced[dir] = true
+	return true, nil
+}
+
+func sameTree(oid1, oid2 *rawgit.OID) bool {
+	if oid1 == nil || oid2 == nil {
+		return oid1 == oid2
+	}
+	return oid1.Equal(oid2)
+}
+
+// isMissing reports whether tree editor found no item at path
+func isMissing(err error) bool {
+	return err == rawgit.ErrNotFound || err == rawgit.ErrNotADirectory
+}
+
+// isFileDirectory reports whether tree editor could not put file and directory
+// at the same path
+func isFileDirectory(err error) bool {
+	return err == rawgit.ErrIsADirectory || err == rawgit.ErrNotADirectory
+}
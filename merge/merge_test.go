@@ -0,0 +1,207 @@
+package merge
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mechmind/git-go/rawgit"
+	"github.com/mechmind/git-go/storage/fsstor"
+)
+
+// numbered makes text of numbered lines, replacing some of them
+func numbered(count int, replaced map[int]string) string {
+	var sb strings.Builder
+	for n := 1; n <= count; n++ {
+		if line, ok := replaced[n]; ok {
+			sb.WriteString(line)
+		} else {
+			fmt.Fprintf(&sb, "line %d\n", n)
+		}
+	}
+	return sb.String()
+}
+
+// writeTestTree writes files into repository and returns id of the root tree.
+// Files are regular, unless their contents start with "exec:"
+func writeTestTree(t *testing.T, repo rawgit.Repository, files map[string]string) *rawgit.OID {
+	dirs := make(map[string]map[string]string)
+	tree := &rawgit.Tree{}
+	for path, data := range files {
+		if slash := strings.IndexByte(path, '/'); slash != -1 {
+			dir := path[:slash]
+			if dirs[dir] == nil {
+				dirs[dir] = make(map[string]string)
+			}
+			dirs[dir][path[slash+1:]] = data
+			continue
+		}
+
+		mode := uint32(rawgit.TreeBlobMode)
+		if strings.HasPrefix(data, "exec:") {
+			mode = rawgit.TreeExecutableBlobMode
+		}
+		oid, err := repo.WriteBlob([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree.Items = append(tree.Items, rawgit.TreeItem{Name: path, Mode: mode, OID: *oid})
+	}
+
+	for dir, files := range dirs {
+		oid := writeTestTree(t, repo, files)
+		tree.Items = append(tree.Items, rawgit.TreeItem{Name: dir, Mode: rawgit.TreeDirectoryMode, OID: *oid})
+	}
+
+	tree.Sort()
+	oid, err := repo.WriteTree(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return oid
+}
+
+func TestMergeTrees(t *testing.T) {
+	text := numbered(20, nil)
+	tests := []struct {
+		name               string
+		base, ours, theirs map[string]string
+		// merged tree, if there are no conflicts
+		want map[string]string
+		// conflicts as "<type> <path>"
+		conflicts []string
+	}{
+		{
+			name:   "different files",
+			base:   map[string]string{"a": "a\n", "b": "b\n", "dir/c": "c\n"},
+			ours:   map[string]string{"a": "ours\n", "b": "b\n", "dir/c": "c\n"},
+			theirs: map[string]string{"a": "a\n", "dir/c": "theirs\n", "dir/d": "d\n"},
+			want:   map[string]string{"a": "ours\n", "dir/c": "theirs\n", "dir/d": "d\n"},
+		},
+		{
+			name:   "content merge",
+			base:   map[string]string{"file": text},
+			ours:   map[string]string{"file": numbered(20, map[int]string{2: "ours\n"})},
+			theirs: map[string]string{"file": numbered(20, map[int]string{18: "theirs\n"})},
+			want:   map[string]string{"file": numbered(20, map[int]string{2: "ours\n", 18: "theirs\n"})},
+		},
+		{
+			name:   "mode and content",
+			base:   map[string]string{"tool": "tool\n"},
+			ours:   map[string]string{"tool": "exec:tool\n"},
+			theirs: map[string]string{"tool": "tool\n", "other": "other\n"},
+			want:   map[string]string{"tool": "exec:tool\n", "other": "other\n"},
+		},
+		{
+			name:   "same changes",
+			base:   map[string]string{"file": text},
+			ours:   map[string]string{"file": "same\n", "new": "new\n"},
+			theirs: map[string]string{"file": "same\n", "new": "new\n"},
+			want:   map[string]string{"file": "same\n", "new": "new\n"},
+		},
+		{
+			name:   "rename and modification",
+			base:   map[string]string{"old": text},
+			ours:   map[string]string{"old": numbered(20, map[int]string{2: "ours\n"})},
+			theirs: map[string]string{"new": numbered(20, map[int]string{18: "theirs\n"})},
+			want:   map[string]string{"new": numbered(20, map[int]string{2: "ours\n", 18: "theirs\n"})},
+		},
+		{
+			name:   "untouched directory from theirs",
+			base:   map[string]string{"a": "a\n", "dir/x": "x\n"},
+			ours:   map[string]string{"a": "ours\n", "dir/x": "x\n"},
+			theirs: map[string]string{"a": "a\n", "dir/sub/y": "y\n", "dir/sub/z": "z\n"},
+			want:   map[string]string{"a": "ours\n", "dir/sub/y": "y\n", "dir/sub/z": "z\n"},
+		},
+		{
+			name:      "content conflict",
+			base:      map[string]string{"file": text},
+			ours:      map[string]string{"file": numbered(20, map[int]string{10: "ours\n"})},
+			theirs:    map[string]string{"file": numbered(20, map[int]string{10: "theirs\n"})},
+			conflicts: []string{"content file"},
+		},
+		{
+			name:      "add/add",
+			base:      map[string]string{"a": "a\n"},
+			ours:      map[string]string{"a": "a\n", "new": "ours\n"},
+			theirs:    map[string]string{"a": "a\n", "new": "theirs\n"},
+			conflicts: []string{"add/add new"},
+		},
+		{
+			name:      "modify/delete",
+			base:      map[string]string{"a": "a\n", "b": "b\n"},
+			ours:      map[string]string{"a": "ours\n"},
+			theirs:    map[string]string{"b": "theirs\n"},
+			conflicts: []string{"modify/delete a", "modify/delete b"},
+		},
+		{
+			name:      "rename/rename",
+			base:      map[string]string{"old": text},
+			ours:      map[string]string{"ours": text},
+			theirs:    map[string]string{"theirs": text},
+			conflicts: []string{"rename/rename old"},
+		},
+		{
+			name:      "rename/delete",
+			base:      map[string]string{"a": text, "b": numbered(30, nil)},
+			ours:      map[string]string{"renamed-a": text},
+			theirs:    map[string]string{"renamed-b": numbered(30, nil)},
+			conflicts: []string{"rename/delete renamed-a", "rename/delete renamed-b"},
+		},
+		{
+			name:      "file in place of directory",
+			base:      map[string]string{"a": "a\n"},
+			ours:      map[string]string{"a": "a\n", "p": "file\n"},
+			theirs:    map[string]string{"a": "a\n", "p/x": "x\n"},
+			conflicts: []string{"file/directory p/x"},
+		},
+		{
+			// theirs subdirectory is taken as a whole, but ours side made its
+			// parent a file
+			name:      "file in place of replaced directory",
+			base:      map[string]string{"p/e": "e\n"},
+			ours:      map[string]string{"p": "file\n"},
+			theirs:    map[string]string{"p/e": "e\n", "p/d/x": "x\n", "p/d/y": "y\n"},
+			conflicts: []string{"file/directory p/d/x", "file/directory p/d/y"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stor, err := fsstor.InitFSStorage(fsstor.NewOSFS(t.TempDir()), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo := rawgit.NewRepository(stor, stor)
+			base := writeTestTree(t, repo, test.base)
+			ours := writeTestTree(t, repo, test.ours)
+			theirs := writeTestTree(t, repo, test.theirs)
+
+			merged, conflicts, err := MergeTrees(repo, base, ours, theirs, &Options{DetectRenames: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, conflict := range conflicts {
+				got = append(got, conflict.Type.String()+" "+conflict.Path)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, test.conflicts) {
+				t.Fatalf("got conflicts %q, want %q", got, test.conflicts)
+			}
+
+			if test.conflicts != nil {
+				if merged != nil {
+					t.Errorf("conflicting merge wrote tree %s", merged)
+				}
+				return
+			}
+			if want := writeTestTree(t, repo, test.want); merged == nil || *merged != *want {
+				t.Errorf("got tree %v, want %s", merged, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package merge
+
+import (
+	"bytes"
+
+	"github.com/mechmind/git-go/diff"
+)
+
+// hunk of three-way merge, as in git's xmerge. Ranges of base, ours and theirs
+// lines are zero-based
+type mergeHunk struct {
+	side              hunkSide
+	base, baseLen     int
+	ours, oursLen     int
+	theirs, theirsLen int
+}
+
+type hunkSide int
+
+const (
+	conflictHunk hunkSide = iota
+	oursHunk
+	theirsHunk
+)
+
+// mergeText merges changes, which were made to base text on both sides, line by
+// line. It reports false, if changes overlap or touch each other, unless they
+// are the same. Binary texts are never merged
+func mergeText(base, ours, theirs []byte, algorithm diff.Algorithm) ([]byte, bool) {
+	if diff.IsBinary(base) || diff.IsBinary(ours) || diff.IsBinary(theirs) {
+		return nil, false
+	}
+
+	oursEdits := diff.DiffLinesNoHeuristic(base, ours, algorithm)
+	theirsEdits := diff.DiffLinesNoHeuristic(base, theirs, algorithm)
+	switch {
+	case len(oursEdits) == 0:
+		return theirs, true
+	case len(theirsEdits) == 0:
+		return ours, true
+	}
+
+	baseCount := len(diff.SplitLines(base))
+	oursLines, theirsLines := diff.SplitLines(ours), diff.SplitLines(theirs)
+	hunks := mergeEdits(oursEdits, theirsEdits, baseCount, oursLines, theirsLines)
+
+	// everything out of hunks is taken from ours
+	var merged bytes.Buffer
+	pos := 0
+	for _, hunk := range hunks {
+		if hunk.side == conflictHunk {
+			// conflicts, which turn out to be the same changes, are kept as in ours
+			oursPart := oursLines[hunk.ours : hunk.ours+hunk.oursLen]
+			theirsPart := theirsLines[hunk.theirs : hunk.theirs+hunk.theirsLen]
+			if len(oursPart) == 0 || len(theirsPart) == 0 || !equalLines(oursPart, theirsPart) {
+				return nil, false
+			}
+			continue
+		}
+
+		writeLines(&merged, oursLines[pos:hunk.ours])
+		if hunk.side == oursHunk {
+			writeLines(&merged, oursLines[hunk.ours:hunk.ours+hunk.oursLen])
+		} else {
+			writeLines(&merged, theirsLines[hunk.theirs:hunk.theirs+hunk.theirsLen])
+		}
+		pos = hunk.ours + hunk.oursLen
+	}
+	writeLines(&merged, oursLines[pos:])
+
+	return merged.Bytes(), true
+}
+
+// mergeEdits walks changes of both sides in order of base lines and joins them
+// into hunks. Overlapping and adjacent changes of different sides make
+// conflicts, unless they are the same
+func mergeEdits(oursEdits, theirsEdits []diff.Edit, baseCount int, oursLines, theirsLines [][]byte) []mergeHunk {
+	var hunks []mergeHunk
+	for len(oursEdits) > 0 && len(theirsEdits) > 0 {
+		ours, theirs := &oursEdits[0], &theirsEdits[0]
+		switch {
+		case ours.OldStart+ours.OldLines < theirs.OldStart:
+			theirsStart := theirs.NewStart - theirs.OldStart + ours.OldStart
+			hunks = appendHunk(hunks, mergeHunk{oursHunk, ours.OldStart, ours.OldLines,
+				ours.NewStart, ours.NewLines, theirsStart, ours.OldLines})
+			oursEdits = oursEdits[1:]
+			continue
+		case theirs.OldStart+theirs.OldLines < ours.OldStart:
+			oursStart := ours.NewStart - ours.OldStart + theirs.OldStart
+			hunks = appendHunk(hunks, mergeHunk{theirsHunk, theirs.OldStart, theirs.OldLines,
+				oursStart, theirs.OldLines, theirs.NewStart, theirs.NewLines})
+			theirsEdits = theirsEdits[1:]
+			continue
+		}
+
+		same := ours.OldStart == theirs.OldStart && ours.OldLines == theirs.OldLines && ours.NewLines == theirs.NewLines &&
+			equalLines(oursLines[ours.NewStart:ours.NewStart+ours.NewLines], theirsLines[theirs.NewStart:theirs.NewStart+theirs.NewLines])
+		if !same {
+			// conflict covers both changes
+			hunk := mergeHunk{side: conflictHunk, base: ours.OldStart, ours: ours.NewStart, theirs: theirs.NewStart}
+			if off := ours.OldStart - theirs.OldStart; off > 0 {
+				hunk.base -= off
+				hunk.ours -= off
+			} else {
+				hunk.theirs += off
+			}
+			hunk.baseLen = ours.OldStart + ours.OldLines - hunk.base
+			hunk.oursLen = ours.NewStart + ours.NewLines - hunk.ours
+			hunk.theirsLen = theirs.NewStart + theirs.NewLines - hunk.theirs
+			if ffo := ours.OldStart + ours.OldLines - theirs.OldStart - theirs.OldLines; ffo < 0 {
+				hunk.baseLen -= ffo
+				hunk.oursLen -= ffo
+			} else {
+				hunk.theirsLen += ffo
+			}
+			hunks = appendHunk(hunks, hunk)
+		}
+
+		oursEnd, theirsEnd := ours.OldStart+ours.OldLines, theirs.OldStart+theirs.OldLines
+		if oursEnd >= theirsEnd {
+			theirsEdits = theirsEdits[1:]
+		}
+		if theirsEnd >= oursEnd {
+			oursEdits = oursEdits[1:]
+		}
+	}
+
+	for _, ours := range oursEdits {
+		hunks = appendHunk(hunks, mergeHunk{oursHunk, ours.OldStart, ours.OldLines,
+			ours.NewStart, ours.NewLines, ours.OldStart + len(theirsLines) - baseCount, ours.OldLines})
+	}
+	for _, theirs := range theirsEdits {
+		hunks = appendHunk(hunks, mergeHunk{theirsHunk, theirs.OldStart, theirs.OldLines,
+			theirs.OldStart + len(oursLines) - baseCount, theirs.OldLines, theirs.NewStart, theirs.NewLines})
+	}
+
+	return hunks
+}
+
+// appendHunk appends hunk or joins it with the last one, if they overlap or
+// touch. Joined hunks of different sides become conflict
+func appendHunk(hunks []mergeHunk, hunk mergeHunk) []mergeHunk {
+	if len(hunks) == 0 {
+		return append(hunks, hunk)
+	}
+
+	last := &hunks[len(hunks)-1]
+	if hunk.ours > last.ours+last.oursLen && hunk.theirs > last.theirs+last.theirsLen {
+		return append(hunks, hunk)
+	}
+
+	if hunk.side != last.side {
+		last.side = conflictHunk
+	}
+	last.baseLen = hunk.base + hunk.baseLen - last.base
+	last.oursLen = hunk.ours + hunk.oursLen - last.ours
+	last.theirsLen = hunk.theirs + hunk.theirsLen - last.theirs
+	return hunks
+}
+
+func equalLines(lines1, lines2 [][]byte) bool {
+	if len(lines1) != len(lines2) {
+		return false
+	}
+	for idx := range lines1 {
+		if !bytes.Equal(lines1[idx], lines2[idx]) {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLines(buf *bytes.Buffer, lines [][]byte) {
+	for _, line := range lines {
+		buf.Write(line)
+	}
+}
@@ -0,0 +1,48 @@
+package rawgit
+
+const (
+	// same as git's default core.abbrev
+	DefaultAbbrevLength = 7
+	// git never makes shorter abbreviations
+	MinAbbrevLength = 4
+)
+
+// Abbreviator is implemented by storages, that can find shortest unique prefix of
+// object id without listing all matching objects
+type Abbreviator interface {
+	AbbreviateOID(oid *OID, minLength int) (string, error)
+}
+
+// AbbreviateOID returns shortest prefix of object id, that is unique in the storage,
+// but not shorter than minLength, like 'git rev-parse --short' does
+func AbbreviateOID(storage Storage, oid *OID, minLength int) (string, error) {
+	if abbreviator, ok := storage.(Abbreviator); ok {
+		return abbreviator.AbbreviateOID(oid, minLength)
+	}
+
+	hash := oid.String()
+	if minLength < MinAbbrevLength {
+		minLength = MinAbbrevLength
+	}
+
+	for length := minLength; length < len(hash); length++ {
+		infos, err := storage.MatchObjectsPrefix(hash[:length])
+		if err != nil {
+			return "", err
+		}
+
+		unique := true
+		for _, info := range infos {
+			if !info.GetOID().Equal(oid) {
+				unique = false
+				break
+			}
+		}
+
+		if unique {
+			return hash[:length], nil
+		}
+	}
+
+	return hash, nil
+}
@@ -1,20 +1,13 @@
 package rawgit
 
 import (
+	"bytes"
 	"io"
 	"io/ioutil"
-	"strconv"
-	"strings"
-	"time"
 )
 
 const CommitEntryBufferSize = 1024
 
-type UserTime struct {
-	Name, Email string
-	Time        time.Time
-}
-
 type Commit struct {
 	OID
 	OType
@@ -23,179 +16,125 @@ type Commit struct {
 	Author     UserTime
 	Committer  UserTime
 	Encoding   string
-	Message    string
+	// headers following committer, like 'mergetag' or 'gpgsig', in original order
+	ExtraHeaders Headers
+	Message      string
+
+	// number of extra headers preceding encoding header
+	encodingPos int
 }
 
 func ReadCommit(obj io.ReadCloser) (*Commit, error) {
 	var commit = new(Commit)
 	commit.OType = OTypeCommit
 
-	var infobuf = make([]byte, CommitEntryBufferSize)
-	var name string
-
 	defer obj.Close()
 
-	// read tree id
-	buf, err := scanUntil(obj, ' ', infobuf)
+	data, err := ioutil.ReadAll(obj)
 	if err != nil {
 		return nil, err
 	}
-	name = string(buf)
-	if name != "tree" {
-		return nil, ErrNoTree
-	}
 
-	buf, err = scanUntil(obj, '\n', infobuf)
+	headers, message, err := parseHeaders(data)
 	if err != nil {
 		return nil, err
 	}
+	commit.Message = message
 
-	oid, err := ParseOID(string(buf))
+	// read tree id
+	if len(headers) == 0 || headers[0].Key != "tree" {
+		return nil, ErrNoTree
+	}
+
+	commit.TreeOID, err = ParseOID(headers[0].Value)
 	if err != nil {
 		return nil, err
 	}
-	commit.TreeOID = oid
+	headers = headers[1:]
 
 	// read parents, if any
-	for {
-		buf, err = scanUntil(obj, ' ', infobuf)
-		if err != nil {
-			return nil, err
-		}
-		name = string(buf)
-		if name != "parent" {
-			// end of parents
-			break
-		}
-
-		buf, err = scanUntil(obj, '\n', infobuf)
-		if err != nil {
-			return nil, err
-		}
-
-		oid, err := ParseOID(string(buf))
+	for len(headers) > 0 && headers[0].Key == "parent" {
+		oid, err := ParseOID(headers[0].Value)
 		if err != nil {
 			return nil, err
 		}
 
 		commit.ParentOIDs = append(commit.ParentOIDs, oid)
+		headers = headers[1:]
 	}
 
-	// read author record
-	// 'name' already read
-	if name != "author" {
+	if len(headers) == 0 || headers[0].Key != "author" {
 		return nil, ErrNoAuthor
 	}
 
-	userTime, err := readUserRecord(obj, infobuf)
-	if err != nil {
-		return nil, err
-	}
-
-	commit.Author = userTime
-
-	// read commiter record
-	buf, err = scanUntil(obj, ' ', infobuf)
+	commit.Author, err = ParseUserTime(headers[0].Value)
 	if err != nil {
 		return nil, err
 	}
+	headers = headers[1:]
 
-	name = string(buf)
-	if name != "committer" {
+	if len(headers) == 0 || headers[0].Key != "committer" {
 		return nil, ErrNoCommitter
 	}
 
-	userTime, err = readUserRecord(obj, infobuf)
-	if err != nil {
-		return nil, err
-	}
-
-	commit.Committer = userTime
-
-	// read encoding, if any
-	buf, err = scanUntil(obj, '\n', infobuf)
+	commit.Committer, err = ParseUserTime(headers[0].Value)
 	if err != nil {
 		return nil, err
 	}
 
-	encLine := string(buf)
-	if encLine != "" {
-		idx := strings.Index(encLine, " ")
-		if idx == -1 {
-			return nil, ErrInvalidEncoding
-		}
-		if encLine[:idx] != "encoding" {
-			return nil, ErrInvalidEncoding
+	// the rest are optional headers
+	encodingSeen := false
+	for _, header := range headers[1:] {
+		if header.Key == "encoding" && !encodingSeen {
+			encodingSeen = true
+			commit.Encoding = header.Value
+			commit.encodingPos = len(commit.ExtraHeaders)
+			continue
 		}
-		commit.Encoding = encLine[idx+1:]
 
-		// read empty line before message
-		buf, err = scanUntil(obj, '\n', infobuf)
-		if err != nil {
-			return nil, err
-		}
-		if len(buf) != 0 {
-			return nil, ErrInvalidRecord
-		}
+		commit.ExtraHeaders = append(commit.ExtraHeaders, header)
 	}
 
-	// read commit message
-	msg, err := ioutil.ReadAll(obj)
-	if err != nil {
-		return nil, err
-	}
-
-	commit.Message = string(msg)
-
 	return commit, nil
 }
 
-func readUserRecord(obj io.ReadCloser, infobuf []byte) (UserTime, error) {
-	buf, err := scanUntil(obj, '<', infobuf)
-	if err != nil {
-		return UserTime{}, err
+// Encode formats commit as git object contents
+func (commit *Commit) Encode() []byte {
+	var buf bytes.Buffer
+	writeHeader(&buf, "tree", commit.TreeOID.String())
+	for _, parent := range commit.ParentOIDs {
+		writeHeader(&buf, "parent", parent.String())
 	}
+	writeHeader(&buf, "author", commit.Author.String())
+	writeHeader(&buf, "committer", commit.Committer.String())
 
-	if len(buf) < 1 {
-		return UserTime{}, ErrNoAuthor
-	}
-	username := string(buf[:len(buf)-1])
-
-	buf, err = scanUntil(obj, ' ', infobuf)
-	if err != nil {
-		return UserTime{}, err
+	encodingPos := commit.encodingPos
+	if encodingPos > len(commit.ExtraHeaders) {
+		encodingPos = len(commit.ExtraHeaders)
 	}
 
-	if len(buf) < 1 {
-		return UserTime{}, ErrInvalidEmail
-	}
-	email := string(buf[:len(buf)-1])
-
-	buf, err = scanUntil(obj, ' ', infobuf)
-	if err != nil {
-		return UserTime{}, err
-	}
-	timestamp, err := strconv.ParseInt(string(buf), 10, 32)
-
-	if err != nil {
-		return UserTime{}, err
+	for idx := 0; idx <= len(commit.ExtraHeaders); idx++ {
+		if idx == encodingPos && commit.Encoding != "" {
+			writeHeader(&buf, "encoding", commit.Encoding)
+		}
+		if idx < len(commit.ExtraHeaders) {
+			header := commit.ExtraHeaders[idx]
+			writeHeader(&buf, header.Key, header.Value)
+		}
 	}
 
-	buf, err = scanUntil(obj, '\n', infobuf)
-	if err != nil {
-		return UserTime{}, err
-	}
+	buf.WriteString("\n")
+	buf.WriteString(commit.Message)
 
-	timezone, err := strconv.ParseInt(string(buf), 10, 32)
-	if err != nil {
-		return UserTime{}, err
-	}
-	timezone /= 100
+	return buf.Bytes()
+}
 
-	// git does not provide timezone info, so we use fake timezone with proper time shift
-	location := time.FixedZone("GIT", int(timezone)*60*60)
-	commitDate := time.Unix(timestamp, 0).In(location)
+func (commit *Commit) WriteTo(dst io.Writer) (int64, error) {
+	n, err := dst.Write(commit.Encode())
+	return int64(n), err
+}
 
-	userTime := UserTime{username, email, commitDate}
-	return userTime, nil
+// DecodedMessage returns commit message, converted from commit encoding to UTF-8
+func (commit *Commit) DecodedMessage() (string, error) {
+	return DecodeMessage(commit.Message, commit.Encoding)
 }
@@ -0,0 +1,265 @@
+package rawgit
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// ConfigReader is implemented by storages, which keep repository config
+type ConfigReader interface {
+	ReadConfig() (*Config, error)
+}
+
+// Config holds variables of git config file. Section and variable names are
+// case insensitive, subsection names are not. Includes are not followed
+type Config struct {
+	vars []configVar
+}
+
+type configVar struct {
+	section, subsection, name string
+	value                     string
+}
+
+// Get returns the last value of variable. Variables without value, which git
+// takes for boolean true, have empty value
+func (c *Config) Get(section, subsection, name string) (string, bool) {
+	values := c.GetAll(section, subsection, name)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// GetAll returns all values of multivalued variable in order of appearance
+func (c *Config) GetAll(section, subsection, name string) []string {
+	section, name = strings.ToLower(section), strings.ToLower(name)
+	var values []string
+	for _, v := range c.vars {
+		if v.section == section && v.subsection == subsection && v.name == name {
+			values = append(values, v.value)
+		}
+	}
+	return values
+}
+
+// ParseConfig reads config in git format
+func ParseConfig(src io.Reader) (*Config, error) {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &configParser{data: data}
+	return p.parse()
+}
+
+type configParser struct {
+	data []byte
+	pos  int
+
+	section, subsection string
+	sectionSeen         bool
+}
+
+func (p *configParser) parse() (*Config, error) {
+	config := &Config{}
+	for {
+		for p.pos < len(p.data) && isConfigSpace(p.data[p.pos]) {
+			p.pos++
+		}
+		if p.pos == len(p.data) {
+			return config, nil
+		}
+
+		c := p.data[p.pos]
+		switch {
+		case c == '#' || c == ';':
+			p.skipLine()
+		case c == '[':
+			err := p.parseSection()
+			if err != nil {
+				return nil, err
+			}
+		case isConfigNameChar(c) && c != '-' && p.sectionSeen:
+			v, err := p.parseVariable()
+			if err != nil {
+				return nil, err
+			}
+			config.vars = append(config.vars, v)
+		default:
+			return nil, ErrInvalidConfig
+		}
+	}
+}
+
+func (p *configParser) skipLine() {
+	for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+		p.pos++
+	}
+}
+
+// parseSection parses '[section]', '[section "subsection"]' or legacy
+// '[section.subsection]' header
+func (p *configParser) parseSection() error {
+	p.pos++
+	start := p.pos
+	for p.pos < len(p.data) && (isConfigNameChar(p.data[p.pos]) || p.data[p.pos] == '.') {
+		p.pos++
+	}
+	if p.pos == len(p.data) || p.pos == start {
+		return ErrInvalidConfig
+	}
+	name := string(p.data[start:p.pos])
+
+	p.sectionSeen = true
+	if p.data[p.pos] == ']' {
+		p.pos++
+		// legacy subsections are case insensitive
+		name = strings.ToLower(name)
+		if dot := strings.IndexByte(name, '.'); dot != -1 {
+			p.section, p.subsection = name[:dot], name[dot+1:]
+		} else {
+			p.section, p.subsection = name, ""
+		}
+		return nil
+	}
+
+	for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t') {
+		p.pos++
+	}
+	if p.pos == len(p.data) || p.data[p.pos] != '"' || strings.IndexByte(name, '.') != -1 {
+		return ErrInvalidConfig
+	}
+	p.pos++
+
+	var sub strings.Builder
+	for {
+		if p.pos == len(p.data) || p.data[p.pos] == '\n' {
+			return ErrInvalidConfig
+		}
+		c := p.data[p.pos]
+		p.pos++
+		if c == '"' {
+			break
+		}
+		if c == '\\' {
+			if p.pos == len(p.data) || p.data[p.pos] == '\n' {
+				return ErrInvalidConfig
+			}
+			// git drops backslash before any character
+			c = p.data[p.pos]
+			p.pos++
+		}
+		sub.WriteByte(c)
+	}
+
+	if p.pos == len(p.data) || p.data[p.pos] != ']' {
+		return ErrInvalidConfig
+	}
+	p.pos++
+	p.section, p.subsection = strings.ToLower(name), sub.String()
+	return nil
+}
+
+func (p *configParser) parseVariable() (configVar, error) {
+	start := p.pos
+	for p.pos < len(p.data) && isConfigNameChar(p.data[p.pos]) {
+		p.pos++
+	}
+	v := configVar{section: p.section, subsection: p.subsection, name: strings.ToLower(string(p.data[start:p.pos]))}
+
+	for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t') {
+		p.pos++
+	}
+	if p.pos == len(p.data) {
+		return v, nil
+	}
+	switch p.data[p.pos] {
+	case '\n', '\r', '#', ';':
+		p.skipLine()
+		return v, nil
+	case '=':
+		p.pos++
+	default:
+		return v, ErrInvalidConfig
+	}
+
+	value, err := p.parseValue()
+	v.value = value
+	return v, err
+}
+
+// parseValue parses the rest of line as value. Whitespace outside of quotes is
+// collapsed only at the ends of value, comments end it
+func (p *configParser) parseValue() (string, error) {
+	var value strings.Builder
+	quoted := false
+	// whitespace, which is written only if value continues after it
+	pending := 0
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+
+		switch {
+		case c == '\n':
+			if quoted {
+				return "", ErrInvalidConfig
+			}
+			return value.String(), nil
+		case !quoted && (c == ' ' || c == '\t' || c == '\r'):
+			if value.Len() > 0 {
+				pending++
+			}
+			continue
+		case !quoted && (c == '#' || c == ';'):
+			p.skipLine()
+			return value.String(), nil
+		}
+
+		for ; pending > 0; pending-- {
+			value.WriteByte(' ')
+		}
+
+		switch c {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if p.pos == len(p.data) {
+				return "", ErrInvalidConfig
+			}
+			c = p.data[p.pos]
+			p.pos++
+			switch c {
+			case '\n':
+				// line continuation
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'b':
+				value.WriteByte('\b')
+			case '\\', '"':
+				value.WriteByte(c)
+			default:
+				return "", ErrInvalidConfig
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+
+	if quoted {
+		return "", ErrInvalidConfig
+	}
+	return value.String(), nil
+}
+
+func isConfigSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func isConfigNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-'
+}
@@ -0,0 +1,86 @@
+package rawgit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(`# comment
+[core]
+	bare = false
+	FileMode=true ; comment
+[Remote "origin"]
+	url = https://example.com/repo.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+[branch "Feature\"x\\y"]
+	remote = .
+[Branch.Legacy]
+	merge = refs/heads/legacy
+[user]
+	name = "  A U Thor "  # spaces inside of quotes are kept
+	email = author@example.com
+	signingKey = one \
+two
+	quoted = "a;b#c" \t\"x\" \\n
+	flag
+	multi   =   inner 	  spaces   here  
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		section, subsection, name string
+		want                      []string
+	}{
+		{"core", "", "bare", []string{"false"}},
+		{"CORE", "", "filemode", []string{"true"}},
+		{"remote", "origin", "url", []string{"https://example.com/repo.git"}},
+		{"remote", "origin", "fetch", []string{"+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*"}},
+		{"remote", "Origin", "url", nil},
+		{"branch", `Feature"x\y`, "remote", []string{"."}},
+		{"branch", "legacy", "merge", []string{"refs/heads/legacy"}},
+		{"user", "", "name", []string{"  A U Thor "}},
+		{"user", "", "email", []string{"author@example.com"}},
+		{"user", "", "signingkey", []string{"one two"}},
+		{"user", "", "quoted", []string{"a;b#c \t\"x\" \\n"}},
+		{"user", "", "flag", []string{""}},
+		// each whitespace inside of value becomes a space
+		{"user", "", "multi", []string{"inner    spaces   here"}},
+		{"user", "", "missing", nil},
+	}
+
+	for _, test := range tests {
+		got := config.GetAll(test.section, test.subsection, test.name)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s.%s.%s: got %q, want %q", test.section, test.subsection, test.name, got, test.want)
+		}
+	}
+
+	if value, ok := config.Get("remote", "origin", "fetch"); !ok || value != "+refs/tags/*:refs/tags/*" {
+		t.Errorf("last value is %q, %v", value, ok)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []string{
+		"key = value\n",
+		"[section\n",
+		"[section \"sub]\n",
+		"[section \"sub\"\n",
+		"[sec.tion \"sub\"]\n",
+		"[section]\nkey = \"unterminated\n",
+		"[section]\nkey = bad \\escape\n",
+		"[section]\n-key = value\n",
+		"[section]\nkey value\n",
+	}
+
+	for _, data := range tests {
+		if _, err := ParseConfig(strings.NewReader(data)); err != ErrInvalidConfig {
+			t.Errorf("%q: got error %v", data, err)
+		}
+	}
+}
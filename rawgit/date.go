@@ -0,0 +1,206 @@
+package rawgit
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// absolute date formats, zoneless ones are in local time
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006/01/02 15:04:05",
+	"2006.01.02 15:04:05",
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"Mon Jan 2 15:04:05 2006 -0700",
+	"Mon Jan 2 15:04:05 2006",
+	time.RFC850,
+}
+
+// dates without time, git takes time of day from now for them
+var dayLayouts = []string{
+	"2006-01-02",
+	"2006/01/02",
+	"2006.01.02",
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseDate parses date the way git parses dates in options like '--since' and
+// revisions like 'master@{yesterday}'. Supported are raw git dates
+// ('1500000000 +0300'), unix timestamps prefixed with '@', ISO 8601 and RFC 2822
+// dates and approximate dates like '2 weeks ago', '1 year 3 months ago',
+// 'yesterday noon' or 'last friday'. Words of approximate dates may be separated
+// by dots, as in 'master@{1.week.ago}'. Approximate dates are counted from now
+func ParseDate(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, ErrInvalidDate
+	}
+
+	if strings.HasPrefix(value, "@") {
+		timestamp, err := strconv.ParseInt(value[1:], 10, 64)
+		if err != nil {
+			return time.Time{}, ErrInvalidDate
+		}
+		return time.Unix(timestamp, 0), nil
+	}
+
+	if date, ok := parseRawDate(value); ok {
+		return date, nil
+	}
+
+	for _, layout := range dateLayouts {
+		if date, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return date, nil
+		}
+	}
+
+	for _, layout := range dayLayouts {
+		if date, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return date.Add(now.Sub(atHour(now, 0))), nil
+		}
+	}
+
+	return parseApproxDate(value, now)
+}
+
+// parseRawDate parses git internal date format: '1500000000 +0300'
+func parseRawDate(value string) (time.Time, bool) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 || len(fields[0]) < 9 || len(fields[1]) != 5 {
+		return time.Time{}, false
+	}
+
+	timestamp, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || fields[1][0] != '+' && fields[1][0] != '-' {
+		return time.Time{}, false
+	}
+	if _, err := strconv.Atoi(fields[1][1:]); err != nil {
+		return time.Time{}, false
+	}
+
+	location := time.FixedZone("GIT", parseZone(fields[1]))
+	return time.Unix(timestamp, 0).In(location), true
+}
+
+func parseApproxDate(value string, now time.Time) (time.Time, error) {
+	words := strings.FieldsFunc(strings.ToLower(value), func(c rune) bool {
+		return c == ' ' || c == '.' || c == '_' || c == ','
+	})
+
+	date := now
+	number := -1
+	matched := false
+	for _, word := range words {
+		if n, err := strconv.Atoi(word); err == nil {
+			number = n
+			continue
+		}
+
+		switch word {
+		case "ago", "and":
+			continue
+		case "now", "today":
+		case "last":
+			number = 1
+			continue
+		case "yesterday":
+			date = date.AddDate(0, 0, -1)
+		case "midnight":
+			date = atTime(date, 0)
+		case "noon":
+			date = atTime(date, 12)
+		case "tea":
+			date = atTime(date, 17)
+		default:
+			if weekday, ok := parseWeekday(word); ok {
+				// the most recent such day before today
+				days := int(date.Weekday()-weekday+7) % 7
+				if days == 0 {
+					days = 7
+				}
+				date = date.AddDate(0, 0, -days)
+				break
+			}
+
+			n := number
+			if n == -1 {
+				n = 1
+			}
+
+			var ok bool
+			date, ok = subtractUnits(date, word, n)
+			if !ok {
+				return time.Time{}, ErrInvalidDate
+			}
+		}
+
+		number = -1
+		matched = true
+	}
+
+	if !matched || number != -1 {
+		return time.Time{}, ErrInvalidDate
+	}
+	return date, nil
+}
+
+func subtractUnits(date time.Time, unit string, n int) (time.Time, bool) {
+	unit = strings.TrimSuffix(unit, "s")
+	switch unit {
+	case "second", "sec":
+		return date.Add(-time.Duration(n) * time.Second), true
+	case "minute", "min":
+		return date.Add(-time.Duration(n) * time.Minute), true
+	case "hour":
+		return date.Add(-time.Duration(n) * time.Hour), true
+	case "day":
+		return date.AddDate(0, 0, -n), true
+	case "week":
+		return date.AddDate(0, 0, -7*n), true
+	case "month":
+		return date.AddDate(0, -n, 0), true
+	case "year":
+		return date.AddDate(-n, 0, 0), true
+	}
+	return date, false
+}
+
+func parseWeekday(word string) (time.Weekday, bool) {
+	if len(word) < 3 {
+		return 0, false
+	}
+
+	weekday, ok := weekdayNames[word[:3]]
+	if !ok || !strings.HasPrefix(strings.ToLower(weekday.String()), word) {
+		return 0, false
+	}
+	return weekday, true
+}
+
+// atTime moves date to given hour of the same day, or of the day before if that
+// hour is not reached yet
+func atTime(date time.Time, hour int) time.Time {
+	if date.Hour() < hour {
+		date = date.AddDate(0, 0, -1)
+	}
+	return atHour(date, hour)
+}
+
+func atHour(date time.Time, hour int) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), hour, 0, 0, 0, date.Location())
+}
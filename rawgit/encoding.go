@@ -0,0 +1,23 @@
+package rawgit
+
+import (
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// DecodeMessage converts message from given encoding to UTF-8. Empty encoding means
+// UTF-8, as in git
+func DecodeMessage(message, encoding string) (string, error) {
+	switch strings.ToLower(encoding) {
+	case "", "utf-8", "utf8":
+		return message, nil
+	}
+
+	enc, err := htmlindex.Get(encoding)
+	if err != nil {
+		return "", ErrUnknownEncoding
+	}
+
+	return enc.NewDecoder().String(message)
+}
@@ -3,6 +3,7 @@ package rawgit
 import (
 	"errors"
 	"fmt"
+	"os"
 )
 
 type BoundError struct {
@@ -25,6 +26,9 @@ var (
 	ErrNotATag         = errors.New("not a tag object")
 	ErrInvalidPath     = errors.New("invalid path")
 	ErrNotFound        = errors.New("not found")
+	ErrNotADirectory   = errors.New("path component is not a directory")
+	ErrIsADirectory    = errors.New("path is a directory")
+	ErrPathExists      = errors.New("path already exists")
 )
 
 var (
@@ -43,6 +47,7 @@ var (
 	ErrNoTagger          = errors.New("no proper 'tagger' record in tag object")
 	ErrInvalidEmail      = errors.New("no proper email field in record")
 	ErrInvalidEncoding   = errors.New("malformed encoding record")
+	ErrUnknownEncoding   = errors.New("unknown message encoding")
 	ErrInvalidRecord     = errors.New("invalid record")
 )
 
@@ -56,9 +61,31 @@ var ErrAlreadyClosed = errors.New("already closed")
 
 var ErrInvalidRef = errors.New("invalid ref")
 
+var ErrInvalidRefName = errors.New("invalid ref name")
+
+var ErrSymrefTooDeep = errors.New("symbolic ref chain is too deep")
+
+var ErrUnbornBranch = errors.New("branch has no commits yet")
+
+var ErrDuplicateRefUpdate = errors.New("ref updated twice in one transaction")
+
+var ErrInvalidDate = errors.New("invalid date")
+
+var (
+	ErrNotSigned            = errors.New("object is not signed")
+	ErrUnsupportedSignature = errors.New("unsupported signature format")
+	ErrUnknownSigner        = errors.New("signature made by unknown key")
+	ErrBadSignature         = errors.New("bad signature")
+	ErrKeyExpired           = errors.New("signing key is expired")
+	ErrKeyRevoked           = errors.New("signing key is revoked")
+	ErrSignatureExpired     = errors.New("signature is expired")
+)
+
 var ErrAmbiguousShortHash = errors.New("ambiguous short object hash")
 
+var ErrInvalidConfig = errors.New("malformed config file")
+
+// IsNotExist reports whether error means that object or ref does not exist
 func IsNotExist(err error) bool {
-	// FIXME: make actual implementation
-	return false
+	return err == ErrNotFound || err == ErrObjectNotFound || err == ErrUnbornBranch || os.IsNotExist(err)
 }
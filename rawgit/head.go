@@ -0,0 +1,41 @@
+package rawgit
+
+// Head describes state of HEAD
+type Head struct {
+	// branch HEAD points to, empty when HEAD is detached
+	Branch string
+	// commit HEAD points to, nil when branch is unborn
+	OID *OID
+}
+
+// IsDetached reports whether HEAD points directly to a commit
+func (head *Head) IsDetached() bool {
+	return head.Branch == ""
+}
+
+// IsUnborn reports whether current branch has no commits yet, like in freshly
+// initialized repository
+func (head *Head) IsUnborn() bool {
+	return head.OID == nil
+}
+
+// ReadHead reads HEAD and resolves it
+func ReadHead(repo Repository) (*Head, error) {
+	head := &Head{}
+	branch, err := repo.ReadSymbolicRef("HEAD")
+	if err == nil {
+		head.Branch = branch
+	} else if err != ErrNotASymbolicRef {
+		return nil, err
+	}
+
+	oid, err := repo.ResolveRef("HEAD")
+	if err == ErrUnbornBranch {
+		return head, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	head.OID = oid
+	return head, nil
+}
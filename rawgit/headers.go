@@ -0,0 +1,80 @@
+package rawgit
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Header is a header record of commit or tag object, like 'gpgsig' or 'mergetag'.
+// Lines of multiline values are joined with '\n', without leading space of
+// continuation lines
+type Header struct {
+	Key, Value string
+}
+
+type Headers []Header
+
+// Get returns value of the first header with given key
+func (headers Headers) Get(key string) (string, bool) {
+	for _, header := range headers {
+		if header.Key == key {
+			return header.Value, true
+		}
+	}
+	return "", false
+}
+
+// GetAll returns values of all headers with given key
+func (headers Headers) GetAll(key string) []string {
+	var values []string
+	for _, header := range headers {
+		if header.Key == key {
+			values = append(values, header.Value)
+		}
+	}
+	return values
+}
+
+// parseHeaders splits object contents into header records and message
+func parseHeaders(data []byte) (Headers, string, error) {
+	var headers Headers
+	for {
+		if len(data) == 0 {
+			// object ends after headers, without message
+			return headers, "", nil
+		}
+
+		idx := bytes.IndexByte(data, '\n')
+		if idx == -1 {
+			return nil, "", ErrInvalidRecord
+		}
+
+		line := data[:idx]
+		data = data[idx+1:]
+		if len(line) == 0 {
+			// empty line separates message
+			return headers, string(data), nil
+		}
+
+		if line[0] == ' ' {
+			if len(headers) == 0 {
+				return nil, "", ErrInvalidRecord
+			}
+			headers[len(headers)-1].Value += "\n" + string(line[1:])
+			continue
+		}
+
+		sep := bytes.IndexByte(line, ' ')
+		if sep == -1 {
+			return nil, "", ErrInvalidRecord
+		}
+		headers = append(headers, Header{string(line[:sep]), string(line[sep+1:])})
+	}
+}
+
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key)
+	buf.WriteByte(' ')
+	buf.WriteString(strings.Replace(value, "\n", "\n ", -1))
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,304 @@
+package rawgit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testTreeHex   = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	testParentHex = "1f7a7a472abf3dd9643fd615f6da379c4acb3e3a"
+	testOtherHex  = "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+)
+
+var testSignature = "-----BEGIN PGP SIGNATURE-----\n" +
+	" \n" +
+	" iQEzBAABCAAdFiEEexampleexampleexampleexampleexampleFAmXexample\n" +
+	" AAoJEexampleexample=\n" +
+	" =abcd\n" +
+	" -----END PGP SIGNATURE-----"
+
+func TestCommitRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "root commit",
+			data: "tree " + testTreeHex + "\n" +
+				"author A U Thor <author@example.com> 1500000000 +0300\n" +
+				"committer C O Mitter <committer@example.com> 1500000100 -0130\n" +
+				"\n" +
+				"initial\n",
+		},
+		{
+			name: "merge with signature",
+			data: "tree " + testTreeHex + "\n" +
+				"parent " + testParentHex + "\n" +
+				"parent " + testOtherHex + "\n" +
+				"author A U Thor <author@example.com> 1500000000 +0300\n" +
+				"committer C O Mitter <committer@example.com> 1500000100 +0300\n" +
+				"mergetag object " + testOtherHex + "\n" +
+				" type commit\n" +
+				" tag v1.0\n" +
+				" tagger T Agger <tagger@example.com> 1400000000 +0000\n" +
+				" \n" +
+				" release\n" +
+				"gpgsig " + testSignature + "\n" +
+				"\n" +
+				"Merge tag 'v1.0'\n",
+		},
+		{
+			name: "encoding among extra headers",
+			data: "tree " + testTreeHex + "\n" +
+				"parent " + testParentHex + "\n" +
+				"author A U Thor <author@example.com> 1500000000 +0300\n" +
+				"committer C O Mitter <committer@example.com> 1500000100 +0300\n" +
+				"x-custom first\n" +
+				"encoding ISO-8859-1\n" +
+				"x-custom second\n" +
+				"\n" +
+				"caf\xe9\n",
+		},
+		{
+			name: "unusual identities",
+			data: "tree " + testTreeHex + "\n" +
+				"author  <> 0 +0000\n" +
+				"committer Name <email@example.com>  1500000100   +0300\n" +
+				"\n" +
+				"message without newline",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			commit, err := ReadCommit(ioutil.NopCloser(strings.NewReader(test.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			encoded := commit.Encode()
+			if string(encoded) != test.data {
+				t.Fatalf("encoded commit differs:\n%q\nwant:\n%q", encoded, test.data)
+			}
+
+			var buf bytes.Buffer
+			if _, err := commit.WriteTo(&buf); err != nil || buf.String() != test.data {
+				t.Errorf("WriteTo wrote %q, %v", buf.String(), err)
+			}
+
+			reparsed, err := ReadCommit(ioutil.NopCloser(bytes.NewReader(encoded)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(reparsed.ExtraHeaders, commit.ExtraHeaders) ||
+				reparsed.Encoding != commit.Encoding || reparsed.Message != commit.Message ||
+				len(reparsed.ParentOIDs) != len(commit.ParentOIDs) {
+				t.Errorf("reparsed commit differs: %+v", reparsed)
+			}
+		})
+	}
+}
+
+func TestReadWithoutMessage(t *testing.T) {
+	commit, err := ReadCommit(ioutil.NopCloser(strings.NewReader("tree " + testTreeHex + "\n" +
+		"author A U Thor <author@example.com> 1500000000 +0300\n" +
+		"committer C O Mitter <committer@example.com> 1500000100 +0300\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.Message != "" || commit.Committer.Email != "committer@example.com" {
+		t.Errorf("got commit %+v", commit)
+	}
+
+	tag, err := ReadTag(ioutil.NopCloser(strings.NewReader("object " + testParentHex + "\n" +
+		"type commit\n" +
+		"tag v1.0\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.Message != "" || tag.Name != "v1.0" {
+		t.Errorf("got tag %+v", tag)
+	}
+
+	// unterminated header is still invalid
+	if _, err := ReadTag(ioutil.NopCloser(strings.NewReader("object " + testParentHex + "\ntype commit"))); err == nil {
+		t.Error("unterminated header is accepted")
+	}
+}
+
+func TestCommitEncodeFields(t *testing.T) {
+	data := "tree " + testTreeHex + "\n" +
+		"author A U Thor <author@example.com> 1500000000 +0300\n" +
+		"committer C O Mitter <committer@example.com> 1500000100 +0300\n" +
+		"gpgsig " + testSignature + "\n" +
+		"\n" +
+		"message\n"
+	commit, err := ReadCommit(ioutil.NopCloser(strings.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// changed fields are written canonically, the rest is kept as is
+	commit.Author.Name = "New Name"
+	want := strings.Replace(data, "A U Thor", "New Name", 1)
+	if encoded := string(commit.Encode()); encoded != want {
+		t.Errorf("encoded commit differs:\n%q\nwant:\n%q", encoded, want)
+	}
+}
+
+func TestTagRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "annotated tag",
+			data: "object " + testParentHex + "\n" +
+				"type commit\n" +
+				"tag v1.0\n" +
+				"tagger T Agger <tagger@example.com> 1500000000 +0300\n" +
+				"\n" +
+				"release 1.0\n",
+		},
+		{
+			name: "signed tag",
+			data: "object " + testTreeHex + "\n" +
+				"type tree\n" +
+				"tag tree-tag\n" +
+				"tagger T Agger <tagger@example.com> 1500000000 -0700\n" +
+				"\n" +
+				"signed\n" +
+				strings.Replace(testSignature, "\n ", "\n", -1) + "\n",
+		},
+		{
+			name: "tag without tagger",
+			data: "object " + testOtherHex + "\n" +
+				"type blob\n" +
+				"tag old\n" +
+				"\n" +
+				"old style tag\n",
+		},
+		{
+			name: "extra headers",
+			data: "object " + testParentHex + "\n" +
+				"type commit\n" +
+				"tag v2.0\n" +
+				"tagger T Agger <tagger@example.com> 1500000000 +0000\n" +
+				"encoding ISO-8859-1\n" +
+				"x-custom multi\n" +
+				" line\n" +
+				"\n" +
+				"caf\xe9",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tag, err := ReadTag(ioutil.NopCloser(strings.NewReader(test.data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			encoded := tag.Encode()
+			if string(encoded) != test.data {
+				t.Fatalf("encoded tag differs:\n%q\nwant:\n%q", encoded, test.data)
+			}
+
+			var buf bytes.Buffer
+			if _, err := tag.WriteTo(&buf); err != nil || buf.String() != test.data {
+				t.Errorf("WriteTo wrote %q, %v", buf.String(), err)
+			}
+
+			reparsed, err := ReadTag(ioutil.NopCloser(bytes.NewReader(encoded)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reparsed.TargetOID != tag.TargetOID || reparsed.TargetOType != tag.TargetOType ||
+				reparsed.Name != tag.Name || (reparsed.Tagger == nil) != (tag.Tagger == nil) ||
+				!reflect.DeepEqual(reparsed.ExtraHeaders, tag.ExtraHeaders) || reparsed.Message != tag.Message {
+				t.Errorf("reparsed tag differs: %+v", reparsed)
+			}
+		})
+	}
+}
+
+func TestTreeRoundTrip(t *testing.T) {
+	oid, _ := ParseOID(testOtherHex)
+	dirOID, _ := ParseOID(testTreeHex)
+	item := func(name string, mode uint32) TreeItem {
+		if mode == TreeDirectoryMode {
+			return TreeItem{Name: name, Mode: mode, OID: *dirOID}
+		}
+		return TreeItem{Name: name, Mode: mode, OID: *oid}
+	}
+
+	// directories sort as if their names ended with '/'
+	tree := &Tree{Items: []TreeItem{
+		item("foo0", TreeBlobMode),
+		item("foo", TreeDirectoryMode),
+		item("foo.c", TreeExecutableBlobMode),
+		item("foo-bar", TreeSymlinkMode),
+		item("bar", TreeCommitMode),
+		item("a", TreeDirectoryMode),
+		item("a.b", TreeBlobMode),
+	}}
+	want := []string{"a.b", "a", "bar", "foo-bar", "foo.c", "foo", "foo0"}
+
+	data := tree.Encode()
+	tree.Sort()
+	var names []string
+	for _, item := range tree.Items {
+		names = append(names, item.Name)
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("got order %v, want %v", names, want)
+	}
+
+	parsed, err := ReadTree(ioutil.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.Items, tree.Items) {
+		t.Errorf("parsed tree differs:\n%v\nwant:\n%v", parsed.Items, tree.Items)
+	}
+
+	if encoded := parsed.Encode(); !bytes.Equal(encoded, data) {
+		t.Errorf("encoded tree differs:\n%q\nwant:\n%q", encoded, data)
+	}
+
+	var buf bytes.Buffer
+	if _, err := parsed.WriteTo(&buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("WriteTo wrote %q, %v", buf.Bytes(), err)
+	}
+}
+
+func TestTreeNonCanonicalModes(t *testing.T) {
+	oid, _ := ParseOID(testOtherHex)
+	entry := func(mode, name string) string {
+		return mode + " " + name + "\x00" + string(oid[:])
+	}
+	// modes written by old or foreign tools keep their bytes, so tree id is kept
+	data := []byte(entry("100664", "file") + entry("040000", "sub") + entry("100755", "tool"))
+
+	tree, err := ReadTree(ioutil.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := tree.Items[1].Mode; mode != TreeDirectoryMode {
+		t.Errorf("got mode %o, want %o", mode, TreeDirectoryMode)
+	}
+	if encoded := tree.Encode(); !bytes.Equal(encoded, data) {
+		t.Errorf("encoded tree differs:\n%q\nwant:\n%q", encoded, data)
+	}
+
+	// changed mode is written canonically
+	tree.Items[1].Mode = TreeBlobMode
+	want := []byte(entry("100664", "file") + entry("100644", "sub") + entry("100755", "tool"))
+	if encoded := tree.Encode(); !bytes.Equal(encoded, want) {
+		t.Errorf("encoded tree differs:\n%q\nwant:\n%q", encoded, want)
+	}
+}
@@ -2,6 +2,7 @@ package rawgit
 
 import (
 	"encoding/hex"
+	"strings"
 )
 
 type OType int8
@@ -28,6 +29,8 @@ func (ot OType) String() string {
 		return "<none>"
 	case OTypeCommit:
 		return "commit"
+	case OTypeTree:
+		return "tree"
 	case OTypeBlob:
 		return "blob"
 	case OTypeTag:
@@ -75,11 +78,35 @@ func (oid *OID) Equal(comp *OID) bool {
 	return *oid == *comp
 }
 
+// IsZero reports whether object id is all zeroes, which git uses for missing refs
+func (oid *OID) IsZero() bool {
+	return *oid == OID{}
+}
+
 // for embedding
 func (oid *OID) GetOID() *OID {
 	return oid
 }
 
+// HasPrefix reports whether hex form of object id starts with given prefix
+func (oid *OID) HasPrefix(prefix string) bool {
+	return strings.HasPrefix(oid.String(), strings.ToLower(prefix))
+}
+
+// IsHexPrefix reports whether string may be a prefix of object id
+func IsHexPrefix(prefix string) bool {
+	if len(prefix) > 40 {
+		return false
+	}
+
+	for _, c := range prefix {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
 func ParseOID(src string) (*OID, error) {
 	if len(src) != 40 {
 		return nil, ErrInvalidHashLength
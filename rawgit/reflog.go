@@ -0,0 +1,87 @@
+package rawgit
+
+import (
+	"strings"
+	"time"
+)
+
+// ReflogEntry is a single record of ref log
+type ReflogEntry struct {
+	OldOID    OID
+	NewOID    OID
+	Committer UserTime
+	Message   string
+}
+
+// ReflogExpiry describes which reflog entries should be pruned
+type ReflogExpiry struct {
+	// entries older than this are removed, zero time keeps entries of any age
+	Before time.Time
+	// number of newest entries to keep, zero means no limit
+	MaxEntries int
+}
+
+type Reflog interface {
+	// ReadReflog returns log entries of ref, oldest first
+	ReadReflog(ref string) ([]*ReflogEntry, error)
+	AppendReflog(ref string, entry *ReflogEntry) error
+	ExpireReflog(ref string, expiry *ReflogExpiry) error
+}
+
+// String formats entry as a line of reflog file, without trailing newline
+func (entry *ReflogEntry) String() string {
+	line := entry.OldOID.String() + " " + entry.NewOID.String() + " " + entry.Committer.String()
+	if entry.Message != "" {
+		line += "\t" + entry.Message
+	}
+	return line
+}
+
+// ParseReflogEntry parses single line of reflog file
+func ParseReflogEntry(line string) (*ReflogEntry, error) {
+	line = strings.TrimSuffix(line, "\n")
+	if len(line) < 82 || line[40] != ' ' || line[81] != ' ' {
+		return nil, ErrInvalidRecord
+	}
+
+	entry := &ReflogEntry{}
+	oldOID, err := ParseOID(line[:40])
+	if err != nil {
+		return nil, err
+	}
+	entry.OldOID = *oldOID
+
+	newOID, err := ParseOID(line[41:81])
+	if err != nil {
+		return nil, err
+	}
+	entry.NewOID = *newOID
+
+	record := line[82:]
+	if idx := strings.IndexByte(record, '\t'); idx != -1 {
+		entry.Message = record[idx+1:]
+		record = record[:idx]
+	}
+
+	entry.Committer, err = ParseUserTime(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Expire returns entries, which should be kept according to expiry settings
+func (expiry *ReflogExpiry) Expire(entries []*ReflogEntry) []*ReflogEntry {
+	var kept []*ReflogEntry
+	for _, entry := range entries {
+		if expiry.Before.IsZero() || !entry.Committer.Time.Before(expiry.Before) {
+			kept = append(kept, entry)
+		}
+	}
+
+	if expiry.MaxEntries > 0 && len(kept) > expiry.MaxEntries {
+		kept = kept[len(kept)-expiry.MaxEntries:]
+	}
+	return kept
+}
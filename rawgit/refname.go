@@ -0,0 +1,60 @@
+package rawgit
+
+import (
+	"strings"
+)
+
+// CheckRefFormat checks that ref name is valid, following rules of
+// 'git check-ref-format'. One-level names are allowed only for special refs like
+// HEAD or FETCH_HEAD
+func CheckRefFormat(name string) error {
+	if name == "" || name == "@" {
+		return ErrInvalidRefName
+	}
+
+	if !strings.Contains(name, "/") {
+		if !isSpecialRefName(name) {
+			return ErrInvalidRefName
+		}
+		return nil
+	}
+
+	if strings.HasSuffix(name, ".") || strings.Contains(name, "..") || strings.Contains(name, "@{") {
+		return ErrInvalidRefName
+	}
+
+	for idx := 0; idx < len(name); idx++ {
+		c := name[idx]
+		if c < 0x20 || c == 0x7f {
+			return ErrInvalidRefName
+		}
+
+		switch c {
+		case ' ', '~', '^', ':', '?', '*', '[', '\\':
+			return ErrInvalidRefName
+		}
+	}
+
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || component[0] == '.' || strings.HasSuffix(component, ".lock") {
+			return ErrInvalidRefName
+		}
+	}
+
+	return nil
+}
+
+// IsValidRefName reports whether ref name is valid, see CheckRefFormat
+func IsValidRefName(name string) bool {
+	return CheckRefFormat(name) == nil
+}
+
+// CheckBranchName checks short branch name, like 'feature/x', as
+// 'git check-ref-format --branch' does
+func CheckBranchName(name string) error {
+	if name == "" || name[0] == '-' || name == "HEAD" {
+		return ErrInvalidRefName
+	}
+
+	return CheckRefFormat(RefBranchNS + name)
+}
@@ -0,0 +1,59 @@
+package rawgit
+
+import (
+	"path"
+	"strings"
+)
+
+// Ref is a ref together with its value
+type Ref struct {
+	Name string
+	// name of target ref for symbolic refs, empty otherwise
+	Target string
+	// object ref points to, after following symbolic refs. Nil for dangling symbolic refs
+	OID *OID
+	// final target of annotated tag, nil if ref does not point to a tag
+	Peeled *OID
+}
+
+func (ref *Ref) IsSymbolic() bool {
+	return ref.Target != ""
+}
+
+// RefIterator iterates over refs in name order
+type RefIterator interface {
+	// Next advances to the next ref. It returns false when refs are exhausted or
+	// error occured
+	Next() bool
+	Ref() *Ref
+	Err() error
+}
+
+// MatchRefPattern checks ref name against pattern the way 'git for-each-ref' does.
+// Pattern matches refs either as a path prefix, like 'refs/heads', or as a glob,
+// like 'refs/heads/release/*', where wildcards do not match '/'. Empty pattern
+// matches everything
+func MatchRefPattern(pattern, name string) bool {
+	if pattern == "" {
+		return true
+	}
+
+	if strings.HasPrefix(name, pattern) {
+		if len(name) == len(pattern) || pattern[len(pattern)-1] == '/' || name[len(pattern)] == '/' {
+			return true
+		}
+	}
+
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+// RefPatternDir returns the deepest directory, which contains all refs matching pattern
+func RefPatternDir(pattern string) string {
+	if idx := strings.IndexAny(pattern, "*?[\\"); idx != -1 {
+		pattern = pattern[:idx]
+		return pattern[:strings.LastIndexByte(pattern, '/')+1]
+	}
+
+	return strings.TrimSuffix(pattern, "/") + "/"
+}
@@ -0,0 +1,114 @@
+package rawgit
+
+import (
+	"fmt"
+)
+
+// RefUpdate is a single compare-and-swap update of a ref
+type RefUpdate struct {
+	Name string
+	// expected value of ref. Nil skips the check, zero id requires ref to not exist
+	OldOID *OID
+	// new value of ref. Zero id deletes the ref
+	NewOID *OID
+	// update symbolic ref itself instead of the ref it points to
+	NoDeref bool
+	// reflog record. Update is not logged when Committer is nil
+	Committer *UserTime
+	Message   string
+}
+
+// RefTransaction collects ref updates to apply them all at once
+type RefTransaction struct {
+	db      RefDatabase
+	updates []RefUpdate
+
+	committer *UserTime
+	message   string
+}
+
+func NewRefTransaction(db RefDatabase) *RefTransaction {
+	return &RefTransaction{db: db}
+}
+
+// Update queues ref update, see RefUpdate for meaning of arguments
+func (tx *RefTransaction) Update(name string, oldOID, newOID *OID) {
+	tx.updates = append(tx.updates, RefUpdate{Name: name, OldOID: oldOID, NewOID: newOID})
+}
+
+// Create queues creation of ref, which must not exist yet
+func (tx *RefTransaction) Create(name string, newOID *OID) {
+	tx.Update(name, &OID{}, newOID)
+}
+
+// Delete queues removal of ref. Nil oldOID deletes ref regardless of its value
+func (tx *RefTransaction) Delete(name string, oldOID *OID) {
+	tx.Update(name, oldOID, &OID{})
+}
+
+// SetReflog sets reflog record for updates, which do not have their own
+func (tx *RefTransaction) SetReflog(committer *UserTime, message string) {
+	tx.committer = committer
+	tx.message = message
+}
+
+func (tx *RefTransaction) Updates() []RefUpdate {
+	return tx.updates
+}
+
+// Commit applies all queued updates or none of them. When some ref has
+// unexpected value, *RefConflictError is returned, when created ref would be
+// directory of other ref or inside of it, *RefNameConflictError
+func (tx *RefTransaction) Commit() error {
+	updates := make([]RefUpdate, len(tx.updates))
+	for idx, update := range tx.updates {
+		if update.Committer == nil {
+			update.Committer = tx.committer
+			update.Message = tx.message
+		}
+		updates[idx] = update
+	}
+
+	return tx.db.UpdateRefs(updates)
+}
+
+// RefConflictError is returned when ref value differs from the expected one
+type RefConflictError struct {
+	Name     string
+	Expected *OID
+	// nil if ref does not exist
+	Actual *OID
+}
+
+func (e *RefConflictError) Error() string {
+	actual := "missing"
+	if e.Actual != nil {
+		actual = e.Actual.String()
+	}
+
+	if e.Expected.IsZero() {
+		return fmt.Sprintf("ref %s already exists: %s", e.Name, actual)
+	}
+	return fmt.Sprintf("ref %s is at %s, expected %s", e.Name, actual, e.Expected)
+}
+
+// RefLockedError is returned when ref is being updated by someone else
+type RefLockedError struct {
+	Name string
+}
+
+func (e *RefLockedError) Error() string {
+	return fmt.Sprintf("ref %s is locked", e.Name)
+}
+
+// RefNameConflictError is returned when ref can not be created, because other
+// ref is stored at its directory or inside of it, like 'refs/heads/a' and
+// 'refs/heads/a/b'
+type RefNameConflictError struct {
+	Name  string
+	Other string
+}
+
+func (e *RefNameConflictError) Error() string {
+	return fmt.Sprintf("ref %s conflicts with ref %s", e.Name, e.Other)
+}
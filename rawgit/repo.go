@@ -1,13 +1,19 @@
 package rawgit
 
 import (
+	"fmt"
 	"io"
+	"os"
+	"os/user"
 	"strings"
+	"time"
 )
 
 const (
 	RefPrefix   = "ref: "
 	RefBranchNS = "refs/heads/"
+	// max length of symbolic refs chain, same as in git
+	MaxSymrefDepth = 5
 )
 
 type ObjectWriter interface {
@@ -22,41 +28,220 @@ type Repository interface {
 
 	ResolveBranch(branch string) (*OID, error)
 	ResolveRef(ref string) (*OID, error)
+	ReadSymbolicRef(ref string) (string, error)
+	WriteSymbolicRef(ref, target string) error
 	ResolveTag(ref string) (*OID, OType, error)
 
 	OpenCommit(oid *OID) (*Commit, error)
 	OpenTree(oid *OID) (*Tree, error)
 	OpenTag(oid *OID) (*Tag, error)
 
+	WriteCommit(commit *Commit) (*OID, error)
+	WriteTree(tree *Tree) (*OID, error)
+	WriteTag(tag *Tag) (*OID, error)
+	WriteBlob(data []byte) (*OID, error)
+
 	Parents(commit *Commit) ([]*Commit, error)
 	FindInTree(oid *OID, path string) (OType, *OID, error)
+
+	Config() (*Config, error)
 }
 
 type SimpleRepository struct {
 	Storage
-	refdb RefDatabase
+	refdb    RefDatabase
+	identity UserTime
+	signer   Signer
 }
 
 func NewRepository(storage Storage, refdb RefDatabase) *SimpleRepository {
-	return &SimpleRepository{storage, refdb}
+	return &SimpleRepository{Storage: storage, refdb: refdb}
+}
+
+// SetIdentity sets name and email, which are recorded in reflog on ref updates.
+// Until identity is set, it is taken from environment and config, as git does,
+// see DefaultIdentity
+func (repo *SimpleRepository) SetIdentity(name, email string) {
+	repo.identity.Name = name
+	repo.identity.Email = email
+}
+
+// SetSigner sets signer, which signs commits and tags written by WriteCommit and
+// WriteTag. nil disables signing
+func (repo *SimpleRepository) SetSigner(signer Signer) {
+	repo.signer = signer
+}
+
+// Config reads repository config from storage or ref database. Repositories
+// without config have empty one
+func (repo *SimpleRepository) Config() (*Config, error) {
+	if reader, ok := repo.Storage.(ConfigReader); ok {
+		return reader.ReadConfig()
+	}
+	if reader, ok := repo.refdb.(ConfigReader); ok {
+		return reader.ReadConfig()
+	}
+	return &Config{}, nil
+}
+
+// reflogCommitter returns repository identity with current time
+func (repo *SimpleRepository) reflogCommitter() (*UserTime, error) {
+	committer := repo.identity
+	if committer.Name == "" && committer.Email == "" {
+		config, err := repo.Config()
+		if err != nil {
+			return nil, err
+		}
+		committer = DefaultIdentity(config)
+	}
+
+	committer.Time = time.Now()
+	return &committer, nil
+}
+
+// DefaultIdentity returns committer identity as git finds it: from
+// GIT_COMMITTER_NAME and GIT_COMMITTER_EMAIL variables, user.name and
+// user.email config, EMAIL variable and, at last, from system user and host
+// names
+func DefaultIdentity(config *Config) UserTime {
+	var ident UserTime
+	ident.Name = os.Getenv("GIT_COMMITTER_NAME")
+	if ident.Name == "" {
+		ident.Name, _ = config.Get("user", "", "name")
+	}
+	ident.Email = os.Getenv("GIT_COMMITTER_EMAIL")
+	if ident.Email == "" {
+		ident.Email, _ = config.Get("user", "", "email")
+	}
+	if ident.Email == "" {
+		ident.Email = os.Getenv("EMAIL")
+	}
+	if ident.Name != "" && ident.Email != "" {
+		return ident
+	}
+
+	login, fullName := "unknown", ""
+	if current, err := user.Current(); err == nil {
+		login, fullName = current.Username, current.Name
+		// gecos field may have office and phone after the name
+		if comma := strings.IndexByte(fullName, ','); comma != -1 {
+			fullName = fullName[:comma]
+		}
+	}
+	if ident.Name == "" {
+		ident.Name = fullName
+		if ident.Name == "" {
+			ident.Name = login
+		}
+	}
+	if ident.Email == "" {
+		host, err := os.Hostname()
+		if err != nil || host == "" {
+			host = "(none)"
+		}
+		ident.Email = login + "@" + host
+	}
+	return ident
+}
+
+// checkRefName allows only valid refs from 'refs/' directory and special refs
+func checkRefName(ref string) error {
+	if !strings.HasPrefix(ref, "refs/") && !isSpecialRefName(ref) {
+		return ErrInvalidRefName
+	}
+
+	return CheckRefFormat(ref)
 }
 
 func (repo *SimpleRepository) ReadRef(ref string) (string, error) {
-	// TODO: validate ref name. Allow only refs from 'refs/' directory and special refs
+	if err := checkRefName(ref); err != nil {
+		return "", err
+	}
+
 	return repo.refdb.ReadRef(ref)
 }
 
+// WriteRef sets ref value, which must be either object hash or 'ref: <name>'. Changes
+// of object hash are recorded in reflog
 func (repo *SimpleRepository) WriteRef(ref, value string) error {
-	// TODO: validate ref name. Allow only refs from 'refs/' directory and special refs
-	// also validate ref value. It must be either object hash or another ref
-	return repo.refdb.WriteRef(ref, value)
+	if strings.HasPrefix(value, RefPrefix) {
+		return repo.WriteSymbolicRef(ref, value[len(RefPrefix):])
+	}
+
+	oid, err := ParseOID(value)
+	if err != nil {
+		return ErrInvalidRef
+	}
 
+	return repo.UpdateRefs([]RefUpdate{{Name: ref, NewOID: oid, NoDeref: true}})
+}
+
+func (repo *SimpleRepository) DeleteRef(ref string) error {
+	if err := checkRefName(ref); err != nil {
+		return err
+	}
+
+	return repo.refdb.DeleteRef(ref)
+}
+
+// UpdateRefs applies updates atomically. Updates without reflog record are logged
+// with repository identity
+func (repo *SimpleRepository) UpdateRefs(updates []RefUpdate) error {
+	logged := make([]RefUpdate, len(updates))
+	var committer *UserTime
+	for idx, update := range updates {
+		if err := checkRefName(update.Name); err != nil {
+			return err
+		}
+
+		if update.Committer == nil {
+			if committer == nil {
+				var err error
+				committer, err = repo.reflogCommitter()
+				if err != nil {
+					return err
+				}
+			}
+			update.Committer = committer
+		}
+		logged[idx] = update
+	}
+
+	return repo.refdb.UpdateRefs(logged)
+}
+
+func (repo *SimpleRepository) ReadReflog(ref string) ([]*ReflogEntry, error) {
+	if err := checkRefName(ref); err != nil {
+		return nil, err
+	}
+
+	return repo.refdb.ReadReflog(ref)
+}
+
+func (repo *SimpleRepository) AppendReflog(ref string, entry *ReflogEntry) error {
+	if err := checkRefName(ref); err != nil {
+		return err
+	}
+
+	return repo.refdb.AppendReflog(ref, entry)
+}
+
+func (repo *SimpleRepository) ExpireReflog(ref string, expiry *ReflogExpiry) error {
+	if err := checkRefName(ref); err != nil {
+		return err
+	}
+
+	return repo.refdb.ExpireReflog(ref, expiry)
 }
 
 func (repo *SimpleRepository) ListRefs(ns string) ([]string, error) {
 	return repo.refdb.ListRefs(ns)
 }
 
+func (repo *SimpleRepository) IterateRefs(pattern string) (RefIterator, error) {
+	return repo.refdb.IterateRefs(pattern)
+}
+
 func (repo *SimpleRepository) ResolveBranch(branch string) (*OID, error) {
 	return repo.ResolveRef("refs/heads/" + branch)
 }
@@ -169,6 +354,54 @@ func (repo *SimpleRepository) OpenTag(oid *OID) (*Tag, error) {
 	return tag, nil
 }
 
+// WriteCommit stores commit object and sets its id. Commit is signed first, if
+// repository has signer
+func (repo *SimpleRepository) WriteCommit(commit *Commit) (*OID, error) {
+	if repo.signer != nil {
+		err := commit.Sign(repo.signer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	oid, err := WriteObject(repo.Storage, OTypeCommit, commit.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	commit.OID = *oid
+	commit.OType = OTypeCommit
+	return oid, nil
+}
+
+func (repo *SimpleRepository) WriteTree(tree *Tree) (*OID, error) {
+	return WriteObject(repo.Storage, OTypeTree, tree.Encode())
+}
+
+// WriteTag stores tag object and sets its id. Tag is signed first, if repository
+// has signer
+func (repo *SimpleRepository) WriteTag(tag *Tag) (*OID, error) {
+	if repo.signer != nil {
+		err := tag.Sign(repo.signer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	oid, err := WriteObject(repo.Storage, OTypeTag, tag.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	tag.OID = *oid
+	tag.OType = OTypeTag
+	return oid, nil
+}
+
+func (repo *SimpleRepository) WriteBlob(data []byte) (*OID, error) {
+	return WriteObject(repo.Storage, OTypeBlob, data)
+}
+
 func (repo *SimpleRepository) OpenCursor(commitOID *OID, path string) (*Cursor, error) {
 	commit, err := repo.OpenCommit(commitOID)
 	if err != nil {
@@ -194,19 +427,82 @@ func (repo *SimpleRepository) OpenCursor(commitOID *OID, path string) (*Cursor,
 	return cur, nil
 }
 
+// ResolveRef follows symbolic refs and returns object id ref points to. If symbolic
+// ref points to missing ref, ErrUnbornBranch is returned
 func (repo *SimpleRepository) ResolveRef(ref string) (*OID, error) {
-	for {
+	for depth := 0; depth <= MaxSymrefDepth; depth++ {
+		// targets of symbolic refs are checked as well
+		if err := checkRefName(ref); err != nil {
+			return nil, err
+		}
+
 		value, err := repo.refdb.ReadRef(ref)
-		if err != nil {
+		if depth > 0 && IsNotExist(err) {
+			return nil, ErrUnbornBranch
+		} else if err != nil {
 			return nil, err
 		}
 
-		if strings.HasPrefix(value, "ref: ") {
-			ref = value[5:]
+		if strings.HasPrefix(value, RefPrefix) {
+			ref = value[len(RefPrefix):]
 		} else {
 			return ParseOID(value)
 		}
 	}
+
+	return nil, ErrSymrefTooDeep
+}
+
+// ReadSymbolicRef returns name of ref, which symbolic ref points to
+func (repo *SimpleRepository) ReadSymbolicRef(ref string) (string, error) {
+	if err := checkRefName(ref); err != nil {
+		return "", err
+	}
+
+	value, err := repo.refdb.ReadRef(ref)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(value, RefPrefix) {
+		return "", ErrNotASymbolicRef
+	}
+	return value[len(RefPrefix):], nil
+}
+
+// WriteSymbolicRef makes ref point to target ref, which may not exist yet. If
+// target exists, switch is recorded in reflog of ref
+func (repo *SimpleRepository) WriteSymbolicRef(ref, target string) error {
+	if err := checkRefName(ref); err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(target, "refs/") || CheckRefFormat(target) != nil {
+		return ErrInvalidRefName
+	}
+
+	entry := &ReflogEntry{}
+	if oid, err := repo.ResolveRef(ref); err == nil {
+		entry.OldOID = *oid
+	}
+	committer, err := repo.reflogCommitter()
+	if err != nil {
+		return err
+	}
+	entry.Committer = *committer
+
+	err = repo.refdb.WriteRef(ref, RefPrefix+target)
+	if err != nil {
+		return err
+	}
+
+	oid, err := repo.ResolveRef(target)
+	if err != nil {
+		// target is unborn branch, git does not log such switches either
+		return nil
+	}
+	entry.NewOID = *oid
+	return repo.refdb.AppendReflog(ref, entry)
 }
 
 func (repo *SimpleRepository) ResolveTag(ref string) (*OID, OType, error) {
@@ -245,29 +541,86 @@ func FollowTag(repo Repository, oid *OID) (*OID, OType, error) {
 	}
 }
 
-func ResolveName(repo Repository, name string) (*OID, error) {
-	if strings.Index(name, "/") < 0 {
-		infos, err := repo.MatchObjectsPrefix(name)
+// rules for expanding short ref names, same as git uses
+var refRevParseRules = []string{
+	"%s",
+	"refs/%s",
+	"refs/tags/%s",
+	"refs/heads/%s",
+	"refs/remotes/%s",
+	"refs/remotes/%s/HEAD",
+}
+
+// ExpandRef finds full name of existing ref, given its short name, like 'master' or
+// 'origin/master'
+func ExpandRef(repo Repository, name string) (string, error) {
+	if name == "" {
+		return "", ErrInvalidRef
+	}
+
+	for _, rule := range refRevParseRules {
+		full := fmt.Sprintf(rule, name)
+		if checkRefName(full) != nil {
+			// do not read arbitrary files from repository
+			continue
+		}
+
+		_, err := repo.ReadRef(full)
 		if err == nil {
-			switch len(infos) {
-			case 0:
-				break
-			case 1:
-				return infos[0].GetOID(), nil
-			default:
-				return nil, ErrAmbiguousShortHash
-			}
+			return full, nil
 		}
-	}
 
-	_, err := repo.ReadRef(name)
-	if err != nil {
 		if !IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	return "", ErrNotFound
+}
+
+// special refs are one-level refs like HEAD or FETCH_HEAD
+func isSpecialRefName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolveName resolves object id, its prefix or short ref name into object id
+func ResolveName(repo Repository, name string) (*OID, error) {
+	if len(name) == 40 {
+		if oid, err := ParseOID(name); err == nil {
+			return oid, nil
+		}
+	}
+
+	ref, err := ExpandRef(repo, name)
+	if err == nil {
+		return repo.ResolveRef(ref)
+	} else if !IsNotExist(err) {
+		return nil, err
+	}
+
+	if len(name) >= MinAbbrevLength && IsHexPrefix(name) {
+		infos, err := repo.MatchObjectsPrefix(name)
+		if err != nil {
 			return nil, err
 		}
-	} else {
-		// FIXME: ReadRef api call should be more useful
-		return repo.ResolveRef(name)
+
+		switch len(infos) {
+		case 0:
+			break
+		case 1:
+			return infos[0].GetOID(), nil
+		default:
+			return nil, ErrAmbiguousShortHash
+		}
 	}
 
 	return nil, ErrNotFound
@@ -0,0 +1,139 @@
+package rawgit_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mechmind/git-go/rawgit"
+	"github.com/mechmind/git-go/storage/fsstor"
+)
+
+func newTestRepository(t *testing.T, config string) (*rawgit.SimpleRepository, *rawgit.OID) {
+	fs := fsstor.NewOSFS(t.TempDir())
+	stor, err := fsstor.InitFSStorage(fs, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := fs.Create(fsstor.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(config))
+	file.Close()
+
+	repo := rawgit.NewRepository(stor, stor)
+	oid, err := repo.WriteBlob([]byte("blob\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo, oid
+}
+
+func TestReflogIdentity(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		config string
+		// identity set by SetIdentity
+		identity [2]string
+		want     [2]string
+	}{
+		{
+			name:     "explicit identity",
+			env:      map[string]string{"GIT_COMMITTER_NAME": "Env"},
+			config:   "[user]\n\tname = Config\n\temail = config@example.com\n",
+			identity: [2]string{"Set", "set@example.com"},
+			want:     [2]string{"Set", "set@example.com"},
+		},
+		{
+			name:   "environment over config",
+			env:    map[string]string{"GIT_COMMITTER_NAME": "Env", "GIT_COMMITTER_EMAIL": "env@example.com"},
+			config: "[user]\n\tname = Config\n\temail = config@example.com\n",
+			want:   [2]string{"Env", "env@example.com"},
+		},
+		{
+			name:   "config",
+			config: "[user]\n\tname = Config\n\temail = config@example.com\n",
+			want:   [2]string{"Config", "config@example.com"},
+		},
+		{
+			name:   "EMAIL variable",
+			env:    map[string]string{"EMAIL": "email@example.com"},
+			config: "[user]\n\tname = Config\n",
+			want:   [2]string{"Config", "email@example.com"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"GIT_COMMITTER_NAME", "GIT_COMMITTER_EMAIL", "EMAIL"} {
+				t.Setenv(name, test.env[name])
+			}
+
+			repo, oid := newTestRepository(t, test.config)
+			if test.identity[0] != "" {
+				repo.SetIdentity(test.identity[0], test.identity[1])
+			}
+
+			err := repo.WriteRef("refs/heads/master", oid.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := repo.ReadReflog("refs/heads/master")
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("got reflog %v, %v", entries, err)
+			}
+			got := [2]string{entries[0].Committer.Name, entries[0].Committer.Email}
+			if got != test.want {
+				t.Errorf("logged as %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReflogSystemIdentity(t *testing.T) {
+	for _, name := range []string{"GIT_COMMITTER_NAME", "GIT_COMMITTER_EMAIL", "EMAIL"} {
+		t.Setenv(name, "")
+	}
+
+	// without any configured identity updates are still logged
+	ident := rawgit.DefaultIdentity(&rawgit.Config{})
+	if ident.Name == "" || !strings.Contains(ident.Email, "@") {
+		t.Errorf("got identity %q <%s>", ident.Name, ident.Email)
+	}
+}
+
+func TestWriteSymbolicRefReflog(t *testing.T) {
+	repo, oid := newTestRepository(t, "[user]\n\tname = A\n\temail = a@example.com\n")
+	other, err := repo.WriteBlob([]byte("other\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]*rawgit.OID{"refs/heads/a": oid, "refs/heads/b": other} {
+		if err := repo.WriteRef(name, value.String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// switch to unborn branch is not logged
+	for _, target := range []string{"refs/heads/a", "refs/heads/b", "refs/heads/unborn"} {
+		if err := repo.WriteSymbolicRef("HEAD", target); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := repo.ReadReflog("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if !entries[0].OldOID.IsZero() || entries[0].NewOID != *oid {
+		t.Errorf("first switch logged as %s -> %s", entries[0].OldOID, entries[0].NewOID)
+	}
+	if entries[1].OldOID != *oid || entries[1].NewOID != *other {
+		t.Errorf("second switch logged as %s -> %s", entries[1].OldOID, entries[1].NewOID)
+	}
+}
@@ -0,0 +1,174 @@
+package rawgit
+
+import (
+	"strings"
+)
+
+// commit headers, holding signature of the rest of commit
+const (
+	SignatureHeader       = "gpgsig"
+	SignatureHeaderSHA256 = "gpgsig-sha256"
+)
+
+// lines, which start signature in tag message
+var signatureStarts = []string{
+	"-----BEGIN PGP SIGNATURE-----",
+	"-----BEGIN PGP MESSAGE-----",
+	"-----BEGIN SIGNED MESSAGE-----",
+	"-----BEGIN SSH SIGNATURE-----",
+}
+
+// SignatureInfo describes key, which made valid signature
+type SignatureInfo struct {
+	// key fingerprint, as printed by gpg or ssh-keygen
+	Fingerprint string
+	// user id for OpenPGP keys, principals for SSH keys
+	Signer string
+}
+
+// Verifier checks signature of payload. Verifiers return ErrUnsupportedSignature
+// for signature formats they don't handle, ErrUnknownSigner when key is not trusted
+// and ErrBadSignature when signature doesn't match payload. When signature matches,
+// but key or signature is not valid anymore, verifier returns both info and one of
+// ErrKeyExpired, ErrKeyRevoked or ErrSignatureExpired
+type Verifier interface {
+	Verify(payload, signature []byte) (*SignatureInfo, error)
+}
+
+// Verifiers passes signature to the first verifier, which supports its format
+type Verifiers []Verifier
+
+func (verifiers Verifiers) Verify(payload, signature []byte) (*SignatureInfo, error) {
+	for _, verifier := range verifiers {
+		info, err := verifier.Verify(payload, signature)
+		if err != ErrUnsupportedSignature {
+			return info, err
+		}
+	}
+	return nil, ErrUnsupportedSignature
+}
+
+// Signer makes detached armored signature of payload
+type Signer interface {
+	Sign(payload []byte) ([]byte, error)
+}
+
+// SignedPayload splits commit into signed data, which is commit without signature
+// headers, and signature. Returns ErrNotSigned when commit has no signature
+func (commit *Commit) SignedPayload() (payload, signature []byte, err error) {
+	value, ok := commit.ExtraHeaders.Get(SignatureHeader)
+	if !ok {
+		return nil, nil, ErrNotSigned
+	}
+
+	return commit.unsigned().Encode(), []byte(value + "\n"), nil
+}
+
+// Sign signs commit with signer, replacing existing signature
+func (commit *Commit) Sign(signer Signer) error {
+	unsigned := commit.unsigned()
+	signature, err := signer.Sign(unsigned.Encode())
+	if err != nil {
+		return err
+	}
+
+	commit.ExtraHeaders = append(unsigned.ExtraHeaders, Header{
+		Key:   SignatureHeader,
+		Value: strings.TrimSuffix(string(signature), "\n"),
+	})
+	commit.encodingPos = unsigned.encodingPos
+	return nil
+}
+
+// unsigned returns copy of commit without signature headers
+func (commit *Commit) unsigned() *Commit {
+	unsigned := *commit
+	unsigned.ExtraHeaders = nil
+	unsigned.encodingPos = 0
+	for idx, header := range commit.ExtraHeaders {
+		if header.Key == SignatureHeader || header.Key == SignatureHeaderSHA256 {
+			continue
+		}
+		if idx < commit.encodingPos {
+			unsigned.encodingPos++
+		}
+		unsigned.ExtraHeaders = append(unsigned.ExtraHeaders, header)
+	}
+	return &unsigned
+}
+
+// SignedPayload splits tag into signed data and signature, appended to tag message.
+// Returns ErrNotSigned when tag has no signature
+func (tag *Tag) SignedPayload() (payload, signature []byte, err error) {
+	start := tag.signatureStart()
+	if start == -1 {
+		return nil, nil, ErrNotSigned
+	}
+
+	unsigned := *tag
+	unsigned.Message = tag.Message[:start]
+	return unsigned.Encode(), []byte(tag.Message[start:]), nil
+}
+
+// Sign signs tag with signer, replacing existing signature
+func (tag *Tag) Sign(signer Signer) error {
+	message := tag.Message
+	if start := tag.signatureStart(); start != -1 {
+		message = message[:start]
+	}
+	if message != "" && !strings.HasSuffix(message, "\n") {
+		// signature must start on its own line
+		message += "\n"
+	}
+
+	unsigned := *tag
+	unsigned.Message = message
+	signature, err := signer.Sign(unsigned.Encode())
+	if err != nil {
+		return err
+	}
+
+	tag.Message = message + string(signature)
+	return nil
+}
+
+// signatureStart returns position of signature in tag message or -1
+func (tag *Tag) signatureStart() int {
+	start := -1
+	for pos := 0; pos < len(tag.Message); {
+		line := tag.Message[pos:]
+		for _, prefix := range signatureStarts {
+			if strings.HasPrefix(line, prefix) {
+				// the last signature wins, as in git
+				start = pos
+			}
+		}
+
+		eol := strings.IndexByte(line, '\n')
+		if eol == -1 {
+			break
+		}
+		pos += eol + 1
+	}
+	return start
+}
+
+// VerifyCommit checks commit signature with verifier
+func VerifyCommit(commit *Commit, verifier Verifier) (*SignatureInfo, error) {
+	payload, signature, err := commit.SignedPayload()
+	if err != nil {
+		return nil, err
+	}
+
+	return verifier.Verify(payload, signature)
+}
+
+// VerifyTag checks tag signature with verifier
+func VerifyTag(tag *Tag, verifier Verifier) (*SignatureInfo, error) {
+	payload, signature, err := tag.SignedPayload()
+	if err != nil {
+		return nil, err
+	}
+
+	return verifier.Verify(payload, signature)
+}
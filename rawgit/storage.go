@@ -13,11 +13,39 @@ type Storage interface {
 }
 
 type RefDatabase interface {
+	Reflog
+
 	ListRefs(ns string) ([]string, error)
+	// IterateRefs walks refs matching pattern, see MatchRefPattern
+	IterateRefs(pattern string) (RefIterator, error)
 	ReadRef(name string) (string, error)
 	WriteRef(name, value string) error
+	DeleteRef(name string) error
+	// UpdateRefs atomically applies all updates or none of them
+	UpdateRefs(updates []RefUpdate) error
 }
 
 type ReadOnly interface {
 	IsReadOnly() bool
 }
+
+// WriteObject stores object with given contents and returns its id
+func WriteObject(storage Storage, objType OType, data []byte) (*OID, error) {
+	writer, err := storage.CreateObject(objType, uint64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = writer.Write(data)
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return writer.GetOID(), nil
+}
@@ -1,6 +1,7 @@
 package rawgit
 
 import (
+	"bytes"
 	"io"
 	"io/ioutil"
 )
@@ -11,78 +12,93 @@ type Tag struct {
 	TargetOID   OID
 	TargetOType OType
 	Name        string
-	Tagger      UserTime
-	Message     string
+	// nil for old tags, created without tagger
+	Tagger *UserTime
+	// headers following tagger, in original order
+	ExtraHeaders Headers
+	Message      string
 }
 
 func ReadTag(obj io.ReadCloser) (*Tag, error) {
 	var tag = new(Tag)
-	var infobuf = make([]byte, 1024)
-
 	tag.OType = OTypeTag
 
 	defer obj.Close()
 
-	buf, err := scanUntil(obj, ' ', infobuf)
-	if string(buf) != "object" {
-		return nil, ErrNoObject
+	data, err := ioutil.ReadAll(obj)
+	if err != nil {
+		return nil, err
 	}
 
-	buf, err = scanUntil(obj, '\n', infobuf)
-
-	oid, err := ParseOID(string(buf))
+	headers, message, err := parseHeaders(data)
 	if err != nil {
 		return nil, err
 	}
+	tag.Message = message
 
+	if len(headers) == 0 || headers[0].Key != "object" {
+		return nil, ErrNoObject
+	}
+
+	oid, err := ParseOID(headers[0].Value)
+	if err != nil {
+		return nil, err
+	}
 	tag.TargetOID = *oid
+	headers = headers[1:]
 
-	buf, err = scanUntil(obj, ' ', infobuf)
-	if string(buf) != "type" {
+	if len(headers) == 0 || headers[0].Key != "type" {
 		return nil, ErrNoObjectType
 	}
 
-	buf, err = scanUntil(obj, '\n', infobuf)
-	ot := ParseOType(string(buf))
+	ot := ParseOType(headers[0].Value)
 	if ot == OTypeBad {
 		return nil, ErrInvalidObjectType
 	}
 	tag.TargetOType = ot
-	println("parsed otype", ot.String())
+	headers = headers[1:]
 
-	buf, err = scanUntil(obj, ' ', infobuf)
-	if string(buf) != "tag" {
+	if len(headers) == 0 || headers[0].Key != "tag" {
 		return nil, ErrNoTag
 	}
-
-	buf, err = scanUntil(obj, '\n', infobuf)
-	tag.Name = string(buf)
-
-	buf, err = scanUntil(obj, ' ', infobuf)
-	if string(buf) != "tagger" {
-		return nil, ErrNoTagger
+	tag.Name = headers[0].Value
+	headers = headers[1:]
+
+	if len(headers) > 0 && headers[0].Key == "tagger" {
+		tagger, err := ParseUserTime(headers[0].Value)
+		if err != nil {
+			return nil, err
+		}
+		tag.Tagger = &tagger
+		headers = headers[1:]
 	}
 
-	userTime, err := readUserRecord(obj, infobuf)
-	if err != nil {
-		return nil, err
+	if len(headers) > 0 {
+		tag.ExtraHeaders = headers
 	}
-	tag.Tagger = userTime
 
-	// scan empty line before message
-	buf, err = scanUntil(obj, '\n', infobuf)
-	if err != nil {
-		return nil, err
-	} else if len(buf) > 0 {
-		return nil, ErrInvalidRecord
-	}
+	return tag, nil
+}
 
-	buf, err = ioutil.ReadAll(obj)
-	if err != nil {
-		return nil, err
+// Encode formats tag as git object contents
+func (tag *Tag) Encode() []byte {
+	var buf bytes.Buffer
+	writeHeader(&buf, "object", tag.TargetOID.String())
+	writeHeader(&buf, "type", tag.TargetOType.String())
+	writeHeader(&buf, "tag", tag.Name)
+	if tag.Tagger != nil {
+		writeHeader(&buf, "tagger", tag.Tagger.String())
 	}
+	for _, header := range tag.ExtraHeaders {
+		writeHeader(&buf, header.Key, header.Value)
+	}
+	buf.WriteString("\n")
+	buf.WriteString(tag.Message)
 
-	tag.Message = string(buf)
+	return buf.Bytes()
+}
 
-	return tag, nil
+func (tag *Tag) WriteTo(dst io.Writer) (int64, error) {
+	n, err := dst.Write(tag.Encode())
+	return int64(n), err
 }
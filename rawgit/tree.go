@@ -1,8 +1,11 @@
 package rawgit
 
 import (
+	"bytes"
 	"io"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -12,6 +15,8 @@ const (
 	TreeExecutableBlobMode = 0100755
 	TreeSymlinkMode        = 0120000
 	TreeCommitMode         = 0160000
+
+	treeModeTypeMask = 0170000
 )
 
 type Tree struct {
@@ -31,16 +36,122 @@ type TreeItem struct {
 	Name string
 	Mode uint32
 	OID  OID
+
+	// mode as written in parsed tree, when it is not canonical, like '040000'.
+	// It is written back, so that tree keeps its id
+	rawMode string
 }
 
 func (item *TreeItem) GetOType() OType {
-	if item.Mode&TreeDirectoryMode > 0 {
+	switch item.Mode & treeModeTypeMask {
+	case TreeDirectoryMode:
 		return OTypeTree
-	} else {
+	case TreeCommitMode:
+		// submodule
+		return OTypeCommit
+	default:
 		return OTypeBlob
 	}
 }
 
+func (item *TreeItem) IsDir() bool {
+	return item.Mode&treeModeTypeMask == TreeDirectoryMode
+}
+
+// IsFileMode reports whether mode is mode of regular or executable file, symlink
+// or submodule
+func IsFileMode(mode uint32) bool {
+	switch mode {
+	case TreeBlobMode, TreeExecutableBlobMode, TreeSymlinkMode, TreeCommitMode:
+		return true
+	}
+	return false
+}
+
+// IsRegularMode reports whether mode is mode of regular or executable file
+func IsRegularMode(mode uint32) bool {
+	return mode&treeModeTypeMask == TreeBlobMode&treeModeTypeMask
+}
+
+// Sort sorts tree items in git order, where directories are compared as if their
+// names ended with '/'
+func (tree *Tree) Sort() {
+	sort.Stable(treeItemOrder(tree.Items))
+}
+
+// Encode formats tree as git object contents. Items are written in git order
+func (tree *Tree) Encode() []byte {
+	items := make([]TreeItem, len(tree.Items))
+	copy(items, tree.Items)
+	sort.Stable(treeItemOrder(items))
+
+	var buf bytes.Buffer
+	for _, item := range items {
+		buf.WriteString(item.modeString())
+		buf.WriteByte(' ')
+		buf.WriteString(item.Name)
+		buf.WriteByte(0)
+		buf.Write(item.OID[:])
+	}
+
+	return buf.Bytes()
+}
+
+// modeString returns mode as written in tree object. Raw mode is kept only while
+// mode is not changed
+func (item *TreeItem) modeString() string {
+	if item.rawMode != "" {
+		if mode, err := strconv.ParseUint(item.rawMode, 8, 32); err == nil && uint32(mode) == item.Mode {
+			return item.rawMode
+		}
+	}
+	return strconv.FormatUint(uint64(item.Mode), 8)
+}
+
+func (tree *Tree) WriteTo(dst io.Writer) (int64, error) {
+	n, err := dst.Write(tree.Encode())
+	return int64(n), err
+}
+
+type treeItemOrder []TreeItem
+
+func (t treeItemOrder) Len() int      { return len(t) }
+func (t treeItemOrder) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t treeItemOrder) Less(i, j int) bool {
+	return compareTreeNames(t[i].Name, t[i].IsDir(), t[j].Name, t[j].IsDir()) < 0
+}
+
+// compareTreeNames compares names like git does for tree entries
+func compareTreeNames(name1 string, isDir1 bool, name2 string, isDir2 bool) int {
+	length := len(name1)
+	if len(name2) < length {
+		length = len(name2)
+	}
+
+	if cmp := strings.Compare(name1[:length], name2[:length]); cmp != 0 {
+		return cmp
+	}
+
+	c1, c2 := treeNameTail(name1, length, isDir1), treeNameTail(name2, length, isDir2)
+	switch {
+	case c1 < c2:
+		return -1
+	case c1 > c2:
+		return 1
+	}
+	return 0
+}
+
+func treeNameTail(name string, pos int, isDir bool) byte {
+	if pos < len(name) {
+		return name[pos]
+	}
+	if isDir {
+		return '/'
+	}
+	return 0
+}
+
 func (item *TreeItem) GetOID() *OID {
 	return &item.OID
 }
@@ -70,6 +181,9 @@ func ReadTree(obj io.ReadCloser) (*Tree, error) {
 		}
 
 		item.Mode = uint32(mode)
+		if canonical := strconv.FormatUint(mode, 8); string(buf) != canonical {
+			item.rawMode = string(buf)
+		}
 
 		// read name
 		buf, err = scanUntil(obj, 0, infobuf)
@@ -81,7 +195,7 @@ func ReadTree(obj io.ReadCloser) (*Tree, error) {
 
 		// read hash
 
-		_, err = obj.Read(hashbuf)
+		_, err = io.ReadFull(obj, hashbuf)
 		if err != nil {
 			return nil, err
 		}
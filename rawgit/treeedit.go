@@ -0,0 +1,285 @@
+package rawgit
+
+import (
+	"strings"
+)
+
+// TreeEditor changes tree in memory. Subtrees are loaded on first access and
+// only modified ones are written. Failed changes leave tree untouched
+type TreeEditor struct {
+	repo Repository
+	root *treeNode
+}
+
+type treeNode struct {
+	// id of unmodified tree, nil for modified and new trees
+	oid      *OID
+	tree     *Tree
+	children map[string]*treeNode
+}
+
+// NewTreeEditor starts editing of tree. Nil id means empty tree
+func NewTreeEditor(repo Repository, oid *OID) (*TreeEditor, error) {
+	root, err := openTreeNode(repo, oid)
+	if err != nil {
+		return nil, err
+	}
+	return &TreeEditor{repo: repo, root: root}, nil
+}
+
+func openTreeNode(repo Repository, oid *OID) (*treeNode, error) {
+	node := &treeNode{tree: new(Tree), children: make(map[string]*treeNode)}
+	if oid == nil {
+		return node, nil
+	}
+
+	tree, err := repo.OpenTree(oid)
+	if err != nil {
+		return nil, err
+	}
+
+	id := *oid
+	node.oid = &id
+	node.tree = tree
+	return node, nil
+}
+
+// Find returns copy of item at path. ErrNotADirectory is returned, if one of
+// path directories is not a directory
+func (e *TreeEditor) Find(path string) (*TreeItem, error) {
+	dirs, name, err := splitTreePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := e.walk(dirs, false)
+	if err != nil {
+		return nil, err
+	}
+
+	item := node.tree.Find(name)
+	if item == nil {
+		return nil, ErrNotFound
+	}
+
+	found := *item
+	return &found, nil
+}
+
+// Set puts item at path, creating missing directories. Files are not put in
+// place of directories and directories are not created in place of files, but
+// directories replace anything
+func (e *TreeEditor) Set(path string, item TreeItem) error {
+	dirs, name, err := splitTreePath(path)
+	if err != nil {
+		return err
+	}
+
+	// check conflicts before missing directories are created
+	existing, err := e.Find(path)
+	switch {
+	case err == nil && existing.IsDir() && !item.IsDir():
+		return ErrIsADirectory
+	case err != nil && err != ErrNotFound:
+		return err
+	}
+
+	node, err := e.walk(dirs, true)
+	if err != nil {
+		return err
+	}
+
+	item.Name = name
+	node.set(item)
+	return nil
+}
+
+// Remove removes item at path and returns it. Directories, which become empty,
+// are removed too
+func (e *TreeEditor) Remove(path string) (*TreeItem, error) {
+	dirs, name, err := splitTreePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []*treeNode{e.root}
+	for _, dir := range dirs {
+		child, err := e.child(nodes[len(nodes)-1], dir, false)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, child)
+	}
+
+	item := nodes[len(nodes)-1].remove(name)
+	if item == nil {
+		return nil, ErrNotFound
+	}
+
+	for idx := len(nodes) - 1; idx > 0 && len(nodes[idx].tree.Items) == 0; idx-- {
+		nodes[idx-1].remove(dirs[idx-1])
+	}
+	return item, nil
+}
+
+// Rename moves item to new path, which must not exist. Directories are moved
+// with their changes
+func (e *TreeEditor) Rename(from, to string) error {
+	fromDirs, fromName, err := splitTreePath(from)
+	if err != nil {
+		return err
+	}
+	toDirs, toName, err := splitTreePath(to)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(to+"/", from+"/") {
+		// can't move directory into itself
+		return ErrInvalidPath
+	}
+
+	if _, err := e.Find(to); err == nil {
+		return ErrPathExists
+	} else if err != ErrNotFound {
+		return err
+	}
+
+	src, err := e.walk(fromDirs, false)
+	if err != nil {
+		return err
+	}
+	// keep loaded subtree with its changes
+	child := src.children[fromName]
+
+	item, err := e.Remove(from)
+	if err != nil {
+		return err
+	}
+
+	// existing directories are loaded already, so this does not fail
+	dst, err := e.walk(toDirs, true)
+	if err != nil {
+		return err
+	}
+
+	item.Name = toName
+	dst.set(*item)
+	if child != nil {
+		dst.children[toName] = child
+	}
+	return nil
+}
+
+// Write writes modified trees and returns id of the root tree
+func (e *TreeEditor) Write() (*OID, error) {
+	return e.root.write(e.repo)
+}
+
+// walk returns node of directory, creating missing directories if asked to
+func (e *TreeEditor) walk(dirs []string, create bool) (*treeNode, error) {
+	node := e.root
+	for _, dir := range dirs {
+		child, err := e.child(node, dir, create)
+		if err != nil {
+			return nil, err
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// child returns node of subdirectory, loading it or, if asked to, creating it
+func (e *TreeEditor) child(node *treeNode, name string, create bool) (*treeNode, error) {
+	if child, ok := node.children[name]; ok {
+		return child, nil
+	}
+
+	item := node.tree.Find(name)
+	switch {
+	case item == nil && !create:
+		return nil, ErrNotFound
+	case item == nil:
+		child, _ := openTreeNode(e.repo, nil)
+		node.set(TreeItem{Name: name, Mode: TreeDirectoryMode})
+		node.children[name] = child
+		return child, nil
+	case !item.IsDir():
+		return nil, ErrNotADirectory
+	}
+
+	child, err := openTreeNode(e.repo, &item.OID)
+	if err != nil {
+		return nil, err
+	}
+	node.children[name] = child
+	return child, nil
+}
+
+// set adds or replaces item and drops loaded subtree of replaced item
+func (n *treeNode) set(item TreeItem) {
+	n.oid = nil
+	delete(n.children, item.Name)
+	if existing := n.tree.Find(item.Name); existing != nil {
+		*existing = item
+	} else {
+		n.tree.Items = append(n.tree.Items, item)
+	}
+}
+
+// remove removes item and returns it, or nil if there is no such item
+func (n *treeNode) remove(name string) *TreeItem {
+	for idx, item := range n.tree.Items {
+		if item.Name == name {
+			n.oid = nil
+			n.tree.Items = append(n.tree.Items[:idx], n.tree.Items[idx+1:]...)
+			delete(n.children, name)
+			return &item
+		}
+	}
+	return nil
+}
+
+// write writes modified subtrees and then tree itself, if it was modified
+func (n *treeNode) write(repo Repository) (*OID, error) {
+	for name, child := range n.children {
+		oid, err := child.write(repo)
+		if err != nil {
+			return nil, err
+		}
+
+		item := n.tree.Find(name)
+		if item.OID != *oid {
+			item.OID = *oid
+			n.oid = nil
+		}
+	}
+
+	if n.oid != nil {
+		return n.oid, nil
+	}
+
+	n.tree.Sort()
+	oid, err := repo.WriteTree(n.tree)
+	if err != nil {
+		return nil, err
+	}
+
+	n.oid = oid
+	return oid, nil
+}
+
+// splitTreePath splits slash separated path into directories and name. Paths
+// with empty, '.', '..' or '.git' components are invalid
+func splitTreePath(path string) ([]string, string, error) {
+	parts := strings.Split(path, "/")
+	for _, part := range parts {
+		switch {
+		case part == "", part == ".", part == "..", strings.EqualFold(part, ".git"),
+			strings.IndexByte(part, 0) != -1:
+			return nil, "", ErrInvalidPath
+		}
+	}
+
+	return parts[:len(parts)-1], parts[len(parts)-1], nil
+}
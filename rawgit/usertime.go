@@ -0,0 +1,120 @@
+package rawgit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserTime is an identity with timestamp, like author of commit
+type UserTime struct {
+	Name, Email string
+	Time        time.Time
+
+	// parsed record, kept to reproduce it exactly while fields are unchanged
+	orig *userTimeRecord
+}
+
+type userTimeRecord struct {
+	raw         string
+	name, email string
+	time        time.Time
+	zone        string
+}
+
+func (ut UserTime) unchanged() bool {
+	orig := ut.orig
+	if orig == nil {
+		return false
+	}
+
+	_, offset := ut.Time.Zone()
+	_, origOffset := orig.time.Zone()
+	return ut.Name == orig.name && ut.Email == orig.email && ut.Time.Equal(orig.time) &&
+		offset == origOffset
+}
+
+// Zone returns timezone offset as git writes it, like '+0530'. Offset of parsed
+// record is returned as is, so '-0000' is preserved
+func (ut UserTime) Zone() string {
+	if ut.unchanged() && ut.orig.zone != "" {
+		return ut.orig.zone
+	}
+
+	_, offset := ut.Time.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf("%c%02d%02d", sign, offset/3600, offset/60%60)
+}
+
+// String formats user record as git does: 'Name <email> 1500000000 +0300'. Parsed
+// records are reproduced byte to byte, even malformed ones
+func (ut UserTime) String() string {
+	if ut.unchanged() {
+		return ut.orig.raw
+	}
+
+	return fmt.Sprintf("%s <%s> %d %s", ut.Name, ut.Email, ut.Time.Unix(), ut.Zone())
+}
+
+// ParseUserTime parses user record, formatted like 'Name <email> 1500000000 +0300'.
+// Like git, it tolerates malformed records found in old repositories: missing or
+// broken dates and timezones are treated as zero, and record without email is
+// taken as a name
+func ParseUserTime(record string) (UserTime, error) {
+	ut := UserTime{}
+	orig := &userTimeRecord{raw: record}
+
+	start := strings.IndexByte(record, '<')
+	end := -1
+	if start != -1 {
+		end = strings.IndexByte(record[start+1:], '>')
+	}
+
+	var timestamp int64
+	var offset int
+	if end == -1 {
+		ut.Name = strings.TrimSpace(record)
+	} else {
+		ut.Name = strings.TrimRight(record[:start], " \t")
+		ut.Email = record[start+1 : start+1+end]
+
+		// date follows the last '>', in case email is broken
+		fields := strings.Fields(record[strings.LastIndexByte(record, '>')+1:])
+		if len(fields) > 0 {
+			timestamp, _ = strconv.ParseInt(fields[0], 10, 64)
+		}
+		if len(fields) > 1 {
+			orig.zone = fields[1]
+			offset = parseZone(fields[1])
+		}
+	}
+
+	ut.Time = time.Unix(timestamp, 0).In(time.FixedZone("GIT", offset))
+
+	orig.name, orig.email, orig.time = ut.Name, ut.Email, ut.Time
+	ut.orig = orig
+	return ut, nil
+}
+
+// parseZone converts offset like '+0530' into seconds
+func parseZone(zone string) int {
+	if len(zone) < 2 || zone[0] != '+' && zone[0] != '-' {
+		return 0
+	}
+
+	value, err := strconv.Atoi(zone[1:])
+	if err != nil {
+		return 0
+	}
+
+	offset := value/100*3600 + value%100*60
+	if zone[0] == '-' {
+		offset = -offset
+	}
+	return offset
+}
@@ -0,0 +1,19 @@
+package revision
+
+import (
+	"errors"
+)
+
+var ErrInvalidRevision = errors.New("invalid revision")
+var ErrNotSingleRevision = errors.New("revision is a range")
+var ErrIndexNotSupported = errors.New("index revisions are not supported")
+var ErrNoReflog = errors.New("ref has no reflog")
+var ErrReflogTooShort = errors.New("reflog has not enough entries")
+var ErrNoPreviousBranch = errors.New("no such previously checked out branch")
+var ErrNoUpstream = errors.New("branch has no upstream")
+var ErrNoPushDestination = errors.New("branch has no push destination")
+var ErrNoTrackingBranch = errors.New("remote ref is not stored as remote-tracking branch")
+var ErrNotABranch = errors.New("not a branch")
+var ErrNoSuchParent = errors.New("no such parent")
+var ErrCannotPeel = errors.New("object can not be peeled to requested type")
+var ErrNoMatch = errors.New("no commit with matching message")
@@ -0,0 +1,492 @@
+package revision
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mechmind/git-go/history"
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// Resolve parses revision specification and resolves it into single object id
+func Resolve(repo rawgit.Repository, spec string) (*rawgit.OID, error) {
+	rev, err := Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return rev.Resolve(repo)
+}
+
+// Resolve resolves single revision into object id
+func (rev *Revision) Resolve(repo rawgit.Repository) (*rawgit.OID, error) {
+	if rev.Kind != Single {
+		return nil, ErrNotSingleRevision
+	}
+
+	return rev.To.Resolve(repo)
+}
+
+// ResolveRange resolves revision into commits, which history should be included
+// and excluded, like 'git rev-parse' does. Single revision is just included
+func (rev *Revision) ResolveRange(repo rawgit.Repository) (include, exclude []*rawgit.OID, err error) {
+	to, err := rev.To.Resolve(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch rev.Kind {
+	case Single:
+		return []*rawgit.OID{to}, nil, nil
+	case CommitOnly, Parents:
+		commit, err := openCommit(repo, to)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if rev.Kind == Parents {
+			return commit.ParentOIDs, nil, nil
+		}
+		return []*rawgit.OID{commit.GetOID()}, commit.ParentOIDs, nil
+	}
+
+	from, err := rev.From.Resolve(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if rev.Kind == Range {
+		return []*rawgit.OID{to}, []*rawgit.OID{from}, nil
+	}
+
+	// symmetric difference excludes merge base of both sides
+	fromCommit, err := openCommit(repo, from)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	toCommit, err := openCommit(repo, to)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	include = []*rawgit.OID{fromCommit.GetOID(), toCommit.GetOID()}
+	base, err := history.New(repo).Find3WayMergeBase(fromCommit, toCommit)
+	if err != nil {
+		return nil, nil, err
+	}
+	if base != nil {
+		exclude = []*rawgit.OID{base.GetOID()}
+	}
+
+	return include, exclude, nil
+}
+
+// Resolve resolves expression into object id
+func (expr *Expr) Resolve(repo rawgit.Repository) (*rawgit.OID, error) {
+	var oid *rawgit.OID
+	var err error
+
+	switch {
+	case expr.Search != "":
+		oid, err = searchFromRefs(repo, expr.Search)
+	case expr.HasSelector:
+		oid, err = resolveSelector(repo, expr.Name, expr.Selector)
+	default:
+		oid, err = rawgit.ResolveName(repo, expr.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range expr.Ops {
+		oid, err = applyOp(repo, oid, op)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if expr.HasPath {
+		tree, err := peel(repo, oid, rawgit.OTypeTree)
+		if err != nil {
+			return nil, err
+		}
+
+		path := strings.Trim(expr.Path, "/")
+		if path == "" {
+			return tree, nil
+		}
+
+		_, oid, err = repo.FindInTree(tree, path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return oid, nil
+}
+
+func resolveSelector(repo rawgit.Repository, name, selector string) (*rawgit.OID, error) {
+	switch strings.ToLower(selector) {
+	case "u", "upstream", "push":
+		return resolveUpstream(repo, name, strings.ToLower(selector) == "push")
+	}
+
+	if strings.HasPrefix(selector, "-") {
+		// '@{-n}' is n-th previously checked out branch
+		n, err := strconv.Atoi(selector[1:])
+		if name != "" || err != nil || n < 1 {
+			return nil, ErrInvalidRevision
+		}
+
+		branch, err := previousBranch(repo, n)
+		if err != nil {
+			return nil, err
+		}
+		return rawgit.ResolveName(repo, branch)
+	}
+
+	ref, err := reflogRef(repo, name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := repo.ReadReflog(ref)
+	if rawgit.IsNotExist(err) || err == nil && len(entries) == 0 {
+		return nil, ErrNoReflog
+	} else if err != nil {
+		return nil, err
+	}
+
+	if n, err := strconv.Atoi(selector); err == nil && n >= 0 {
+		// value of ref n updates ago
+		if n < len(entries) {
+			return &entries[len(entries)-1-n].NewOID, nil
+		}
+		if n == len(entries) && !entries[0].OldOID.IsZero() {
+			return &entries[0].OldOID, nil
+		}
+		return nil, ErrReflogTooShort
+	}
+
+	date, err := rawgit.ParseDate(selector, time.Now())
+	if err != nil {
+		return nil, ErrInvalidRevision
+	}
+
+	// value of ref at given date
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		if !entries[idx].Committer.Time.After(date) {
+			return &entries[idx].NewOID, nil
+		}
+	}
+
+	// log does not go back that far, use the oldest known value
+	if !entries[0].OldOID.IsZero() {
+		return &entries[0].OldOID, nil
+	}
+	return &entries[0].NewOID, nil
+}
+
+// resolveUpstream resolves remote-tracking branch, which branch merges from or
+// is pushed to
+func resolveUpstream(repo rawgit.Repository, name string, push bool) (*rawgit.OID, error) {
+	branch, err := selectorBranch(repo, name)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	var ref string
+	if push {
+		ref, err = pushRef(config, branch)
+	} else {
+		ref, err = upstreamRef(config, branch)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.ResolveRef(ref)
+}
+
+// selectorBranch returns short name of branch for 'name@{upstream}'. Empty name
+// stands for current branch
+func selectorBranch(repo rawgit.Repository, name string) (string, error) {
+	if name == "" || name == "HEAD" {
+		head, err := rawgit.ReadHead(repo)
+		if err != nil {
+			return "", err
+		}
+		if head.IsDetached() {
+			return "", ErrNotABranch
+		}
+		return strings.TrimPrefix(head.Branch, rawgit.RefBranchNS), nil
+	}
+
+	branch := strings.TrimPrefix(name, rawgit.RefBranchNS)
+	_, err := repo.ReadRef(rawgit.RefBranchNS + branch)
+	if rawgit.IsNotExist(err) {
+		return "", ErrNotABranch
+	}
+	return branch, err
+}
+
+// reflogRef finds ref, which log is used for 'name@{...}'. Empty name stands for
+// current branch
+func reflogRef(repo rawgit.Repository, name string) (string, error) {
+	if name != "" {
+		return rawgit.ExpandRef(repo, name)
+	}
+
+	head, err := rawgit.ReadHead(repo)
+	if err != nil {
+		return "", err
+	}
+
+	if head.IsDetached() {
+		return "HEAD", nil
+	}
+	return head.Branch, nil
+}
+
+// previousBranch finds n-th previously checked out branch from checkout records in
+// HEAD log
+func previousBranch(repo rawgit.Repository, n int) (string, error) {
+	entries, err := repo.ReadReflog("HEAD")
+	if err != nil && !rawgit.IsNotExist(err) {
+		return "", err
+	}
+
+	const checkoutPrefix = "checkout: moving from "
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		message := entries[idx].Message
+		if !strings.HasPrefix(message, checkoutPrefix) {
+			continue
+		}
+
+		n--
+		if n == 0 {
+			message = message[len(checkoutPrefix):]
+			end := strings.Index(message, " to ")
+			if end == -1 {
+				return "", ErrNoPreviousBranch
+			}
+			return message[:end], nil
+		}
+	}
+
+	return "", ErrNoPreviousBranch
+}
+
+func applyOp(repo rawgit.Repository, oid *rawgit.OID, op Op) (*rawgit.OID, error) {
+	switch op.Kind {
+	case OpParent:
+		commit, err := openCommit(repo, oid)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.N == 0 {
+			return commit.GetOID(), nil
+		}
+		if op.N > len(commit.ParentOIDs) {
+			return nil, ErrNoSuchParent
+		}
+		return commit.ParentOIDs[op.N-1], nil
+
+	case OpAncestor:
+		for n := 0; n < op.N; n++ {
+			commit, err := openCommit(repo, oid)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(commit.ParentOIDs) == 0 {
+				return nil, ErrNoSuchParent
+			}
+			oid = commit.ParentOIDs[0]
+		}
+		return oid, nil
+
+	case OpPeel:
+		switch op.Arg {
+		case "":
+			return peel(repo, oid, rawgit.OTypeNone)
+		case "object":
+			return peel(repo, oid, rawgit.OTypeAny)
+		default:
+			return peel(repo, oid, rawgit.ParseOType(op.Arg))
+		}
+
+	case OpSearch:
+		commit, err := peel(repo, oid, rawgit.OTypeCommit)
+		if err != nil {
+			return nil, err
+		}
+		return searchMessage(repo, []*rawgit.OID{commit}, op.Arg)
+	}
+
+	return nil, ErrInvalidRevision
+}
+
+// peel dereferences tags and commits until object of target type is found. OTypeNone
+// peels tags only and OTypeAny just checks that object exists
+func peel(repo rawgit.Repository, oid *rawgit.OID, target rawgit.OType) (*rawgit.OID, error) {
+	for {
+		info, _, err := repo.StatObject(oid)
+		if err != nil {
+			return nil, err
+		}
+
+		otype := info.GetOType()
+		if otype == target || target == rawgit.OTypeAny {
+			return oid, nil
+		}
+
+		switch otype {
+		case rawgit.OTypeTag:
+			tag, err := repo.OpenTag(oid)
+			if err != nil {
+				return nil, err
+			}
+			oid = &tag.TargetOID
+
+		case rawgit.OTypeCommit:
+			if target == rawgit.OTypeNone {
+				return oid, nil
+			}
+			if target != rawgit.OTypeTree {
+				return nil, ErrCannotPeel
+			}
+
+			commit, err := repo.OpenCommit(oid)
+			if err != nil {
+				return nil, err
+			}
+			oid = commit.TreeOID
+
+		default:
+			if target == rawgit.OTypeNone {
+				return oid, nil
+			}
+			return nil, ErrCannotPeel
+		}
+	}
+}
+
+func openCommit(repo rawgit.Repository, oid *rawgit.OID) (*rawgit.Commit, error) {
+	oid, err := peel(repo, oid, rawgit.OTypeCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.OpenCommit(oid)
+}
+
+// searchFromRefs finds youngest commit with matching message, reachable from any ref
+func searchFromRefs(repo rawgit.Repository, pattern string) (*rawgit.OID, error) {
+	var starts []*rawgit.OID
+
+	if head, err := repo.ResolveRef("HEAD"); err == nil {
+		if commit, err := peel(repo, head, rawgit.OTypeCommit); err == nil {
+			starts = append(starts, commit)
+		}
+	}
+
+	refs, err := repo.IterateRefs("")
+	if err != nil {
+		return nil, err
+	}
+
+	for refs.Next() {
+		ref := refs.Ref()
+		if ref.OID == nil {
+			continue
+		}
+
+		commit, err := peel(repo, ref.OID, rawgit.OTypeCommit)
+		if err != nil {
+			// refs to non-commits are skipped
+			continue
+		}
+		starts = append(starts, commit)
+	}
+
+	if err := refs.Err(); err != nil {
+		return nil, err
+	}
+
+	return searchMessage(repo, starts, pattern)
+}
+
+// searchMessage finds youngest commit, reachable from starts, which message matches
+// pattern. Pattern prefixed with '!-' is negated, '!!' stands for literal '!'
+func searchMessage(repo rawgit.Repository, starts []*rawgit.OID, pattern string) (*rawgit.OID, error) {
+	negate := false
+	if strings.HasPrefix(pattern, "!-") {
+		negate = true
+		pattern = pattern[2:]
+	} else if strings.HasPrefix(pattern, "!!") {
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, "!") {
+		return nil, ErrInvalidRevision
+	}
+
+	matcher, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := history.NewCommitSet()
+	var queue []*rawgit.Commit
+	for _, oid := range starts {
+		if seen.Has(oid) {
+			continue
+		}
+
+		commit, err := repo.OpenCommit(oid)
+		if err != nil {
+			return nil, err
+		}
+		seen.Add(oid)
+		queue = append(queue, commit)
+	}
+
+	for len(queue) > 0 {
+		// pick the newest commit
+		newest := 0
+		for idx, commit := range queue {
+			if commit.Committer.Time.After(queue[newest].Committer.Time) {
+				newest = idx
+			}
+		}
+		commit := queue[newest]
+		queue = append(queue[:newest], queue[newest+1:]...)
+
+		if matcher.MatchString(commit.Message) != negate {
+			return commit.GetOID(), nil
+		}
+
+		for _, parentOID := range commit.ParentOIDs {
+			if seen.Has(parentOID) {
+				continue
+			}
+
+			parent, err := repo.OpenCommit(parentOID)
+			if err != nil {
+				return nil, err
+			}
+			seen.Add(parentOID)
+			queue = append(queue, parent)
+		}
+	}
+
+	return nil, ErrNoMatch
+}
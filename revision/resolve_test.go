@@ -0,0 +1,324 @@
+package revision
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mechmind/git-go/rawgit"
+	"github.com/mechmind/git-go/storage/fsstor"
+)
+
+const testConfig = `[remote "origin"]
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = ^refs/heads/private
+[branch "master"]
+	remote = origin
+	merge = refs/heads/master
+[branch "side"]
+	remote = .
+	merge = refs/heads/master
+[branch "topic"]
+	remote = origin
+	merge = refs/heads/other
+[branch "private"]
+	remote = origin
+	merge = refs/heads/private
+`
+
+type testRepo struct {
+	*rawgit.SimpleRepository
+	// objects by name: commits a, b, c, d and merge m, tree and readme of m,
+	// tag v1 of b
+	oids map[string]*rawgit.OID
+}
+
+// newTestRepo makes history
+//
+//	a - b - c - m   master, origin/master at c
+//	         \ /
+//	          d     side
+func newTestRepo(t *testing.T, config string) *testRepo {
+	fs := fsstor.NewOSFS(t.TempDir())
+	stor, err := fsstor.InitFSStorage(fs, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := fs.Create(fsstor.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(config))
+	file.Close()
+
+	repo := &testRepo{SimpleRepository: rawgit.NewRepository(stor, stor), oids: make(map[string]*rawgit.OID)}
+	when := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	commit := func(name, message string, parents ...string) {
+		blob, err := repo.WriteBlob([]byte(name + "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir, err := repo.WriteTree(&rawgit.Tree{Items: []rawgit.TreeItem{{Name: "file", Mode: rawgit.TreeBlobMode, OID: *blob}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := repo.WriteTree(&rawgit.Tree{Items: []rawgit.TreeItem{
+			{Name: "README", Mode: rawgit.TreeBlobMode, OID: *blob},
+			{Name: "dir", Mode: rawgit.TreeDirectoryMode, OID: *dir},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		when = when.Add(time.Hour)
+		ident := rawgit.UserTime{Name: "A U Thor", Email: "author@example.com", Time: when}
+		c := &rawgit.Commit{TreeOID: tree, Author: ident, Committer: ident, Message: message}
+		for _, parent := range parents {
+			c.ParentOIDs = append(c.ParentOIDs, repo.oids[parent])
+		}
+		oid, err := repo.WriteCommit(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo.oids[name] = oid
+		repo.oids[name+":README"] = blob
+		repo.oids[name+"^{tree}"] = tree
+	}
+
+	commit("a", "initial\n")
+	commit("b", "second: fix bug\n", "a")
+	commit("c", "third\n", "b")
+	commit("d", "side change\n", "b")
+	commit("m", "merge side\n", "c", "d")
+
+	tag := &rawgit.Tag{
+		TargetOID:   *repo.oids["b"],
+		TargetOType: rawgit.OTypeCommit,
+		Name:        "v1",
+		Tagger:      &rawgit.UserTime{Name: "T Agger", Email: "tagger@example.com", Time: when},
+		Message:     "release\n",
+	}
+	oid, err := repo.WriteTag(tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.oids["v1"] = oid
+
+	// master moves through history at known times, HEAD points to it
+	var old *rawgit.OID
+	for idx, name := range []string{"a", "b", "c", "m"} {
+		repo.update(t, "refs/heads/master", old, repo.oids[name], time.Date(2021, 1, idx+1, 0, 0, 0, 0, time.UTC))
+		old = repo.oids[name]
+	}
+	for name, target := range map[string]string{
+		"refs/heads/side":            "d",
+		"refs/heads/topic":           "c",
+		"refs/heads/private":         "c",
+		"refs/heads/plain":           "c",
+		"refs/remotes/origin/master": "c",
+		"refs/remotes/origin/topic":  "c",
+		"refs/tags/v1":               "v1",
+		"refs/tags/light":            "c",
+	} {
+		repo.update(t, name, nil, repo.oids[target], when)
+	}
+
+	for _, message := range []string{"checkout: moving from master to side", "checkout: moving from side to master"} {
+		err := repo.AppendReflog("HEAD", &rawgit.ReflogEntry{OldOID: *repo.oids["m"], NewOID: *repo.oids["m"], Committer: rawgit.UserTime{Time: when}, Message: message})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func (repo *testRepo) update(t *testing.T, name string, old, new *rawgit.OID, when time.Time) {
+	if old == nil {
+		old = &rawgit.OID{}
+	}
+	tx := rawgit.NewRefTransaction(repo)
+	tx.Update(name, old, new)
+	tx.SetReflog(&rawgit.UserTime{Name: "C O Mitter", Email: "committer@example.com", Time: when}, "update")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		spec string
+		// name of object in testRepo.oids
+		want string
+		err  error
+	}{
+		{spec: "master", want: "m"},
+		{spec: "refs/heads/master", want: "m"},
+		{spec: "HEAD", want: "m"},
+		{spec: "@", want: "m"},
+		{spec: "origin/master", want: "c"},
+		{spec: "light", want: "c"},
+		{spec: "missing", err: rawgit.ErrNotFound},
+
+		{spec: "master^", want: "c"},
+		{spec: "master^2", want: "d"},
+		{spec: "master^0", want: "m"},
+		{spec: "master^^", want: "b"},
+		{spec: "master~2", want: "b"},
+		{spec: "master^2~1", want: "b"},
+		{spec: "master~3", want: "a"},
+		{spec: "master^3", err: ErrNoSuchParent},
+		{spec: "master~4", err: ErrNoSuchParent},
+
+		{spec: "v1", want: "v1"},
+		{spec: "v1^{}", want: "b"},
+		{spec: "v1^{commit}", want: "b"},
+		{spec: "v1^{tag}", want: "v1"},
+		{spec: "v1^{object}", want: "v1"},
+		{spec: "v1^{tree}", want: "b^{tree}"},
+		{spec: "v1~1", want: "a"},
+		{spec: "master^{tree}", want: "m^{tree}"},
+		{spec: "master^{blob}", err: ErrCannotPeel},
+		{spec: "master^{tag}", err: ErrCannotPeel},
+
+		{spec: "master:README", want: "m:README"},
+		{spec: "master:", want: "m^{tree}"},
+		{spec: "v1:/README", want: "b:README"},
+		{spec: "master~1:README", want: "c:README"},
+		{spec: "master:missing", err: rawgit.ErrNotFound},
+
+		{spec: ":/fix", want: "b"},
+		{spec: ":/^side", want: "d"},
+		{spec: ":/!-merge", want: "d"},
+		{spec: ":/!!", err: ErrNoMatch},
+		{spec: "master^{/^initial}", want: "a"},
+		{spec: "master^2^{/third}", err: ErrNoMatch},
+		{spec: "master^{/fix}~1", want: "a"},
+
+		{spec: "master@{0}", want: "m"},
+		{spec: "master@{1}", want: "c"},
+		{spec: "master@{3}", want: "a"},
+		{spec: "master@{4}", err: ErrReflogTooShort},
+		{spec: "@{2}", want: "b"},
+		{spec: "master@{2021-01-02 12:00:00 +0000}", want: "b"},
+		{spec: "master@{2030-01-01}", want: "m"},
+		{spec: "master@{2000-01-01}", want: "a"},
+		{spec: "light@{0}", want: "c"},
+		{spec: "@{-1}", want: "d"},
+		{spec: "@{-2}", want: "m"},
+		{spec: "@{-3}", err: ErrNoPreviousBranch},
+
+		{spec: "@{u}", want: "c"},
+		{spec: "@{UPSTREAM}", want: "c"},
+		{spec: "master@{upstream}", want: "c"},
+		{spec: "refs/heads/master@{u}", want: "c"},
+		{spec: "master@{u}^", want: "b"},
+		{spec: "side@{u}", want: "m"},
+		{spec: "topic@{u}", err: rawgit.ErrNotFound},
+		{spec: "private@{u}", err: ErrNoTrackingBranch},
+		{spec: "plain@{u}", err: ErrNoUpstream},
+		{spec: "missing@{u}", err: ErrNotABranch},
+		{spec: "v1@{u}", err: ErrNotABranch},
+		{spec: "@{push}", want: "c"},
+		{spec: "master@{push}", want: "c"},
+		{spec: "side@{push}", err: ErrNoTrackingBranch},
+		{spec: "topic@{push}", err: ErrNoPushDestination},
+		{spec: "plain@{push}", err: ErrNoUpstream},
+
+		{spec: "master~1..master", err: ErrNotSingleRevision},
+		{spec: "master^!", err: ErrNotSingleRevision},
+	}
+
+	repo := newTestRepo(t, testConfig)
+	for _, test := range tests {
+		oid, err := Resolve(repo, test.spec)
+		if err != test.err {
+			t.Errorf("%q: got error %v, want %v", test.spec, err, test.err)
+			continue
+		}
+		if err == nil && *oid != *repo.oids[test.want] {
+			t.Errorf("%q: got %s, want %s", test.spec, oid, test.want)
+		}
+	}
+}
+
+func TestResolvePush(t *testing.T) {
+	tests := []struct {
+		config string
+		spec   string
+		want   string
+		err    error
+	}{
+		{config: "[push]\n\tdefault = current\n", spec: "topic@{push}", want: "c"},
+		{config: "[push]\n\tdefault = current\n", spec: "plain@{push}", err: rawgit.ErrNotFound},
+		{config: "[push]\n\tdefault = upstream\n", spec: "side@{push}", want: "m"},
+		{config: "[push]\n\tdefault = nothing\n", spec: "master@{push}", err: ErrNoPushDestination},
+		{config: "[remote]\n\tpushDefault = fork\n[remote \"fork\"]\n\tfetch = +refs/heads/*:refs/remotes/fork/*\n", spec: "master@{push}", err: ErrNoPushDestination},
+		{config: "[branch \"topic\"]\n\tpushRemote = origin\n[push]\n\tdefault = current\n", spec: "topic@{push}", want: "c"},
+		{config: "[remote \"origin\"]\n\tpush = refs/heads/master:refs/heads/topic\n", spec: "master@{push}", want: "c"},
+		{config: "[remote \"origin\"]\n\tpush = refs/heads/topic\n", spec: "topic@{push}", want: "c"},
+		{config: "[remote \"origin\"]\n\tpush = refs/heads/topic\n", spec: "master@{push}", err: ErrNoPushDestination},
+		{config: "[remote \"origin\"]\n\tmirror\n", spec: "topic@{push}", want: "c"},
+	}
+
+	for _, test := range tests {
+		repo := newTestRepo(t, testConfig+test.config)
+		oid, err := Resolve(repo, test.spec)
+		if err != test.err {
+			t.Errorf("%q with %q: got error %v, want %v", test.spec, test.config, err, test.err)
+			continue
+		}
+		if err == nil && *oid != *repo.oids[test.want] {
+			t.Errorf("%q with %q: got %s, want %s", test.spec, test.config, oid, test.want)
+		}
+	}
+}
+
+func TestResolveRange(t *testing.T) {
+	tests := []struct {
+		spec             string
+		include, exclude []string
+	}{
+		{spec: "master", include: []string{"m"}},
+		{spec: "master~3..master", include: []string{"m"}, exclude: []string{"a"}},
+		{spec: "master~1..", include: []string{"m"}, exclude: []string{"c"}},
+		{spec: "master~1...side", include: []string{"c", "d"}, exclude: []string{"b"}},
+		{spec: "master^!", include: []string{"m"}, exclude: []string{"c", "d"}},
+		{spec: "v1^!", include: []string{"b"}, exclude: []string{"a"}},
+		{spec: "master~3^!", include: []string{"a"}},
+		{spec: "master^@", include: []string{"c", "d"}},
+		{spec: "master~3^@"},
+		{spec: "master^-", include: []string{"m"}, exclude: []string{"c"}},
+		{spec: "master^-2", include: []string{"m"}, exclude: []string{"d"}},
+	}
+
+	repo := newTestRepo(t, testConfig)
+	names := make(map[rawgit.OID]string)
+	for name, oid := range repo.oids {
+		names[*oid] = name
+	}
+	toNames := func(oids []*rawgit.OID) []string {
+		var result []string
+		for _, oid := range oids {
+			result = append(result, names[*oid])
+		}
+		return result
+	}
+
+	for _, test := range tests {
+		rev, err := Parse(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		include, exclude, err := rev.ResolveRange(repo)
+		if err != nil {
+			t.Errorf("%q: %v", test.spec, err)
+			continue
+		}
+		if got := toNames(include); !reflect.DeepEqual(got, test.include) {
+			t.Errorf("%q: got included %q, want %q", test.spec, got, test.include)
+		}
+		if got := toNames(exclude); !reflect.DeepEqual(got, test.exclude) {
+			t.Errorf("%q: got excluded %q, want %q", test.spec, got, test.exclude)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package revision
+
+import (
+	"strconv"
+	"strings"
+)
+
+type RevisionKind int
+
+const (
+	// single object, like 'master~2'
+	Single RevisionKind = iota
+	// commits reachable from To, but not from From: 'A..B'
+	Range
+	// commits reachable from either side, but not from both: 'A...B'
+	SymmetricDifference
+	// commit, but none of its ancestors: 'A^!'
+	CommitOnly
+	// all parents of commit: 'A^@'
+	Parents
+)
+
+// Revision is a parsed revision specification, as described in gitrevisions(7)
+type Revision struct {
+	Kind RevisionKind
+	// left side of a range, nil for other kinds. 'A^-n' is parsed as 'A^n..A'
+	From *Expr
+	To   *Expr
+}
+
+// Expr is a single revision expression, like 'v1.0^{commit}' or 'master@{2}~3:README'
+type Expr struct {
+	// ref name, object id or its prefix. Empty for ':/' searches
+	Name string
+	// contents of '@{...}' selector, if any
+	Selector    string
+	HasSelector bool
+	// message regexp of ':/' search
+	Search string
+	Ops    []Op
+	// path inside of tree for 'rev:path' expressions
+	Path    string
+	HasPath bool
+}
+
+type OpKind int
+
+const (
+	// '^n', n-th parent of commit
+	OpParent OpKind = iota
+	// '~n', n-th generation ancestor, following first parents
+	OpAncestor
+	// '^{type}', peel object until given type. Empty type peels tags
+	OpPeel
+	// '^{/regexp}', youngest reachable commit with matching message
+	OpSearch
+)
+
+type Op struct {
+	Kind OpKind
+	N    int
+	Arg  string
+}
+
+// Parse parses revision specification. Sides of ranges default to HEAD
+func Parse(spec string) (*Revision, error) {
+	if spec == "" {
+		return nil, ErrInvalidRevision
+	}
+
+	rev := &Revision{Kind: Single}
+	if strings.HasSuffix(spec, "^!") || strings.HasSuffix(spec, "^@") {
+		expr, err := parseCommitExpr(spec[:len(spec)-2])
+		if err != nil {
+			return nil, err
+		}
+
+		rev.Kind = CommitOnly
+		if strings.HasSuffix(spec, "^@") {
+			rev.Kind = Parents
+		}
+		rev.To = expr
+		return rev, nil
+	}
+
+	if idx := strings.LastIndex(spec, "^-"); idx != -1 && isDigits(spec[idx+2:]) {
+		n := 1
+		if idx+2 < len(spec) {
+			var err error
+			n, err = strconv.Atoi(spec[idx+2:])
+			if err != nil {
+				return nil, ErrInvalidRevision
+			}
+		}
+
+		expr, err := parseCommitExpr(spec[:idx])
+		if err != nil {
+			return nil, err
+		}
+
+		from := *expr
+		from.Ops = append(append([]Op(nil), expr.Ops...), Op{Kind: OpParent, N: n})
+		rev.Kind = Range
+		rev.From, rev.To = &from, expr
+		return rev, nil
+	}
+
+	left, right := spec, ""
+	if idx := findRange(spec, "..."); idx != -1 {
+		rev.Kind = SymmetricDifference
+		left, right = spec[:idx], spec[idx+3:]
+	} else if idx := findRange(spec, ".."); idx != -1 {
+		rev.Kind = Range
+		left, right = spec[:idx], spec[idx+2:]
+	}
+
+	if rev.Kind == Single {
+		expr, err := ParseExpr(spec)
+		if err != nil {
+			return nil, err
+		}
+		rev.To = expr
+		return rev, nil
+	}
+
+	if left == "" && right == "" {
+		return nil, ErrInvalidRevision
+	}
+	if left == "" {
+		left = "HEAD"
+	}
+	if right == "" {
+		right = "HEAD"
+	}
+
+	var err error
+	rev.From, err = ParseExpr(left)
+	if err != nil {
+		return nil, err
+	}
+
+	rev.To, err = ParseExpr(right)
+	if err != nil {
+		return nil, err
+	}
+
+	return rev, nil
+}
+
+// parseCommitExpr parses expression, followed by '^!', '^@' or '^-' suffix, which
+// can't be a range or a path
+func parseCommitExpr(spec string) (*Expr, error) {
+	if findRange(spec, "..") != -1 {
+		return nil, ErrInvalidRevision
+	}
+
+	expr, err := ParseExpr(spec)
+	if err != nil {
+		return nil, err
+	}
+	if expr.HasPath {
+		return nil, ErrInvalidRevision
+	}
+	return expr, nil
+}
+
+func isDigits(s string) bool {
+	for idx := 0; idx < len(s); idx++ {
+		if s[idx] < '0' || s[idx] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// findRange finds range operator, that is not part of path or braced argument
+func findRange(spec, op string) int {
+	if strings.HasPrefix(spec, ":") {
+		return -1
+	}
+
+	depth := 0
+	for idx := 0; idx < len(spec); idx++ {
+		switch spec[idx] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ':':
+			if depth == 0 {
+				// rest is a path
+				return -1
+			}
+		case '.':
+			if depth == 0 && strings.HasPrefix(spec[idx:], op) {
+				return idx
+			}
+		}
+	}
+	return -1
+}
+
+// ParseExpr parses single revision expression
+func ParseExpr(spec string) (*Expr, error) {
+	expr := &Expr{}
+
+	if strings.HasPrefix(spec, ":/") {
+		expr.Search = spec[2:]
+		if expr.Search == "" {
+			return nil, ErrInvalidRevision
+		}
+		return expr, nil
+	}
+
+	if strings.HasPrefix(spec, ":") {
+		// ':path' and ':n:path' refer to index entries
+		return nil, ErrIndexNotSupported
+	}
+
+	// split off path
+	depth := 0
+	for idx := 0; idx < len(spec); idx++ {
+		if spec[idx] == '{' {
+			depth++
+		} else if spec[idx] == '}' {
+			depth--
+		} else if spec[idx] == ':' && depth == 0 {
+			expr.Path = spec[idx+1:]
+			expr.HasPath = true
+			spec = spec[:idx]
+			break
+		}
+	}
+
+	// name is followed by suffix operators, which can not appear in ref names
+	opsStart := len(spec)
+	depth = 0
+	for idx := 0; idx < len(spec); idx++ {
+		if spec[idx] == '{' {
+			depth++
+		} else if spec[idx] == '}' {
+			depth--
+		} else if (spec[idx] == '^' || spec[idx] == '~') && depth == 0 {
+			opsStart = idx
+			break
+		}
+	}
+
+	name := spec[:opsStart]
+	if idx := strings.Index(name, "@{"); idx != -1 {
+		if !strings.HasSuffix(name, "}") {
+			return nil, ErrInvalidRevision
+		}
+		expr.Selector = name[idx+2 : len(name)-1]
+		expr.HasSelector = true
+		name = name[:idx]
+	}
+
+	if name == "@" {
+		name = "HEAD"
+	}
+	if name == "" && !expr.HasSelector {
+		return nil, ErrInvalidRevision
+	}
+	expr.Name = name
+
+	ops, err := parseOps(spec[opsStart:])
+	if err != nil {
+		return nil, err
+	}
+	expr.Ops = ops
+
+	return expr, nil
+}
+
+func parseOps(spec string) ([]Op, error) {
+	var ops []Op
+	for len(spec) > 0 {
+		var op Op
+		c := spec[0]
+		spec = spec[1:]
+
+		if c == '^' && strings.HasPrefix(spec, "{") {
+			end := matchingBrace(spec)
+			if end == -1 {
+				return nil, ErrInvalidRevision
+			}
+
+			arg := spec[1:end]
+			spec = spec[end+1:]
+			if strings.HasPrefix(arg, "/") {
+				op.Kind = OpSearch
+				op.Arg = arg[1:]
+			} else {
+				switch arg {
+				case "", "commit", "tree", "blob", "tag", "object":
+				default:
+					return nil, ErrInvalidRevision
+				}
+				op.Kind = OpPeel
+				op.Arg = arg
+			}
+			ops = append(ops, op)
+			continue
+		}
+
+		switch c {
+		case '^':
+			op.Kind = OpParent
+		case '~':
+			op.Kind = OpAncestor
+		default:
+			return nil, ErrInvalidRevision
+		}
+
+		digits := 0
+		for digits < len(spec) && spec[digits] >= '0' && spec[digits] <= '9' {
+			digits++
+		}
+
+		op.N = 1
+		if digits > 0 {
+			n, err := strconv.Atoi(spec[:digits])
+			if err != nil {
+				return nil, ErrInvalidRevision
+			}
+			op.N = n
+			spec = spec[digits:]
+		}
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+func matchingBrace(spec string) int {
+	depth := 0
+	for idx := 0; idx < len(spec); idx++ {
+		switch spec[idx] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return idx
+			}
+		}
+	}
+	return -1
+}
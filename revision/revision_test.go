@@ -0,0 +1,114 @@
+package revision
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec string
+		want *Revision
+		err  error
+	}{
+		{
+			spec: "master",
+			want: &Revision{Kind: Single, To: &Expr{Name: "master"}},
+		},
+		{
+			spec: "@",
+			want: &Revision{Kind: Single, To: &Expr{Name: "HEAD"}},
+		},
+		{
+			spec: "v1.0^{commit}~2^2",
+			want: &Revision{Kind: Single, To: &Expr{Name: "v1.0", Ops: []Op{
+				{Kind: OpPeel, Arg: "commit"}, {Kind: OpAncestor, N: 2}, {Kind: OpParent, N: 2},
+			}}},
+		},
+		{
+			spec: "HEAD^^{}~",
+			want: &Revision{Kind: Single, To: &Expr{Name: "HEAD", Ops: []Op{
+				{Kind: OpParent, N: 1}, {Kind: OpPeel}, {Kind: OpAncestor, N: 1},
+			}}},
+		},
+		{
+			spec: "master^{/fix: a..b}",
+			want: &Revision{Kind: Single, To: &Expr{Name: "master", Ops: []Op{{Kind: OpSearch, Arg: "fix: a..b"}}}},
+		},
+		{
+			spec: "master@{2}:dir/a..b",
+			want: &Revision{Kind: Single, To: &Expr{Name: "master", Selector: "2", HasSelector: true, Path: "dir/a..b", HasPath: true}},
+		},
+		{
+			spec: "@{-1}",
+			want: &Revision{Kind: Single, To: &Expr{Selector: "-1", HasSelector: true}},
+		},
+		{
+			spec: "@{upstream}~1",
+			want: &Revision{Kind: Single, To: &Expr{Selector: "upstream", HasSelector: true, Ops: []Op{{Kind: OpAncestor, N: 1}}}},
+		},
+		{
+			spec: "master:",
+			want: &Revision{Kind: Single, To: &Expr{Name: "master", HasPath: true}},
+		},
+		{
+			spec: ":/fix",
+			want: &Revision{Kind: Single, To: &Expr{Search: "fix"}},
+		},
+		{
+			spec: "a..b",
+			want: &Revision{Kind: Range, From: &Expr{Name: "a"}, To: &Expr{Name: "b"}},
+		},
+		{
+			spec: "a..",
+			want: &Revision{Kind: Range, From: &Expr{Name: "a"}, To: &Expr{Name: "HEAD"}},
+		},
+		{
+			spec: "...b",
+			want: &Revision{Kind: SymmetricDifference, From: &Expr{Name: "HEAD"}, To: &Expr{Name: "b"}},
+		},
+		{
+			spec: "master~2^!",
+			want: &Revision{Kind: CommitOnly, To: &Expr{Name: "master", Ops: []Op{{Kind: OpAncestor, N: 2}}}},
+		},
+		{
+			spec: "master^@",
+			want: &Revision{Kind: Parents, To: &Expr{Name: "master"}},
+		},
+		{
+			spec: "master^-",
+			want: &Revision{Kind: Range, From: &Expr{Name: "master", Ops: []Op{{Kind: OpParent, N: 1}}}, To: &Expr{Name: "master"}},
+		},
+		{
+			spec: "master~^-2",
+			want: &Revision{
+				Kind: Range,
+				From: &Expr{Name: "master", Ops: []Op{{Kind: OpAncestor, N: 1}, {Kind: OpParent, N: 2}}},
+				To:   &Expr{Name: "master", Ops: []Op{{Kind: OpAncestor, N: 1}}},
+			},
+		},
+		{spec: "", err: ErrInvalidRevision},
+		{spec: "..", err: ErrInvalidRevision},
+		{spec: ":/", err: ErrInvalidRevision},
+		{spec: ":README", err: ErrIndexNotSupported},
+		{spec: "master^{bad}", err: ErrInvalidRevision},
+		{spec: "master^{commit", err: ErrInvalidRevision},
+		{spec: "master@{1", err: ErrInvalidRevision},
+		{spec: "master^x", err: ErrInvalidRevision},
+		{spec: "^!", err: ErrInvalidRevision},
+		{spec: "a..b^!", err: ErrInvalidRevision},
+		{spec: "master:README^@", err: ErrInvalidRevision},
+		{spec: "master^-x", err: ErrInvalidRevision},
+	}
+
+	for _, test := range tests {
+		rev, err := Parse(test.spec)
+		if err != test.err {
+			t.Errorf("%q: got error %v, want %v", test.spec, err, test.err)
+			continue
+		}
+		if !reflect.DeepEqual(rev, test.want) {
+			t.Errorf("%q: got %+v, want %+v", test.spec, rev, test.want)
+		}
+	}
+}
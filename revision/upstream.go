@@ -0,0 +1,145 @@
+package revision
+
+import (
+	"strings"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// upstreamRef finds remote-tracking branch, which branch merges from, as
+// configured by branch.<name>.remote and branch.<name>.merge
+func upstreamRef(config *rawgit.Config, branch string) (string, error) {
+	remote, _ := config.Get("branch", branch, "remote")
+	merge, _ := config.Get("branch", branch, "merge")
+	if remote == "" || merge == "" {
+		return "", ErrNoUpstream
+	}
+	if !strings.HasPrefix(merge, "refs/") {
+		merge = rawgit.RefBranchNS + merge
+	}
+
+	if remote == "." {
+		// upstream is local branch
+		return merge, nil
+	}
+
+	return trackingRef(config, remote, merge)
+}
+
+// pushRef finds remote-tracking branch, which branch is pushed to by 'git push'
+func pushRef(config *rawgit.Config, branch string) (string, error) {
+	remote, ok := config.Get("branch", branch, "pushremote")
+	if !ok {
+		remote, ok = config.Get("remote", "", "pushdefault")
+	}
+	if !ok {
+		remote, ok = config.Get("branch", branch, "remote")
+	}
+	if !ok {
+		remote = "origin"
+	}
+
+	ref := rawgit.RefBranchNS + branch
+	if refspecs := config.GetAll("remote", remote, "push"); len(refspecs) > 0 {
+		for idx, refspec := range refspecs {
+			if !strings.HasPrefix(refspec, "^") && !strings.Contains(refspec, ":") {
+				// ref is pushed to the same name
+				refspecs[idx] = refspec + ":" + strings.TrimPrefix(refspec, "+")
+			}
+		}
+		dst, ok := applyRefspecs(refspecs, ref)
+		if !ok {
+			return "", ErrNoPushDestination
+		}
+		return trackingRef(config, remote, dst)
+	}
+	if mirror, ok := config.Get("remote", remote, "mirror"); ok && isConfigTrue(mirror) {
+		return trackingRef(config, remote, ref)
+	}
+
+	mode, _ := config.Get("push", "", "default")
+	switch strings.ToLower(mode) {
+	case "nothing":
+		return "", ErrNoPushDestination
+	case "matching", "current":
+		return trackingRef(config, remote, ref)
+	case "upstream", "tracking":
+		return upstreamRef(config, branch)
+	case "", "simple":
+		// upstream, which has the same name as branch
+		upstream, err := upstreamRef(config, branch)
+		if err != nil {
+			return "", err
+		}
+		current, err := trackingRef(config, remote, ref)
+		if err != nil {
+			return "", err
+		}
+		if current != upstream {
+			return "", ErrNoPushDestination
+		}
+		return current, nil
+	}
+	return "", ErrNoPushDestination
+}
+
+// trackingRef maps ref of remote repository to local remote-tracking branch
+// with fetch refspecs of remote
+func trackingRef(config *rawgit.Config, remote, ref string) (string, error) {
+	tracking, ok := applyRefspecs(config.GetAll("remote", remote, "fetch"), ref)
+	if !ok {
+		return "", ErrNoTrackingBranch
+	}
+	return tracking, nil
+}
+
+// applyRefspecs maps ref with the first matching refspec like
+// '+refs/heads/*:refs/remotes/origin/*'. Refs, matching negative refspecs, which
+// start with '^', are not mapped
+func applyRefspecs(refspecs []string, ref string) (string, bool) {
+	for _, refspec := range refspecs {
+		if strings.HasPrefix(refspec, "^") {
+			if _, ok := matchRefspec(refspec[1:], refspec[1:], ref); ok {
+				return "", false
+			}
+		}
+	}
+
+	for _, refspec := range refspecs {
+		refspec = strings.TrimPrefix(refspec, "+")
+		colon := strings.IndexByte(refspec, ':')
+		if strings.HasPrefix(refspec, "^") || colon == -1 {
+			continue
+		}
+
+		if dst, ok := matchRefspec(refspec[:colon], refspec[colon+1:], ref); ok && dst != "" {
+			return dst, true
+		}
+	}
+	return "", false
+}
+
+// matchRefspec matches ref against source side of refspec and maps it to
+// destination side. Sides may have one '*', which matches any part of ref
+func matchRefspec(src, dst, ref string) (string, bool) {
+	star := strings.IndexByte(src, '*')
+	if star == -1 {
+		return dst, src == ref
+	}
+
+	prefix, suffix := src[:star], src[star+1:]
+	if len(ref) < len(prefix)+len(suffix) || !strings.HasPrefix(ref, prefix) || !strings.HasSuffix(ref, suffix) {
+		return "", false
+	}
+	return strings.Replace(dst, "*", ref[len(prefix):len(ref)-len(suffix)], 1), true
+}
+
+// isConfigTrue reports whether boolean variable is true. Variable without value
+// is true too
+func isConfigTrue(value string) bool {
+	switch strings.ToLower(value) {
+	case "", "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+package signature
+
+import (
+	"errors"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+var (
+	ErrUnsupportedSignature = rawgit.ErrUnsupportedSignature
+	ErrUnknownSigner        = rawgit.ErrUnknownSigner
+	ErrBadSignature         = rawgit.ErrBadSignature
+	ErrKeyExpired           = rawgit.ErrKeyExpired
+	ErrKeyRevoked           = rawgit.ErrKeyRevoked
+	ErrSignatureExpired     = rawgit.ErrSignatureExpired
+)
+
+var ErrInvalidAllowedSigners = errors.New("malformed allowed signers file")
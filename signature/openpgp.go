@@ -0,0 +1,111 @@
+package signature
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/mechmind/git-go/rawgit"
+)
+
+const pgpArmorStart = "-----BEGIN PGP SIGNATURE-----"
+
+// OpenPGPVerifier checks OpenPGP signatures, made by 'gpg --detach-sign', against
+// public keys in keyring
+type OpenPGPVerifier struct {
+	keyring openpgp.EntityList
+}
+
+// NewOpenPGPVerifier reads keyring with trusted public keys, armored or binary, as
+// produced by 'gpg --export'
+func NewOpenPGPVerifier(keyring io.Reader) (*OpenPGPVerifier, error) {
+	data, err := ioutil.ReadAll(keyring)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys openpgp.EntityList
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		keys, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &OpenPGPVerifier{keyring: keys}, nil
+}
+
+// Verify checks armored OpenPGP signature. When signature matches, but signing key
+// is expired or revoked, info is returned with ErrKeyExpired, ErrKeyRevoked or
+// ErrSignatureExpired
+func (verifier *OpenPGPVerifier) Verify(payload, signature []byte) (*rawgit.SignatureInfo, error) {
+	if !strings.HasPrefix(string(signature), pgpArmorStart) {
+		return nil, ErrUnsupportedSignature
+	}
+
+	block, err := armor.Decode(bytes.NewReader(signature))
+	if err != nil || block.Type != openpgp.SignatureType {
+		return nil, ErrBadSignature
+	}
+
+	sig, signer, err := openpgp.VerifyDetachedSignature(verifier.keyring, bytes.NewReader(payload), block.Body, nil)
+	if sig == nil || signer == nil {
+		if err == pgperrors.ErrUnknownIssuer {
+			return nil, ErrUnknownSigner
+		}
+		return nil, ErrBadSignature
+	}
+
+	info := &rawgit.SignatureInfo{}
+	if sig.IssuerKeyId != nil {
+		for _, key := range verifier.keyring.KeysById(*sig.IssuerKeyId) {
+			info.Fingerprint = strings.ToUpper(hex.EncodeToString(key.PublicKey.Fingerprint))
+			break
+		}
+	}
+	if identity := signer.PrimaryIdentity(); identity != nil {
+		info.Signer = identity.Name
+	}
+
+	switch err {
+	case nil:
+		return info, nil
+	case pgperrors.ErrKeyExpired:
+		return info, ErrKeyExpired
+	case pgperrors.ErrKeyRevoked:
+		return info, ErrKeyRevoked
+	case pgperrors.ErrSignatureExpired:
+		return info, ErrSignatureExpired
+	default:
+		// e.g. unknown critical notation
+		return nil, ErrBadSignature
+	}
+}
+
+// OpenPGPSigner signs objects with OpenPGP key, as 'gpg --detach-sign --armor' does
+type OpenPGPSigner struct {
+	entity *openpgp.Entity
+}
+
+// NewOpenPGPSigner makes signer from entity with decrypted private key
+func NewOpenPGPSigner(entity *openpgp.Entity) *OpenPGPSigner {
+	return &OpenPGPSigner{entity: entity}
+}
+
+func (signer *OpenPGPSigner) Sign(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	err := openpgp.ArmoredDetachSign(&buf, signer.entity, bytes.NewReader(payload), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
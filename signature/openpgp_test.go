@@ -0,0 +1,216 @@
+package signature
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// public keys of good, expired, revoked and short signers, made by 'gpg --export'
+const testPGPKeyring = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEatLZ5xYJKwYBBAHaRw8BAQdAAbx/es9lu/AqKk2G/c160f7PSWwCskdeXUNc
+UNMRNdy0Hkdvb2QgU2lnbmVyIDxnb29kQGV4YW1wbGUuY29tPoiQBBMWCAA4FiEE
+4kU/1qPmQUhDhI6xqBSMoo+bq3IFAmrS2ecCGwMFCwkIBwIGFQoJCAsCBBYCAwEC
+HgECF4AACgkQqBSMoo+bq3JPJAEAqwse4BOAtvf0bTjDpCUuthJ7L5rU999Jio4j
+U0S+ZZcA/3sAfhniLL29LfHtgplLq3nm70djPgjv42VBsECyfuAKmDMEXgvhABYJ
+KwYBBAHaRw8BAQdAtuTZ3v1Rv/xD229xdnlUTEUpKkEviJh3Y4hNo7w5geK0JEV4
+cGlyZWQgU2lnbmVyIDxleHBpcmVkQGV4YW1wbGUuY29tPoiWBBMWCAA+FiEEinbD
+saiJnBOKVQQPtNNBCjDnD+IFAl4L4QACGwMFCQABUYAFCwkIBwIGFQoJCAsCBBYC
+AwECHgECF4AACgkQtNNBCjDnD+Iy0AD/fD0pHIDuHe2+sbjFFeMlSyrsAB+2wh8M
+Nly4Hro6Ru8A/RA1Dx8BqT2u9kJ4UJtRHe9JPAqpkSrFt//2oFqBrtoMmDMEatLZ
+5xYJKwYBBAHaRw8BAQdArL9NK5nqUrW0r4dCRWAZpnIXqvAe8fZPlXeOzA2dL3mI
+eAQgFggAIBYhBNar0mPWv62RwE0GvvY3XUu3OTiUBQJq0tnnAh0AAAoJEPY3XUu3
+OTiUjo4BALNugr6ZddBV25V6cxkp0eqMqZgrWkvzvjBIZ96ESC46AQDLdpJ2NOY8
+5qhfMOUnNiP8/kAiuATQZLimu+smGhXtDbQkUmV2b2tlZCBTaWduZXIgPHJldm9r
+ZWRAZXhhbXBsZS5jb20+iJAEExYIADgWIQTWq9Jj1r+tkcBNBr72N11Ltzk4lAUC
+atLZ5wIbAwULCQgHAgYVCgkICwIEFgIDAQIeAQIXgAAKCRD2N11Ltzk4lAFTAQDX
+2HJYPKifLvhayvmXOIptJqmWbtDD/brgWMQ1sYZ12QD+I3/N2B1AG1sQQEtzr9Ei
+G/zHYV6fDhoXBNpIEt/pnw2YMwReC+EAFgkrBgEEAdpHDwEBB0BRhlN4pJJKEBIb
+H1U2dOXxUgCLyVBCYPfq4QGIvezOorQgU2hvcnQgU2lnbmVyIDxzaG9ydEBleGFt
+cGxlLmNvbT6IkAQTFggAOBYhBEku4jKjlHS1ETXu9j9HMpPq3p1mBQJeC+EAAhsD
+BQsJCAcCBhUKCQgLAgQWAgMBAh4BAheAAAoJED9HMpPq3p1msmYBALtjU/kIv8kT
+HqhrljM8i2Yq/4oJyBGfjfMRVZsbLLxzAP9q3gc0OKjKf76nWlmg3e6kAPU4Rkwq
+HqEppH6ler8bDg==
+=Io16
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+// fixtures are made by 'gpg --detach-sign --armor' of testPayload
+const (
+	testPGPSig = `-----BEGIN PGP SIGNATURE-----
+
+iIcEABYIAC8WIQTiRT/Wo+ZBSEOEjrGoFIyij5urcgUCatLZ7BEcZ29vZEBleGFt
+cGxlLmNvbQAKCRCoFIyij5urcvnoAP9DIHttT4P3/W3pOc0ehzT2sVp2CLLfMOOM
+nuAfEz8eygD/fUUExlpDETcEP1r1F12vy4P3GR8b2mIRmNPRfLy6DgE=
+=wYrR
+-----END PGP SIGNATURE-----
+`
+	// key was valid for one day since 2020-01-01
+	testPGPExpiredKeySig = `-----BEGIN PGP SIGNATURE-----
+
+iIoEABYIADIWIQSKdsOxqImcE4pVBA+000EKMOcP4gUCXgvvEBQcZXhwaXJlZEBl
+eGFtcGxlLmNvbQAKCRC000EKMOcP4nhwAP4hM3+wiaugRx8YPdg4iUmpzR3scMjS
+feDak5Xrta62hwD7BJpL25r2KCsZ4Xc6kAoqM49pbMJNNt3V2baqkbegJA8=
+=Ccw6
+-----END PGP SIGNATURE-----
+`
+	// made before key was revoked
+	testPGPRevokedKeySig = `-----BEGIN PGP SIGNATURE-----
+
+iIoEABYIADIWIQTWq9Jj1r+tkcBNBr72N11Ltzk4lAUCatLZ7BQccmV2b2tlZEBl
+eGFtcGxlLmNvbQAKCRD2N11Ltzk4lPXhAP9I30pv7DdgzJbvBDrKNHAS1EIypyPI
+td8OUms7pca+5AEAwhdg1KF2WjqsDk6aYVVQddkdn+lzQFdvG8Pne56jdwA=
+=zZ62
+-----END PGP SIGNATURE-----
+`
+	// made in 2020-01-01 and valid for one day
+	testPGPExpiredSig = `-----BEGIN PGP SIGNATURE-----
+
+iI4EABYIADYWIQRJLuIyo5R0tRE17vY/RzKT6t6dZgUCXgvvEAWDAAFRgBIcc2hv
+cnRAZXhhbXBsZS5jb20ACgkQP0cyk+renWYV7wD+Nqt5T+Y34MTCpxpDLcuf2os+
+DBAeKTc2bFO3XAtLXOMBAIGUFaNEnv8gZLaDGx5dF4GzXuvYwjvskL99v7k8WXEN
+=gULq
+-----END PGP SIGNATURE-----
+`
+	// key is not in testPGPKeyring
+	testPGPUnknownSig = `-----BEGIN PGP SIGNATURE-----
+
+iIoEABYIADIWIQQM2J24oVEICMRZrnpp1tFR05iNZAUCatLZ7BQcdW5rbm93bkBl
+eGFtcGxlLmNvbQAKCRBp1tFR05iNZAmRAPsEmtAS8UGN9r+Dg2MLrHopURxR/M5y
+Vvh+S+I9NuIfygEAp3YQlGGS+hOhWU8gvD7qLnTxz7N4rGB+eF6MjWeHswM=
+=P1hF
+-----END PGP SIGNATURE-----
+`
+)
+
+func TestOpenPGPVerifier(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string
+		signature string
+		want      *rawgit.SignatureInfo
+		err       error
+	}{
+		{
+			name:      "good",
+			signature: testPGPSig,
+			want: &rawgit.SignatureInfo{
+				Fingerprint: "E2453FD6A3E6414843848EB1A8148CA28F9BAB72",
+				Signer:      "Good Signer <good@example.com>",
+			},
+		},
+		{
+			name:      "changed payload",
+			payload:   testPayload + "changed\n",
+			signature: testPGPSig,
+			err:       ErrBadSignature,
+		},
+		{
+			name:      "broken armor",
+			signature: "-----BEGIN PGP SIGNATURE-----\n\nbroken\n",
+			err:       ErrBadSignature,
+		},
+		{
+			name:      "other format",
+			signature: testSSHSig,
+			err:       ErrUnsupportedSignature,
+		},
+		{
+			name:      "unknown key",
+			signature: testPGPUnknownSig,
+			err:       ErrUnknownSigner,
+		},
+		{
+			name:      "expired key",
+			signature: testPGPExpiredKeySig,
+			want: &rawgit.SignatureInfo{
+				Fingerprint: "8A76C3B1A8899C138A55040FB4D3410A30E70FE2",
+				Signer:      "Expired Signer <expired@example.com>",
+			},
+			err: ErrKeyExpired,
+		},
+		{
+			name:      "revoked key",
+			signature: testPGPRevokedKeySig,
+			want: &rawgit.SignatureInfo{
+				Fingerprint: "D6ABD263D6BFAD91C04D06BEF6375D4BB7393894",
+				Signer:      "Revoked Signer <revoked@example.com>",
+			},
+			err: ErrKeyRevoked,
+		},
+		{
+			name:      "expired signature",
+			signature: testPGPExpiredSig,
+			want: &rawgit.SignatureInfo{
+				Fingerprint: "492EE232A39474B51135EEF63F473293EADE9D66",
+				Signer:      "Short Signer <short@example.com>",
+			},
+			err: ErrSignatureExpired,
+		},
+	}
+
+	verifier, err := NewOpenPGPVerifier(strings.NewReader(testPGPKeyring))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := test.payload
+			if payload == "" {
+				payload = testPayload
+			}
+			info, err := verifier.Verify([]byte(payload), []byte(test.signature))
+			if err != test.err {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if !reflect.DeepEqual(info, test.want) {
+				t.Errorf("got %+v, want %+v", info, test.want)
+			}
+		})
+	}
+}
+
+func TestOpenPGPSigner(t *testing.T) {
+	entity, err := openpgp.NewEntity("Test Signer", "", "test@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// binary keyring
+	var keyring bytes.Buffer
+	if err := entity.Serialize(&keyring); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewOpenPGPVerifier(&keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := NewOpenPGPSigner(entity).Sign([]byte(testPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(signature, []byte(pgpArmorStart)) || !bytes.HasSuffix(signature, []byte("-----\n")) {
+		t.Errorf("got signature %q", signature)
+	}
+
+	info, err := verifier.Verify([]byte(testPayload), signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Signer != "Test Signer <test@example.com>" {
+		t.Errorf("got signer %q", info.Signer)
+	}
+
+	_, err = verifier.Verify([]byte(testPayload+"changed\n"), signature)
+	if err != ErrBadSignature {
+		t.Errorf("changed payload verified with %v", err)
+	}
+}
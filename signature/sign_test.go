@@ -0,0 +1,204 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/mechmind/git-go/rawgit"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testTreeHex   = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	testParentHex = "1111111111111111111111111111111111111111"
+)
+
+// testSigners makes ssh and OpenPGP signers with verifiers, which trust them
+func testSigners(t *testing.T) (map[string]rawgit.Signer, rawgit.Verifiers) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshSigner, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshVerifier, err := NewSSHVerifier(strings.NewReader("ssh@example.com " +
+		string(ssh.MarshalAuthorizedKey(sshSigner.PublicKey()))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entity, err := openpgp.NewEntity("PGP Signer", "", "pgp@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keyring bytes.Buffer
+	if err := entity.Serialize(&keyring); err != nil {
+		t.Fatal(err)
+	}
+	pgpVerifier, err := NewOpenPGPVerifier(&keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signers := map[string]rawgit.Signer{
+		"ssh@example.com":              NewSSHSigner(sshSigner),
+		"PGP Signer <pgp@example.com>": NewOpenPGPSigner(entity),
+	}
+	return signers, rawgit.Verifiers{sshVerifier, pgpVerifier}
+}
+
+func TestSignCommit(t *testing.T) {
+	signers, verifiers := testSigners(t)
+
+	tests := []struct {
+		name string
+		data string
+		// commit, which is signed, if it differs from data
+		unsigned string
+	}{
+		{
+			name: "plain commit",
+			data: "tree " + testTreeHex + "\n" +
+				"parent " + testParentHex + "\n" +
+				"author A U Thor <author@example.com> 1500000000 +0300\n" +
+				"committer C O Mitter <committer@example.com> 1500000100 +0300\n" +
+				"\n" +
+				"message\n",
+		},
+		{
+			name: "extra headers and encoding",
+			data: "tree " + testTreeHex + "\n" +
+				"author A U Thor <author@example.com> 1500000000 +0300\n" +
+				"committer C O Mitter <committer@example.com> 1500000100 +0300\n" +
+				"mergetag object " + testParentHex + "\n" +
+				" type commit\n" +
+				" \n" +
+				" release\n" +
+				"encoding ISO-8859-1\n" +
+				"x-custom value\n" +
+				"\n" +
+				"caf\xe9\n",
+		},
+		{
+			name: "resigned commit",
+			data: "tree " + testTreeHex + "\n" +
+				"author A U Thor <author@example.com> 1500000000 +0300\n" +
+				"committer C O Mitter <committer@example.com> 1500000100 +0300\n" +
+				"gpgsig " + strings.ReplaceAll(strings.TrimSuffix(testSSHSig, "\n"), "\n", "\n ") + "\n" +
+				"\n" +
+				"message",
+			unsigned: "tree " + testTreeHex + "\n" +
+				"author A U Thor <author@example.com> 1500000000 +0300\n" +
+				"committer C O Mitter <committer@example.com> 1500000100 +0300\n" +
+				"\n" +
+				"message",
+		},
+	}
+
+	for _, test := range tests {
+		for name, signer := range signers {
+			t.Run(test.name+"/"+name, func(t *testing.T) {
+				commit, err := rawgit.ReadCommit(ioutil.NopCloser(strings.NewReader(test.data)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := commit.Sign(signer); err != nil {
+					t.Fatal(err)
+				}
+				if headers := commit.ExtraHeaders.GetAll(rawgit.SignatureHeader); len(headers) != 1 {
+					t.Errorf("got %d signatures", len(headers))
+				}
+
+				encoded := commit.Encode()
+				reparsed, err := rawgit.ReadCommit(ioutil.NopCloser(bytes.NewReader(encoded)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if reencoded := reparsed.Encode(); !bytes.Equal(reencoded, encoded) {
+					t.Errorf("reparsed commit encodes as:\n%s\nwant:\n%s", reencoded, encoded)
+				}
+
+				unsigned := test.unsigned
+				if unsigned == "" {
+					unsigned = test.data
+				}
+				payload, _, err := reparsed.SignedPayload()
+				if err != nil || string(payload) != unsigned {
+					t.Errorf("got payload %q, %v, want %q", payload, err, unsigned)
+				}
+
+				info, err := rawgit.VerifyCommit(reparsed, verifiers)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Signer != name {
+					t.Errorf("signed by %q", info.Signer)
+				}
+			})
+		}
+	}
+}
+
+func TestSignTag(t *testing.T) {
+	signers, verifiers := testSigners(t)
+	header := "object " + testParentHex + "\n" +
+		"type commit\n" +
+		"tag v1.0\n" +
+		"tagger T Agger <tagger@example.com> 1400000000 +0000\n" +
+		"\n"
+
+	tests := []struct {
+		name     string
+		message  string
+		unsigned string
+	}{
+		{name: "message", message: "release\n", unsigned: "release\n"},
+		{name: "no newline at end", message: "release", unsigned: "release\n"},
+		{name: "empty message", message: "", unsigned: ""},
+		{name: "resigned tag", message: "release\n" + testPGPSig, unsigned: "release\n"},
+	}
+
+	for _, test := range tests {
+		for name, signer := range signers {
+			t.Run(test.name+"/"+name, func(t *testing.T) {
+				tag, err := rawgit.ReadTag(ioutil.NopCloser(strings.NewReader(header + test.message)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := tag.Sign(signer); err != nil {
+					t.Fatal(err)
+				}
+
+				encoded := tag.Encode()
+				reparsed, err := rawgit.ReadTag(ioutil.NopCloser(bytes.NewReader(encoded)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if reencoded := reparsed.Encode(); !bytes.Equal(reencoded, encoded) {
+					t.Errorf("reparsed tag encodes as:\n%s\nwant:\n%s", reencoded, encoded)
+				}
+
+				payload, _, err := reparsed.SignedPayload()
+				if err != nil || string(payload) != header+test.unsigned {
+					t.Errorf("got payload %q, %v, want %q", payload, err, header+test.unsigned)
+				}
+
+				info, err := rawgit.VerifyTag(reparsed, verifiers)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Signer != name {
+					t.Errorf("signed by %q", info.Signer)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package signature
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mechmind/git-go/rawgit"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sshArmorStart = "-----BEGIN SSH SIGNATURE-----"
+	sshArmorEnd   = "-----END SSH SIGNATURE-----"
+	sshSigMagic   = "SSHSIG"
+	sshSigVersion = 1
+	// git signs objects in this namespace
+	sshNamespace = "git"
+	// line length of base64 in armor, as in ssh-keygen
+	sshArmorWidth = 70
+)
+
+// sshSignature is a signature blob of 'ssh-keygen -Y sign', see PROTOCOL.sshsig
+type sshSignature struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// sshSignedData is what is actually signed by ssh key, prefixed with magic
+type sshSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+// allowedSigner is a line of allowed signers file, see ALLOWED SIGNERS in ssh-keygen(1)
+type allowedSigner struct {
+	principals    string
+	key           ssh.PublicKey
+	certAuthority bool
+	namespaces    string
+	validAfter    time.Time
+	validBefore   time.Time
+}
+
+// SSHVerifier checks ssh signatures against keys from allowed signers file, as
+// configured by 'gpg.ssh.allowedSignersFile' in git
+type SSHVerifier struct {
+	signers []allowedSigner
+}
+
+// NewSSHVerifier reads allowed signers file
+func NewSSHVerifier(allowedSigners io.Reader) (*SSHVerifier, error) {
+	verifier := new(SSHVerifier)
+	scanner := bufio.NewScanner(allowedSigners)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		signer, err := parseAllowedSigner(line)
+		if err != nil {
+			return nil, err
+		}
+		verifier.signers = append(verifier.signers, *signer)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return verifier, nil
+}
+
+func parseAllowedSigner(line string) (*allowedSigner, error) {
+	var signer = new(allowedSigner)
+
+	// principals are the first field, maybe quoted
+	var rest string
+	if line[0] == '"' {
+		end := strings.IndexByte(line[1:], '"')
+		if end == -1 {
+			return nil, ErrInvalidAllowedSigners
+		}
+		signer.principals, rest = line[1:end+1], line[end+2:]
+	} else {
+		end := strings.IndexAny(line, " \t")
+		if end == -1 {
+			return nil, ErrInvalidAllowedSigners
+		}
+		signer.principals, rest = line[:end], line[end:]
+	}
+
+	key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(rest)))
+	if err != nil {
+		return nil, ErrInvalidAllowedSigners
+	}
+	signer.key = key
+
+	for _, option := range options {
+		name, value := option, ""
+		if eq := strings.IndexByte(option, '='); eq != -1 {
+			name, value = option[:eq], strings.Trim(option[eq+1:], `"`)
+		}
+
+		switch strings.ToLower(name) {
+		case "cert-authority":
+			signer.certAuthority = true
+		case "namespaces":
+			signer.namespaces = value
+		case "valid-after":
+			signer.validAfter, err = parseSignerTime(value)
+		case "valid-before":
+			signer.validBefore, err = parseSignerTime(value)
+		default:
+			return nil, ErrInvalidAllowedSigners
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return signer, nil
+}
+
+// parseSignerTime parses YYYYMMDD[HHMM[SS]][Z] timestamps of allowed signers file
+func parseSignerTime(value string) (time.Time, error) {
+	location := time.Local
+	if strings.HasSuffix(value, "Z") {
+		location = time.UTC
+		value = value[:len(value)-1]
+	}
+
+	for _, layout := range []string{"20060102", "200601021504", "20060102150405"} {
+		if len(value) != len(layout) {
+			continue
+		}
+		if date, err := time.ParseInLocation(layout, value, location); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, ErrInvalidAllowedSigners
+}
+
+// Verify checks ssh signature, made by 'ssh-keygen -Y sign -n git'
+func (verifier *SSHVerifier) Verify(payload, signature []byte) (*rawgit.SignatureInfo, error) {
+	blob, err := decodeSSHArmor(signature)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(blob, []byte(sshSigMagic)) {
+		return nil, ErrBadSignature
+	}
+
+	var sig sshSignature
+	err = ssh.Unmarshal(blob[len(sshSigMagic):], &sig)
+	if err != nil || sig.Version != sshSigVersion || sig.Namespace != sshNamespace {
+		return nil, ErrBadSignature
+	}
+
+	key, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+
+	var h hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, ErrBadSignature
+	}
+	h.Write(payload)
+
+	signed := ssh.Marshal(sshSignedData{
+		Namespace:     sig.Namespace,
+		Reserved:      sig.Reserved,
+		HashAlgorithm: sig.HashAlgorithm,
+		Hash:          h.Sum(nil),
+	})
+	signed = append([]byte(sshSigMagic), signed...)
+
+	var keySig ssh.Signature
+	err = ssh.Unmarshal(sig.Signature, &keySig)
+	if err != nil || keySig.Format == ssh.KeyAlgoRSA {
+		// sha1 rsa signatures are not allowed in sshsig
+		return nil, ErrBadSignature
+	}
+
+	if err := key.Verify(signed, &keySig); err != nil {
+		return nil, ErrBadSignature
+	}
+
+	return verifier.findSigner(key)
+}
+
+// findSigner looks up key or its certificate authority in allowed signers
+func (verifier *SSHVerifier) findSigner(key ssh.PublicKey) (*rawgit.SignatureInfo, error) {
+	now := time.Now()
+	cert, isCert := key.(*ssh.Certificate)
+	for _, signer := range verifier.signers {
+		if signer.namespaces != "" && !matchPatternList(signer.namespaces, sshNamespace) {
+			continue
+		}
+		if !signer.validAfter.IsZero() && now.Before(signer.validAfter) {
+			continue
+		}
+		if !signer.validBefore.IsZero() && !now.Before(signer.validBefore) {
+			continue
+		}
+
+		if !isCert {
+			if !signer.certAuthority && bytes.Equal(signer.key.Marshal(), key.Marshal()) {
+				return &rawgit.SignatureInfo{
+					Fingerprint: ssh.FingerprintSHA256(key),
+					Signer:      signer.principals,
+				}, nil
+			}
+			continue
+		}
+
+		if !signer.certAuthority || cert.CertType != ssh.UserCert ||
+			!bytes.Equal(signer.key.Marshal(), cert.SignatureKey.Marshal()) {
+			continue
+		}
+
+		checker := ssh.CertChecker{}
+		for _, principal := range cert.ValidPrincipals {
+			if !matchPatternList(signer.principals, principal) {
+				continue
+			}
+			if checker.CheckCert(principal, cert) == nil {
+				return &rawgit.SignatureInfo{
+					Fingerprint: ssh.FingerprintSHA256(cert.Key),
+					Signer:      principal,
+				}, nil
+			}
+		}
+	}
+
+	return nil, ErrUnknownSigner
+}
+
+// SSHSigner signs objects with ssh key, as 'ssh-keygen -Y sign -n git' does.
+// Certificate signers, made by ssh.NewCertSigner, embed certificate in signature
+type SSHSigner struct {
+	signer ssh.Signer
+}
+
+func NewSSHSigner(signer ssh.Signer) *SSHSigner {
+	return &SSHSigner{signer: signer}
+}
+
+func (signer *SSHSigner) Sign(payload []byte) ([]byte, error) {
+	digest := sha512.Sum512(payload)
+	signed := ssh.Marshal(sshSignedData{
+		Namespace:     sshNamespace,
+		HashAlgorithm: "sha512",
+		Hash:          digest[:],
+	})
+	signed = append([]byte(sshSigMagic), signed...)
+
+	key := signer.signer.PublicKey()
+	keyType := key.Type()
+	if cert, ok := key.(*ssh.Certificate); ok {
+		keyType = cert.Key.Type()
+	}
+
+	var keySig *ssh.Signature
+	var err error
+	if algSigner, ok := signer.signer.(ssh.AlgorithmSigner); ok && keyType == ssh.KeyAlgoRSA {
+		// sha1 rsa signatures are not allowed in sshsig
+		keySig, err = algSigner.SignWithAlgorithm(rand.Reader, signed, ssh.KeyAlgoRSASHA512)
+	} else {
+		keySig, err = signer.signer.Sign(rand.Reader, signed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := ssh.Marshal(sshSignature{
+		Version:       sshSigVersion,
+		PublicKey:     key.Marshal(),
+		Namespace:     sshNamespace,
+		HashAlgorithm: "sha512",
+		Signature:     ssh.Marshal(keySig),
+	})
+	blob = append([]byte(sshSigMagic), blob...)
+
+	return encodeSSHArmor(blob), nil
+}
+
+func encodeSSHArmor(blob []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(sshArmorStart + "\n")
+
+	text := base64.StdEncoding.EncodeToString(blob)
+	for len(text) > sshArmorWidth {
+		buf.WriteString(text[:sshArmorWidth] + "\n")
+		text = text[sshArmorWidth:]
+	}
+	buf.WriteString(text + "\n")
+
+	buf.WriteString(sshArmorEnd + "\n")
+	return buf.Bytes()
+}
+
+func decodeSSHArmor(signature []byte) ([]byte, error) {
+	text := strings.TrimSpace(string(signature))
+	if !strings.HasPrefix(text, sshArmorStart) {
+		return nil, ErrUnsupportedSignature
+	}
+	if !strings.HasSuffix(text, sshArmorEnd) {
+		return nil, ErrBadSignature
+	}
+
+	text = text[len(sshArmorStart) : len(text)-len(sshArmorEnd)]
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+	return blob, nil
+}
+
+// matchPatternList matches value against comma separated list of ssh patterns, any
+// of which may be negated with '!'
+func matchPatternList(list, value string) bool {
+	matched := false
+	for _, pattern := range strings.Split(list, ",") {
+		negated := strings.HasPrefix(pattern, "!")
+		if negated {
+			pattern = pattern[1:]
+		}
+
+		if matchPattern(pattern, value) {
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchPattern matches value against ssh pattern with '*' and '?' wildcards
+func matchPattern(pattern, value string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for idx := 0; idx <= len(value); idx++ {
+				if matchPattern(pattern[1:], value[idx:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if value == "" {
+				return false
+			}
+		default:
+			if value == "" || value[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, value = pattern[1:], value[1:]
+	}
+	return value == ""
+}
@@ -0,0 +1,412 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mechmind/git-go/rawgit"
+	"golang.org/x/crypto/ssh"
+)
+
+// fixtures are made by 'ssh-keygen -Y sign -n git' of testPayload
+const (
+	testPayload = `tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904
+author A U Thor <author@example.com> 1500000000 +0000
+committer A U Thor <author@example.com> 1500000000 +0000
+
+signed commit
+`
+
+	testSSHKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIFmL3bqj8id6oDzOjTiZfsQh9WjW9OUOIGv4QVa5w1sr signer"
+	testSSHCA  = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIE7UNqKhnPy56Btw54vf/dxi6zF0aAFwCbXsYAQjHAne ca"
+	testSSHRSA = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQDN+4bw/p+TMAZ9R4JyKT7+fMQo8zN75o3LHEKbhgJTGrxR7NHxrMg/7V93MFgUpJmgzImNIkGzhuwAjjDPvwuqZAxTGfNUBkzi4mq5GBmAfk2fPn82uyinzpE5uQZJzuRGPSajcgmlUyBipAITunjSqcYAd+xnhHHeuzc2/+vkFhfnmMdgZTyBpZyYEi9BOnwG8ZEemnPl+AnQLOWEh0RoOIKJKosl+aY6Oc6pQYTJGxnu5QxK/ObVu8qmJqJS7n6gpO8s8/BOhsRKDL/tNw7xYXvvACXfuZkODFEntvIJ1WK95Ol0OD+tjucQHrTohXvtW3SEA74GXn17jQYaXO4B rsa"
+	testSSHSig = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgWYvduqPyJ3qgPM6NOJl+xCH1aN
+b05Q4ga/hBVrnDWysAAAADZ2l0AAAAAAAAAAZzaGE1MTIAAABTAAAAC3NzaC1lZDI1NTE5
+AAAAQD1ay0sIRvLmiTElaqFhwqvluwNPKfUtPnCxtEjjxdnmF01uFbmUAtkf3IXmSYBtYC
+38Ktaq7lAaEo9/VJM8oQA=
+-----END SSH SIGNATURE-----
+`
+	// made with -n file
+	testSSHFile = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAADMAAAALc3NoLWVkMjU1MTkAAAAgWYvduqPyJ3qgPM6NOJl+xCH1aN
+b05Q4ga/hBVrnDWysAAAAEZmlsZQAAAAAAAAAGc2hhNTEyAAAAUwAAAAtzc2gtZWQyNTUx
+OQAAAECbrTyglRBU+zlVO+CkujkbNwYMxpBZ5xoD+8wHtH7vzEh89e8hahOSHOQUjfImRc
+//RIrKiOb+3nk6ZmQF7MkJ
+-----END SSH SIGNATURE-----
+`
+	testSSHRSASig = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAARcAAAAHc3NoLXJzYQAAAAMBAAEAAAEBAM37hvD+n5MwBn1HgnIpPv
+58xCjzM3vmjcscQpuGAlMavFHs0fGsyD/tX3cwWBSkmaDMiY0iQbOG7ACOMM+/C6pkDFMZ
+81QGTOLiarkYGYB+TZ8+fza7KKfOkTm5BknO5EY9JqNyCaVTIGKkAhO6eNKpxgB37GeEcd
+67Nzb/6+QWF+eYx2BlPIGlnJgSL0E6fAbxkR6ac+X4CdAs5YSHRGg4gokqiyX5pjo5zqlB
+hMkbGe7lDEr85tW7yqYmolLufqCk7yzz8E6GxEoMv+03DvFhe+8AJd+5mQ4MUSe28gnVYr
+3k6XQ4P62O5xAetOiFe+1bdIQDvgZefXuNBhpc7gEAAAADZ2l0AAAAAAAAAAZzaGE1MTIA
+AAEUAAAADHJzYS1zaGEyLTUxMgAAAQB12k/Q0K/7chaekCa7NY8r1KmD80D5eXmHll69TK
+BzYujBPRHBFtutIMImLp9HtTUd/8D/mv52ReztJRZS9nM4nsqCENcP9/FB/+pEmBDQKaGS
+0p4ErYOfG6sTv0Gzlr1kJYt3OAP40rZpndYZsAQPgGqiYXA3PCS9gNi8K6dytbX8ad9BBM
++vd/JP6E564KVhyo7fbZHYFs7HEstTgMVJBWaDIeg3C5Zp9ArUN8WgS1KGpnEtG1/mw0xl
+TM+NXdE+zZpDMA48DNx6yZjyp8n7EKsyNkU1wT2CL0FDc6ICDsW02VljSn75cU9PbYVChM
+wXPBxrgQoa4d7PQN0KkGg/
+-----END SSH SIGNATURE-----
+`
+	// made by key with certificate for principals alice and bob, valid forever
+	testSSHCertSig = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAAcUAAAAgc3NoLWVkMjU1MTktY2VydC12MDFAb3BlbnNzaC5jb20AAA
+AgbVlTdg6CZkWBtTc7xQQUuB2oXHxEmcMBNfA1QCL33KgAAAAgTtDuXDLGTR35a/3PiMPj
+AgdR0IQPHiJPePbOwTaGRPAAAAAAAAAAAAAAAAEAAAAJY2VydGlmaWVkAAAAEAAAAAVhbG
+ljZQAAAANib2IAAAAAAAAAAP//////////AAAAAAAAAIIAAAAVcGVybWl0LVgxMS1mb3J3
+YXJkaW5nAAAAAAAAABdwZXJtaXQtYWdlbnQtZm9yd2FyZGluZwAAAAAAAAAWcGVybWl0LX
+BvcnQtZm9yd2FyZGluZwAAAAAAAAAKcGVybWl0LXB0eQAAAAAAAAAOcGVybWl0LXVzZXIt
+cmMAAAAAAAAAAAAAADMAAAALc3NoLWVkMjU1MTkAAAAgTtQ2oqGc/LnoG3Dni9/93GLrMX
+RoAXAJtexgBCMcCd4AAABTAAAAC3NzaC1lZDI1NTE5AAAAQPsQu10AnxuRi0C+Q6jEn4MY
+LN24Fnvn2R5J1ZjklSZlT8jiZCCcx8L0C1Q9RvBqY+Oo09Z6k5rlzPlELxodBQoAAAADZ2
+l0AAAAAAAAAAZzaGE1MTIAAABTAAAAC3NzaC1lZDI1NTE5AAAAQJPVI6vL8V2eoAxEyLaE
+Grfl+nSgPLCP5uV4QM/kBf/y5WUWR/BpCleuHcHoxvPrLQnsBy/k4rYak08CbZ/nxQ8=
+-----END SSH SIGNATURE-----
+`
+	// certificate for alice was valid in 2020-01-01..2020-01-02
+	testSSHExpiredCertSig = `-----BEGIN SSH SIGNATURE-----
+U1NIU0lHAAAAAQAAAdUAAAAgc3NoLWVkMjU1MTktY2VydC12MDFAb3BlbnNzaC5jb20AAA
+Ag/RqlWwwYankiw+FX4/ApoB+jb4xhA9ouAdz6927OACQAAAAgTtDuXDLGTR35a/3PiMPj
+AgdR0IQPHiJPePbOwTaGRPAAAAAAAAAAAAAAAAEAAAAHZXhwaXJlZAAAAAkAAAAFYWxpY2
+UAAAAAXgvhAAAAAABeDTKAAAAAAAAAAJsAAAARbm8tdG91Y2gtcmVxdWlyZWQAAAAAAAAA
+FXBlcm1pdC1YMTEtZm9yd2FyZGluZwAAAAAAAAAXcGVybWl0LWFnZW50LWZvcndhcmRpbm
+cAAAAAAAAAFnBlcm1pdC1wb3J0LWZvcndhcmRpbmcAAAAAAAAACnBlcm1pdC1wdHkAAAAA
+AAAADnBlcm1pdC11c2VyLXJjAAAAAAAAAAAAAAAzAAAAC3NzaC1lZDI1NTE5AAAAIE7UNq
+KhnPy56Btw54vf/dxi6zF0aAFwCbXsYAQjHAneAAAAUwAAAAtzc2gtZWQyNTUxOQAAAEBH
+MPSvMYlx4CgB7tPPYPgjfXylKb6siZkRVkizOUONqYhbfotcjYPZzNYDCufrWMuim9s7Hy
+amxnfAhEya2bUFAAAAA2dpdAAAAAAAAAAGc2hhNTEyAAAAUwAAAAtzc2gtZWQyNTUxOQAA
+AECT1SOry/FdnqAMRMi2hBq35fp0oDywj+bleEDP5AX/8uVlFkfwaQpXrh3B6Mbz6y0J7A
+cv5OK2GpNPAm2f58UP
+-----END SSH SIGNATURE-----
+`
+)
+
+const (
+	testSSHKeyFingerprint  = "SHA256:8TepoR6fGG2AvMsubBIAGDEKOXnuK4U/KBKcWGzL9AU"
+	testSSHCertFingerprint = "SHA256:W8V9cv+kTGXWVkpOBFfJiGNIEM15fNIUQOB60BNbpnM"
+	testSSHRSAFingerprint  = "SHA256:4Vr/V1o8JUrew2PqaAredgjlO4llwHZ2TvBeNl+Z55g"
+)
+
+func TestParseAllowedSigner(t *testing.T) {
+	utc := func(year int, month time.Month, day, hour, min, sec int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+	}
+
+	tests := []struct {
+		line string
+		want allowedSigner
+		err  error
+	}{
+		{
+			line: "user@example.com " + testSSHKey,
+			want: allowedSigner{principals: "user@example.com"},
+		},
+		{
+			line: "a@example.com,*@example.org\t" + testSSHKey,
+			want: allowedSigner{principals: "a@example.com,*@example.org"},
+		},
+		{
+			line: `"a b@example.com" ` + testSSHKey,
+			want: allowedSigner{principals: "a b@example.com"},
+		},
+		{
+			line: `*@example.com cert-authority,namespaces="git,file" ` + testSSHKey,
+			want: allowedSigner{principals: "*@example.com", certAuthority: true, namespaces: "git,file"},
+		},
+		{
+			line: `user valid-after="20200102Z",valid-before="202101021530Z" ` + testSSHKey,
+			want: allowedSigner{principals: "user", validAfter: utc(2020, 1, 2, 0, 0, 0), validBefore: utc(2021, 1, 2, 15, 30, 0)},
+		},
+		{
+			line: `user VALID-BEFORE=20210102153045Z ` + testSSHKey,
+			want: allowedSigner{principals: "user", validBefore: utc(2021, 1, 2, 15, 30, 45)},
+		},
+		{line: "user", err: ErrInvalidAllowedSigners},
+		{line: `"user ` + testSSHKey, err: ErrInvalidAllowedSigners},
+		{line: "user ssh-ed25519 AAAAbroken", err: ErrInvalidAllowedSigners},
+		{line: "user no-touch-required " + testSSHKey, err: ErrInvalidAllowedSigners},
+		{line: `user valid-after="2020" ` + testSSHKey, err: ErrInvalidAllowedSigners},
+		{line: `user valid-before="20201340" ` + testSSHKey, err: ErrInvalidAllowedSigners},
+	}
+
+	for _, test := range tests {
+		signer, err := parseAllowedSigner(test.line)
+		if err != test.err {
+			t.Errorf("%q: got error %v, want %v", test.line, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if string(signer.key.Marshal()) != string(mustParseKey(t, testSSHKey).Marshal()) {
+			t.Errorf("%q: got key %s", test.line, ssh.FingerprintSHA256(signer.key))
+		}
+		signer.key = nil
+		if !reflect.DeepEqual(*signer, test.want) {
+			t.Errorf("%q: got %+v, want %+v", test.line, *signer, test.want)
+		}
+	}
+}
+
+func TestNewSSHVerifier(t *testing.T) {
+	verifier, err := NewSSHVerifier(strings.NewReader("# comment\n\n  a " + testSSHKey + "\nb " + testSSHCA + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifier.signers) != 2 || verifier.signers[0].principals != "a" || verifier.signers[1].principals != "b" {
+		t.Errorf("got signers %+v", verifier.signers)
+	}
+
+	_, err = NewSSHVerifier(strings.NewReader("a " + testSSHKey + "\nbroken\n"))
+	if err != ErrInvalidAllowedSigners {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestSSHVerifier(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedSigners string
+		payload        string
+		signature      string
+		want           *rawgit.SignatureInfo
+		err            error
+	}{
+		{
+			name:           "key",
+			allowedSigners: "other " + testSSHRSA + "\nuser@example.com " + testSSHKey,
+			signature:      testSSHSig,
+			want:           &rawgit.SignatureInfo{Fingerprint: testSSHKeyFingerprint, Signer: "user@example.com"},
+		},
+		{
+			name:           "rsa key",
+			allowedSigners: "user@example.com " + testSSHRSA,
+			signature:      testSSHRSASig,
+			want:           &rawgit.SignatureInfo{Fingerprint: testSSHRSAFingerprint, Signer: "user@example.com"},
+		},
+		{
+			name:           "changed payload",
+			allowedSigners: "user@example.com " + testSSHKey,
+			payload:        testPayload + "changed\n",
+			signature:      testSSHSig,
+			err:            ErrBadSignature,
+		},
+		{
+			name:           "other namespace",
+			allowedSigners: "user@example.com " + testSSHKey,
+			signature:      testSSHFile,
+			err:            ErrBadSignature,
+		},
+		{
+			name:           "broken armor",
+			allowedSigners: "user@example.com " + testSSHKey,
+			signature:      strings.TrimSuffix(testSSHSig, "-----END SSH SIGNATURE-----\n"),
+			err:            ErrBadSignature,
+		},
+		{
+			name:           "other format",
+			allowedSigners: "user@example.com " + testSSHKey,
+			signature:      "-----BEGIN PGP SIGNATURE-----\n",
+			err:            ErrUnsupportedSignature,
+		},
+		{
+			name:           "unknown key",
+			allowedSigners: "user@example.com " + testSSHRSA,
+			signature:      testSSHSig,
+			err:            ErrUnknownSigner,
+		},
+		{
+			name:           "key is not certificate authority",
+			allowedSigners: "user@example.com cert-authority " + testSSHKey,
+			signature:      testSSHSig,
+			err:            ErrUnknownSigner,
+		},
+		{
+			name:           "allowed namespaces",
+			allowedSigners: `user@example.com namespaces="file,g*" ` + testSSHKey,
+			signature:      testSSHSig,
+			want:           &rawgit.SignatureInfo{Fingerprint: testSSHKeyFingerprint, Signer: "user@example.com"},
+		},
+		{
+			name:           "other namespaces",
+			allowedSigners: `user@example.com namespaces="file,*,!git" ` + testSSHKey,
+			signature:      testSSHSig,
+			err:            ErrUnknownSigner,
+		},
+		{
+			name:           "valid",
+			allowedSigners: `user@example.com valid-after="20200101",valid-before="29991231" ` + testSSHKey,
+			signature:      testSSHSig,
+			want:           &rawgit.SignatureInfo{Fingerprint: testSSHKeyFingerprint, Signer: "user@example.com"},
+		},
+		{
+			name:           "not valid yet",
+			allowedSigners: `user@example.com valid-after="29990101Z" ` + testSSHKey,
+			signature:      testSSHSig,
+			err:            ErrUnknownSigner,
+		},
+		{
+			name:           "not valid anymore",
+			allowedSigners: `user@example.com valid-before="20200101Z" ` + testSSHKey,
+			signature:      testSSHSig,
+			err:            ErrUnknownSigner,
+		},
+		{
+			name:           "certificate",
+			allowedSigners: "alice cert-authority " + testSSHCA,
+			signature:      testSSHCertSig,
+			want:           &rawgit.SignatureInfo{Fingerprint: testSSHCertFingerprint, Signer: "alice"},
+		},
+		{
+			name:           "certificate principal pattern",
+			allowedSigners: "carol,!alice,b* cert-authority " + testSSHCA,
+			signature:      testSSHCertSig,
+			want:           &rawgit.SignatureInfo{Fingerprint: testSSHCertFingerprint, Signer: "bob"},
+		},
+		{
+			name:           "certificate of other principal",
+			allowedSigners: "carol cert-authority " + testSSHCA,
+			signature:      testSSHCertSig,
+			err:            ErrUnknownSigner,
+		},
+		{
+			name:           "authority without cert-authority option",
+			allowedSigners: "alice " + testSSHCA,
+			signature:      testSSHCertSig,
+			err:            ErrUnknownSigner,
+		},
+		{
+			name:           "expired certificate",
+			allowedSigners: "alice cert-authority " + testSSHCA,
+			signature:      testSSHExpiredCertSig,
+			err:            ErrUnknownSigner,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifier, err := NewSSHVerifier(strings.NewReader(test.allowedSigners))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payload := test.payload
+			if payload == "" {
+				payload = testPayload
+			}
+			info, err := verifier.Verify([]byte(payload), []byte(test.signature))
+			if err != test.err {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if !reflect.DeepEqual(info, test.want) {
+				t.Errorf("got %+v, want %+v", info, test.want)
+			}
+		})
+	}
+}
+
+func TestSSHSigner(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySigner, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSigner, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             keySigner.PublicKey(),
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"alice"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, keySigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allowedSigners := "user@example.com " + string(ssh.MarshalAuthorizedKey(keySigner.PublicKey())) +
+		"alice cert-authority " + string(ssh.MarshalAuthorizedKey(caSigner.PublicKey()))
+	verifier, err := NewSSHVerifier(strings.NewReader(allowedSigners))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		signer ssh.Signer
+		want   string
+	}{
+		{keySigner, "user@example.com"},
+		{certSigner, "alice"},
+	} {
+		signature, err := NewSSHSigner(test.signer).Sign([]byte(testPayload))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := verifier.Verify([]byte(testPayload), signature)
+		if err != nil {
+			t.Fatalf("%s: %v", test.want, err)
+		}
+		if info.Signer != test.want || info.Fingerprint != ssh.FingerprintSHA256(keySigner.PublicKey()) {
+			t.Errorf("got %+v, want signer %s", info, test.want)
+		}
+
+		_, err = verifier.Verify([]byte(testPayload+"changed\n"), signature)
+		if err != ErrBadSignature {
+			t.Errorf("%s: changed payload verified with %v", test.want, err)
+		}
+	}
+}
+
+func TestMatchPatternList(t *testing.T) {
+	tests := []struct {
+		list, value string
+		want        bool
+	}{
+		{"git", "git", true},
+		{"git", "gitx", false},
+		{"file,git", "git", true},
+		{"g?t", "git", true},
+		{"g?t", "gt", false},
+		{"*", "", true},
+		{"*@example.com", "a@example.com", true},
+		{"*@example.com", "a@example.org", false},
+		{"*,!bob", "bob", false},
+		{"!bob,*", "alice", true},
+		{"!bob", "alice", false},
+	}
+
+	for _, test := range tests {
+		if got := matchPatternList(test.list, test.value); got != test.want {
+			t.Errorf("%q matches %q: %v", test.list, test.value, got)
+		}
+	}
+}
+
+func mustParseKey(t *testing.T, line string) ssh.PublicKey {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
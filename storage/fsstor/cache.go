@@ -0,0 +1,103 @@
+package fsstor
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// same as git's core.deltaBaseCacheLimit
+const DefaultDeltaBaseCacheSize = 96 << 20
+
+// deltaBaseCache keeps recently inflated delta bases, up to the limit of total
+// size. Least recently used bases are evicted first
+type deltaBaseCache struct {
+	lock  sync.Mutex
+	limit int64
+	size  int64
+	items map[deltaBaseKey]*list.Element
+	lru   *list.List
+}
+
+type deltaBaseKey struct {
+	pack   *Pack
+	offset int64
+}
+
+type deltaBaseEntry struct {
+	key   deltaBaseKey
+	otype rawgit.OType
+	data  []byte
+}
+
+func newDeltaBaseCache(limit int64) *deltaBaseCache {
+	return &deltaBaseCache{
+		limit: limit,
+		items: make(map[deltaBaseKey]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+func (c *deltaBaseCache) get(pack *Pack, offset int64) (rawgit.OType, []byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.items[deltaBaseKey{pack, offset}]
+	if !ok {
+		return rawgit.OTypeBad, nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	entry := elem.Value.(*deltaBaseEntry)
+	return entry.otype, entry.data, true
+}
+
+func (c *deltaBaseCache) add(pack *Pack, offset int64, otype rawgit.OType, data []byte) {
+	if int64(len(data)) > c.limit {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := deltaBaseKey{pack, offset}
+	if _, ok := c.items[key]; ok {
+		return
+	}
+
+	c.items[key] = c.lru.PushFront(&deltaBaseEntry{key, otype, data})
+	c.size += int64(len(data))
+
+	for c.size > c.limit {
+		c.remove(c.lru.Back())
+	}
+}
+
+// dropPack removes all bases of closed pack
+func (c *deltaBaseCache) dropPack(pack *Pack) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key, elem := range c.items {
+		if key.pack == pack {
+			c.remove(elem)
+		}
+	}
+}
+
+func (c *deltaBaseCache) setLimit(limit int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.limit = limit
+	for c.size > c.limit {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *deltaBaseCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*deltaBaseEntry)
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.data))
+}
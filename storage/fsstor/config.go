@@ -0,0 +1,22 @@
+package fsstor
+
+import (
+	"github.com/mechmind/git-go/rawgit"
+)
+
+const ConfigFile = "config"
+
+// ReadConfig reads repository config. Missing config is empty
+func (r *FSStorage) ReadConfig() (*rawgit.Config, error) {
+	if !r.fs.IsFileExist(ConfigFile) {
+		return &rawgit.Config{}, nil
+	}
+
+	file, err := r.fs.Open(ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return rawgit.ParseConfig(file)
+}
@@ -2,105 +2,101 @@ package fsstor
 
 import (
 	"bytes"
-	"io"
-	"io/ioutil"
 )
 
-// Read delta headers and return Reader of target object.
-// For now, just read apply delta immediately
-func newDeltaApplier(src, delta io.ReadCloser, size int64) (srcSize, objSize uint64, obj io.ReadCloser, err error) {
-	srcSizeInt, err := readVarInt(delta)
-	if err != nil {
-		return 0, 0, nil, err
-	}
+// deltaOp copies range of base or inserts range of delta itself
+type deltaOp struct {
+	offset, size int64
+	insert       bool
+}
 
-	objSizeInt, err := readVarInt(delta)
+// patchDelta applies whole delta to the base and returns resulting object.
+// Deltas come from packs, which may be received from anyone, so all opcodes are
+// checked before result is allocated
+func patchDelta(base, delta []byte) ([]byte, error) {
+	reader := bytes.NewReader(delta)
+	srcSize, err := readVarInt(reader)
 	if err != nil {
-		return 0, 0, nil, err
+		return nil, err
 	}
-
-	srcBuf, err := ioutil.ReadAll(src)
-	if err != nil {
-		return 0, 0, nil, err
+	if srcSize != int64(len(base)) {
+		return nil, ErrInvalidDeltaBaseSize
 	}
 
-	deltaBuf, err := ioutil.ReadAll(delta)
+	objSize, err := readVarInt(reader)
 	if err != nil {
-		return 0, 0, nil, err
+		return nil, err
 	}
 
-	objBuf := make([]byte, objSizeInt)
-	err = applyDeltaBuf(srcBuf, deltaBuf, objBuf)
-	if err != nil {
-		return 0, 0, nil, err
+	start := len(delta) - reader.Len()
+	var total int64
+	for pos := start; pos < len(delta); {
+		var op deltaOp
+		op, pos, err = readDeltaOp(delta, pos)
+		if err != nil {
+			return nil, err
+		}
+		if !op.insert && op.offset+op.size > int64(len(base)) {
+			return nil, ErrInvalidDelta
+		}
+
+		total += op.size
+		if total > objSize {
+			return nil, ErrInvalidDelta
+		}
+	}
+	if total != objSize {
+		return nil, ErrInvalidDelta
 	}
 
-	return uint64(srcSizeInt), uint64(objSizeInt),
-		ioutil.NopCloser(bytes.NewBuffer(objBuf)), nil
+	obj := make([]byte, 0, objSize)
+	for pos := start; pos < len(delta); {
+		var op deltaOp
+		op, pos, _ = readDeltaOp(delta, pos)
+		if op.insert {
+			obj = append(obj, delta[op.offset:op.offset+op.size]...)
+		} else {
+			obj = append(obj, base[op.offset:op.offset+op.size]...)
+		}
+	}
+	return obj, nil
 }
 
-func applyDeltaBuf(src, delta, obj []byte) error {
-	var pos int
-	var c byte
-
-	for pos < len(delta) {
-		// read delta opcode
-		c = delta[pos]
-		pos++
-		if c&0x80 > 0 {
-			// this is copy opcode
-			// read offset
-			var offset, size uint32
-			if c&0x01 > 0 {
-				offset = uint32(delta[pos])
-				pos++
-			}
-
-			if c&0x02 > 0 {
-				offset += uint32(delta[pos]) << 8
-				pos++
-			}
-
-			if c&0x04 > 0 {
-				offset += uint32(delta[pos]) << 16
-				pos++
-			}
-
-			if c&0x08 > 0 {
-				offset += uint32(delta[pos]) << 24
-				pos++
-			}
-
-			// read size
-			if c&0x10 > 0 {
-				size = uint32(delta[pos])
-				pos++
-			}
-
-			if c&0x20 > 0 {
-				size += uint32(delta[pos]) << 8
-				pos++
-			}
-
-			if c&0x40 > 0 {
-				size += uint32(delta[pos]) << 16
-				pos++
-			}
+// readDeltaOp reads opcode at pos and returns it with position of the next
+// opcode. Inserted range is checked to be inside of delta
+func readDeltaOp(delta []byte, pos int) (deltaOp, int, error) {
+	c := delta[pos]
+	pos++
+	if c&0x80 == 0 {
+		if c == 0 {
+			return deltaOp{}, 0, ErrInvalidDeltaOpcode
+		}
+		if pos+int(c) > len(delta) {
+			return deltaOp{}, 0, ErrInvalidDelta
+		}
+		return deltaOp{offset: int64(pos), size: int64(c), insert: true}, pos + int(c), nil
+	}
 
-			if size == 0 {
-				size = 0x10000
-			}
+	// copy opcode: bits 0-3 mark present bytes of offset, bits 4-6 of size
+	var offset, size uint32
+	for bit := uint(0); bit < 7; bit++ {
+		if c&(1<<bit) == 0 {
+			continue
+		}
+		if pos >= len(delta) {
+			return deltaOp{}, 0, ErrInvalidDelta
+		}
 
-			n := copy(obj, src[int(offset):int(offset+size)])
-			obj = obj[n:]
-		} else if c > 0 {
-			n := copy(obj, delta[pos:pos+int(c)])
-			obj = obj[n:]
-			pos += int(c)
+		if bit < 4 {
+			offset |= uint32(delta[pos]) << (8 * bit)
 		} else {
-			return ErrInvalidDeltaOpcode
+			size |= uint32(delta[pos]) << (8 * (bit - 4))
 		}
+		pos++
 	}
 
-	return nil
+	if size == 0 {
+		size = 0x10000
+	}
+	return deltaOp{offset: int64(offset), size: int64(size)}, pos, nil
 }
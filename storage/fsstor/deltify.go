@@ -0,0 +1,150 @@
+package fsstor
+
+import (
+	"encoding/binary"
+)
+
+const (
+	deltaBlockSize     = 16
+	deltaMaxBucketLen  = 64
+	deltaMaxInsertSize = 0x7f
+	deltaMaxCopySize   = 0x10000
+)
+
+// deltaIndex holds positions of fixed-size blocks of the delta source
+type deltaIndex struct {
+	src    []byte
+	blocks map[uint64][]int
+}
+
+func newDeltaIndex(src []byte) *deltaIndex {
+	idx := &deltaIndex{src, make(map[uint64][]int, len(src)/deltaBlockSize+1)}
+	for pos := 0; pos+deltaBlockSize <= len(src); pos += deltaBlockSize {
+		h := blockHash(src[pos:])
+		bucket := idx.blocks[h]
+		if len(bucket) >= deltaMaxBucketLen {
+			// overpopulated bucket (i.e. long runs of same data), keep only recent positions
+			bucket = bucket[1:]
+		}
+		idx.blocks[h] = append(bucket, pos)
+	}
+	return idx
+}
+
+func blockHash(buf []byte) uint64 {
+	a := binary.LittleEndian.Uint64(buf)
+	b := binary.LittleEndian.Uint64(buf[8:])
+	return a*0x9e3779b97f4a7c15 ^ b*0xc2b2ae3d27d4eb4f
+}
+
+// findMatch returns the longest match of trg prefix in source
+func (idx *deltaIndex) findMatch(trg []byte) (offset, size int) {
+	if len(trg) < deltaBlockSize {
+		return 0, 0
+	}
+
+	for _, pos := range idx.blocks[blockHash(trg)] {
+		n := 0
+		src := idx.src[pos:]
+		for n < len(src) && n < len(trg) && src[n] == trg[n] {
+			n++
+		}
+		if n > size {
+			offset, size = pos, n
+		}
+	}
+	return offset, size
+}
+
+// createDelta makes delta that converts src into trg. If delta would be larger
+// than maxSize, nil is returned
+func (idx *deltaIndex) createDelta(trg []byte, maxSize int) []byte {
+	delta := make([]byte, 0, len(trg)/4+32)
+	delta = appendVarInt(delta, uint64(len(idx.src)))
+	delta = appendVarInt(delta, uint64(len(trg)))
+
+	var insertFrom int
+	var pos int
+	for pos < len(trg) {
+		offset, size := idx.findMatch(trg[pos:])
+		if size < deltaBlockSize {
+			pos++
+			continue
+		}
+
+		// extend match backwards, into pending insert
+		for offset > 0 && pos > insertFrom && idx.src[offset-1] == trg[pos-1] {
+			offset--
+			pos--
+			size++
+		}
+
+		delta = appendInsertOps(delta, trg[insertFrom:pos])
+		delta = appendCopyOps(delta, offset, size)
+		pos += size
+		insertFrom = pos
+
+		if maxSize > 0 && len(delta) > maxSize {
+			return nil
+		}
+	}
+
+	delta = appendInsertOps(delta, trg[insertFrom:])
+	if maxSize > 0 && len(delta) > maxSize {
+		return nil
+	}
+
+	return delta
+}
+
+func appendInsertOps(delta, data []byte) []byte {
+	for len(data) > 0 {
+		n := len(data)
+		if n > deltaMaxInsertSize {
+			n = deltaMaxInsertSize
+		}
+		delta = append(delta, byte(n))
+		delta = append(delta, data[:n]...)
+		data = data[n:]
+	}
+	return delta
+}
+
+func appendCopyOps(delta []byte, offset, size int) []byte {
+	for size > 0 {
+		n := size
+		if n > deltaMaxCopySize {
+			n = deltaMaxCopySize
+		}
+
+		opPos := len(delta)
+		var op byte = 0x80
+		delta = append(delta, 0)
+		for i := uint(0); i < 4; i++ {
+			if b := byte(offset >> (i * 8)); b != 0 {
+				op |= 1 << i
+				delta = append(delta, b)
+			}
+		}
+		// 0x10000 could be encoded as zero size, but git writes its third byte
+		for i := uint(0); i < 3; i++ {
+			if b := byte(n >> (i * 8)); b != 0 {
+				op |= 0x10 << i
+				delta = append(delta, b)
+			}
+		}
+		delta[opPos] = op
+
+		offset += n
+		size -= n
+	}
+	return delta
+}
+
+func appendVarInt(buf []byte, num uint64) []byte {
+	for num >= 0x80 {
+		buf = append(buf, byte(num)|0x80)
+		num >>= 7
+	}
+	return append(buf, byte(num))
+}
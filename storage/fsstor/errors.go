@@ -2,12 +2,14 @@ package fsstor
 
 import (
 	"errors"
+
+	"github.com/mechmind/git-go/rawgit"
 )
 
 var (
 	ErrInvalidDeltaOpcode = errors.New("invalid delta opcode")
-	ErrNotFound           = errors.New("not found")
-	ErrNotASymbolicRef    = errors.New("not a symbolic reference")
+	ErrNotFound           = rawgit.ErrNotFound
+	ErrNotASymbolicRef    = rawgit.ErrNotASymbolicRef
 	ErrObjectOverflow     = errors.New("object size overflow")
 	ErrIncompletedObject  = errors.New("object was not fully written")
 )
@@ -18,5 +20,13 @@ var ErrInvalidPackLength = errors.New("invalid pack length")
 var ErrInvalidPackFileHeader = errors.New("invalid pack file header")
 var ErrOffsetIdOutOfRange = errors.New("extended offset id is out of range")
 var ErrInvalidDeltaBaseSize = errors.New("invalid base object size in delta")
-var ErrObjectNotFound = errors.New("object not found")
+var ErrObjectNotFound = rawgit.ErrObjectNotFound
 var ErrInvalidObjectType = errors.New("invalid object type")
+var ErrPackChecksumMismatch = errors.New("pack checksum mismatch")
+var ErrInvalidDeltaBase = errors.New("invalid delta base")
+var ErrUnresolvedDelta = errors.New("delta base not found")
+var ErrInvalidDelta = errors.New("delta does not match its base or result")
+var ErrInvalidObjectSize = errors.New("object size does not match header")
+var ErrInvalidFanout = errors.New("invalid fanout table in pack index")
+var ErrInvalidPackedRefs = errors.New("malformed packed-refs file")
+var ErrRepositoryExists = errors.New("repository already exists")
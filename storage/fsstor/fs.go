@@ -12,8 +12,17 @@ type File interface {
 type FS interface {
 	Open(path string) (File, error)
 	Create(path string) (File, error)
+	// CreateExclusive creates new file, failing if it already exists
+	CreateExclusive(path string) (File, error)
+	// Append opens file for appending, creating it if needed
+	Append(path string) (File, error)
+	// TempFile creates temporary file, which is removed on close, unless it
+	// was moved before
 	TempFile() (File, error)
 	Move(from string, to string) error
+	Remove(path string) error
+	// MkdirAll creates directory with all missing parents
+	MkdirAll(path string) error
 	ListDir(path string) ([]string, error)
 	IsFileExist(path string) bool
 	IsDir(path string) bool
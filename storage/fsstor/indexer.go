@@ -0,0 +1,550 @@
+package fsstor
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// packIndexer reads pack stream sequentially, resolves all deltas and computes
+// object ids, so index can be built for the pack
+type packIndexer struct {
+	// storage for resolving bases of thin packs. If nil, thin packs are rejected
+	base rawgit.Storage
+
+	data     io.ReaderAt
+	size     int64
+	checksum rawgit.OID
+
+	objects     []*indexerObject
+	ofsChildren map[int64][]*indexerObject
+	refChildren map[rawgit.OID][]*indexerObject
+
+	// external bases, that must be appended to the thin pack
+	missing []*indexerObject
+}
+
+type indexerObject struct {
+	offset     int64
+	dataOffset int64
+	packType   rawgit.OType
+	size       uint64
+	crc        uint32
+
+	baseOffset int64
+	baseOID    rawgit.OID
+
+	resolved bool
+	otype    rawgit.OType
+	oid      rawgit.OID
+}
+
+func newPackIndexer(base rawgit.Storage) *packIndexer {
+	return &packIndexer{
+		base:        base,
+		ofsChildren: make(map[int64][]*indexerObject),
+		refChildren: make(map[rawgit.OID][]*indexerObject),
+	}
+}
+
+// readPack scans pack stream, copying it to dst. Object ids are computed for all
+// non-delta objects
+func (ix *packIndexer) readPack(src io.Reader, dst io.Writer) error {
+	stream := newPackStreamReader(src, dst)
+
+	count, err := readPackFileHeader(stream)
+	if err != nil {
+		return err
+	}
+
+	ix.objects = make([]*indexerObject, 0, count)
+	var i int32
+	for i = 0; i < count; i++ {
+		obj, err := ix.readEntry(stream)
+		if err != nil {
+			return err
+		}
+		ix.objects = append(ix.objects, obj)
+	}
+
+	// checksum is not a part of checksummed data
+	checksum := stream.hash.Sum(nil)
+	stream.hash = nil
+	_, err = io.ReadFull(stream, ix.checksum[:])
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(checksum, ix.checksum[:]) {
+		return ErrPackChecksumMismatch
+	}
+
+	ix.size = stream.offset
+	return stream.flush()
+}
+
+func (ix *packIndexer) readEntry(stream *packStreamReader) (*indexerObject, error) {
+	obj := &indexerObject{offset: stream.offset}
+	stream.crc.Reset()
+
+	objType, objSize, err := readPackEntryHeader(stream)
+	if err != nil {
+		return nil, err
+	}
+	obj.packType = objType
+	obj.size = objSize
+
+	switch objType {
+	case rawgit.OTypeCommit, rawgit.OTypeTree, rawgit.OTypeBlob, rawgit.OTypeTag:
+		obj.dataOffset = stream.offset
+		hw := newObjectHasher(objType, objSize)
+		err = inflateTo(stream, hw, objSize)
+		if err != nil {
+			return nil, err
+		}
+		obj.otype = objType
+		copy(obj.oid[:], hw.Sum(nil))
+		obj.resolved = true
+
+	case rawgit.OTypeOffsetDelta:
+		relOffset, err := readOffset(stream)
+		if err != nil {
+			return nil, err
+		}
+		obj.baseOffset = obj.offset - relOffset
+		if obj.baseOffset < 0 || obj.baseOffset >= obj.offset {
+			return nil, ErrInvalidDeltaBase
+		}
+		obj.dataOffset = stream.offset
+		err = inflateTo(stream, ioutil.Discard, objSize)
+		if err != nil {
+			return nil, err
+		}
+		ix.ofsChildren[obj.baseOffset] = append(ix.ofsChildren[obj.baseOffset], obj)
+
+	case rawgit.OTypeRefDelta:
+		_, err = io.ReadFull(stream, obj.baseOID[:])
+		if err != nil {
+			return nil, err
+		}
+		obj.dataOffset = stream.offset
+		err = inflateTo(stream, ioutil.Discard, objSize)
+		if err != nil {
+			return nil, err
+		}
+		ix.refChildren[obj.baseOID] = append(ix.refChildren[obj.baseOID], obj)
+
+	default:
+		return nil, ErrInvalidObjectType
+	}
+
+	obj.crc = stream.crc.Sum32()
+	return obj, nil
+}
+
+// resolveDeltas computes ids of all deltified objects. Bases of thin pack are
+// looked up in base storage and remembered, so they can be appended to the pack
+func (ix *packIndexer) resolveDeltas() error {
+	for _, obj := range ix.objects {
+		if obj.packType == rawgit.OTypeOffsetDelta || obj.packType == rawgit.OTypeRefDelta {
+			continue
+		}
+		if !ix.hasChildren(obj) {
+			continue
+		}
+
+		data, err := ix.inflateAt(obj.dataOffset, obj.size)
+		if err != nil {
+			return err
+		}
+
+		err = ix.resolveChildren(obj, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	// whatever is left have bases outside of pack
+	for _, obj := range ix.objects {
+		if obj.resolved {
+			continue
+		}
+		if obj.packType != rawgit.OTypeRefDelta {
+			return ErrInvalidDeltaBase
+		}
+
+		if ix.base == nil {
+			return ErrUnresolvedDelta
+		}
+
+		info, body, err := ix.base.OpenObject(&obj.baseOID)
+		if err != nil {
+			if rawgit.IsNotExist(err) {
+				return ErrUnresolvedDelta
+			}
+			return err
+		}
+
+		data, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+
+		base := &indexerObject{
+			packType: info.OType,
+			size:     uint64(len(data)),
+			resolved: true,
+			otype:    info.OType,
+			oid:      obj.baseOID,
+		}
+		ix.missing = append(ix.missing, base)
+
+		err = ix.resolveChildren(base, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ix *packIndexer) hasChildren(obj *indexerObject) bool {
+	return len(ix.ofsChildren[obj.offset]) > 0 || len(ix.refChildren[obj.oid]) > 0
+}
+
+func (ix *packIndexer) resolveChildren(base *indexerObject, baseData []byte) error {
+	children := append(ix.refChildren[base.oid], ix.ofsChildren[base.offset]...)
+	delete(ix.refChildren, base.oid)
+	delete(ix.ofsChildren, base.offset)
+
+	for _, child := range children {
+		delta, err := ix.inflateAt(child.dataOffset, child.size)
+		if err != nil {
+			return err
+		}
+
+		data, err := patchDelta(baseData, delta)
+		if err != nil {
+			return err
+		}
+
+		child.otype = base.otype
+		child.oid = hashObject(child.otype, data)
+		child.resolved = true
+
+		if ix.hasChildren(child) {
+			err = ix.resolveChildren(child, data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (ix *packIndexer) inflateAt(offset int64, size uint64) ([]byte, error) {
+	section := io.NewSectionReader(ix.data, offset, ix.size-offset)
+	return inflate(bufio.NewReader(section), size)
+}
+
+// completePack writes pack with all missing bases appended. Source pack
+// must be thin
+func (ix *packIndexer) completePack(dst io.Writer) error {
+	out := newPackOutput(dst)
+	err := writePackFileHeader(out, uint32(len(ix.objects)+len(ix.missing)))
+	if err != nil {
+		return err
+	}
+
+	// copy all objects as is
+	_, err = io.Copy(out, io.NewSectionReader(ix.data, 12, ix.size-12-20))
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range ix.missing {
+		obj.offset = out.offset
+		out.crc.Reset()
+
+		err = writePackEntryHeader(out, obj.otype, obj.size)
+		if err != nil {
+			return err
+		}
+
+		_, body, err := ix.base.OpenObject(&obj.oid)
+		if err != nil {
+			return err
+		}
+		err = writeCompressed(out, body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+
+		obj.crc = out.crc.Sum32()
+		ix.objects = append(ix.objects, obj)
+	}
+	ix.missing = nil
+
+	checksum, err := out.finish()
+	if err != nil {
+		return err
+	}
+
+	ix.checksum = *checksum
+	return nil
+}
+
+func (ix *packIndexer) isThin() bool {
+	return len(ix.missing) > 0
+}
+
+func (ix *packIndexer) writeIndex(dst io.Writer) error {
+	entries := make([]packIndexEntry, len(ix.objects))
+	for idx, obj := range ix.objects {
+		entries[idx] = packIndexEntry{obj.oid, obj.offset, obj.crc}
+	}
+
+	return writeIDXFile(dst, entries, &ix.checksum)
+}
+
+// IndexPack verifies complete (not thin) pack and writes v2 index for it.
+// Returns pack checksum
+func IndexPack(pack io.ReaderAt, size int64, idx io.Writer) (*rawgit.OID, error) {
+	ix := newPackIndexer(nil)
+	ix.data = pack
+
+	err := ix.readPack(io.NewSectionReader(pack, 0, size), ioutil.Discard)
+	if err != nil {
+		return nil, err
+	}
+	if ix.size != size {
+		// garbage after pack trailer
+		return nil, ErrInvalidPackLength
+	}
+
+	err = ix.resolveDeltas()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ix.writeIndex(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ix.checksum, nil
+}
+
+// indexPackFile builds missing index for pack in objects/pack
+func (r *FSStorage) indexPackFile(id string) error {
+	basename := path.Join("objects/pack", "pack-"+id)
+
+	packFile, err := r.fs.Open(basename + ".pack")
+	if err != nil {
+		return err
+	}
+	defer packFile.Close()
+
+	var data io.ReaderAt
+	var size int64
+	if reader, ok := packFile.(io.ReaderAt); ok {
+		size, err = fileSize(packFile)
+		if err != nil {
+			return err
+		}
+		data = reader
+	} else {
+		content, err := ioutil.ReadAll(packFile)
+		if err != nil {
+			return err
+		}
+		data, size = bytes.NewReader(content), int64(len(content))
+	}
+
+	idxTmp, err := r.fs.TempFile()
+	if err != nil {
+		return err
+	}
+	defer idxTmp.Close()
+
+	checksum, err := IndexPack(data, size, idxTmp)
+	if err != nil {
+		return err
+	}
+
+	if checksum.String() != id {
+		return ErrPackChecksumMismatch
+	}
+
+	return r.fs.Move(idxTmp.Name(), basename+".idx")
+}
+
+func fileSize(file File) (int64, error) {
+	if seeker, ok := file.(io.Seeker); ok {
+		return seeker.Seek(0, io.SeekEnd)
+	}
+
+	return io.Copy(ioutil.Discard, file)
+}
+
+// ReceivePack reads pack stream (possibly thin), stores it in objects/pack
+// and indexes it. Bases, missing from thin pack, are taken from the storage
+// and appended to the pack. Returns pack name
+func (r *FSStorage) ReceivePack(src io.Reader) (string, error) {
+	ix := newPackIndexer(r)
+
+	packTmp, err := r.fs.TempFile()
+	if err != nil {
+		return "", err
+	}
+	defer packTmp.Close()
+
+	// resolving deltas requires random access to the pack
+	var memPack *bytes.Buffer
+	var dst io.Writer = packTmp
+	if reader, ok := packTmp.(io.ReaderAt); ok {
+		ix.data = reader
+	} else {
+		memPack = new(bytes.Buffer)
+		dst = io.MultiWriter(packTmp, memPack)
+	}
+
+	err = ix.readPack(src, dst)
+	if err != nil {
+		return "", err
+	}
+	if memPack != nil {
+		ix.data = bytes.NewReader(memPack.Bytes())
+	}
+
+	err = ix.resolveDeltas()
+	if err != nil {
+		return "", err
+	}
+
+	if ix.isThin() {
+		packTmp, err = r.fs.TempFile()
+		if err != nil {
+			return "", err
+		}
+		defer packTmp.Close()
+
+		err = ix.completePack(packTmp)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	idxTmp, err := r.fs.TempFile()
+	if err != nil {
+		return "", err
+	}
+	defer idxTmp.Close()
+
+	err = ix.writeIndex(idxTmp)
+	if err != nil {
+		return "", err
+	}
+
+	id := ix.checksum.String()
+	return id, r.insertPack(id, packTmp, idxTmp)
+}
+
+// packStreamReader tracks position, crc32 and checksum of consumed pack data
+// and copies it to the destination
+type packStreamReader struct {
+	src    *bufio.Reader
+	dst    *bufio.Writer
+	hash   hash.Hash
+	crc    hash.Hash32
+	offset int64
+	buf    [1]byte
+}
+
+func newPackStreamReader(src io.Reader, dst io.Writer) *packStreamReader {
+	return &packStreamReader{
+		src:  bufio.NewReader(src),
+		dst:  bufio.NewWriter(dst),
+		hash: sha1.New(),
+		crc:  crc32.NewIEEE(),
+	}
+}
+
+func (p *packStreamReader) consume(data []byte) error {
+	if p.hash != nil {
+		p.hash.Write(data)
+	}
+	p.crc.Write(data)
+	p.offset += int64(len(data))
+	_, err := p.dst.Write(data)
+	return err
+}
+
+func (p *packStreamReader) Read(buf []byte) (int, error) {
+	n, err := p.src.Read(buf)
+	if n > 0 {
+		if werr := p.consume(buf[:n]); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// ReadByte makes decompressor read exactly the compressed data
+func (p *packStreamReader) ReadByte() (byte, error) {
+	c, err := p.src.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	p.buf[0] = c
+	return c, p.consume(p.buf[:])
+}
+
+func (p *packStreamReader) flush() error {
+	return p.dst.Flush()
+}
+
+func inflateTo(src io.Reader, dst io.Writer, size uint64) error {
+	zr, err := zlib.NewReader(src)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(dst, zr)
+	if err != nil {
+		return err
+	}
+	if uint64(n) != size {
+		return ErrInvalidObjectSize
+	}
+
+	return zr.Close()
+}
+
+func newObjectHasher(objType rawgit.OType, size uint64) hash.Hash {
+	hw := sha1.New()
+	hw.Write([]byte(objType.String()))
+	hw.Write([]byte{' '})
+	hw.Write([]byte(strconv.FormatUint(size, 10)))
+	hw.Write([]byte{0})
+	return hw
+}
+
+func hashObject(objType rawgit.OType, data []byte) rawgit.OID {
+	var oid rawgit.OID
+	hw := newObjectHasher(objType, uint64(len(data)))
+	hw.Write(data)
+	copy(oid[:], hw.Sum(nil))
+	return oid
+}
@@ -0,0 +1,456 @@
+package fsstor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// testEntry is an object of test pack. Deltified entries are stored against
+// earlier entry of the pack (ofsBase) or against object with given contents
+// (refBase), which may be out of the pack
+type testEntry struct {
+	otype   rawgit.OType
+	data    string
+	ofsBase int
+	refBase string
+	isRef   bool
+	// delta is written as is instead of one made from data
+	delta []byte
+}
+
+func fullEntry(otype rawgit.OType, data string) testEntry {
+	return testEntry{otype: otype, data: data, ofsBase: -1}
+}
+
+func ofsEntry(base int, data string) testEntry {
+	return testEntry{data: data, ofsBase: base}
+}
+
+func refEntry(otype rawgit.OType, base, data string) testEntry {
+	return testEntry{otype: otype, data: data, ofsBase: -1, refBase: base, isRef: true}
+}
+
+func rawDeltaEntry(base int, delta []byte) testEntry {
+	return testEntry{ofsBase: base, delta: delta}
+}
+
+// testDelta makes delta header followed by opcodes
+func testDelta(srcSize, size uint64, ops ...byte) []byte {
+	delta := appendVarInt(nil, srcSize)
+	delta = appendVarInt(delta, size)
+	return append(delta, ops...)
+}
+
+// testPack is a pack, built from entries, with ids and offsets of its objects
+type testPack struct {
+	data    []byte
+	types   []rawgit.OType
+	oids    []rawgit.OID
+	offsets []int64
+}
+
+func buildTestPack(t *testing.T, entries ...testEntry) *testPack {
+	pack := &testPack{}
+	var buf bytes.Buffer
+	out := newPackOutput(&buf)
+	if err := writePackFileHeader(out, uint32(len(entries))); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		offset := out.offset
+		otype, payload := entry.otype, []byte(entry.data)
+		var err error
+		switch {
+		case entry.ofsBase >= 0:
+			otype = pack.types[entry.ofsBase]
+			payload = newDeltaIndex([]byte(entries[entry.ofsBase].data)).createDelta(payload, 0)
+			if entry.delta != nil {
+				payload = entry.delta
+			}
+			err = writePackEntryHeader(out, rawgit.OTypeOffsetDelta, uint64(len(payload)))
+			if err == nil {
+				_, err = out.Write(encodeOffset(offset - pack.offsets[entry.ofsBase]))
+			}
+		case entry.isRef:
+			base := hashObject(otype, []byte(entry.refBase))
+			payload = newDeltaIndex([]byte(entry.refBase)).createDelta(payload, 0)
+			err = writePackEntryHeader(out, rawgit.OTypeRefDelta, uint64(len(payload)))
+			if err == nil {
+				_, err = out.Write(base[:])
+			}
+		default:
+			err = writePackEntryHeader(out, otype, uint64(len(payload)))
+		}
+		if err == nil {
+			err = writeCompressed(out, bytes.NewReader(payload))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pack.types = append(pack.types, otype)
+		pack.oids = append(pack.oids, hashObject(otype, []byte(entry.data)))
+		pack.offsets = append(pack.offsets, offset)
+	}
+
+	if _, err := out.finish(); err != nil {
+		t.Fatal(err)
+	}
+	pack.data = buf.Bytes()
+	return pack
+}
+
+// testText makes text, which versions share most of their lines
+func testText(name string, version int) string {
+	var sb strings.Builder
+	for n := 0; n < 100; n++ {
+		if n%10 == version%10 {
+			fmt.Fprintf(&sb, "line %d of %s changed in version %d\n", n, name, version)
+		} else {
+			fmt.Fprintf(&sb, "line %d of %s\n", n, name)
+		}
+	}
+	return sb.String()
+}
+
+func newTestStorage(t *testing.T) *FSStorage {
+	stor, err := InitFSStorage(NewOSFS(t.TempDir()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stor
+}
+
+type objectOpener interface {
+	OpenObject(oid *rawgit.OID) (rawgit.ObjectInfo, io.ReadCloser, error)
+}
+
+func checkObject(t *testing.T, stor objectOpener, oid *rawgit.OID, otype rawgit.OType, data string) {
+	t.Helper()
+	info, body, err := stor.OpenObject(oid)
+	if err != nil {
+		t.Fatalf("object %s: %v", oid, err)
+	}
+	defer body.Close()
+
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatalf("object %s: %v", oid, err)
+	}
+	if info.GetOType() != otype || string(content) != data {
+		t.Errorf("object %s: got %s %q, want %s %q", oid, info.GetOType(), content, otype, data)
+	}
+}
+
+func TestReceivePack(t *testing.T) {
+	base := testText("base", 0)
+	tests := []struct {
+		name string
+		// objects, which storage has before receiving
+		stored  []string
+		entries []testEntry
+		// number of external bases appended to the pack
+		appended int
+		err      error
+	}{
+		{
+			name: "complete pack",
+			entries: []testEntry{
+				fullEntry(rawgit.OTypeBlob, base),
+				ofsEntry(0, testText("base", 1)),
+				refEntry(rawgit.OTypeBlob, base, testText("base", 2)),
+			},
+		},
+		{
+			name:   "thin pack",
+			stored: []string{base},
+			entries: []testEntry{
+				refEntry(rawgit.OTypeBlob, base, testText("base", 1)),
+				fullEntry(rawgit.OTypeCommit, "commit body\n"),
+			},
+			appended: 1,
+		},
+		{
+			name:   "delta chain on external base",
+			stored: []string{base, testText("other", 0)},
+			entries: []testEntry{
+				refEntry(rawgit.OTypeBlob, base, testText("base", 1)),
+				ofsEntry(0, testText("base", 2)),
+				ofsEntry(1, testText("base", 3)),
+				refEntry(rawgit.OTypeBlob, testText("other", 0), testText("other", 1)),
+			},
+			appended: 2,
+		},
+		{
+			name: "copy past end of base",
+			entries: []testEntry{
+				fullEntry(rawgit.OTypeBlob, base),
+				rawDeltaEntry(0, testDelta(uint64(len(base)), 16, appendCopyOps(nil, len(base)-8, 16)...)),
+			},
+			err: ErrInvalidDelta,
+		},
+		{
+			name: "insert past end of delta",
+			entries: []testEntry{
+				fullEntry(rawgit.OTypeBlob, base),
+				rawDeltaEntry(0, testDelta(uint64(len(base)), 16, 16, 'x')),
+			},
+			err: ErrInvalidDelta,
+		},
+		{
+			name: "truncated copy opcode",
+			entries: []testEntry{
+				fullEntry(rawgit.OTypeBlob, base),
+				rawDeltaEntry(0, testDelta(uint64(len(base)), 16, 0x91, 0)),
+			},
+			err: ErrInvalidDelta,
+		},
+		{
+			name: "result shorter than header",
+			entries: []testEntry{
+				fullEntry(rawgit.OTypeBlob, base),
+				rawDeltaEntry(0, testDelta(uint64(len(base)), 16, 0x90, 8)),
+			},
+			err: ErrInvalidDelta,
+		},
+		{
+			// nothing is allocated for the header size
+			name: "huge result size",
+			entries: []testEntry{
+				fullEntry(rawgit.OTypeBlob, base),
+				rawDeltaEntry(0, testDelta(uint64(len(base)), 1<<50, 0x90, 8)),
+			},
+			err: ErrInvalidDelta,
+		},
+		{
+			name: "wrong base size",
+			entries: []testEntry{
+				fullEntry(rawgit.OTypeBlob, base),
+				rawDeltaEntry(0, testDelta(uint64(len(base))+1, 8, 0x90, 8)),
+			},
+			err: ErrInvalidDeltaBaseSize,
+		},
+		{
+			name: "zero opcode",
+			entries: []testEntry{
+				fullEntry(rawgit.OTypeBlob, base),
+				rawDeltaEntry(0, testDelta(uint64(len(base)), 8, 0)),
+			},
+			err: ErrInvalidDeltaOpcode,
+		},
+		{
+			name: "missing base",
+			entries: []testEntry{
+				refEntry(rawgit.OTypeBlob, base, testText("base", 1)),
+			},
+			err: ErrUnresolvedDelta,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stor := newTestStorage(t)
+			for _, data := range test.stored {
+				if _, err := rawgit.WriteObject(stor, rawgit.OTypeBlob, []byte(data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			pack := buildTestPack(t, test.entries...)
+			id, err := stor.ReceivePack(bytes.NewReader(pack.data))
+			if err != test.err {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+
+			names, _ := stor.fs.ListDir("objects/pack")
+			if err != nil {
+				// nothing is left behind
+				if len(names) != 0 {
+					t.Errorf("pack directory is not empty: %v", names)
+				}
+				return
+			}
+
+			for idx, entry := range test.entries {
+				checkObject(t, stor, &pack.oids[idx], pack.types[idx], entry.data)
+			}
+
+			// stored pack is complete and indexed as git would do it
+			packFile, err := stor.fs.Open("objects/pack/pack-" + id + ".pack")
+			if err != nil {
+				t.Fatal(err)
+			}
+			packData, err := ioutil.ReadAll(packFile)
+			packFile.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var idxBuf bytes.Buffer
+			checksum, err := IndexPack(bytes.NewReader(packData), int64(len(packData)), &idxBuf)
+			if err != nil {
+				t.Fatalf("stored pack: %v", err)
+			}
+			if checksum.String() != id {
+				t.Errorf("pack is named %s, but its checksum is %s", id, checksum)
+			}
+
+			idx, err := ReadIDXFile(&idxBuf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := len(test.entries) + test.appended; idx.Count() != want {
+				t.Errorf("stored pack has %d objects, want %d", idx.Count(), want)
+			}
+		})
+	}
+}
+
+func TestIndexPack(t *testing.T) {
+	base := testText("base", 0)
+	tests := []struct {
+		name    string
+		entries []testEntry
+		// changes pack data before indexing
+		corrupt func([]byte) []byte
+		err     error
+	}{
+		{
+			name: "full objects",
+			entries: []testEntry{
+				fullEntry(rawgit.OTypeCommit, "commit body\n"),
+				fullEntry(rawgit.OTypeTree, ""),
+				fullEntry(rawgit.OTypeBlob, base),
+				fullEntry(rawgit.OTypeTag, "tag body\n"),
+			},
+		},
+		{
+			name: "offset delta chain",
+			entries: []testEntry{
+				fullEntry(rawgit.OTypeBlob, base),
+				ofsEntry(0, testText("base", 1)),
+				ofsEntry(1, testText("base", 2)),
+				ofsEntry(0, testText("base", 3)),
+			},
+		},
+		{
+			name: "ref deltas",
+			entries: []testEntry{
+				// base may follow its delta
+				refEntry(rawgit.OTypeBlob, base, testText("base", 1)),
+				fullEntry(rawgit.OTypeBlob, base),
+				refEntry(rawgit.OTypeBlob, testText("base", 1), testText("base", 2)),
+				ofsEntry(2, testText("base", 3)),
+			},
+		},
+		{
+			name: "thin pack",
+			entries: []testEntry{
+				refEntry(rawgit.OTypeBlob, base, testText("base", 1)),
+			},
+			err: ErrUnresolvedDelta,
+		},
+		{
+			name:    "checksum mismatch",
+			entries: []testEntry{fullEntry(rawgit.OTypeBlob, base)},
+			corrupt: func(data []byte) []byte {
+				data[len(data)-1] ^= 0xff
+				return data
+			},
+			err: ErrPackChecksumMismatch,
+		},
+		{
+			name:    "trailing garbage",
+			entries: []testEntry{fullEntry(rawgit.OTypeBlob, base)},
+			corrupt: func(data []byte) []byte {
+				return append(data, 0)
+			},
+			err: ErrInvalidPackLength,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pack := buildTestPack(t, test.entries...)
+			data := pack.data
+			if test.corrupt != nil {
+				data = test.corrupt(append([]byte(nil), data...))
+			}
+
+			var idxBuf bytes.Buffer
+			checksum, err := IndexPack(bytes.NewReader(data), int64(len(data)), &idxBuf)
+			if err != test.err {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+
+			if !bytes.Equal(checksum[:], data[len(data)-20:]) {
+				t.Errorf("got checksum %s, want pack trailer", checksum)
+			}
+
+			idxData := idxBuf.Bytes()
+			idx, err := ReadIDXFile(bytes.NewReader(idxData))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if idx.Count() != len(test.entries) {
+				t.Errorf("index has %d objects, want %d", idx.Count(), len(test.entries))
+			}
+			for n := range test.entries {
+				if offset := idx.LookupObject(&pack.oids[n]); offset != pack.offsets[n] {
+					t.Errorf("object %d is at %d, want %d", n, offset, pack.offsets[n])
+				}
+				if _, ok := idx.CRC32(&pack.oids[n]); !ok {
+					t.Errorf("object %d has no crc32", n)
+				}
+			}
+
+			packed, err := OpenPack(ioutil.NopCloser(bytes.NewReader(idxData)), ioutil.NopCloser(bytes.NewReader(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer packed.Close()
+			for n, entry := range test.entries {
+				checkObject(t, packed, &pack.oids[n], pack.types[n], entry.data)
+			}
+		})
+	}
+}
+
+func TestScanPacks(t *testing.T) {
+	stor := newTestStorage(t)
+	pack := buildTestPack(t,
+		fullEntry(rawgit.OTypeBlob, testText("base", 0)),
+		ofsEntry(0, testText("base", 1)))
+	checksum := rawgit.OID{}
+	copy(checksum[:], pack.data[len(pack.data)-20:])
+
+	files := map[string][]byte{
+		// pack without index is indexed on open
+		"pack-" + checksum.String() + ".pack": pack.data,
+		// stray and broken packs are ignored
+		"x.pack": []byte("junk"),
+		"pack-" + strings.Repeat("0", 40) + ".pack": []byte("PACK"),
+	}
+	for name, data := range files {
+		if err := writeFile(stor.fs, "objects/pack/"+name, string(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stor, err := OpenFSStorage(stor.fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := range pack.oids {
+		checkObject(t, stor, &pack.oids[n], pack.types[n], testText("base", n))
+	}
+}
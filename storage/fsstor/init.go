@@ -0,0 +1,70 @@
+package fsstor
+
+import (
+	"github.com/mechmind/git-go/rawgit"
+)
+
+const DefaultBranch = "master"
+
+const defaultDescription = "Unnamed repository; edit this file 'description' to name the repository.\n"
+
+// InitFSStorage creates layout of git directory in fs and opens it. HEAD points to
+// DefaultBranch. Existing repository is not reinitialized, ErrRepositoryExists is
+// returned instead. Other existing files, like config, are kept untouched
+func InitFSStorage(fs FS, bare bool) (*FSStorage, error) {
+	if fs.IsFileExist("HEAD") {
+		return nil, ErrRepositoryExists
+	}
+
+	for _, dir := range []string{"objects/info", "objects/pack", "refs/heads", "refs/tags", "info"} {
+		err := fs.MkdirAll(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config := "[core]\n" +
+		"\trepositoryformatversion = 0\n" +
+		"\tfilemode = true\n"
+	if bare {
+		config += "\tbare = true\n"
+	} else {
+		config += "\tbare = false\n" +
+			"\tlogallrefupdates = true\n"
+	}
+
+	files := []struct {
+		name, contents string
+	}{
+		{"HEAD", rawgit.RefPrefix + rawgit.RefBranchNS + DefaultBranch + "\n"},
+		{ConfigFile, config},
+		{"description", defaultDescription},
+	}
+	for _, file := range files {
+		if fs.IsFileExist(file.name) {
+			continue
+		}
+
+		err := writeFile(fs, file.name, file.contents)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return OpenFSStorage(fs)
+}
+
+func writeFile(fs FS, name, contents string) error {
+	file, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write([]byte(contents))
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
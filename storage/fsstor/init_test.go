@@ -0,0 +1,110 @@
+package fsstor
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+func readTestFile(t *testing.T, fs FS, name string) string {
+	file, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestInitFSStorage(t *testing.T) {
+	tests := []struct {
+		bare bool
+		// core variables of config
+		want map[string]string
+	}{
+		{
+			bare: true,
+			want: map[string]string{"repositoryformatversion": "0", "filemode": "true", "bare": "true"},
+		},
+		{
+			bare: false,
+			want: map[string]string{"repositoryformatversion": "0", "filemode": "true", "bare": "false", "logallrefupdates": "true"},
+		},
+	}
+
+	for _, test := range tests {
+		fs := NewOSFS(t.TempDir())
+		stor, err := InitFSStorage(fs, test.bare)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, dir := range []string{"objects/info", "objects/pack", "refs/heads", "refs/tags", "info"} {
+			if !fs.IsDir(dir) {
+				t.Errorf("bare %v: %s is not created", test.bare, dir)
+			}
+		}
+		if head := readTestFile(t, fs, "HEAD"); head != "ref: refs/heads/master\n" {
+			t.Errorf("bare %v: got HEAD %q", test.bare, head)
+		}
+		if description := readTestFile(t, fs, "description"); description != defaultDescription {
+			t.Errorf("bare %v: got description %q", test.bare, description)
+		}
+
+		config, err := stor.ReadConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range test.want {
+			if got := config.GetAll("core", "", name); len(got) != 1 || got[0] != value {
+				t.Errorf("bare %v: got core.%s %q, want %q", test.bare, name, got, value)
+			}
+		}
+		if got := config.GetAll("core", "", "logallrefupdates"); test.bare && got != nil {
+			t.Errorf("bare repository has core.logallrefupdates %q", got)
+		}
+
+		// new repository has unborn default branch
+		repo := rawgit.NewRepository(stor, stor)
+		if ref, err := repo.ReadSymbolicRef("HEAD"); err != nil || ref != "refs/heads/master" {
+			t.Errorf("bare %v: HEAD points to %q, %v", test.bare, ref, err)
+		}
+		if _, err := repo.ResolveRef("HEAD"); err != rawgit.ErrUnbornBranch {
+			t.Errorf("bare %v: got error %v for unborn HEAD", test.bare, err)
+		}
+	}
+}
+
+func TestInitFSStorageExisting(t *testing.T) {
+	fs := NewOSFS(t.TempDir())
+	if _, err := InitFSStorage(fs, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(fs, ConfigFile, "[core]\n\tbare = true\n[user]\n\tname = Kept\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := InitFSStorage(fs, false); err != ErrRepositoryExists {
+		t.Errorf("got error %v", err)
+	}
+	if config := readTestFile(t, fs, ConfigFile); config != "[core]\n\tbare = true\n[user]\n\tname = Kept\n" {
+		t.Errorf("config is changed to %q", config)
+	}
+
+	// directory with some files, but without HEAD, is initialized
+	fs = NewOSFS(t.TempDir())
+	if err := writeFile(fs, "description", "kept\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InitFSStorage(fs, true); err != nil {
+		t.Fatal(err)
+	}
+	if description := readTestFile(t, fs, "description"); description != "kept\n" {
+		t.Errorf("description is changed to %q", description)
+	}
+}
@@ -31,6 +31,32 @@ func (o OSFS) Create(path string) (File, error) {
 	return os.Create(path)
 }
 
+func (o OSFS) CreateExclusive(path string) (File, error) {
+	path = filepath.Join(o.root, path)
+	base := filepath.Dir(path)
+	if _, err := os.Stat(base); os.IsNotExist(err) {
+		err := os.MkdirAll(base, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+}
+
+func (o OSFS) Append(path string) (File, error) {
+	path = filepath.Join(o.root, path)
+	base := filepath.Dir(path)
+	if _, err := os.Stat(base); os.IsNotExist(err) {
+		err := os.MkdirAll(base, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+}
+
 func (o OSFS) TempFile() (File, error) {
 	tmp, err := ioutil.TempFile(o.root, "tmpgitgo.")
 	if err != nil {
@@ -41,17 +67,28 @@ func (o OSFS) TempFile() (File, error) {
 }
 
 func (o OSFS) Move(from string, to string) error {
-	dst, err := o.Create(filepath.Join(o.root, to))
-	if err != nil {
-		return err
+	to = filepath.Join(o.root, to)
+	base := filepath.Dir(to)
+	if _, err := os.Stat(base); os.IsNotExist(err) {
+		err := os.MkdirAll(base, 0755)
+		if err != nil {
+			return err
+		}
 	}
 
-	return os.Rename(from, dst.Name())
+	return os.Rename(from, to)
+}
+
+func (o OSFS) Remove(path string) error {
+	return os.Remove(filepath.Join(o.root, path))
+}
+
+func (o OSFS) MkdirAll(path string) error {
+	return os.MkdirAll(filepath.Join(o.root, path), 0755)
 }
 
 func (o OSFS) ListDir(path string) ([]string, error) {
 	baseDir := filepath.Join(o.root, path)
-	// FIXME: refs with slashes
 	if _, err := os.Stat(baseDir); os.IsNotExist(err) {
 		// no dir, no refs
 		return nil, nil
@@ -69,12 +106,11 @@ func (o OSFS) ListDir(path string) ([]string, error) {
 	return names, nil
 }
 
+// IsFileExist reports whether path exists. Paths inside of regular files do
+// not exist either
 func (o OSFS) IsFileExist(path string) bool {
-	if _, err := os.Stat(filepath.Join(o.root, path)); os.IsNotExist(err) {
-		return false
-	} else {
-		return true
-	}
+	_, err := os.Stat(filepath.Join(o.root, path))
+	return err == nil
 }
 
 func (o OSFS) IsDir(path string) bool {
@@ -92,7 +128,19 @@ func (o OSFS) IsReadOnly() bool {
 
 // rawgit.Globber interface
 func (o OSFS) Glob(pattern string) ([]string, error) {
-	return filepath.Glob(filepath.Join(o.root, pattern))
+	matches, err := filepath.Glob(filepath.Join(o.root, pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	// paths must be relative to fs root, like in other fs methods
+	for idx, match := range matches {
+		matches[idx], err = filepath.Rel(o.root, match)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return matches, nil
 }
 
 type tmpFileRemover struct {
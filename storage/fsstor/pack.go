@@ -1,19 +1,22 @@
 package fsstor
 
 import (
+	"bufio"
 	"bytes"
 	"compress/zlib"
 	"encoding/binary"
 	"io"
 	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/mechmind/git-go/rawgit"
 )
 
 const PackV2Magic = -9154717 // decoded int value of '\377t0c'
-type ReadSeekCloser interface {
-	io.Reader
-	io.Seeker
+type ReadAtCloser interface {
+	io.ReaderAt
 	io.Closer
 }
 
@@ -68,18 +71,21 @@ func (p *inMemoryPackFile) OpenObjectAt(offs int64) (info rawgit.ObjectInfo, dat
 	return info, reader, nil
 }
 
+// seekablePackFile reads objects directly from the file. There is no shared read
+// position, so it is safe for concurrent use
 type seekablePackFile struct {
-	storage ReadSeekCloser
+	storage ReadAtCloser
+	size    int64
 	count   int32
 }
 
-func OpenPackFile(src ReadSeekCloser) (PackFile, error) {
-	count, err := readPackFileHeader(src)
+func OpenPackFile(src ReadAtCloser, size int64) (PackFile, error) {
+	count, err := readPackFileHeader(io.NewSectionReader(src, 0, size))
 	if err != nil {
 		return nil, err
 	}
 
-	return &seekablePackFile{src, count}, nil
+	return &seekablePackFile{src, size, count}, nil
 }
 
 func (p *seekablePackFile) Close() error {
@@ -87,13 +93,33 @@ func (p *seekablePackFile) Close() error {
 }
 
 func (p *seekablePackFile) OpenObjectAt(offs int64) (info rawgit.ObjectInfo, data io.Reader, err error) {
-	_, err = p.storage.Seek(offs, 0)
-	objType, objSize, err := readPackEntryHeader(p.storage)
+	if offs < 0 || offs >= p.size {
+		return rawgit.ObjectInfo{}, nil, ErrObjectNotFound
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(p.storage, offs, p.size-offs))
+	objType, objSize, err := readPackEntryHeader(reader)
 	if err != nil {
 		return
 	}
 
-	return rawgit.ObjectInfo{OType: objType, Size: objSize}, p.storage, nil
+	return rawgit.ObjectInfo{OType: objType, Size: objSize}, reader, nil
+}
+
+// openPackFile picks best available way to read the pack
+func openPackFile(src io.ReadCloser) (PackFile, error) {
+	if reader, ok := src.(ReadAtCloser); ok {
+		if file, ok := src.(File); ok {
+			size, err := fileSize(file)
+			if err != nil {
+				return nil, err
+			}
+			return OpenPackFile(reader, size)
+		}
+	}
+
+	defer src.Close()
+	return LoadPackFile(src)
 }
 
 func readPackFileHeader(src io.Reader) (int32, error) {
@@ -145,177 +171,285 @@ func readPackEntryHeader(src io.Reader) (rawgit.OType, uint64, error) {
 	return objType, size, nil
 }
 
+// IDXFile keeps pack index tables as they are stored in the file, lookups are done
+// by binary search over them
 type IDXFile struct {
-	offsets map[rawgit.OID]int64
+	fanout     [256]uint32
+	hashes     []byte
+	crcs       []byte
+	offsets    []byte
+	extOffsets []byte
+
+	// positions of objects, sorted by their offsets in pack
+	revOnce sync.Once
+	rev     []uint32
 }
 
+func (i *IDXFile) Count() int {
+	return int(i.fanout[255])
+}
+
+// LookupObject returns offset of object in pack or -1 if there is no such object
 func (i *IDXFile) LookupObject(oid *rawgit.OID) int64 {
-	offset, ok := i.offsets[*oid]
-	if !ok {
+	pos := i.find(oid)
+	if pos == -1 {
 		return -1
 	}
-	return offset
+	return i.offsetAt(pos)
 }
 
+// LookupOID returns id of object at offset or nil if there is no object at offset
 func (i *IDXFile) LookupOID(offset int64) *rawgit.OID {
-	for oid, oidOffset := range i.offsets {
-		if offset == oidOffset {
-			return &oid
-		}
+	i.revOnce.Do(i.buildReverseIndex)
+
+	idx := sort.Search(len(i.rev), func(n int) bool {
+		return i.offsetAt(int(i.rev[n])) >= offset
+	})
+	if idx == len(i.rev) || i.offsetAt(int(i.rev[idx])) != offset {
+		return nil
 	}
-	return nil
-}
 
-func ReadIDXFile(src io.Reader) (*IDXFile, error) {
-	var magic int32
+	return i.oidAt(int(i.rev[idx]))
+}
 
-	// read first value
-	err := binary.Read(src, binary.BigEndian, &magic)
-	if err != nil {
-		return nil, err
+// find returns position of object in index or -1
+func (i *IDXFile) find(oid *rawgit.OID) int {
+	lo, hi := i.fanoutRange(oid[0])
+	pos := lo + sort.Search(hi-lo, func(n int) bool {
+		return bytes.Compare(i.hashAt(lo+n), oid[:]) >= 0
+	})
+	if pos == hi || !bytes.Equal(i.hashAt(pos), oid[:]) {
+		return -1
 	}
+	return pos
+}
 
-	if magic == PackV2Magic {
-		// this is v2 or greater pack index
-		return readV2IDXFile(src)
-	} else {
-		// this is v1 pack index
-		return readV1IDXFile(src)
+// fanoutRange returns range of positions of objects, which ids start with given byte
+func (i *IDXFile) fanoutRange(first byte) (lo, hi int) {
+	if first > 0 {
+		lo = int(i.fanout[first-1])
 	}
+	return lo, int(i.fanout[first])
 }
 
-func readV1IDXFile(src io.Reader) (*IDXFile, error) {
-	idx := &IDXFile{}
-
-	// skip to last (256) fanout entry
-	_, err := io.CopyN(ioutil.Discard, src, 254*4)
+// MatchPrefix returns ids of all objects, which hex form starts with prefix.
+// Prefix must be a valid hex string
+func (i *IDXFile) MatchPrefix(prefix string) []rawgit.OID {
+	// lowest possible id with such prefix
+	low, err := rawgit.ParseOID(prefix + strings.Repeat("0", 40-len(prefix)))
 	if err != nil {
-		return nil, err
+		return nil
 	}
 
-	var total int32
-	// read last fanout entry (= total objects)
-	err = binary.Read(src, binary.BigEndian, &total)
-	if err != nil {
-		return nil, err
+	lo, _ := i.fanoutRange(low[0])
+	total := i.Count()
+	pos := lo + sort.Search(total-lo, func(n int) bool {
+		return bytes.Compare(i.hashAt(lo+n), low[:]) >= 0
+	})
+
+	var result []rawgit.OID
+	for ; pos < total; pos++ {
+		oid := i.oidAt(pos)
+		if !oid.HasPrefix(prefix) {
+			break
+		}
+		result = append(result, *oid)
 	}
+	return result
+}
+
+// uniquePrefixLength returns length of hex prefix, that is enough to distinguish
+// given id from all other objects in index. Object itself may be absent in index
+func (i *IDXFile) uniquePrefixLength(oid *rawgit.OID) int {
+	lo, hi := i.fanoutRange(oid[0])
+	pos := lo + sort.Search(hi-lo, func(n int) bool {
+		return bytes.Compare(i.hashAt(lo+n), oid[:]) >= 0
+	})
 
-	idx.offsets = make(map[rawgit.OID]int64, total)
+	// only nearest neighbours may share longest prefix with oid
+	var length int
+	if pos > 0 {
+		length = commonHexPrefix(i.hashAt(pos-1), oid[:]) + 1
+	}
 
-	var offset int32
-	var oid = [20]byte{}
+	if pos < i.Count() && bytes.Equal(i.hashAt(pos), oid[:]) {
+		pos++
+	}
 
-	var i int32
-	for i = 0; i < total; i++ {
-		// read offset table
-		// read offset, then object name
-		err = binary.Read(src, binary.BigEndian, &offset)
-		if err != nil {
-			return nil, err
+	if pos < i.Count() {
+		if next := commonHexPrefix(i.hashAt(pos), oid[:]) + 1; next > length {
+			length = next
 		}
+	}
+	return length
+}
 
-		_, err = src.Read(oid[:])
-		if err != nil {
-			return nil, err
+func commonHexPrefix(a, b []byte) int {
+	for n := 0; n < len(a) && n < len(b); n++ {
+		if a[n] != b[n] {
+			if a[n]>>4 == b[n]>>4 {
+				return n*2 + 1
+			}
+			return n * 2
 		}
-		idx.offsets[oid] = int64(offset)
 	}
+	return len(a) * 2
+}
 
-	return idx, nil
+func (i *IDXFile) hashAt(pos int) []byte {
+	return i.hashes[pos*20 : (pos+1)*20]
 }
 
-func readV2IDXFile(src io.Reader) (*IDXFile, error) {
-	var idx = &IDXFile{}
+func (i *IDXFile) oidAt(pos int) *rawgit.OID {
+	oid := rawgit.OID{}
+	copy(oid[:], i.hashAt(pos))
+	return &oid
+}
 
-	var version int32
-	err := binary.Read(src, binary.BigEndian, &version)
-	if err != nil {
-		return nil, err
+func (i *IDXFile) offsetAt(pos int) int64 {
+	offset := binary.BigEndian.Uint32(i.offsets[pos*4:])
+	// v1 indexes have no crc32 sums and keep whole 32-bit offsets
+	if i.crcs == nil || offset&(1<<31) == 0 {
+		return int64(offset)
 	}
 
-	if version != 2 {
-		return nil, ErrInvalidPackVersion
+	// it is an extended offset, which is checked on reading of index
+	extPos := int(offset&(1<<31-1)) * 8
+	if extPos+8 > len(i.extOffsets) {
+		return -1
 	}
+	return int64(binary.BigEndian.Uint64(i.extOffsets[extPos:]))
+}
 
-	// skip to last (256) fanout entry
-	_, err = io.CopyN(ioutil.Discard, src, 255*4)
-	if err != nil {
-		return nil, err
+// CRC32 returns checksum of packed object data. Only v2 indexes have them
+func (i *IDXFile) CRC32(oid *rawgit.OID) (uint32, bool) {
+	pos := i.find(oid)
+	if pos == -1 || i.crcs == nil {
+		return 0, false
 	}
+	return binary.BigEndian.Uint32(i.crcs[pos*4:]), true
+}
 
-	var total int32
-	// read last fanout entry (= total objects)
-	err = binary.Read(src, binary.BigEndian, &total)
-	if err != nil {
-		return nil, err
+func (i *IDXFile) buildReverseIndex() {
+	i.rev = make([]uint32, i.Count())
+	for pos := range i.rev {
+		i.rev[pos] = uint32(pos)
 	}
 
-	// read all hashes
-	allHashes := make([]byte, int(total)*20)
-	_, err = src.Read(allHashes)
+	sort.Slice(i.rev, func(a, b int) bool {
+		return i.offsetAt(int(i.rev[a])) < i.offsetAt(int(i.rev[b]))
+	})
+}
+
+func ReadIDXFile(src io.Reader) (*IDXFile, error) {
+	content, err := ioutil.ReadAll(src)
 	if err != nil {
 		return nil, err
 	}
 
-	// skip all crc32 sums
-	io.CopyN(ioutil.Discard, src, int64(total)*4)
+	if len(content) >= 4 && bytes.Equal(content[:4], idxV2Magic) {
+		// this is v2 or greater pack index
+		return readV2IDXFile(content[4:])
+	} else {
+		// this is v1 pack index
+		return readV1IDXFile(content)
+	}
+}
+
+func readFanout(idx *IDXFile, buf []byte) error {
+	if len(buf) < 256*4 {
+		return ErrInvalidPackLength
+	}
+
+	for n := range idx.fanout {
+		idx.fanout[n] = binary.BigEndian.Uint32(buf[n*4:])
+		if n > 0 && idx.fanout[n] < idx.fanout[n-1] {
+			return ErrInvalidFanout
+		}
+	}
+	return nil
+}
+
+func readV1IDXFile(buf []byte) (*IDXFile, error) {
+	idx := &IDXFile{}
 
-	// read all primary offsets
-	primaryOffsets := make([]int32, int(total))
-	err = binary.Read(src, binary.BigEndian, primaryOffsets)
+	err := readFanout(idx, buf)
 	if err != nil {
 		return nil, err
 	}
+	buf = buf[256*4:]
 
-	// read extended offset table and checksums
-	trailer, err := ioutil.ReadAll(src)
+	total := idx.Count()
+	// offset and object name for every object, then two checksums
+	if len(buf) != total*24+40 {
+		return nil, ErrInvalidPackLength
+	}
+
+	// split entries into tables, like in v2 index
+	idx.hashes = make([]byte, total*20)
+	idx.offsets = make([]byte, total*4)
+	for n := 0; n < total; n++ {
+		entry := buf[n*24 : (n+1)*24]
+		copy(idx.offsets[n*4:], entry[:4])
+		copy(idx.hashes[n*20:], entry[4:])
+	}
+
+	return idx, nil
+}
+
+func readV2IDXFile(buf []byte) (*IDXFile, error) {
+	var idx = &IDXFile{}
+
+	if len(buf) < 4 {
+		return nil, ErrInvalidPackLength
+	}
+
+	version := binary.BigEndian.Uint32(buf)
+	if version != 2 {
+		return nil, ErrInvalidPackVersion
+	}
+
+	err := readFanout(idx, buf[4:])
 	if err != nil {
 		return nil, err
 	}
+	buf = buf[4+256*4:]
 
-	if len(trailer) < 40 {
+	total := idx.Count()
+	// hashes, crc32 sums, primary offsets, then extended offset table and checksums
+	if len(buf) < total*28+40 {
 		return nil, ErrInvalidPackLength
 	}
 
-	extOffsetBuf := trailer[:len(trailer)-40]
-	extOffsetCount := len(extOffsetBuf) / 8
-	var extOffsets []int64
+	idx.hashes, buf = buf[:total*20], buf[total*20:]
+	idx.crcs, buf = buf[:total*4], buf[total*4:]
+	idx.offsets, buf = buf[:total*4], buf[total*4:]
 
-	if extOffsetCount > 0 {
-		extOffsets = make([]int64, extOffsetCount)
-		err = binary.Read(bytes.NewReader(extOffsetBuf), binary.BigEndian, extOffsets)
-		if err != nil {
-			return nil, err
-		}
+	idx.extOffsets = buf[:len(buf)-40]
+	if len(idx.extOffsets)%8 != 0 {
+		return nil, ErrInvalidPackLength
 	}
 
-	idx.offsets = make(map[rawgit.OID]int64, total)
-
-	// load ids and offsets
-	var i, offset4 int32
-	var offset int64
-	for i = 0; i < total; i++ {
-		offset4 = primaryOffsets[i]
-		if i < 0 {
-			// it is an extended offset
-			extOffsetId := int(offset & (1<<31 - 1))
-			if extOffsetId > len(extOffsets) {
-				return nil, ErrOffsetIdOutOfRange
-			}
-			offset = extOffsets[extOffsetId]
-		} else {
-			offset = int64(offset4)
+	// check that all extended offsets are in place
+	extCount := len(idx.extOffsets) / 8
+	for pos := 0; pos < total; pos++ {
+		offset := binary.BigEndian.Uint32(idx.offsets[pos*4:])
+		if offset&(1<<31) != 0 && int(offset&(1<<31-1)) >= extCount {
+			// extended offset table is too short
+			return nil, ErrInvalidPackLength
 		}
-		oid := [20]byte{}
-		copy(oid[:], allHashes[i*20:(i+1)*20])
-		idx.offsets[oid] = offset
 	}
+
 	return idx, nil
 }
 
 type Pack struct {
-	pack PackFile
-	idx  *IDXFile
+	idx   *IDXFile
+	cache *deltaBaseCache
+
+	lock   sync.Mutex
+	pack   PackFile
+	opener func() (io.ReadCloser, error)
 }
 
 func OpenPack(idxFile, packFile io.ReadCloser) (*Pack, error) {
@@ -324,19 +458,54 @@ func OpenPack(idxFile, packFile io.ReadCloser) (*Pack, error) {
 		return nil, err
 	}
 
-	var pack PackFile
-	if seekablePackFile, ok := packFile.(ReadSeekCloser); ok && false {
-		pack, err = OpenPackFile(seekablePackFile)
+	pack, err := openPackFile(packFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Pack{idx: idx, pack: pack, cache: newDeltaBaseCache(DefaultDeltaBaseCacheSize)}, nil
+}
+
+// openLazyPack makes pack, that will be opened on first access to its objects
+func openLazyPack(idxFile io.Reader, opener func() (io.ReadCloser, error), cache *deltaBaseCache) (*Pack, error) {
+	idx, err := ReadIDXFile(idxFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pack{idx: idx, cache: cache, opener: opener}, nil
+}
+
+func (p *Pack) packFile() (PackFile, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.pack == nil {
+		src, err := p.opener()
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		pack, err = LoadPackFile(packFile)
+
+		p.pack, err = openPackFile(src)
 		if err != nil {
 			return nil, err
 		}
 	}
-	return &Pack{pack, idx}, nil
+
+	return p.pack, nil
+}
+
+func (p *Pack) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.cache.dropPack(p)
+	if p.pack == nil {
+		return nil
+	}
+
+	err := p.pack.Close()
+	p.pack = nil
+	return err
 }
 
 func (p *Pack) HasObject(oid *rawgit.OID) bool {
@@ -352,6 +521,71 @@ func (p *Pack) OpenObject(oid *rawgit.OID) (rawgit.ObjectInfo, io.ReadCloser, er
 	return p.openObject(oid, offset)
 }
 
+// StatObject returns type and size of object, without unpacking it
+func (p *Pack) StatObject(oid *rawgit.OID) (rawgit.ObjectInfo, error) {
+	offset := p.idx.LookupObject(oid)
+	if offset == -1 {
+		return rawgit.ObjectInfo{}, ErrObjectNotFound
+	}
+
+	objType, size, err := p.statAt(offset)
+	if err != nil {
+		return rawgit.ObjectInfo{}, err
+	}
+
+	return rawgit.ObjectInfo{OID: *oid, OType: objType, Size: size}, nil
+}
+
+func (p *Pack) statAt(offset int64) (rawgit.OType, uint64, error) {
+	if objType, obj, ok := p.cache.get(p, offset); ok {
+		return objType, uint64(len(obj)), nil
+	}
+
+	pack, err := p.packFile()
+	if err != nil {
+		return rawgit.OTypeBad, 0, err
+	}
+
+	info, data, err := pack.OpenObjectAt(offset)
+	if err != nil {
+		return rawgit.OTypeBad, 0, err
+	}
+
+	if info.OType != rawgit.OTypeRefDelta && info.OType != rawgit.OTypeOffsetDelta {
+		return info.OType, info.Size, nil
+	}
+
+	baseOffset, err := p.readDeltaBase(offset, info, data)
+	if err != nil {
+		return rawgit.OTypeBad, 0, err
+	}
+
+	// size of resulting object is stored in delta header, right after base size
+	zlibReader, err := zlib.NewReader(data)
+	if err != nil {
+		return rawgit.OTypeBad, 0, err
+	}
+	defer zlibReader.Close()
+
+	_, err = readVarInt(zlibReader)
+	if err != nil {
+		return rawgit.OTypeBad, 0, err
+	}
+
+	size, err := readVarInt(zlibReader)
+	if err != nil {
+		return rawgit.OTypeBad, 0, err
+	}
+
+	// type is taken from the end of delta chain
+	baseType, _, err := p.statAt(baseOffset)
+	if err != nil {
+		return rawgit.OTypeBad, 0, err
+	}
+
+	return baseType, uint64(size), nil
+}
+
 func (p *Pack) OpenObjectAt(offset int64) (rawgit.ObjectInfo, io.ReadCloser, error) {
 	oid := p.idx.LookupOID(offset)
 	if oid == nil {
@@ -362,71 +596,132 @@ func (p *Pack) OpenObjectAt(offset int64) (rawgit.ObjectInfo, io.ReadCloser, err
 }
 
 func (p *Pack) openObject(oid *rawgit.OID, offset int64) (rawgit.ObjectInfo, io.ReadCloser, error) {
-	info, data, err := p.pack.OpenObjectAt(offset)
+	pack, err := p.packFile()
+	if err != nil {
+		return rawgit.ObjectInfo{}, nil, err
+	}
+
+	info, data, err := pack.OpenObjectAt(offset)
 	if err != nil {
 		return rawgit.ObjectInfo{}, nil, err
 	}
 
 	info.OID = *oid
-	if info.OType == rawgit.OTypeRefDelta {
-		var hashbuf = make([]byte, 20)
-		_, err = data.Read(hashbuf)
+	if info.OType == rawgit.OTypeRefDelta || info.OType == rawgit.OTypeOffsetDelta {
+		// deltified objects are assembled in memory
+		objType, obj, err := p.undeltify(offset, info, data)
 		if err != nil {
 			return rawgit.ObjectInfo{}, nil, err
 		}
 
-		srcOID := rawgit.OID{}
-		copy(srcOID[:], hashbuf)
-		srcInfo, src, err := p.OpenObject(&srcOID)
-		if err != nil {
-			return rawgit.ObjectInfo{}, nil, err
-		}
+		info.OType = objType
+		info.Size = uint64(len(obj))
+		return info, ioutil.NopCloser(bytes.NewReader(obj)), nil
+	}
+
+	// regular objects are streamed right from the pack
+	zlibReader, err := zlib.NewReader(data)
+	if err != nil {
+		return rawgit.ObjectInfo{}, nil, err
+	}
 
-		info.OType = srcInfo.OType
+	return info, newObjectReader(zlibReader, info.Size), nil
+}
 
-		zlibReader, err := zlib.NewReader(data)
-		if err != nil {
-			return rawgit.ObjectInfo{}, nil, err
-		}
+// unpackBase returns contents of object at offset. Objects are cached,
+// because they are likely used as bases for other deltas
+func (p *Pack) unpackBase(offset int64) (rawgit.OType, []byte, error) {
+	if objType, obj, ok := p.cache.get(p, offset); ok {
+		return objType, obj, nil
+	}
 
-		return applyDelta(src, zlibReader, info)
+	pack, err := p.packFile()
+	if err != nil {
+		return rawgit.OTypeBad, nil, err
+	}
+
+	info, data, err := pack.OpenObjectAt(offset)
+	if err != nil {
+		return rawgit.OTypeBad, nil, err
+	}
+
+	var objType rawgit.OType
+	var obj []byte
+	if info.OType == rawgit.OTypeRefDelta || info.OType == rawgit.OTypeOffsetDelta {
+		objType, obj, err = p.undeltify(offset, info, data)
+	} else {
+		objType = info.OType
+		obj, err = inflate(data, info.Size)
+	}
+	if err != nil {
+		return rawgit.OTypeBad, nil, err
+	}
 
-	} else if info.OType == rawgit.OTypeOffsetDelta {
-		baseOffset, err := readOffset(data)
+	p.cache.add(p, offset, objType, obj)
+	return objType, obj, nil
+}
+
+// readDeltaBase reads reference to delta base and returns base offset
+func (p *Pack) readDeltaBase(offset int64, info rawgit.ObjectInfo, data io.Reader) (int64, error) {
+	if info.OType == rawgit.OTypeRefDelta {
+		var baseOID rawgit.OID
+		_, err := io.ReadFull(data, baseOID[:])
 		if err != nil {
-			return rawgit.ObjectInfo{}, nil, err
+			return 0, err
 		}
 
-		baseAddr := offset - baseOffset
-		srcInfo, src, err := p.OpenObjectAt(baseAddr)
-		if err != nil {
-			return rawgit.ObjectInfo{}, nil, err
+		baseOffset := p.idx.LookupObject(&baseOID)
+		if baseOffset == -1 {
+			// this is a thin pack
+			return 0, ErrUnresolvedDelta
 		}
+		return baseOffset, nil
+	}
 
-		info.OType = srcInfo.OType
+	relOffset, err := readOffset(data)
+	if err != nil {
+		return 0, err
+	}
+	return offset - relOffset, nil
+}
 
-		zlibReader, err := zlib.NewReader(data)
-		if err != nil {
-			return rawgit.ObjectInfo{}, nil, err
-		}
-		return applyDelta(src, zlibReader, info)
+// undeltify reads delta from data and applies it to its base
+func (p *Pack) undeltify(offset int64, info rawgit.ObjectInfo, data io.Reader) (rawgit.OType, []byte, error) {
+	baseOffset, err := p.readDeltaBase(offset, info, data)
+	if err != nil {
+		return rawgit.OTypeBad, nil, err
 	}
 
-	zlibReader, err := zlib.NewReader(data)
+	delta, err := inflate(data, info.Size)
 	if err != nil {
-		return rawgit.ObjectInfo{}, nil, err
+		return rawgit.OTypeBad, nil, err
 	}
 
-	return info, newObjectReader(zlibReader, info.Size), nil
+	baseType, base, err := p.unpackBase(baseOffset)
+	if err != nil {
+		return rawgit.OTypeBad, nil, err
+	}
+
+	obj, err := patchDelta(base, delta)
+	if err != nil {
+		return rawgit.OTypeBad, nil, err
+	}
+	return baseType, obj, nil
 }
 
-func applyDelta(src, delta io.ReadCloser, info rawgit.ObjectInfo) (rawgit.ObjectInfo, io.ReadCloser, error) {
-	_, objSize, applier, err := newDeltaApplier(src, delta, int64(info.Size))
+func inflate(src io.Reader, size uint64) ([]byte, error) {
+	zlibReader, err := zlib.NewReader(src)
 	if err != nil {
-		return rawgit.ObjectInfo{}, nil, err
+		return nil, err
 	}
-	info.Size = objSize
-	return info, newObjectReader(applier, info.Size), nil
+	defer zlibReader.Close()
+
+	buf := make([]byte, size)
+	_, err = io.ReadFull(zlibReader, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
 }
 
 func readVarInt(src io.Reader) (int64, error) {
@@ -0,0 +1,110 @@
+package fsstor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+func testIndexEntries(offsets ...int64) []packIndexEntry {
+	entries := make([]packIndexEntry, len(offsets))
+	for n, offset := range offsets {
+		entries[n] = packIndexEntry{
+			oid:    hashObject(rawgit.OTypeBlob, []byte(fmt.Sprint(n))),
+			offset: offset,
+			crc:    uint32(n),
+		}
+	}
+	return entries
+}
+
+func TestIDXFileOffsets(t *testing.T) {
+	tests := []struct {
+		name    string
+		offsets []int64
+	}{
+		{"small offsets", []int64{12, 100, 1<<31 - 1}},
+		{"extended offsets", []int64{12, 1 << 31, 1<<31 + 5, 1 << 40, 1<<63 - 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries := testIndexEntries(test.offsets...)
+			var buf bytes.Buffer
+			err := writeIDXFile(&buf, append([]packIndexEntry(nil), entries...), &rawgit.OID{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			idx, err := ReadIDXFile(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, entry := range entries {
+				if offset := idx.LookupObject(&entry.oid); offset != entry.offset {
+					t.Errorf("object %s is at %d, want %d", entry.oid, offset, entry.offset)
+				}
+				if oid := idx.LookupOID(entry.offset); oid == nil || *oid != entry.oid {
+					t.Errorf("object at %d is %v, want %s", entry.offset, oid, entry.oid)
+				}
+				if crc, _ := idx.CRC32(&entry.oid); crc != entry.crc {
+					t.Errorf("object %s has crc32 %d, want %d", entry.oid, crc, entry.crc)
+				}
+			}
+		})
+	}
+}
+
+func TestReadIDXFileV1(t *testing.T) {
+	// v1 offsets use all 32 bits, there is no extended offset table
+	entries := testIndexEntries(12, 1<<31, 1<<32-1)
+	sorted := append([]packIndexEntry(nil), entries...)
+	sort.Sort(packIndexOrder(sorted))
+
+	var buf bytes.Buffer
+	var fanout [256]uint32
+	for _, entry := range sorted {
+		fanout[entry.oid[0]]++
+	}
+	var total uint32
+	for _, count := range fanout {
+		total += count
+		binary.Write(&buf, binary.BigEndian, total)
+	}
+	for _, entry := range sorted {
+		binary.Write(&buf, binary.BigEndian, uint32(entry.offset))
+		buf.Write(entry.oid[:])
+	}
+	buf.Write(make([]byte, 40))
+
+	idx, err := ReadIDXFile(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if offset := idx.LookupObject(&entry.oid); offset != entry.offset {
+			t.Errorf("object %s is at %d, want %d", entry.oid, offset, entry.offset)
+		}
+	}
+}
+
+func TestReadIDXFileTruncatedExtendedOffsets(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeIDXFile(&buf, testIndexEntries(12, 1<<31, 1<<32), &rawgit.OID{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// drop the last extended offset, keeping checksums
+	data := buf.Bytes()
+	data = append(data[:len(data)-48:len(data)-48], data[len(data)-40:]...)
+	_, err = ReadIDXFile(bytes.NewReader(data))
+	if err != ErrInvalidPackLength {
+		t.Errorf("got error %v, want %v", err, ErrInvalidPackLength)
+	}
+}
@@ -0,0 +1,330 @@
+package fsstor
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+const PackedRefsFile = "packed-refs"
+
+// packedRef is a single record of packed-refs file
+type packedRef struct {
+	name   string
+	value  string
+	peeled string
+}
+
+// packedRefs holds contents of packed-refs file, sorted by ref name
+type packedRefs struct {
+	refs []packedRef
+	// tags under refs/tags/ have peeled values recorded
+	peeled bool
+	// all tags have peeled values recorded, so refs without them are not tags
+	fullyPeeled bool
+}
+
+func readPackedRefs(fs FS) (*packedRefs, error) {
+	packed := &packedRefs{}
+	if !fs.IsFileExist(PackedRefsFile) {
+		return packed, nil
+	}
+
+	file, err := fs.Open(PackedRefsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parsePackedRefs(file)
+}
+
+func parsePackedRefs(src io.Reader) (*packedRefs, error) {
+	packed := &packedRefs{}
+	scanner := bufio.NewScanner(src)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case line == "" || line[0] == '#':
+			// header or comment
+			if strings.HasPrefix(line, "# pack-refs with:") {
+				packed.peeled = strings.Contains(line+" ", " peeled ")
+				packed.fullyPeeled = strings.Contains(line+" ", " fully-peeled ")
+			}
+
+		case line[0] == '^':
+			// peeled value of previous ref
+			if len(packed.refs) == 0 {
+				return nil, ErrInvalidPackedRefs
+			}
+			packed.refs[len(packed.refs)-1].peeled = line[1:]
+
+		default:
+			idx := strings.IndexByte(line, ' ')
+			if idx == -1 {
+				return nil, ErrInvalidPackedRefs
+			}
+			packed.refs = append(packed.refs, packedRef{name: line[idx+1:], value: line[:idx]})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// older git versions did not sort refs
+	sort.Sort(packedRefOrder(packed.refs))
+	return packed, nil
+}
+
+func (p *packedRefs) find(name string) int {
+	idx := sort.Search(len(p.refs), func(n int) bool {
+		return p.refs[n].name >= name
+	})
+	if idx == len(p.refs) || p.refs[idx].name != name {
+		return -1
+	}
+	return idx
+}
+
+func (p *packedRefs) get(name string) (packedRef, bool) {
+	idx := p.find(name)
+	if idx == -1 {
+		return packedRef{}, false
+	}
+	return p.refs[idx], true
+}
+
+func (p *packedRefs) set(ref packedRef) {
+	idx := sort.Search(len(p.refs), func(n int) bool {
+		return p.refs[n].name >= ref.name
+	})
+	if idx < len(p.refs) && p.refs[idx].name == ref.name {
+		p.refs[idx] = ref
+		return
+	}
+
+	p.refs = append(p.refs, packedRef{})
+	copy(p.refs[idx+1:], p.refs[idx:])
+	p.refs[idx] = ref
+}
+
+func (p *packedRefs) remove(name string) bool {
+	idx := p.find(name)
+	if idx == -1 {
+		return false
+	}
+
+	p.refs = append(p.refs[:idx], p.refs[idx+1:]...)
+	return true
+}
+
+// withPrefix returns refs which names start with prefix
+func (p *packedRefs) withPrefix(prefix string) []packedRef {
+	start := sort.Search(len(p.refs), func(n int) bool {
+		return p.refs[n].name >= prefix
+	})
+
+	end := start
+	for end < len(p.refs) && strings.HasPrefix(p.refs[end].name, prefix) {
+		end++
+	}
+	return p.refs[start:end]
+}
+
+func (p *packedRefs) writeTo(dst io.Writer) error {
+	bw := bufio.NewWriter(dst)
+	switch {
+	case p.fullyPeeled:
+		bw.WriteString("# pack-refs with: peeled fully-peeled sorted \n")
+	case p.peeled:
+		bw.WriteString("# pack-refs with: peeled sorted \n")
+	default:
+		bw.WriteString("# pack-refs with: sorted \n")
+	}
+	for _, ref := range p.refs {
+		bw.WriteString(ref.value + " " + ref.name + "\n")
+		if ref.peeled != "" {
+			bw.WriteString("^" + ref.peeled + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+type packedRefOrder []packedRef
+
+func (p packedRefOrder) Len() int           { return len(p) }
+func (p packedRefOrder) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p packedRefOrder) Less(i, j int) bool { return p[i].name < p[j].name }
+
+// PackRefs moves loose refs into packed-refs file and removes them. If all is false,
+// only tags and already packed refs are packed, like 'git pack-refs' does.
+// Symbolic refs are never packed
+func (r *FSStorage) PackRefs(all bool) error {
+	lock, err := lockFile(r.fs, PackedRefsFile)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	packed, err := readPackedRefs(r.fs)
+	if err != nil {
+		return err
+	}
+
+	names, err := r.listLooseRefs("refs/")
+	if err != nil {
+		return err
+	}
+
+	// refs, which were packed before, may have no peeled values recorded
+	if !packed.fullyPeeled {
+		for idx := range packed.refs {
+			ref := &packed.refs[idx]
+			oid, err := rawgit.ParseOID(ref.value)
+			if ref.peeled != "" || err != nil {
+				continue
+			}
+
+			peeled, err := r.peelTag(oid)
+			if err != nil {
+				return err
+			}
+			if peeled != nil {
+				ref.peeled = peeled.String()
+			}
+		}
+		packed.peeled, packed.fullyPeeled = true, true
+	}
+
+	var pruned []packedRef
+	for _, name := range names {
+		_, wasPacked := packed.get(name)
+		if !all && !wasPacked && !strings.HasPrefix(name, "refs/tags/") {
+			continue
+		}
+
+		value, err := readRefFile(r.fs, name)
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(value, rawgit.RefPrefix) {
+			continue
+		}
+
+		oid, err := rawgit.ParseOID(value)
+		if err != nil {
+			// broken refs are left as is
+			continue
+		}
+
+		ref := packedRef{name: name, value: value}
+		peeled, err := r.peelTag(oid)
+		if err != nil {
+			return err
+		}
+		if peeled != nil {
+			ref.peeled = peeled.String()
+		}
+
+		packed.set(ref)
+		pruned = append(pruned, ref)
+	}
+
+	err = packed.writeTo(lock)
+	if err != nil {
+		return err
+	}
+
+	err = lock.commit()
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range pruned {
+		err = r.pruneLooseRef(ref.name, ref.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pruneLooseRef removes packed loose ref under its lock, unless it was changed
+// after packing. Refs, which are locked by someone else, are left as is
+func (r *FSStorage) pruneLooseRef(name, value string) error {
+	lock, err := lockFile(r.fs, name)
+	if err != nil {
+		return nil
+	}
+	defer lock.release()
+
+	current, err := readRefFile(r.fs, name)
+	if err != nil || current != value {
+		return nil
+	}
+	return r.fs.Remove(name)
+}
+
+// peelTag returns final target of tag object or nil if object is not a tag
+func (r *FSStorage) peelTag(oid *rawgit.OID) (*rawgit.OID, error) {
+	var peeled *rawgit.OID
+	for {
+		info, body, err := r.OpenObject(oid)
+		if err != nil {
+			if rawgit.IsNotExist(err) {
+				// git allows refs to missing objects
+				return peeled, nil
+			}
+			return nil, err
+		}
+
+		if info.GetOType() != rawgit.OTypeTag {
+			body.Close()
+			return peeled, nil
+		}
+
+		tag, err := rawgit.ReadTag(body)
+		if err != nil {
+			return nil, err
+		}
+
+		peeled = &tag.TargetOID
+		oid = peeled
+	}
+}
+
+// listLooseRefs returns names of all loose refs under given directory
+func (r *FSStorage) listLooseRefs(dir string) ([]string, error) {
+	if !r.fs.IsDir(dir) {
+		return nil, nil
+	}
+
+	entries, err := r.fs.ListDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		entry = filepath.ToSlash(entry)
+		if r.fs.IsDir(entry) {
+			sub, err := r.listLooseRefs(entry)
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, sub...)
+		} else if !strings.HasSuffix(entry, ".lock") {
+			names = append(names, entry)
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,459 @@
+package fsstor
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+const (
+	DefaultDeltaWindow = 10
+	DefaultDeltaDepth  = 50
+
+	// objects smaller than that are not worth deltifying
+	minDeltaObjectSize = 50
+)
+
+// PackWriter collects objects and writes them out as a v2 pack with OFS_DELTA
+// compression and a matching v2 index
+type PackWriter struct {
+	// number of objects considered as delta bases for each object
+	Window int
+	// maximum length of delta chains
+	Depth int
+
+	storage rawgit.Storage
+	entries []*packWriterEntry
+	seen    map[rawgit.OID]struct{}
+}
+
+type packWriterEntry struct {
+	oid      rawgit.OID
+	otype    rawgit.OType
+	size     uint64
+	nameHash uint32
+
+	base  *packWriterEntry
+	delta []byte
+	depth int
+
+	written bool
+	offset  int64
+	crc     uint32
+}
+
+func NewPackWriter(storage rawgit.Storage) *PackWriter {
+	return &PackWriter{
+		Window:  DefaultDeltaWindow,
+		Depth:   DefaultDeltaDepth,
+		storage: storage,
+		seen:    make(map[rawgit.OID]struct{}),
+	}
+}
+
+// AddObject schedules object for packing. Path hint is a path where object was
+// found (if any), it used to group similar objects together when picking delta bases
+func (pw *PackWriter) AddObject(oid *rawgit.OID, pathHint string) error {
+	if _, ok := pw.seen[*oid]; ok {
+		return nil
+	}
+
+	info, _, err := pw.storage.StatObject(oid)
+	if err != nil {
+		return err
+	}
+
+	pw.seen[*oid] = struct{}{}
+	pw.entries = append(pw.entries, &packWriterEntry{
+		oid:      *oid,
+		otype:    info.OType,
+		size:     info.Size,
+		nameHash: packNameHash(pathHint),
+	})
+	return nil
+}
+
+// Count returns number of scheduled objects
+func (pw *PackWriter) Count() int {
+	return len(pw.entries)
+}
+
+// Write deltifies scheduled objects and writes pack and its index. Returned
+// OID is a pack checksum, which also is the pack name. Writer may be written
+// several times, objects are deltified anew each time
+func (pw *PackWriter) Write(pack, idx io.Writer) (*rawgit.OID, error) {
+	for _, entry := range pw.entries {
+		entry.base, entry.delta, entry.depth = nil, nil, 0
+		entry.written = false
+	}
+
+	err := pw.deltify()
+	if err != nil {
+		return nil, err
+	}
+
+	out := newPackOutput(pack)
+	err = writePackFileHeader(out, uint32(len(pw.entries)))
+	if err != nil {
+		return nil, err
+	}
+
+	// write objects in order they were added, but always write delta bases first
+	for _, entry := range pw.entries {
+		err = pw.writeEntry(out, entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	checksum, err := out.finish()
+	if err != nil {
+		return nil, err
+	}
+
+	if idx != nil {
+		idxEntries := make([]packIndexEntry, len(pw.entries))
+		for i, entry := range pw.entries {
+			idxEntries[i] = packIndexEntry{entry.oid, entry.offset, entry.crc}
+		}
+
+		err = writeIDXFile(idx, idxEntries, checksum)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return checksum, nil
+}
+
+// deltify finds delta bases by sliding window over objects sorted by type, name
+// hash and size, like git does
+func (pw *PackWriter) deltify() error {
+	if pw.Window <= 0 || pw.Depth <= 0 {
+		return nil
+	}
+
+	sorted := make([]*packWriterEntry, len(pw.entries))
+	copy(sorted, pw.entries)
+	sort.Stable(deltaOrder(sorted))
+
+	type windowItem struct {
+		entry *packWriterEntry
+		data  []byte
+		index *deltaIndex
+	}
+	window := make([]*windowItem, 0, pw.Window)
+
+	for _, entry := range sorted {
+		if entry.size < minDeltaObjectSize {
+			continue
+		}
+
+		data, err := pw.readObject(&entry.oid)
+		if err != nil {
+			return err
+		}
+
+		for idx := len(window) - 1; idx >= 0; idx-- {
+			candidate := window[idx]
+			if candidate.entry.otype != entry.otype {
+				continue
+			}
+			if candidate.entry.depth >= pw.Depth {
+				continue
+			}
+			if uint64(len(candidate.data)) < entry.size/32 {
+				// base too small to be useful
+				continue
+			}
+
+			maxSize := int(entry.size/2) - 20
+			if entry.delta != nil {
+				maxSize = len(entry.delta) - 1
+			}
+			if maxSize <= 0 {
+				break
+			}
+
+			if candidate.index == nil {
+				candidate.index = newDeltaIndex(candidate.data)
+			}
+
+			delta := candidate.index.createDelta(data, maxSize)
+			if delta != nil {
+				entry.base = candidate.entry
+				entry.delta = delta
+				entry.depth = candidate.entry.depth + 1
+			}
+		}
+
+		if len(window) == pw.Window {
+			copy(window, window[1:])
+			window = window[:len(window)-1]
+		}
+		window = append(window, &windowItem{entry: entry, data: data})
+	}
+
+	return nil
+}
+
+func (pw *PackWriter) writeEntry(out *packOutput, entry *packWriterEntry) error {
+	if entry.written {
+		return nil
+	}
+
+	if entry.base != nil {
+		err := pw.writeEntry(out, entry.base)
+		if err != nil {
+			return err
+		}
+	}
+
+	entry.offset = out.offset
+	out.crc.Reset()
+
+	var err error
+	if entry.base != nil {
+		err = writePackEntryHeader(out, rawgit.OTypeOffsetDelta, uint64(len(entry.delta)))
+		if err != nil {
+			return err
+		}
+
+		_, err = out.Write(encodeOffset(entry.offset - entry.base.offset))
+		if err != nil {
+			return err
+		}
+
+		err = writeCompressed(out, bytes.NewReader(entry.delta))
+	} else {
+		err = writePackEntryHeader(out, entry.otype, entry.size)
+		if err != nil {
+			return err
+		}
+
+		var body io.ReadCloser
+		_, body, err = pw.storage.OpenObject(&entry.oid)
+		if err != nil {
+			return err
+		}
+		err = writeCompressed(out, body)
+		body.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	entry.crc = out.crc.Sum32()
+	entry.written = true
+	// delta will not be used anymore
+	entry.delta = nil
+	return nil
+}
+
+func (pw *PackWriter) readObject(oid *rawgit.OID) ([]byte, error) {
+	_, body, err := pw.storage.OpenObject(oid)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
+
+type deltaOrder []*packWriterEntry
+
+func (d deltaOrder) Len() int      { return len(d) }
+func (d deltaOrder) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d deltaOrder) Less(i, j int) bool {
+	if d[i].otype != d[j].otype {
+		return d[i].otype < d[j].otype
+	}
+	if d[i].nameHash != d[j].nameHash {
+		return d[i].nameHash < d[j].nameHash
+	}
+	// bigger objects first, so smaller ones are deltified against them
+	return d[i].size > d[j].size
+}
+
+// packNameHash is a port of git's pack_name_hash: it groups objects by last
+// characters of their paths
+func packNameHash(name string) uint32 {
+	var hash uint32
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == ' ' || c >= '\t' && c <= '\r' {
+			continue
+		}
+		hash = (hash >> 2) + (uint32(c) << 24)
+	}
+	return hash
+}
+
+// packOutput tracks offset, checksum and crc32 of written pack data
+type packOutput struct {
+	writer *bufio.Writer
+	hash   hash.Hash
+	crc    hash.Hash32
+	offset int64
+}
+
+func newPackOutput(dst io.Writer) *packOutput {
+	return &packOutput{writer: bufio.NewWriter(dst), hash: sha1.New(), crc: crc32.NewIEEE()}
+}
+
+func (p *packOutput) Write(data []byte) (int, error) {
+	n, err := p.writer.Write(data)
+	p.hash.Write(data[:n])
+	p.crc.Write(data[:n])
+	p.offset += int64(n)
+	return n, err
+}
+
+// finish writes pack trailer and returns the checksum
+func (p *packOutput) finish() (*rawgit.OID, error) {
+	checksum, err := rawgit.OIDFromBytes(p.hash.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.writer.Write(checksum[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return checksum, p.writer.Flush()
+}
+
+func writePackFileHeader(dst io.Writer, count uint32) error {
+	var header [12]byte
+	copy(header[:], "PACK")
+	binary.BigEndian.PutUint32(header[4:], 2)
+	binary.BigEndian.PutUint32(header[8:], count)
+	_, err := dst.Write(header[:])
+	return err
+}
+
+func writePackEntryHeader(dst io.Writer, objType rawgit.OType, size uint64) error {
+	var buf [16]byte
+	c := byte(objType&0x7)<<4 | byte(size&0xf)
+	size >>= 4
+	n := 0
+	for size > 0 {
+		buf[n] = c | 0x80
+		n++
+		c = byte(size & 0x7f)
+		size >>= 7
+	}
+	buf[n] = c
+	n++
+
+	_, err := dst.Write(buf[:n])
+	return err
+}
+
+// encodeOffset is the inverse of readOffset
+func encodeOffset(offset int64) []byte {
+	var buf [16]byte
+	pos := len(buf) - 1
+	buf[pos] = byte(offset & 0x7f)
+	for offset >>= 7; offset > 0; offset >>= 7 {
+		offset--
+		pos--
+		buf[pos] = 0x80 | byte(offset&0x7f)
+	}
+	return buf[pos:]
+}
+
+func writeCompressed(dst io.Writer, src io.Reader) error {
+	zw := zlib.NewWriter(dst)
+	_, err := io.Copy(zw, src)
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+var idxV2Magic = []byte{0xff, 't', 'O', 'c'}
+
+type packIndexEntry struct {
+	oid    rawgit.OID
+	offset int64
+	crc    uint32
+}
+
+type packIndexOrder []packIndexEntry
+
+func (p packIndexOrder) Len() int           { return len(p) }
+func (p packIndexOrder) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p packIndexOrder) Less(i, j int) bool { return bytes.Compare(p[i].oid[:], p[j].oid[:]) < 0 }
+
+// writeIDXFile writes v2 pack index. Entries will be sorted in place
+func writeIDXFile(dst io.Writer, entries []packIndexEntry, packChecksum *rawgit.OID) error {
+	sort.Sort(packIndexOrder(entries))
+
+	hw := sha1.New()
+	bw := bufio.NewWriter(io.MultiWriter(dst, hw))
+
+	var buf [8]byte
+	write32 := func(value uint32) {
+		binary.BigEndian.PutUint32(buf[:4], value)
+		bw.Write(buf[:4])
+	}
+
+	bw.Write(idxV2Magic)
+	write32(2)
+
+	// fanout table
+	var fanout [256]uint32
+	for _, entry := range entries {
+		fanout[entry.oid[0]]++
+	}
+	var total uint32
+	for _, count := range fanout {
+		total += count
+		write32(total)
+	}
+
+	for _, entry := range entries {
+		bw.Write(entry.oid[:])
+	}
+
+	for _, entry := range entries {
+		write32(entry.crc)
+	}
+
+	// offsets, that do not fit into 31 bit go to extended table
+	var extOffsets []int64
+	for _, entry := range entries {
+		if entry.offset < 1<<31 {
+			write32(uint32(entry.offset))
+		} else {
+			write32(uint32(len(extOffsets)) | 1<<31)
+			extOffsets = append(extOffsets, entry.offset)
+		}
+	}
+
+	for _, offset := range extOffsets {
+		binary.BigEndian.PutUint64(buf[:], uint64(offset))
+		bw.Write(buf[:])
+	}
+
+	bw.Write(packChecksum[:])
+
+	err := bw.Flush()
+	if err != nil {
+		return err
+	}
+
+	_, err = dst.Write(hw.Sum(nil))
+	return err
+}
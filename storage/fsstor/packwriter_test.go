@@ -0,0 +1,257 @@
+package fsstor
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// testObject is an object added to pack writer with path hint
+type testObject struct {
+	otype rawgit.OType
+	data  string
+	path  string
+}
+
+func testPackObjects() []testObject {
+	var objects []testObject
+	for version := 0; version < 10; version++ {
+		objects = append(objects, testObject{rawgit.OTypeBlob, testText("file", version), "dir/file"})
+	}
+	return append(objects,
+		testObject{rawgit.OTypeBlob, testText("other", 0), "other"},
+		testObject{rawgit.OTypeBlob, "small\n", "small"},
+		testObject{rawgit.OTypeBlob, "", "empty"},
+		testObject{rawgit.OTypeCommit, "commit body\n", ""},
+		testObject{rawgit.OTypeCommit, strings.Repeat("commit body\n", 10), ""},
+	)
+}
+
+// newTestPackWriter stores objects loosely and schedules them for packing
+func newTestPackWriter(t *testing.T, stor *FSStorage, objects []testObject) (*PackWriter, []rawgit.OID) {
+	pw := NewPackWriter(stor)
+	oids := make([]rawgit.OID, len(objects))
+	for idx, object := range objects {
+		oid, err := rawgit.WriteObject(stor, object.otype, []byte(object.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		oids[idx] = *oid
+		if err := pw.AddObject(oid, object.path); err != nil {
+			t.Fatal(err)
+		}
+		// objects are added once
+		if err := pw.AddObject(oid, object.path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pw, oids
+}
+
+// deltaDepth returns length of delta chain of object at offset
+func deltaDepth(t *testing.T, pack PackFile, offset int64) int {
+	info, data, err := pack.OpenObjectAt(offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.OType != rawgit.OTypeOffsetDelta {
+		return 0
+	}
+
+	distance, err := readOffset(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return deltaDepth(t, pack, offset-distance) + 1
+}
+
+func TestPackWriter(t *testing.T) {
+	tests := []struct {
+		name          string
+		window, depth int
+		deltas        int
+		maxDepth      int
+	}{
+		{name: "without deltas", window: 0, depth: DefaultDeltaDepth},
+		{name: "default window", window: DefaultDeltaWindow, depth: DefaultDeltaDepth, deltas: 9, maxDepth: 3},
+		{name: "short chains", window: DefaultDeltaWindow, depth: 2, deltas: 9, maxDepth: 2},
+		{name: "small window", window: 1, depth: DefaultDeltaDepth, deltas: 9, maxDepth: 9},
+		{name: "no chains", window: 1, depth: 1, deltas: 5, maxDepth: 1},
+	}
+
+	objects := testPackObjects()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stor := newTestStorage(t)
+			pw, oids := newTestPackWriter(t, stor, objects)
+			pw.Window, pw.Depth = test.window, test.depth
+			if pw.Count() != len(objects) {
+				t.Fatalf("got %d objects, want %d", pw.Count(), len(objects))
+			}
+
+			var packBuf, idxBuf bytes.Buffer
+			checksum, err := pw.Write(&packBuf, &idxBuf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			packData, idxData := packBuf.Bytes(), idxBuf.Bytes()
+			if !bytes.Equal(packData[len(packData)-20:], checksum[:]) {
+				t.Errorf("pack checksum is %x, want %s", packData[len(packData)-20:], checksum)
+			}
+
+			// index is the same as made by indexer
+			var indexed bytes.Buffer
+			if _, err := IndexPack(bytes.NewReader(packData), int64(len(packData)), &indexed); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(indexed.Bytes(), idxData) {
+				t.Error("written index differs from index of written pack")
+			}
+
+			idx, err := ReadIDXFile(bytes.NewReader(idxData))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if idx.Count() != len(objects) {
+				t.Errorf("index has %d objects, want %d", idx.Count(), len(objects))
+			}
+
+			packFile, err := LoadPackFile(bytes.NewReader(packData))
+			if err != nil {
+				t.Fatal(err)
+			}
+			deltas, maxDepth := 0, 0
+			for _, oid := range oids {
+				if depth := deltaDepth(t, packFile, idx.LookupObject(&oid)); depth > 0 {
+					deltas++
+					if depth > maxDepth {
+						maxDepth = depth
+					}
+				}
+			}
+			if deltas != test.deltas || maxDepth != test.maxDepth {
+				t.Errorf("got %d deltas with depth up to %d, want %d up to %d", deltas, maxDepth, test.deltas, test.maxDepth)
+			}
+
+			pack, err := OpenPack(ioutil.NopCloser(bytes.NewReader(idxData)), ioutil.NopCloser(bytes.NewReader(packData)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for idx, object := range objects {
+				checkObject(t, pack, &oids[idx], object.otype, object.data)
+			}
+		})
+	}
+}
+
+// shiftedPackFile serves pack at offsets moved by shift, as if pack was bigger
+type shiftedPackFile struct {
+	PackFile
+	shift int64
+}
+
+func (p *shiftedPackFile) OpenObjectAt(offset int64) (rawgit.ObjectInfo, io.Reader, error) {
+	return p.PackFile.OpenObjectAt(offset - p.shift)
+}
+
+func TestPackExtendedOffsets(t *testing.T) {
+	stor := newTestStorage(t)
+	objects := testPackObjects()
+	pw, oids := newTestPackWriter(t, stor, objects)
+
+	var packBuf, idxBuf bytes.Buffer
+	checksum, err := pw.Write(&packBuf, &idxBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := ReadIDXFile(&idxBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// objects are moved past 4 GiB, so all of them need extended offsets
+	const shift = 1 << 32
+	entries := make([]packIndexEntry, len(oids))
+	for n := range oids {
+		crc, _ := written.CRC32(&oids[n])
+		entries[n] = packIndexEntry{oids[n], written.LookupObject(&oids[n]) + shift, crc}
+	}
+	var shiftedIdx bytes.Buffer
+	if err := writeIDXFile(&shiftedIdx, entries, checksum); err != nil {
+		t.Fatal(err)
+	}
+
+	packFile, err := LoadPackFile(&packBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := ReadIDXFile(&shiftedIdx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pack := &Pack{idx: idx, pack: &shiftedPackFile{packFile, shift}, cache: newDeltaBaseCache(DefaultDeltaBaseCacheSize)}
+
+	for n, object := range objects {
+		if offset := idx.LookupObject(&oids[n]); offset < shift {
+			t.Errorf("object %s is at %d", oids[n], offset)
+		}
+		checkObject(t, pack, &oids[n], object.otype, object.data)
+		info, err := pack.StatObject(&oids[n])
+		if err != nil || info.Size != uint64(len(object.data)) {
+			t.Errorf("object %s: got size %d, %v", oids[n], info.Size, err)
+		}
+	}
+}
+
+func TestStorePack(t *testing.T) {
+	src := newTestStorage(t)
+	objects := testPackObjects()
+	pw, oids := newTestPackWriter(t, src, objects)
+
+	stor := newTestStorage(t)
+	id, err := stor.StorePack(pw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, object := range objects {
+		checkObject(t, stor, &oids[n], object.otype, object.data)
+	}
+
+	// the same pack is stored once
+	again, err := stor.StorePack(pw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != id {
+		t.Errorf("pack is stored again as %s, first as %s", again, id)
+	}
+	names, err := stor.fs.ListDir("objects/pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"objects/pack/pack-" + id + ".idx", "objects/pack/pack-" + id + ".pack"}; strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("got pack files %q, want %q", names, want)
+	}
+	names, err = stor.fs.ListDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if strings.Contains(name, "tmpgitgo.") {
+			t.Errorf("temporary file %s is left", name)
+		}
+	}
+
+	// stored pack is found by reopened storage
+	stor, err = OpenFSStorage(stor.fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, object := range objects {
+		checkObject(t, stor, &oids[n], object.otype, object.data)
+	}
+}
@@ -0,0 +1,106 @@
+package fsstor
+
+import (
+	"bufio"
+	"path"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+const reflogDir = "logs"
+
+func reflogPath(ref string) string {
+	return path.Join(reflogDir, ref)
+}
+
+// ReadReflog reads log of ref, oldest entries first. Malformed entries are skipped
+func (r *FSStorage) ReadReflog(ref string) ([]*rawgit.ReflogEntry, error) {
+	logPath := reflogPath(ref)
+	if !r.fs.IsFileExist(logPath) || r.fs.IsDir(logPath) {
+		return nil, ErrNotFound
+	}
+
+	file, err := r.fs.Open(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*rawgit.ReflogEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 4096), 1<<20)
+	for scanner.Scan() {
+		entry, err := rawgit.ParseReflogEntry(scanner.Text())
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (r *FSStorage) AppendReflog(ref string, entry *rawgit.ReflogEntry) error {
+	file, err := r.fs.Append(reflogPath(ref))
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write([]byte(entry.String() + "\n"))
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// ExpireReflog removes old entries from log of ref. Ref is locked meanwhile, so
+// concurrent updates of it fail
+func (r *FSStorage) ExpireReflog(ref string, expiry *rawgit.ReflogExpiry) error {
+	refLock, err := lockFile(r.fs, ref)
+	if err != nil {
+		return err
+	}
+	defer refLock.release()
+
+	entries, err := r.ReadReflog(ref)
+	if err != nil {
+		return err
+	}
+
+	kept := expiry.Expire(entries)
+	if len(kept) == len(entries) {
+		return nil
+	}
+
+	logLock, err := lockFile(r.fs, reflogPath(ref))
+	if err != nil {
+		return err
+	}
+	defer logLock.release()
+
+	bw := bufio.NewWriter(logLock)
+	for _, entry := range kept {
+		bw.WriteString(entry.String() + "\n")
+	}
+
+	err = bw.Flush()
+	if err != nil {
+		return err
+	}
+
+	return logLock.commit()
+}
+
+func (r *FSStorage) removeReflog(ref string) error {
+	logPath := reflogPath(ref)
+	if !r.fs.IsFileExist(logPath) || r.fs.IsDir(logPath) {
+		return nil
+	}
+
+	return r.fs.Remove(logPath)
+}
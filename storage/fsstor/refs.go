@@ -0,0 +1,167 @@
+package fsstor
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+// IterateRefs walks loose and packed refs matching pattern in name order. Only refs
+// under 'refs/' are visited, like 'git for-each-ref' does
+func (r *FSStorage) IterateRefs(pattern string) (rawgit.RefIterator, error) {
+	names, packed, err := r.collectRefs(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return &refIterator{storage: r, names: names, packed: packed, pos: -1}, nil
+}
+
+// collectRefs returns sorted names of loose and packed refs, matching pattern
+func (r *FSStorage) collectRefs(pattern string) ([]string, *packedRefs, error) {
+	dir := rawgit.RefPatternDir(pattern)
+	if !strings.HasPrefix(dir, "refs/") {
+		dir = "refs/"
+	}
+
+	root := strings.TrimSuffix(dir, "/")
+	var loose []string
+	if r.fs.IsFileExist(root) && !r.fs.IsDir(root) {
+		loose = []string{root}
+	} else {
+		var err error
+		loose, err = r.listLooseRefs(root)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	packed, err := readPackedRefs(r.fs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := make(map[string]struct{}, len(loose))
+	var names []string
+	for _, name := range loose {
+		if rawgit.MatchRefPattern(pattern, name) {
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+
+	for _, ref := range packed.withPrefix(root) {
+		if _, ok := seen[ref.name]; !ok && rawgit.MatchRefPattern(pattern, ref.name) {
+			names = append(names, ref.name)
+		}
+	}
+
+	sort.Strings(names)
+	return names, packed, nil
+}
+
+type refIterator struct {
+	storage *FSStorage
+	names   []string
+	packed  *packedRefs
+	pos     int
+	ref     *rawgit.Ref
+	err     error
+}
+
+func (it *refIterator) Next() bool {
+	for it.err == nil && it.pos+1 < len(it.names) {
+		it.pos++
+		ref, err := it.storage.readRefEntry(it.names[it.pos], it.packed)
+		if err != nil {
+			it.err = err
+			break
+		}
+
+		if ref != nil {
+			it.ref = ref
+			return true
+		}
+	}
+
+	it.ref = nil
+	return false
+}
+
+func (it *refIterator) Ref() *rawgit.Ref {
+	return it.ref
+}
+
+func (it *refIterator) Err() error {
+	return it.err
+}
+
+// readRefEntry reads ref value, follows symbolic refs and peels tags. Broken refs
+// are skipped by returning nil
+func (r *FSStorage) readRefEntry(name string, packed *packedRefs) (*rawgit.Ref, error) {
+	ref := &rawgit.Ref{Name: name}
+
+	var value, peeled string
+	peelKnown := false
+	if r.fs.IsFileExist(name) && !r.fs.IsDir(name) {
+		var err error
+		value, err = readRefFile(r.fs, name)
+		if err != nil {
+			return nil, err
+		}
+	} else if packedRef, ok := packed.get(name); ok {
+		value, peeled = packedRef.value, packedRef.peeled
+		// fully peeled packed-refs records all tags, so ref without peeled value is not a tag
+		peelKnown = peeled != "" || packed.fullyPeeled
+	} else {
+		// removed while iterating
+		return nil, nil
+	}
+
+	target := name
+	for depth := 0; strings.HasPrefix(value, rawgit.RefPrefix); depth++ {
+		if depth == rawgit.MaxSymrefDepth {
+			return nil, nil
+		}
+
+		target = value[len(rawgit.RefPrefix):]
+		if ref.Target == "" {
+			ref.Target = target
+		}
+
+		var err error
+		value, err = r.ReadRef(target)
+		if rawgit.IsNotExist(err) {
+			// dangling symbolic ref
+			return ref, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	oid, err := rawgit.ParseOID(value)
+	if err != nil {
+		return nil, nil
+	}
+	ref.OID = oid
+
+	if target != name {
+		// value came from another ref, so does peeled value
+		peelKnown = false
+	}
+
+	if !peelKnown {
+		ref.Peeled, err = r.peelTag(oid)
+		if err != nil {
+			return nil, err
+		}
+	} else if peeled != "" {
+		ref.Peeled, err = rawgit.ParseOID(peeled)
+		if err != nil {
+			return nil, ErrInvalidPackedRefs
+		}
+	}
+
+	return ref, nil
+}
@@ -0,0 +1,436 @@
+package fsstor
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+const lockSuffix = ".lock"
+
+// refLock is a '<name>.lock' file, which holds new contents of a file until commit
+type refLock struct {
+	fs   FS
+	name string
+	file File
+	// lock contents are written
+	closed bool
+	done   bool
+}
+
+func lockFile(fs FS, name string) (*refLock, error) {
+	file, err := fs.CreateExclusive(name + lockSuffix)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, &rawgit.RefLockedError{Name: name}
+		}
+		return nil, err
+	}
+
+	return &refLock{fs: fs, name: name, file: file}, nil
+}
+
+func (l *refLock) Write(data []byte) (int, error) {
+	return l.file.Write(data)
+}
+
+// close finishes writing of lock contents, lock is kept until commit or release
+func (l *refLock) close() error {
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	return l.file.Close()
+}
+
+// commit replaces locked file with lock contents
+func (l *refLock) commit() error {
+	if l.done {
+		return ErrAlreadyClosed
+	}
+	l.done = true
+
+	err := l.close()
+	if err == nil {
+		err = l.fs.Move(l.file.Name(), l.name)
+	}
+	if err != nil {
+		l.fs.Remove(l.name + lockSuffix)
+	}
+	return err
+}
+
+// release removes lock without touching locked file
+func (l *refLock) release() {
+	if l.done {
+		return
+	}
+	l.done = true
+
+	l.close()
+	l.fs.Remove(l.name + lockSuffix)
+}
+
+type lockedUpdate struct {
+	rawgit.RefUpdate
+	// name before following symbolic refs
+	origName string
+	current  *rawgit.OID
+	lock     *refLock
+	// loose ref before update, restored if transaction fails
+	hadLoose bool
+	oldLoose string
+}
+
+// UpdateRefs locks all refs, verifies their current values and then applies
+// updates. When any check fails, nothing is changed. Everything, which may fail,
+// is done before the first ref is replaced, and replaced refs are restored if
+// renaming of others fails
+func (r *FSStorage) UpdateRefs(updates []rawgit.RefUpdate) error {
+	pending := make([]*lockedUpdate, len(updates))
+	deletes := false
+	for idx, update := range updates {
+		if update.NewOID == nil {
+			return rawgit.ErrInvalidRef
+		}
+
+		if err := rawgit.CheckRefFormat(update.Name); err != nil {
+			return err
+		}
+
+		origName := update.Name
+		if !update.NoDeref {
+			name, err := r.resolveSymbolicName(update.Name)
+			if err != nil {
+				return err
+			}
+			if err := rawgit.CheckRefFormat(name); err != nil {
+				return err
+			}
+			update.Name = name
+		}
+
+		if update.NewOID.IsZero() {
+			deletes = true
+		}
+		pending[idx] = &lockedUpdate{RefUpdate: update, origName: origName}
+	}
+
+	// updates of current branch are logged in HEAD log too
+	headTarget := ""
+	if value, err := r.ReadRef("HEAD"); err == nil && strings.HasPrefix(value, rawgit.RefPrefix) {
+		headTarget, _ = r.resolveSymbolicName("HEAD")
+	}
+
+	// always lock in the same order
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Name < pending[j].Name
+	})
+
+	for idx := 1; idx < len(pending); idx++ {
+		if pending[idx].Name == pending[idx-1].Name {
+			return rawgit.ErrDuplicateRefUpdate
+		}
+	}
+
+	// locks of refs inside of other refs could not be even created
+	err := r.checkNameConflicts(pending)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		for _, update := range pending {
+			if update.lock != nil {
+				update.lock.release()
+			}
+		}
+	}()
+
+	for _, update := range pending {
+		lock, err := lockFile(r.fs, update.Name)
+		if err != nil {
+			return err
+		}
+		update.lock = lock
+	}
+
+	var packedLock *refLock
+	if deletes {
+		packedLock, err = lockFile(r.fs, PackedRefsFile)
+		if err != nil {
+			return err
+		}
+		defer packedLock.release()
+	}
+
+	packed, err := readPackedRefs(r.fs)
+	if err != nil {
+		return err
+	}
+
+	for _, update := range pending {
+		if update.OldOID == nil && update.Committer == nil {
+			continue
+		}
+
+		current, err := r.readRefOID(update.Name, packed)
+		if err != nil {
+			return err
+		}
+		update.current = current
+
+		if update.OldOID == nil || current == nil && update.OldOID.IsZero() {
+			continue
+		}
+		if current == nil || !current.Equal(update.OldOID) {
+			return &rawgit.RefConflictError{Name: update.Name, Expected: update.OldOID, Actual: current}
+		}
+	}
+
+	// everything is checked, prepare new contents of refs and packed-refs
+	for _, update := range pending {
+		if r.fs.IsFileExist(update.Name) && !r.fs.IsDir(update.Name) {
+			update.oldLoose, err = readRefFile(r.fs, update.Name)
+			if err != nil {
+				return err
+			}
+			update.hadLoose = true
+		}
+
+		if !update.NewOID.IsZero() {
+			_, err = update.lock.Write([]byte(update.NewOID.String() + "\n"))
+			if err != nil {
+				return err
+			}
+		}
+
+		err = update.lock.close()
+		if err != nil {
+			return err
+		}
+	}
+
+	var oldPacked *packedRefs
+	if deletes {
+		newPacked := &packedRefs{
+			refs:        append([]packedRef(nil), packed.refs...),
+			peeled:      packed.peeled,
+			fullyPeeled: packed.fullyPeeled,
+		}
+		changed := false
+		for _, update := range pending {
+			if update.NewOID.IsZero() && newPacked.remove(update.Name) {
+				changed = true
+			}
+		}
+
+		if changed {
+			oldPacked = packed
+			err = newPacked.writeTo(packedLock)
+			if err == nil {
+				err = packedLock.close()
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// apply updates
+	if oldPacked != nil {
+		err = packedLock.commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	for idx, update := range pending {
+		if !update.NewOID.IsZero() {
+			err = update.lock.commit()
+		} else if update.hadLoose {
+			err = r.fs.Remove(update.Name)
+		}
+		if err != nil {
+			r.rollbackRefs(pending[:idx], oldPacked)
+			return err
+		}
+	}
+
+	// refs are updated, only now their logs get new entries
+	for _, update := range pending {
+		if update.NewOID.IsZero() {
+			err = r.removeReflog(update.Name)
+		} else if update.Committer != nil {
+			err = r.logRefUpdate(update, headTarget)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkNameConflicts fails, if created ref would be directory of other ref or
+// would be inside of other ref
+func (r *FSStorage) checkNameConflicts(pending []*lockedUpdate) error {
+	packed, err := readPackedRefs(r.fs)
+	if err != nil {
+		return err
+	}
+
+	updated := make(map[string]bool, len(pending))
+	for _, update := range pending {
+		updated[update.Name] = true
+	}
+
+	for _, update := range pending {
+		if update.NewOID.IsZero() {
+			continue
+		}
+
+		name := update.Name
+		for slash := strings.IndexByte(name, '/'); slash != -1; {
+			dir := name[:slash]
+			_, isPacked := packed.get(dir)
+			if updated[dir] || isPacked || r.fs.IsFileExist(dir) && !r.fs.IsDir(dir) {
+				return &rawgit.RefNameConflictError{Name: name, Other: dir}
+			}
+
+			next := strings.IndexByte(name[slash+1:], '/')
+			if next == -1 {
+				break
+			}
+			slash += next + 1
+		}
+
+		if inside := packed.withPrefix(name + "/"); len(inside) > 0 {
+			return &rawgit.RefNameConflictError{Name: name, Other: inside[0].name}
+		}
+		loose, err := r.listLooseRefs(name)
+		if err != nil {
+			return err
+		}
+		if len(loose) > 0 {
+			return &rawgit.RefNameConflictError{Name: name, Other: loose[0]}
+		}
+	}
+
+	return nil
+}
+
+// rollbackRefs restores applied updates and packed-refs, if it was changed.
+// Refs are restored as far as possible, errors are ignored, as the original
+// failure is reported
+func (r *FSStorage) rollbackRefs(applied []*lockedUpdate, oldPacked *packedRefs) {
+	for _, update := range applied {
+		if update.hadLoose {
+			lock, err := lockFile(r.fs, update.Name)
+			if err != nil {
+				continue
+			}
+			_, err = lock.Write([]byte(update.oldLoose + "\n"))
+			if err == nil {
+				lock.commit()
+			}
+			lock.release()
+		} else if !update.NewOID.IsZero() {
+			r.fs.Remove(update.Name)
+		}
+	}
+
+	if oldPacked != nil {
+		lock, err := lockFile(r.fs, PackedRefsFile)
+		if err != nil {
+			return
+		}
+		if oldPacked.writeTo(lock) == nil {
+			lock.commit()
+		}
+		lock.release()
+	}
+}
+
+// logRefUpdate appends reflog entries to ref and symbolic refs, pointing to it
+func (r *FSStorage) logRefUpdate(update *lockedUpdate, headTarget string) error {
+	entry := &rawgit.ReflogEntry{
+		NewOID:    *update.NewOID,
+		Committer: *update.Committer,
+		Message:   update.Message,
+	}
+	if update.current != nil {
+		entry.OldOID = *update.current
+	}
+
+	names := []string{update.Name}
+	if update.origName != update.Name {
+		names = append(names, update.origName)
+	}
+	if update.Name == headTarget && update.origName != "HEAD" {
+		names = append(names, "HEAD")
+	}
+
+	for _, name := range names {
+		err := r.AppendReflog(name, entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRefOID reads current value of ref, following symbolic refs. Returns nil if ref
+// does not exist
+func (r *FSStorage) readRefOID(name string, packed *packedRefs) (*rawgit.OID, error) {
+	var value string
+	if r.fs.IsFileExist(name) && !r.fs.IsDir(name) {
+		var err error
+		value, err = readRefFile(r.fs, name)
+		if err != nil {
+			return nil, err
+		}
+	} else if packedRef, ok := packed.get(name); ok {
+		value = packedRef.value
+	} else {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(value, rawgit.RefPrefix) {
+		target, err := r.resolveSymbolicName(name)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err = r.ReadRef(target)
+		if rawgit.IsNotExist(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return rawgit.ParseOID(value)
+}
+
+// resolveSymbolicName follows chain of symbolic refs and returns name of the last ref
+// in it, which may not exist
+func (r *FSStorage) resolveSymbolicName(name string) (string, error) {
+	for depth := 0; depth <= rawgit.MaxSymrefDepth; depth++ {
+		value, err := r.ReadRef(name)
+		if rawgit.IsNotExist(err) {
+			return name, nil
+		} else if err != nil {
+			return "", err
+		}
+
+		if !strings.HasPrefix(value, rawgit.RefPrefix) {
+			return name, nil
+		}
+		name = value[len(rawgit.RefPrefix):]
+	}
+
+	return "", rawgit.ErrSymrefTooDeep
+}
@@ -0,0 +1,338 @@
+package fsstor
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/mechmind/git-go/rawgit"
+)
+
+func testOID(c string) *rawgit.OID {
+	oid, err := rawgit.ParseOID(strings.Repeat(c, 40))
+	if err != nil {
+		panic(err)
+	}
+	return oid
+}
+
+func TestUpdateRefs(t *testing.T) {
+	zero := &rawgit.OID{}
+	tests := []struct {
+		name string
+		// initial loose and packed refs
+		loose  map[string]string
+		packed map[string]string
+		// refs, which are being updated by someone else
+		locked  []string
+		updates []rawgit.RefUpdate
+		// refs after transaction, empty value for missing refs
+		want map[string]string
+		// checks error
+		err func(error) bool
+	}{
+		{
+			name:    "create",
+			updates: []rawgit.RefUpdate{{Name: "refs/heads/a", OldOID: zero, NewOID: testOID("1")}},
+			want:    map[string]string{"refs/heads/a": testOID("1").String()},
+		},
+		{
+			name:    "create existing",
+			loose:   map[string]string{"refs/heads/a": testOID("1").String()},
+			updates: []rawgit.RefUpdate{{Name: "refs/heads/a", OldOID: zero, NewOID: testOID("2")}},
+			want:    map[string]string{"refs/heads/a": testOID("1").String()},
+			err:     isRefConflict("refs/heads/a"),
+		},
+		{
+			name:  "compare and swap",
+			loose: map[string]string{"refs/heads/a": testOID("1").String()},
+			updates: []rawgit.RefUpdate{
+				{Name: "refs/heads/a", OldOID: testOID("1"), NewOID: testOID("2")},
+				{Name: "refs/heads/b", NewOID: testOID("3")},
+			},
+			want: map[string]string{"refs/heads/a": testOID("2").String(), "refs/heads/b": testOID("3").String()},
+		},
+		{
+			name:   "compare packed ref",
+			packed: map[string]string{"refs/tags/v1": testOID("1").String()},
+			updates: []rawgit.RefUpdate{
+				{Name: "refs/tags/v1", OldOID: testOID("1"), NewOID: testOID("2")},
+			},
+			want: map[string]string{"refs/tags/v1": testOID("2").String()},
+		},
+		{
+			name: "conflict rolls back",
+			loose: map[string]string{
+				"refs/heads/a": testOID("1").String(),
+				"refs/heads/b": testOID("2").String(),
+			},
+			updates: []rawgit.RefUpdate{
+				{Name: "refs/heads/a", OldOID: testOID("1"), NewOID: testOID("3")},
+				{Name: "refs/heads/b", OldOID: testOID("1"), NewOID: testOID("3")},
+				{Name: "refs/heads/c", NewOID: testOID("3")},
+			},
+			want: map[string]string{
+				"refs/heads/a": testOID("1").String(),
+				"refs/heads/b": testOID("2").String(),
+				"refs/heads/c": "",
+			},
+			err: isRefConflict("refs/heads/b"),
+		},
+		{
+			name:   "delete",
+			loose:  map[string]string{"refs/heads/a": testOID("1").String()},
+			packed: map[string]string{"refs/heads/a": testOID("2").String(), "refs/heads/b": testOID("3").String()},
+			updates: []rawgit.RefUpdate{
+				{Name: "refs/heads/a", OldOID: testOID("1"), NewOID: zero},
+			},
+			want: map[string]string{"refs/heads/a": "", "refs/heads/b": testOID("3").String()},
+		},
+		{
+			name:   "delete with wrong value",
+			packed: map[string]string{"refs/heads/a": testOID("1").String()},
+			updates: []rawgit.RefUpdate{
+				{Name: "refs/heads/a", OldOID: testOID("2"), NewOID: zero},
+			},
+			want: map[string]string{"refs/heads/a": testOID("1").String()},
+			err:  isRefConflict("refs/heads/a"),
+		},
+		{
+			name:   "locked ref",
+			loose:  map[string]string{"refs/heads/a": testOID("1").String()},
+			locked: []string{"refs/heads/b"},
+			updates: []rawgit.RefUpdate{
+				{Name: "refs/heads/a", NewOID: testOID("2")},
+				{Name: "refs/heads/b", NewOID: testOID("2")},
+			},
+			want: map[string]string{"refs/heads/a": testOID("1").String(), "refs/heads/b": ""},
+			err: func(err error) bool {
+				locked, ok := err.(*rawgit.RefLockedError)
+				return ok && locked.Name == "refs/heads/b"
+			},
+		},
+		{
+			name:    "inside of packed ref",
+			packed:  map[string]string{"refs/heads/a": testOID("1").String()},
+			updates: []rawgit.RefUpdate{{Name: "refs/heads/a/b", NewOID: testOID("2")}},
+			want:    map[string]string{"refs/heads/a": testOID("1").String(), "refs/heads/a/b": ""},
+			err:     isNameConflict("refs/heads/a/b", "refs/heads/a"),
+		},
+		{
+			name:    "directory of packed ref",
+			packed:  map[string]string{"refs/heads/a/b": testOID("1").String()},
+			updates: []rawgit.RefUpdate{{Name: "refs/heads/a", NewOID: testOID("2")}},
+			want:    map[string]string{"refs/heads/a/b": testOID("1").String(), "refs/heads/a": ""},
+			err:     isNameConflict("refs/heads/a", "refs/heads/a/b"),
+		},
+		{
+			name:    "inside of loose ref",
+			loose:   map[string]string{"refs/heads/a": testOID("1").String()},
+			updates: []rawgit.RefUpdate{{Name: "refs/heads/a/b/c", NewOID: testOID("2")}},
+			want:    map[string]string{"refs/heads/a": testOID("1").String()},
+			err:     isNameConflict("refs/heads/a/b/c", "refs/heads/a"),
+		},
+		{
+			name:    "directory of loose ref",
+			loose:   map[string]string{"refs/heads/a/b": testOID("1").String()},
+			updates: []rawgit.RefUpdate{{Name: "refs/heads/a", NewOID: testOID("2")}},
+			want:    map[string]string{"refs/heads/a/b": testOID("1").String()},
+			err:     isNameConflict("refs/heads/a", "refs/heads/a/b"),
+		},
+		{
+			name: "conflicting updates",
+			updates: []rawgit.RefUpdate{
+				{Name: "refs/heads/a", NewOID: testOID("1")},
+				{Name: "refs/heads/a-b", NewOID: testOID("1")},
+				{Name: "refs/heads/a/b", NewOID: testOID("2")},
+			},
+			want: map[string]string{"refs/heads/a": "", "refs/heads/a-b": "", "refs/heads/a/b": ""},
+			err:  isNameConflict("refs/heads/a/b", "refs/heads/a"),
+		},
+		{
+			name:   "replace deleted directory",
+			packed: map[string]string{"refs/heads/a/b": testOID("1").String()},
+			updates: []rawgit.RefUpdate{
+				{Name: "refs/heads/a/b", NewOID: zero},
+				{Name: "refs/heads/a-b", NewOID: testOID("2")},
+			},
+			want: map[string]string{"refs/heads/a/b": "", "refs/heads/a-b": testOID("2").String()},
+		},
+		{
+			name: "duplicate update",
+			updates: []rawgit.RefUpdate{
+				{Name: "refs/heads/a", NewOID: testOID("1")},
+				{Name: "refs/heads/a", NewOID: testOID("2")},
+			},
+			want: map[string]string{"refs/heads/a": ""},
+			err: func(err error) bool {
+				return err == rawgit.ErrDuplicateRefUpdate
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stor := newTestStorage(t)
+			for name, value := range test.loose {
+				if err := stor.fs.MkdirAll(path.Dir(name)); err != nil {
+					t.Fatal(err)
+				}
+				if err := writeFile(stor.fs, name, value+"\n"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(test.packed) > 0 {
+				packed := &packedRefs{peeled: true, fullyPeeled: true}
+				for name, value := range test.packed {
+					packed.set(packedRef{name: name, value: value})
+				}
+				file, err := stor.fs.Create(PackedRefsFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = packed.writeTo(file)
+				file.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, name := range test.locked {
+				if err := writeFile(stor.fs, name+lockSuffix, ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := stor.UpdateRefs(test.updates)
+			switch {
+			case test.err == nil && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case test.err != nil && !test.err(err):
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for name, want := range test.want {
+				value, err := stor.ReadRef(name)
+				switch {
+				case want == "" && !rawgit.IsNotExist(err):
+					t.Errorf("ref %s: got %q, %v, want no ref", name, value, err)
+				case want != "" && (err != nil || value != want):
+					t.Errorf("ref %s: got %q, %v, want %s", name, value, err, want)
+				}
+			}
+
+			// only locks of others are left
+			for _, update := range test.updates {
+				if !contains(test.locked, update.Name) && stor.fs.IsFileExist(update.Name+lockSuffix) {
+					t.Errorf("lock of %s is left", update.Name)
+				}
+			}
+			if stor.fs.IsFileExist(PackedRefsFile + lockSuffix) {
+				t.Errorf("lock of %s is left", PackedRefsFile)
+			}
+		})
+	}
+}
+
+func isNameConflict(name, other string) func(error) bool {
+	return func(err error) bool {
+		conflict, ok := err.(*rawgit.RefNameConflictError)
+		return ok && conflict.Name == name && conflict.Other == other
+	}
+}
+
+func isRefConflict(name string) func(error) bool {
+	return func(err error) bool {
+		conflict, ok := err.(*rawgit.RefConflictError)
+		return ok && conflict.Name == name
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+var errTestMove = errors.New("move failed")
+
+// failingFS fails to move files to failPath
+type failingFS struct {
+	FS
+	failPath string
+}
+
+func (fs *failingFS) Move(from, to string) error {
+	if to == fs.failPath {
+		return errTestMove
+	}
+	return fs.FS.Move(from, to)
+}
+
+func TestUpdateRefsCommitFailure(t *testing.T) {
+	base := newTestStorage(t)
+	for name, value := range map[string]string{
+		"refs/heads/a":           testOID("1").String(),
+		"logs/refs/heads/a":      "",
+		"logs/refs/heads/packed": "",
+	} {
+		if err := writeFile(base.fs, name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	packed := &packedRefs{refs: []packedRef{{name: "refs/heads/packed", value: testOID("3").String()}}}
+	file, err := base.fs.Create(PackedRefsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed.writeTo(file)
+	file.Close()
+
+	// packed-refs and refs/heads/a are replaced before refs/heads/b fails
+	stor, err := OpenFSStorage(&failingFS{FS: base.fs, failPath: "refs/heads/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	committer := &rawgit.UserTime{Name: "A", Email: "a@example.com"}
+	err = stor.UpdateRefs([]rawgit.RefUpdate{
+		{Name: "refs/heads/a", OldOID: testOID("1"), NewOID: testOID("2"), Committer: committer},
+		{Name: "refs/heads/b", NewOID: testOID("2"), Committer: committer},
+		{Name: "refs/heads/packed", NewOID: &rawgit.OID{}, Committer: committer},
+	})
+	if err != errTestMove {
+		t.Fatalf("got error %v, want %v", err, errTestMove)
+	}
+
+	want := map[string]string{
+		"refs/heads/a":      testOID("1").String(),
+		"refs/heads/b":      "",
+		"refs/heads/packed": testOID("3").String(),
+	}
+	for name, value := range want {
+		got, err := stor.ReadRef(name)
+		if value == "" && !rawgit.IsNotExist(err) || value != "" && got != value {
+			t.Errorf("ref %s: got %q, %v, want %q", name, got, err, value)
+		}
+	}
+
+	// reflogs are neither appended nor removed
+	for _, name := range []string{"refs/heads/a", "refs/heads/packed"} {
+		entries, err := stor.ReadReflog(name)
+		if err != nil || len(entries) != 0 {
+			t.Errorf("reflog of %s: %v, %v", name, entries, err)
+		}
+	}
+	if _, err := stor.ReadReflog("refs/heads/b"); err != ErrNotFound {
+		t.Errorf("reflog of refs/heads/b is created")
+	}
+
+	names, _ := stor.fs.ListDir("refs/heads")
+	for _, name := range append(names, PackedRefsFile) {
+		if strings.HasSuffix(name, lockSuffix) || stor.fs.IsFileExist(name+lockSuffix) {
+			t.Errorf("lock %s is left", name)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/mechmind/git-go/rawgit"
 )
@@ -17,26 +18,36 @@ import (
 const HeaderBufferSize = 30
 
 type FSStorage struct {
-	fs    FS
-	packs map[string]*Pack
+	fs FS
+
+	packsLock sync.RWMutex
+	packs     map[string]*Pack
+	cache     *deltaBaseCache
 }
 
 func OpenFSStorage(fs FS) (*FSStorage, error) {
-	repo := &FSStorage{fs, make(map[string]*Pack)}
+	repo := &FSStorage{
+		fs:    fs,
+		packs: make(map[string]*Pack),
+		cache: newDeltaBaseCache(DefaultDeltaBaseCacheSize),
+	}
 	// load packs
 	err := repo.scanPacks()
 	return repo, err
 }
 
+// SetDeltaBaseCacheSize limits total size of delta bases, cached in memory
+func (r *FSStorage) SetDeltaBaseCacheSize(size int64) {
+	r.cache.setLimit(size)
+}
+
 func (r *FSStorage) OpenObject(oid *rawgit.OID) (rawgit.ObjectInfo, io.ReadCloser, error) {
 	hash := oid.String()
 	path := filepath.Join("objects", hash[:2], hash[2:])
 	if !r.fs.IsFileExist(path) {
 		// lookup object in packs
-		for _, pack := range r.packs {
-			if pack.HasObject(oid) {
-				return pack.OpenObject(oid)
-			}
+		if pack := r.findPack(oid); pack != nil {
+			return pack.OpenObject(oid)
 		}
 		return rawgit.ObjectInfo{}, nil, ErrObjectNotFound
 	}
@@ -44,6 +55,26 @@ func (r *FSStorage) OpenObject(oid *rawgit.OID) (rawgit.ObjectInfo, io.ReadClose
 	return r.openLooseObject(oid, path)
 }
 
+func (r *FSStorage) findPack(oid *rawgit.OID) *Pack {
+	for _, pack := range r.packList() {
+		if pack.HasObject(oid) {
+			return pack
+		}
+	}
+	return nil
+}
+
+func (r *FSStorage) packList() []*Pack {
+	r.packsLock.RLock()
+	defer r.packsLock.RUnlock()
+
+	packs := make([]*Pack, 0, len(r.packs))
+	for _, pack := range r.packs {
+		packs = append(packs, pack)
+	}
+	return packs
+}
+
 func (r *FSStorage) openLooseObject(oid *rawgit.OID, path string) (rawgit.ObjectInfo, *objectReader, error) {
 
 	file, err := r.fs.Open(path)
@@ -68,7 +99,17 @@ func (r *FSStorage) openLooseObject(oid *rawgit.OID, path string) (rawgit.Object
 }
 
 func (r *FSStorage) StatObject(oid *rawgit.OID) (rawgit.ObjectInfo, interface{}, error) {
-	info, closer, err := r.OpenObject(oid)
+	hash := oid.String()
+	path := filepath.Join("objects", hash[:2], hash[2:])
+	if !r.fs.IsFileExist(path) {
+		if pack := r.findPack(oid); pack != nil {
+			info, err := pack.StatObject(oid)
+			return info, nil, err
+		}
+		return rawgit.ObjectInfo{}, nil, ErrObjectNotFound
+	}
+
+	info, closer, err := r.openLooseObject(oid, path)
 	if err != nil {
 		return rawgit.ObjectInfo{}, nil, err
 	}
@@ -109,26 +150,34 @@ func (r *FSStorage) CreateObject(objType rawgit.OType, size uint64) (rawgit.Obje
 }
 
 func (r *FSStorage) MatchObjectsPrefix(prefix string) ([]rawgit.ObjectInfo, error) {
-	// FIXME: also search in packs
 	result := []rawgit.ObjectInfo{}
-	if prefix == "" {
-		prefix = "??"
-	} else if len(prefix) == 1 {
-		prefix += "?"
+	if !rawgit.IsHexPrefix(prefix) {
+		return result, nil
 	}
 
-	pattern := "objects/" + prefix[:2] + "/" + prefix[2:] + "*"
+	prefix = strings.ToLower(prefix)
+	seen := make(map[rawgit.OID]struct{})
+
+	// loose objects are found by file names
+	pattern := prefix
+	if pattern == "" {
+		pattern = "??"
+	} else if len(pattern) == 1 {
+		pattern += "?"
+	}
+
+	pattern = "objects/" + pattern[:2] + "/" + pattern[2:] + "*"
 	entries, err := Glob(r.fs, pattern)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, entry := range entries {
-		parts := strings.Split(entry, "/")
-		oid, err := rawgit.ParseOID(parts[1] + parts[2])
+		parts := strings.Split(filepath.ToSlash(entry), "/")
+		oid, err := rawgit.ParseOID(parts[len(parts)-2] + parts[len(parts)-1])
 		if err != nil {
-			// wtf?
-			return nil, err
+			// not an object, i.e. temporary file
+			continue
 		}
 
 		info, closer, err := r.openLooseObject(oid, entry)
@@ -137,23 +186,121 @@ func (r *FSStorage) MatchObjectsPrefix(prefix string) ([]rawgit.ObjectInfo, erro
 		}
 		closer.Close()
 
+		seen[*oid] = struct{}{}
 		result = append(result, info)
 	}
 
+	// packed objects are found by index lookups
+	for _, pack := range r.packList() {
+		for _, oid := range pack.idx.MatchPrefix(prefix) {
+			if _, ok := seen[oid]; ok {
+				continue
+			}
+
+			info, err := pack.StatObject(&oid)
+			if err != nil {
+				return nil, err
+			}
+
+			seen[oid] = struct{}{}
+			result = append(result, info)
+		}
+	}
+
 	return result, nil
 }
 
+// AbbreviateOID implements rawgit.Abbreviator interface
+func (r *FSStorage) AbbreviateOID(oid *rawgit.OID, minLength int) (string, error) {
+	hash := oid.String()
+	length := minLength
+	if length < rawgit.MinAbbrevLength {
+		length = rawgit.MinAbbrevLength
+	}
+
+	// loose objects with same first byte
+	names, err := r.fs.ListDir(path.Join("objects", hash[:2]))
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range names {
+		name = filepath.Base(name)
+		if name == hash[2:] || len(name) != len(hash)-2 {
+			continue
+		}
+
+		common := 2
+		for common < len(hash) && name[common-2] == hash[common] {
+			common++
+		}
+		if common+1 > length {
+			length = common + 1
+		}
+	}
+
+	for _, pack := range r.packList() {
+		if packLength := pack.idx.uniquePrefixLength(oid); packLength > length {
+			length = packLength
+		}
+	}
+
+	if length > len(hash) {
+		length = len(hash)
+	}
+	return hash[:length], nil
+}
+
 func (r *FSStorage) ReadRef(ref string) (string, error) {
-	// read refs till object found
-	return readRefFile(r.fs, ref)
+	// loose refs take precedence over packed ones
+	if r.fs.IsFileExist(ref) && !r.fs.IsDir(ref) {
+		return readRefFile(r.fs, ref)
+	}
+
+	packed, err := readPackedRefs(r.fs)
+	if err != nil {
+		return "", err
+	}
+
+	if packedRef, ok := packed.get(ref); ok {
+		return packedRef.value, nil
+	}
+
+	return "", ErrNotFound
 }
 
+// WriteRef unconditionally sets ref value, which is either object id or 'ref: <name>'
 func (r *FSStorage) WriteRef(ref, value string) error {
-	return writeRefFile(r.fs, path.Join("refs", ref), value)
+	if err := rawgit.CheckRefFormat(ref); err != nil {
+		return err
+	}
+
+	lock, err := lockFile(r.fs, ref)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	_, err = lock.Write([]byte(value + "\n"))
+	if err != nil {
+		return err
+	}
+
+	return lock.commit()
 }
 
+// ListRefs returns names of all refs under 'refs/<ns>/', including nested ones
 func (r *FSStorage) ListRefs(ns string) ([]string, error) {
-	return r.fs.ListDir(filepath.Join("refs", ns))
+	names, _, err := r.collectRefs(path.Join("refs", ns) + "/")
+	return names, err
+}
+
+func (r *FSStorage) DeleteRef(ref string) error {
+	if _, err := r.ReadRef(ref); err != nil {
+		return err
+	}
+
+	return r.UpdateRefs([]rawgit.RefUpdate{{Name: ref, NewOID: &rawgit.OID{}, NoDeref: true}})
 }
 
 func (r *FSStorage) IsObjectExist(oid *rawgit.OID) bool {
@@ -184,31 +331,107 @@ func (r *FSStorage) scanPacks() error {
 	}
 
 	for _, name := range names {
-		if strings.HasSuffix(name, ".idx") {
+		base := path.Base(name)
+		if strings.HasPrefix(base, "pack-") && strings.HasSuffix(base, ".pack") && len(base) > 10 {
 			// extract hash from pack name
-			id := name[18 : len(name)-4]
-			packFileName := name[:len(name)-4] + ".pack"
-
-			idxFile, err := r.fs.Open(name)
-			if err != nil {
-				return err
-			}
-
-			packFile, err := r.fs.Open(packFileName)
-			if err != nil {
-				return err
+			id := base[5 : len(base)-5]
+			if !r.fs.IsFileExist(name[:len(name)-5] + ".idx") {
+				if r.fs.IsReadOnly() {
+					// can not use pack without an index
+					continue
+				}
+
+				err = r.indexPackFile(id)
+				if err != nil {
+					// broken or foreign pack, git ignores it too
+					continue
+				}
 			}
 
-			pack, err := OpenPack(idxFile, packFile)
+			err = r.loadPack(id)
 			if err != nil {
 				return err
 			}
-			r.packs[id] = pack
 		}
 	}
 	return nil
 }
 
+func (r *FSStorage) loadPack(id string) error {
+	basename := path.Join("objects/pack", "pack-"+id)
+
+	idxFile, err := r.fs.Open(basename + ".idx")
+	if err != nil {
+		return err
+	}
+	defer idxFile.Close()
+
+	// pack file itself is opened on first access
+	opener := func() (io.ReadCloser, error) {
+		return r.fs.Open(basename + ".pack")
+	}
+
+	pack, err := openLazyPack(idxFile, opener, r.cache)
+	if err != nil {
+		return err
+	}
+
+	r.packsLock.Lock()
+	r.packs[id] = pack
+	r.packsLock.Unlock()
+	return nil
+}
+
+// StorePack writes pack from given writer into objects/pack and makes its
+// objects available. Returns pack name
+func (r *FSStorage) StorePack(pw *PackWriter) (string, error) {
+	packTmp, err := r.fs.TempFile()
+	if err != nil {
+		return "", err
+	}
+	defer packTmp.Close()
+
+	idxTmp, err := r.fs.TempFile()
+	if err != nil {
+		return "", err
+	}
+	defer idxTmp.Close()
+
+	checksum, err := pw.Write(packTmp, idxTmp)
+	if err != nil {
+		return "", err
+	}
+
+	id := checksum.String()
+	return id, r.insertPack(id, packTmp, idxTmp)
+}
+
+func (r *FSStorage) insertPack(id string, packTmp, idxTmp File) error {
+	basename := path.Join("objects/pack", "pack-"+id)
+	r.packsLock.RLock()
+	_, ok := r.packs[id]
+	r.packsLock.RUnlock()
+	if ok {
+		// already have exactly same pack, temporary files are dropped
+		packTmp.Close()
+		idxTmp.Close()
+		return nil
+	}
+
+	// pack goes first, so there will be no index without a pack
+	err := r.fs.Move(packTmp.Name(), basename+".pack")
+	if err != nil {
+		return err
+	}
+
+	err = r.fs.Move(idxTmp.Name(), basename+".idx")
+	if err != nil {
+		return err
+	}
+
+	return r.loadPack(id)
+}
+
 type objectReader struct {
 	source io.ReadCloser
 	io.Reader
@@ -319,7 +542,8 @@ func readHeader(src io.Reader) (rawgit.ObjectInfo, error) {
 func scanUntil(src io.Reader, needle byte, buf []byte) ([]byte, error) {
 	buf = buf[:0]
 	for i := 0; i < cap(buf)-1; i++ {
-		_, err := src.Read(buf[i : i+1])
+		// zlib reader returns EOF with the last byte of empty objects
+		_, err := io.ReadFull(src, buf[i:i+1])
 		if err != nil {
 			return nil, err
 		}
@@ -335,6 +559,7 @@ func readRefFile(fs FS, path string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	defer file.Close()
 
 	data, err := ioutil.ReadAll(file)
 	if err != nil {
@@ -345,18 +570,6 @@ func readRefFile(fs FS, path string) (string, error) {
 	return value, nil
 }
 
-func writeRefFile(fs FS, path string, data string) error {
-	file, err := fs.Create(path)
-	if err != nil {
-		return err
-	}
-
-	defer file.Close()
-	_, err = file.Write([]byte(data))
-
-	return err
-}
-
 type exactSizeWriter struct {
 	bytesLeft uint64
 	writer    io.Writer